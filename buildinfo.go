@@ -0,0 +1,71 @@
+package log4go
+
+import (
+	"runtime"
+	"runtime/debug"
+)
+
+// BuildInfo describes the running binary, as embedded by the Go toolchain (see debug.ReadBuildInfo).
+type BuildInfo struct {
+	// Path of the main module, e.g. "github.com/example/app".
+	Path string
+	// Version of the main module, e.g. "v1.2.3" (or "(devel)" if not built from a module version).
+	Version string
+	// Revision is the VCS revision (e.g. git commit hash) the binary was built from, if known.
+	Revision string
+	// Dirty is true if the VCS working tree had uncommitted changes.
+	Dirty bool
+	// GoVersion is the Go version the binary was built with.
+	GoVersion string
+}
+
+// buildInfo is captured at init, it doesn't change.
+var buildInfo = readBuildInfo()
+
+func readBuildInfo() BuildInfo {
+	info := BuildInfo{GoVersion: runtime.Version()}
+
+	if bi, ok := debug.ReadBuildInfo(); ok {
+		info.Path = bi.Main.Path
+		info.Version = bi.Main.Version
+		info.Revision, info.Dirty = vcsInfo(bi)
+	}
+
+	return info
+}
+
+// GetBuildInfo returns information about the running binary (rendered by the {version} and {revision} tokens).
+func GetBuildInfo() BuildInfo {
+	return buildInfo
+}
+
+// releaseVersion returns the main module version, if it's a proper one (i.e. not "(devel)").
+func releaseVersion() string {
+	if buildInfo.Version == "(devel)" {
+		return ""
+	}
+	return buildInfo.Version
+}
+
+// LogStartup logs a standard "startup banner" record (INFO level), describing the binary, e.g.:
+//
+//	starting github.com/example/app v1.2.3 (revision 0123abc, go1.20.1)
+func (l *Logger) LogStartup() {
+	version := buildInfo.Version
+	if len(version) == 0 {
+		version = "(unknown version)"
+	}
+	details := ""
+	if len(buildInfo.Revision) > 0 {
+		details = "revision " + buildInfo.Revision
+		if buildInfo.Dirty {
+			details += " (dirty)"
+		}
+		details += ", "
+	}
+
+	l.With(Fields{
+		"version":  buildInfo.Version,
+		"revision": buildInfo.Revision,
+	}).Info("starting %s %s (%s%s)", buildInfo.Path, version, details, buildInfo.GoVersion)
+}
@@ -0,0 +1,11 @@
+//go:build !go1.18
+// +build !go1.18
+
+package log4go
+
+import "runtime/debug"
+
+// vcsInfo returns nothing, VCS information is only stamped into binaries by Go 1.18+.
+func vcsInfo(info *debug.BuildInfo) (revision string, dirty bool) {
+	return "", false
+}
@@ -0,0 +1,19 @@
+//go:build go1.18
+// +build go1.18
+
+package log4go
+
+import "runtime/debug"
+
+// vcsInfo returns the VCS revision and modification flag stamped into the binary (Go 1.18+).
+func vcsInfo(info *debug.BuildInfo) (revision string, dirty bool) {
+	for _, setting := range info.Settings {
+		switch setting.Key {
+		case "vcs.revision":
+			revision = setting.Value
+		case "vcs.modified":
+			dirty = setting.Value == "true"
+		}
+	}
+	return revision, dirty
+}
@@ -0,0 +1,114 @@
+package log4go
+
+import (
+	"bytes"
+	"os/exec"
+	"regexp"
+	"sync"
+)
+
+// maxPartialLine is the maximum length of a line buffered by LineWriter before it's logged anyway.
+const maxPartialLine = 64 * 1024
+
+// LineWriter is an io.Writer logging each written line as a message.
+// Partial lines are buffered until completed (or until Close is called).
+type LineWriter struct {
+	logger *Logger
+	level  Level
+	strip  *regexp.Regexp
+
+	lock sync.Mutex
+	buf  []byte
+}
+
+// NewLineWriter returns a new LineWriter logging lines using the logger and level.
+// If strip is not nil, the part of each line matching it (e.g. a timestamp prefix) is removed.
+func NewLineWriter(logger *Logger, level Level, strip *regexp.Regexp) *LineWriter {
+	return &LineWriter{
+		logger: logger,
+		level:  level,
+		strip:  strip,
+	}
+}
+
+// Write logs all complete lines in p, buffering a trailing partial line.
+func (w *LineWriter) Write(p []byte) (int, error) {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+
+	w.buf = append(w.buf, p...)
+
+	for {
+		idx := bytes.IndexByte(w.buf, '\n')
+		if idx < 0 {
+			break
+		}
+		w.logLine(w.buf[:idx])
+		w.buf = w.buf[idx+1:]
+	}
+
+	if len(w.buf) > maxPartialLine {
+		w.logLine(w.buf)
+		w.buf = nil
+	}
+
+	return len(p), nil
+}
+
+// Close logs any buffered partial line.
+func (w *LineWriter) Close() error {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+
+	if len(w.buf) > 0 {
+		w.logLine(w.buf)
+		w.buf = nil
+	}
+	return nil
+}
+
+func (w *LineWriter) logLine(line []byte) {
+	line = bytes.TrimSuffix(line, []byte{'\r'})
+	if w.strip != nil {
+		line = w.strip.ReplaceAll(line, nil)
+	}
+	w.logger.Log(w.level, "%s", line)
+}
+
+// CaptureOpts controls how RunCommand logs the output of a command.
+type CaptureOpts struct {
+	// StdoutLevel is used for lines written to stdout (default INFO).
+	StdoutLevel Level
+	// StderrLevel is used for lines written to stderr (default WARNING).
+	StderrLevel Level
+	// Strip removes the matching part of each line (e.g. the command's own timestamp prefix), if set.
+	Strip *regexp.Regexp
+}
+
+// RunCommand runs the command, logging its stdout and stderr line by line, and waits for it to finish.
+// Use cmd.Start & cmd.Wait with writers from NewLineWriter for more control.
+func (l *Logger) RunCommand(cmd *exec.Cmd, opts ...CaptureOpts) error {
+	var o CaptureOpts
+	if len(opts) > 0 {
+		o = opts[0]
+	}
+	if o.StdoutLevel == INHERIT {
+		o.StdoutLevel = INFO
+	}
+	if o.StderrLevel == INHERIT {
+		o.StderrLevel = WARNING
+	}
+
+	stdout := NewLineWriter(l, o.StdoutLevel, o.Strip)
+	stderr := NewLineWriter(l, o.StderrLevel, o.Strip)
+	cmd.Stdout = stdout
+	cmd.Stderr = stderr
+
+	err := cmd.Run()
+
+	// any unterminated last lines
+	stdout.Close()
+	stderr.Close()
+
+	return err
+}
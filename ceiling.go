@@ -0,0 +1,139 @@
+package log4go
+
+import (
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// RateCeiling is a global safety valve against runaway logging (e.g. a bug logging in a tight loop),
+// protecting disks and collectors, see SetRateCeiling.
+type RateCeiling struct {
+	// Rate is the number of records per second (logged by all loggers together) switching to emergency mode.
+	Rate int
+	// MinLevel is the level of the records still logged in emergency mode (default WARNING).
+	MinLevel Level
+	// SampleEvery makes every Nth record below MinLevel still be logged in emergency mode (default none).
+	SampleEvery int
+	// Cooldown is how long the rate must stay below the ceiling to leave emergency mode (default 10 seconds).
+	Cooldown time.Duration
+}
+
+// ceilingWindow is the period the records are counted over (shortened by tests).
+var ceilingWindow = time.Second
+
+// rateCeiling counts the records logged in each window, set by SetRateCeiling.
+type rateCeiling struct {
+	ceiling RateCeiling
+	window  time.Duration
+	limit   int64 // records per window
+
+	current   int64  // the window counted (the time divided by its length), accessed atomically
+	count     int64  // records logged in the window, accessed atomically
+	emergency int32  // non-zero in emergency mode, accessed atomically
+	sampled   uint64 // records below MinLevel in emergency mode, accessed atomically
+	dropped   uint64 // records dropped in emergency mode, accessed atomically
+
+	lock      sync.Mutex // guards calmSince
+	calmSince int64      // the first window below the ceiling (0 if none), in emergency mode
+}
+
+var currentCeiling atomic.Value // *rateCeiling
+
+// SetRateCeiling sets a ceiling on the number of records logged per second (by all loggers together).
+// Exceeding it switches to emergency mode, only logging records of (at least) MinLevel (and 1 in SampleEvery
+// of the others), until the rate has stayed below the ceiling for the cooldown. Entering and leaving emergency
+// mode is alerted by records of the "log4go" logger (ERROR and WARNING), the latter stating the number dropped.
+// Records dropped by rate limits (see SetRateLimit) aren't counted, captures and staged records aren't limited.
+// A zero Rate removes the ceiling.
+func SetRateCeiling(ceiling RateCeiling) {
+	if ceiling.Rate <= 0 {
+		currentCeiling.Store((*rateCeiling)(nil))
+		return
+	}
+	if ceiling.MinLevel == INHERIT {
+		ceiling.MinLevel = WARNING
+	}
+	if ceiling.Cooldown <= 0 {
+		ceiling.Cooldown = 10 * time.Second
+	}
+	limit := int64(float64(ceiling.Rate) * ceilingWindow.Seconds())
+	if limit < 1 {
+		limit = 1
+	}
+	currentCeiling.Store(&rateCeiling{
+		ceiling: ceiling,
+		window:  ceilingWindow,
+		limit:   limit,
+		current: time.Now().UnixNano() / int64(ceilingWindow),
+	})
+}
+
+// EmergencyMode returns whether the rate ceiling has been exceeded (see SetRateCeiling).
+func EmergencyMode() bool {
+	c, _ := currentCeiling.Load().(*rateCeiling)
+	return c != nil && atomic.LoadInt32(&c.emergency) != 0
+}
+
+// ceilingExceeded returns whether a record of the level is to be dropped, in emergency mode.
+func ceilingExceeded(lvl Level) bool {
+	c, _ := currentCeiling.Load().(*rateCeiling)
+	if c == nil {
+		return false
+	}
+
+	window := time.Now().UnixNano() / int64(c.window)
+	if current := atomic.LoadInt64(&c.current); current != window && atomic.CompareAndSwapInt64(&c.current, current, window) {
+		c.windowEnded(current, atomic.SwapInt64(&c.count, 0), window)
+	}
+	if count := atomic.AddInt64(&c.count, 1); count > c.limit && atomic.CompareAndSwapInt32(&c.emergency, 0, 1) {
+		c.lock.Lock()
+		c.calmSince = 0
+		c.lock.Unlock()
+		alert(ERROR, "record rate ceiling (%d/s) exceeded, emergency mode: only logging %s and above",
+			c.ceiling.Rate, LevelName(c.ceiling.MinLevel))
+	}
+
+	if atomic.LoadInt32(&c.emergency) == 0 || lvl >= c.ceiling.MinLevel {
+		return false
+	}
+	if every := uint64(c.ceiling.SampleEvery); every > 0 && atomic.AddUint64(&c.sampled, 1)%every == 0 {
+		return false
+	}
+	atomic.AddUint64(&c.dropped, 1)
+	return true
+}
+
+// windowEnded leaves emergency mode once the rate has stayed below the ceiling for the cooldown, given the count
+// of the last window counted, and the current one; the windows in between had no records, i.e. were calm.
+func (c *rateCeiling) windowEnded(last int64, count int64, current int64) {
+	if atomic.LoadInt32(&c.emergency) == 0 {
+		return
+	}
+
+	c.lock.Lock()
+	if count > c.limit {
+		c.calmSince = last + 1
+	} else if c.calmSince == 0 {
+		c.calmSince = last
+	}
+	ended := false
+	if calm := time.Duration(current-c.calmSince) * c.window; calm > 0 && calm >= c.ceiling.Cooldown {
+		c.calmSince = 0
+		ended = atomic.CompareAndSwapInt32(&c.emergency, 1, 0)
+	}
+	c.lock.Unlock()
+
+	if ended {
+		alert(WARNING, "record rate back below the ceiling (%d/s), emergency mode ended: dropped %d records",
+			c.ceiling.Rate, atomic.SwapUint64(&c.dropped, 0))
+	}
+}
+
+// alert logs a record of the "log4go" logger, bypassing the ceiling.
+func alert(lvl Level, message string, args ...interface{}) {
+	logger := GetLogger("log4go")
+	rec := logger.newRecord(logger, lvl, 0, message, args)
+	logger.dispatch(rec)
+	recordPool.Put(rec)
+}
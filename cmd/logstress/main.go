@@ -0,0 +1,301 @@
+// Command logstress exercises a logger tree and handler at a target rate, reporting the throughput,
+// the latency of the logging calls (percentiles), dropped records and allocations; e.g. to size queues
+// (see ParallelOpts.QueueSize) or choose handlers for a workload:
+//
+//	logstress -handler file -rate 200000 -goroutines 8 -duration 10s
+//
+// Logging calls blocking on a full queue show as high latency percentiles.
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/neonrust/log4go"
+)
+
+type options struct {
+	handler    string
+	format     string
+	rate       int
+	goroutines int
+	duration   time.Duration
+	depth      int
+	fanout     int
+	fields     int
+	level      string
+	queueSize  int
+	wait       string
+	workers    int
+	shards     int
+	samples    int
+	dir        string
+}
+
+func main() {
+	var opts options
+	flag.StringVar(&opts.handler, "handler", "stream", "handler: stream (discarding), sync (discarding), file, parallel (writing a file) or sharded")
+	flag.StringVar(&opts.format, "format", "{time} {name} {level} {message}", "template of the records")
+	flag.IntVar(&opts.rate, "rate", 0, "target rate (records per second, in total); 0 is unlimited")
+	flag.IntVar(&opts.goroutines, "goroutines", 4, "number of logging goroutines")
+	flag.DurationVar(&opts.duration, "duration", 5*time.Second, "logging time")
+	flag.IntVar(&opts.depth, "depth", 2, "depth of the logger tree")
+	flag.IntVar(&opts.fanout, "fanout", 4, "number of sub-loggers of each logger in the tree")
+	flag.IntVar(&opts.fields, "fields", 0, "number of fields added to each record")
+	flag.StringVar(&opts.level, "level", "INFO", "level of the root logger (records are logged at INFO)")
+	flag.IntVar(&opts.queueSize, "queue-size", 1000, "queue size of the handler (stream, file or parallel)")
+	flag.StringVar(&opts.wait, "wait", "block", "wait strategy of the stream and file handlers: block, yield or sleep")
+	flag.IntVar(&opts.workers, "workers", 4, "number of goroutines of the parallel handler")
+	flag.IntVar(&opts.shards, "shards", 4, "number of files of the sharded handler")
+	flag.IntVar(&opts.samples, "samples", 1000000, "number of latency samples kept (in total), a uniform sample of the logging calls")
+	flag.StringVar(&opts.dir, "dir", "", "directory of the files written (default a temporary one, removed when done)")
+	flag.Parse()
+
+	if err := run(opts); err != nil {
+		fmt.Fprintf(os.Stderr, "logstress: %v\n", err)
+		os.Exit(1)
+	}
+}
+
+func run(opts options) error {
+	level, err := log4go.ParseLevel(opts.level)
+	if err != nil {
+		return err
+	}
+	if opts.goroutines < 1 {
+		opts.goroutines = 1
+	}
+
+	if len(opts.dir) == 0 && (opts.handler == "file" || opts.handler == "parallel" || opts.handler == "sharded") {
+		if opts.dir, err = ioutil.TempDir("", "logstress"); err != nil {
+			return err
+		}
+		defer os.RemoveAll(opts.dir)
+	}
+	handler, streams, err := newHandler(opts)
+	if err != nil {
+		return err
+	}
+	formatter, err := log4go.NewTemplateFormatter(opts.format)
+	if err != nil {
+		return err
+	}
+	handler.SetFormatter(formatter)
+
+	if err := log4go.BasicConfig(log4go.BasicConfigOpts{Level: level, Handlers: []log4go.Handler{handler}}); err != nil {
+		return err
+	}
+	loggers := loggerTree(log4go.GetLogger("stress"), opts.depth, opts.fanout, opts.fields)
+
+	// allocated before measuring, so the allocations reported are the logging calls' own
+	workers := make([]*worker, opts.goroutines)
+	for idx := range workers {
+		workers[idx] = newWorker(idx, opts)
+	}
+
+	var before, after runtime.MemStats
+	runtime.GC()
+	runtime.ReadMemStats(&before)
+
+	var wg sync.WaitGroup
+	start := time.Now()
+	for _, w := range workers {
+		wg.Add(1)
+		go func(w *worker) {
+			defer wg.Done()
+			w.stress(loggers, opts, start)
+		}(w)
+	}
+	wg.Wait()
+	logged := time.Since(start)
+
+	runtime.ReadMemStats(&after)
+	if err := log4go.Flush(); err != nil {
+		return err
+	}
+	drained := time.Since(start)
+
+	records := 0
+	var all []time.Duration
+	for _, w := range workers {
+		records += w.calls
+		all = append(all, w.latencies...)
+	}
+	report(opts, streams, records, all, logged, drained, &before, &after)
+
+	log4go.Shutdown()
+	return nil
+}
+
+// newHandler returns the handler named by the options, and the stream handlers (writing) in it.
+func newHandler(opts options) (log4go.Handler, []*log4go.StreamHandler, error) {
+	filename := filepath.Join(opts.dir, "stress.log")
+	waits := map[string]log4go.WaitStrategy{"block": log4go.BlockingWait, "yield": log4go.YieldingWait, "sleep": log4go.SleepingWait}
+	wait, ok := waits[opts.wait]
+	if !ok {
+		return nil, nil, fmt.Errorf("unknown wait strategy: %s", opts.wait)
+	}
+	var handler *log4go.StreamHandler
+	var err error
+	switch opts.handler {
+	case "stream":
+		handler, err = log4go.NewStreamHandler(ioutil.Discard, log4go.StreamOpts{QueueSize: opts.queueSize, Wait: wait})
+	case "sync":
+		handler, err = log4go.NewStreamHandler(ioutil.Discard, log4go.StreamOpts{Sync: true})
+	case "file":
+		handler, err = log4go.NewFileHandler(filename, false, log4go.FileOpts{QueueSize: opts.queueSize, Wait: wait})
+	case "parallel":
+		if handler, err = log4go.NewFileHandler(filename, false, log4go.FileOpts{Sync: true}); err != nil {
+			return nil, nil, err
+		}
+		parallel := log4go.NewParallelHandler(handler, log4go.ParallelOpts{Workers: opts.workers, QueueSize: opts.queueSize})
+		return parallel, []*log4go.StreamHandler{handler}, nil
+	case "sharded":
+		sharded, err := log4go.NewShardedFileHandler(filename, false, log4go.ShardOpts{Shards: opts.shards})
+		return sharded, nil, err
+	default:
+		return nil, nil, fmt.Errorf("unknown handler: %s", opts.handler)
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+	return handler, []*log4go.StreamHandler{handler}, nil
+}
+
+// loggerTree returns the loggers of a tree of the depth and fanout below the logger,
+// each adding the number of fields to its records.
+func loggerTree(root *log4go.Logger, depth, fanout, numFields int) []*log4go.Logger {
+	fields := log4go.Fields{}
+	for idx := 0; idx < numFields; idx++ {
+		fields[fmt.Sprintf("field%d", idx)] = idx
+	}
+
+	loggers := []*log4go.Logger{root}
+	level := []*log4go.Logger{root}
+	for d := 0; d < depth; d++ {
+		var next []*log4go.Logger
+		for _, parent := range level {
+			for idx := 0; idx < fanout; idx++ {
+				next = append(next, parent.GetLogger(fmt.Sprintf("l%d", idx)))
+			}
+		}
+		loggers = append(loggers, next...)
+		level = next
+	}
+
+	if numFields > 0 {
+		for idx, logger := range loggers {
+			loggers[idx] = logger.With(fields)
+		}
+	}
+	return loggers
+}
+
+// worker is a logging goroutine, keeping a sample of the latencies of its logging calls.
+type worker struct {
+	goroutine int
+	calls     int
+	latencies []time.Duration // at most its capacity, a uniform sample of the calls
+	random    *rand.Rand
+}
+
+// newWorker returns a worker, with its share of the latency samples allocated.
+func newWorker(goroutine int, opts options) *worker {
+	capacity := opts.samples / opts.goroutines
+	if opts.rate > 0 {
+		if expected := int(int64(opts.rate)*int64(opts.duration)/int64(time.Second))/opts.goroutines + 1; expected < capacity {
+			capacity = expected
+		}
+	}
+	if capacity < 1 {
+		capacity = 1
+	}
+	return &worker{
+		goroutine: goroutine,
+		latencies: make([]time.Duration, 0, capacity),
+		random:    rand.New(rand.NewSource(int64(goroutine))),
+	}
+}
+
+// stress logs (at the goroutine's share of the rate) until the duration has passed,
+// sampling the latency of the logging calls (by reservoir sampling, once the samples are full).
+func (w *worker) stress(loggers []*log4go.Logger, opts options, start time.Time) {
+	var interval time.Duration
+	if opts.rate > 0 {
+		interval = time.Duration(int64(time.Second) * int64(opts.goroutines) / int64(opts.rate))
+	}
+	end := start.Add(opts.duration)
+
+	for n := 0; ; n++ {
+		now := time.Now()
+		if !now.Before(end) {
+			break
+		}
+		if interval > 0 {
+			if due := start.Add(time.Duration(n) * interval); now.Before(due) {
+				time.Sleep(due.Sub(now))
+			}
+		}
+
+		logger := loggers[(w.goroutine+n)%len(loggers)]
+		before := time.Now()
+		logger.Info("record %d of goroutine %d", n, w.goroutine)
+		latency := time.Since(before)
+
+		w.calls++
+		if len(w.latencies) < cap(w.latencies) {
+			w.latencies = append(w.latencies, latency)
+		} else if idx := w.random.Intn(w.calls); idx < len(w.latencies) {
+			w.latencies[idx] = latency
+		}
+	}
+}
+
+func report(opts options, streams []*log4go.StreamHandler, records int, latencies []time.Duration, logged, drained time.Duration,
+	before, after *runtime.MemStats) {
+	fmt.Printf("handler:     %s (%d goroutines, %d loggers deep, fanout %d, %d fields)\n",
+		opts.handler, opts.goroutines, opts.depth, opts.fanout, opts.fields)
+	if records == 0 {
+		fmt.Println("no records logged")
+		return
+	}
+
+	target := "unlimited"
+	if opts.rate > 0 {
+		target = fmt.Sprintf("%d/s", opts.rate)
+	}
+	fmt.Printf("records:     %d in %v (target %s)\n", records, logged.Round(time.Millisecond), target)
+	fmt.Printf("throughput:  %.0f/s logged, %.0f/s written (incl. draining the queues in %v)\n",
+		float64(records)/logged.Seconds(), float64(records)/drained.Seconds(), (drained - logged).Round(time.Millisecond))
+
+	sort.Slice(latencies, func(i, j int) bool { return latencies[i] < latencies[j] })
+	var percentiles []string
+	for _, p := range []float64{0.5, 0.9, 0.99, 0.999} {
+		percentiles = append(percentiles, fmt.Sprintf("p%g %v", p*100, latencies[int(p*float64(len(latencies)-1))]))
+	}
+	fmt.Printf("latency:     %s, max %v (of %d samples)\n", strings.Join(percentiles, ", "), latencies[len(latencies)-1], len(latencies))
+
+	fmt.Printf("allocations: %.1f/record, %.0f bytes/record, %d GCs\n",
+		float64(after.Mallocs-before.Mallocs)/float64(records),
+		float64(after.TotalAlloc-before.TotalAlloc)/float64(records), after.NumGC-before.NumGC)
+
+	if len(streams) == 0 {
+		return // the handler doesn't report its health
+	}
+	var dropped, errors uint64
+	for _, stream := range streams {
+		health := stream.Health()
+		dropped += health.Dropped
+		errors += health.WriteErrors
+	}
+	fmt.Printf("dropped:     %d (%d write errors)\n", dropped, errors)
+}
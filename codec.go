@@ -0,0 +1,119 @@
+package log4go
+
+import (
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"sync"
+)
+
+// Codec compresses (or otherwise encodes) the payloads sent by network handlers, e.g. batches of records
+// sent by a RelayHandler (see RelayOpts.Codec and WebhookOpts.Codec), cutting egress for high volume logs.
+// GzipCodec is included; other algorithms (e.g. zstd or snappy) may be plugged in by implementing Codec
+// (and registering it, for RelayServer to decode).
+type Codec interface {
+	// Name identifies the codec, e.g. "gzip" (also used as the Content-Encoding of HTTP requests).
+	Name() string
+	// Encode returns the encoded data.
+	Encode(data []byte) ([]byte, error)
+	// Decode returns the decoded data.
+	Decode(data []byte) ([]byte, error)
+}
+
+// LimitedDecoder is implemented by codecs able to stop decoding beyond a size, e.g. RelayServer not letting
+// a small batch decompress to gigabytes (a decompression bomb). Other codecs' data is checked once decoded.
+type LimitedDecoder interface {
+	// DecodeLimited returns the decoded data, or an error if it exceeds limit bytes.
+	DecodeLimited(data []byte, limit int) ([]byte, error)
+}
+
+// GzipCodec compresses using gzip, at Level (see compress/gzip; 0 means the default level).
+type GzipCodec struct {
+	Level int
+}
+
+// Name returns "gzip".
+func (c GzipCodec) Name() string {
+	return "gzip"
+}
+
+// Encode returns the compressed data.
+func (c GzipCodec) Encode(data []byte) ([]byte, error) {
+	level := c.Level
+	if level == 0 {
+		level = gzip.DefaultCompression
+	}
+
+	var buf bytes.Buffer
+	writer, err := gzip.NewWriterLevel(&buf, level)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := writer.Write(data); err != nil {
+		return nil, err
+	}
+	if err := writer.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// Decode returns the decompressed data.
+func (c GzipCodec) Decode(data []byte) ([]byte, error) {
+	reader, err := gzip.NewReader(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	defer reader.Close()
+	return ioutil.ReadAll(reader)
+}
+
+// DecodeLimited returns the decompressed data, or an error if it exceeds limit bytes.
+func (c GzipCodec) DecodeLimited(data []byte, limit int) ([]byte, error) {
+	reader, err := gzip.NewReader(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	defer reader.Close()
+	decoded, err := ioutil.ReadAll(io.LimitReader(reader, int64(limit)+1))
+	if err != nil {
+		return nil, err
+	}
+	if len(decoded) > limit {
+		return nil, fmt.Errorf("decoded data exceeds %d bytes", limit)
+	}
+	return decoded, nil
+}
+
+// decodeLimited decodes the data, failing if it exceeds limit bytes (see LimitedDecoder).
+func decodeLimited(codec Codec, data []byte, limit int) ([]byte, error) {
+	if limited, ok := codec.(LimitedDecoder); ok {
+		return limited.DecodeLimited(data, limit)
+	}
+	decoded, err := codec.Decode(data)
+	if err == nil && len(decoded) > limit {
+		return nil, fmt.Errorf("decoded data exceeds %d bytes", limit)
+	}
+	return decoded, err
+}
+
+var codecs = map[string]Codec{"gzip": GzipCodec{}}
+var codecsLock sync.RWMutex
+
+// RegisterCodec makes the codec available (by its name) for decoding, e.g. by RelayServer.
+func RegisterCodec(codec Codec) {
+	codecsLock.Lock()
+	defer codecsLock.Unlock()
+
+	codecs[codec.Name()] = codec
+}
+
+// codecByName returns the registered codec with the name (nil if none).
+func codecByName(name string) Codec {
+	codecsLock.RLock()
+	defer codecsLock.RUnlock()
+
+	return codecs[name]
+}
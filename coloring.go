@@ -0,0 +1,46 @@
+package log4go
+
+// ColorToggler is implemented by formatters (or handlers) whose coloring may be switched off and on at runtime,
+// e.g. when the output is being captured rather than shown on a terminal.
+type ColorToggler interface {
+	SetColorEnabled(enable bool)
+}
+
+// SetHandlerColorEnabled switches the coloring of the handler off or on, using the handler (or those it wraps)
+// if it's a ColorToggler, otherwise its formatter; returning false if none of them is.
+func SetHandlerColorEnabled(h Handler, enable bool) bool {
+	if toggler, ok := h.(ColorToggler); ok {
+		toggler.SetColorEnabled(enable)
+		return true
+	}
+	if w, ok := h.(handlerWrapper); ok {
+		toggled := false
+		for _, wrapped := range w.wrappedHandlers() {
+			if SetHandlerColorEnabled(wrapped, enable) {
+				toggled = true
+			}
+		}
+		return toggled
+	}
+	if toggler, ok := h.Formatter().(ColorToggler); ok {
+		toggler.SetColorEnabled(enable)
+		return true
+	}
+	return false
+}
+
+// SetColorEnabled switches the coloring of all handlers off or on (see SetHandlerColorEnabled),
+// e.g. from an admin endpoint or a signal handler.
+func SetColorEnabled(enable bool) {
+	loggersLock.RLock()
+	defer loggersLock.RUnlock()
+
+	if rootLogger == nil {
+		return
+	}
+	for _, h := range allHandlers() {
+		if _, wrapper := h.handler.(handlerWrapper); !wrapper {
+			SetHandlerColorEnabled(h.handler, enable)
+		}
+	}
+}
@@ -0,0 +1,251 @@
+// Package logrus provides a logrus-like API (Fields, Entry, WithField etc) backed by log4go,
+// so code written for logrus can log through log4go without rewriting all call sites at once.
+//
+// Only the commonly used parts of the logrus API are provided; formatters, hooks and
+// output settings are configured using log4go instead.
+package logrus
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/neonrust/log4go"
+)
+
+// Fields are key/value pairs attached to an Entry.
+type Fields map[string]interface{}
+
+// Level is a logrus logging level.
+type Level uint32
+
+// Logging levels, in logrus order.
+const (
+	PanicLevel Level = iota
+	FatalLevel
+	ErrorLevel
+	WarnLevel
+	InfoLevel
+	DebugLevel
+	TraceLevel
+)
+
+// ErrorKey is the field key used by WithError.
+var ErrorKey = "error"
+
+var toLog4go = map[Level]log4go.Level{
+	PanicLevel: log4go.ERROR,
+	FatalLevel: log4go.FATAL,
+	ErrorLevel: log4go.ERROR,
+	WarnLevel:  log4go.WARNING,
+	InfoLevel:  log4go.INFO,
+	DebugLevel: log4go.DEBUG,
+	TraceLevel: log4go.TRACE,
+}
+
+// Logger wraps a log4go logger.
+type Logger struct {
+	log *log4go.Logger
+}
+
+// New returns a Logger using the log4go root logger.
+func New() *Logger {
+	return &Logger{log4go.GetLogger()}
+}
+
+// NewLogger returns a Logger using the specified log4go logger.
+func NewLogger(log *log4go.Logger) *Logger {
+	return &Logger{log}
+}
+
+// SetLevel sets the level of the underlying log4go logger.
+func (l *Logger) SetLevel(level Level) {
+	l.log.SetLevel(toLog4go[level])
+}
+
+// GetLevel returns the (effective) level of the underlying log4go logger.
+func (l *Logger) GetLevel() Level {
+	lvl := l.log.Level()
+	for level := TraceLevel; level > PanicLevel; level-- {
+		if toLog4go[level] >= lvl {
+			return level
+		}
+	}
+	return PanicLevel
+}
+
+// IsLevelEnabled returns whether records of the level would be logged.
+func (l *Logger) IsLevelEnabled(level Level) bool {
+	return toLog4go[level] >= l.log.Level()
+}
+
+// WithField returns an Entry with a single field.
+func (l *Logger) WithField(key string, value interface{}) *Entry {
+	return l.WithFields(Fields{key: value})
+}
+
+// WithFields returns an Entry with the fields.
+func (l *Logger) WithFields(fields Fields) *Entry {
+	return newEntry(l).WithFields(fields)
+}
+
+// WithError returns an Entry with the error as the ErrorKey field.
+func (l *Logger) WithError(err error) *Entry {
+	return l.WithField(ErrorKey, err)
+}
+
+// Entry is a set of fields, logged with every record.
+type Entry struct {
+	Logger *Logger
+	Data   Fields
+
+	log *log4go.Logger
+}
+
+func newEntry(l *Logger) *Entry {
+	return &Entry{
+		Logger: l,
+		Data:   Fields{},
+		log:    l.log,
+	}
+}
+
+// WithField returns a new Entry with the field added.
+func (e *Entry) WithField(key string, value interface{}) *Entry {
+	return e.WithFields(Fields{key: value})
+}
+
+// WithFields returns a new Entry with the fields added.
+func (e *Entry) WithFields(fields Fields) *Entry {
+	data := make(Fields, len(e.Data)+len(fields))
+	for key, value := range e.Data {
+		data[key] = value
+	}
+	for key, value := range fields {
+		data[key] = value
+	}
+	return &Entry{
+		Logger: e.Logger,
+		Data:   data,
+		log:    e.Logger.log.With(log4go.Fields(data)),
+	}
+}
+
+// WithError returns a new Entry with the error as the ErrorKey field.
+func (e *Entry) WithError(err error) *Entry {
+	return e.WithField(ErrorKey, err)
+}
+
+// Log logs the arguments (formatted as by fmt.Sprint) with the level.
+func (e *Entry) Log(level Level, args ...interface{}) {
+	e.logMessage(level, fmt.Sprint, args)
+}
+
+// Logf logs the formatted message with the level.
+func (e *Entry) Logf(level Level, format string, args ...interface{}) {
+	e.logMessage(level, func(...interface{}) string { return fmt.Sprintf(format, args...) }, nil)
+}
+
+// Logln logs the arguments (formatted as by fmt.Sprintln) with the level.
+func (e *Entry) Logln(level Level, args ...interface{}) {
+	e.logMessage(level, fmt.Sprintln, args)
+}
+
+func (e *Entry) logMessage(level Level, sprint func(...interface{}) string, args []interface{}) {
+	if !e.Logger.IsLevelEnabled(level) && level > FatalLevel {
+		return
+	}
+	msg := strings.TrimSuffix(sprint(args...), "\n")
+
+	switch level {
+	case PanicLevel:
+		e.log.Error("%s", msg)
+		panic(msg)
+	case FatalLevel:
+		e.log.Fatal("%s", msg)
+	case ErrorLevel:
+		e.log.Error("%s", msg)
+	default:
+		e.log.Log(toLog4go[level], "%s", msg)
+	}
+}
+
+// Trace logs with TRACE level.
+func (e *Entry) Trace(args ...interface{}) { e.Log(TraceLevel, args...) }
+
+// Debug logs with DEBUG level.
+func (e *Entry) Debug(args ...interface{}) { e.Log(DebugLevel, args...) }
+
+// Info logs with INFO level.
+func (e *Entry) Info(args ...interface{}) { e.Log(InfoLevel, args...) }
+
+// Print logs with INFO level.
+func (e *Entry) Print(args ...interface{}) { e.Log(InfoLevel, args...) }
+
+// Warn logs with WARNING level.
+func (e *Entry) Warn(args ...interface{}) { e.Log(WarnLevel, args...) }
+
+// Warning logs with WARNING level.
+func (e *Entry) Warning(args ...interface{}) { e.Log(WarnLevel, args...) }
+
+// Error logs with ERROR level.
+func (e *Entry) Error(args ...interface{}) { e.Log(ErrorLevel, args...) }
+
+// Fatal logs with FATAL level, then exits.
+func (e *Entry) Fatal(args ...interface{}) { e.Log(FatalLevel, args...) }
+
+// Panic logs with ERROR level, then panics.
+func (e *Entry) Panic(args ...interface{}) { e.Log(PanicLevel, args...) }
+
+// Tracef logs with TRACE level.
+func (e *Entry) Tracef(format string, args ...interface{}) { e.Logf(TraceLevel, format, args...) }
+
+// Debugf logs with DEBUG level.
+func (e *Entry) Debugf(format string, args ...interface{}) { e.Logf(DebugLevel, format, args...) }
+
+// Infof logs with INFO level.
+func (e *Entry) Infof(format string, args ...interface{}) { e.Logf(InfoLevel, format, args...) }
+
+// Printf logs with INFO level.
+func (e *Entry) Printf(format string, args ...interface{}) { e.Logf(InfoLevel, format, args...) }
+
+// Warnf logs with WARNING level.
+func (e *Entry) Warnf(format string, args ...interface{}) { e.Logf(WarnLevel, format, args...) }
+
+// Warningf logs with WARNING level.
+func (e *Entry) Warningf(format string, args ...interface{}) { e.Logf(WarnLevel, format, args...) }
+
+// Errorf logs with ERROR level.
+func (e *Entry) Errorf(format string, args ...interface{}) { e.Logf(ErrorLevel, format, args...) }
+
+// Fatalf logs with FATAL level, then exits.
+func (e *Entry) Fatalf(format string, args ...interface{}) { e.Logf(FatalLevel, format, args...) }
+
+// Panicf logs with ERROR level, then panics.
+func (e *Entry) Panicf(format string, args ...interface{}) { e.Logf(PanicLevel, format, args...) }
+
+// Traceln logs with TRACE level.
+func (e *Entry) Traceln(args ...interface{}) { e.Logln(TraceLevel, args...) }
+
+// Debugln logs with DEBUG level.
+func (e *Entry) Debugln(args ...interface{}) { e.Logln(DebugLevel, args...) }
+
+// Infoln logs with INFO level.
+func (e *Entry) Infoln(args ...interface{}) { e.Logln(InfoLevel, args...) }
+
+// Println logs with INFO level.
+func (e *Entry) Println(args ...interface{}) { e.Logln(InfoLevel, args...) }
+
+// Warnln logs with WARNING level.
+func (e *Entry) Warnln(args ...interface{}) { e.Logln(WarnLevel, args...) }
+
+// Warningln logs with WARNING level.
+func (e *Entry) Warningln(args ...interface{}) { e.Logln(WarnLevel, args...) }
+
+// Errorln logs with ERROR level.
+func (e *Entry) Errorln(args ...interface{}) { e.Logln(ErrorLevel, args...) }
+
+// Fatalln logs with FATAL level, then exits.
+func (e *Entry) Fatalln(args ...interface{}) { e.Logln(FatalLevel, args...) }
+
+// Panicln logs with ERROR level, then panics.
+func (e *Entry) Panicln(args ...interface{}) { e.Logln(PanicLevel, args...) }
@@ -0,0 +1,64 @@
+package logrus
+
+import (
+	"errors"
+	"sync"
+	"testing"
+
+	"github.com/neonrust/log4go"
+)
+
+func TestEntry(t *testing.T) {
+	handler := &recordingHandler{}
+
+	log4go.BasicConfig(log4go.BasicConfigOpts{
+		Level:    log4go.INFO,
+		Handlers: []log4go.Handler{handler},
+	})
+
+	log := NewLogger(log4go.GetLogger("compat"))
+	if log.GetLevel() != InfoLevel {
+		t.Errorf("unexpected level: %v", log.GetLevel())
+	}
+
+	entry := log.WithField("user", "bob").WithFields(Fields{"id": 1})
+	entry.Infof("hello %s", "world")
+	entry.WithError(errors.New("boom")).Warn("warned ", 2)
+	entry.Debug("not logged")
+
+	log4go.Shutdown()
+
+	if len(handler.records) != 2 {
+		t.Fatalf("expected 2 records, got %d", len(handler.records))
+	}
+	first, second := handler.records[0], handler.records[1]
+	if first.Level != log4go.INFO || first.Message != "hello world" || first.Fields["user"] != "bob" || first.Fields["id"] != 1 {
+		t.Errorf("unexpected record: %+v", first)
+	}
+	if second.Level != log4go.WARNING || second.Message != "warned 2" || second.Fields[ErrorKey].(error).Error() != "boom" {
+		t.Errorf("unexpected record: %+v", second)
+	}
+	if len(entry.Data) != 2 {
+		t.Errorf("entry data modified: %v", entry.Data)
+	}
+}
+
+type recordingHandler struct {
+	lock      sync.Mutex
+	records   []log4go.Record
+	formatter log4go.Formatter
+	level     log4go.Level
+}
+
+func (h *recordingHandler) Handle(rec *log4go.Record) error {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	h.records = append(h.records, *rec)
+	return nil
+}
+
+func (h *recordingHandler) SetFormatter(formatter log4go.Formatter) { h.formatter = formatter }
+func (h *recordingHandler) Formatter() log4go.Formatter             { return h.formatter }
+func (h *recordingHandler) SetLevel(level log4go.Level)             { h.level = level }
+func (h *recordingHandler) Level() log4go.Level                     { return h.level }
+func (h *recordingHandler) Shutdown()                               {}
@@ -0,0 +1,87 @@
+package logrus
+
+var std = New()
+
+// StandardLogger returns the Logger used by the package-level functions (using the log4go root logger).
+func StandardLogger() *Logger {
+	return std
+}
+
+// SetLevel sets the level of the standard logger.
+func SetLevel(level Level) {
+	std.SetLevel(level)
+}
+
+// GetLevel returns the level of the standard logger.
+func GetLevel() Level {
+	return std.GetLevel()
+}
+
+// WithField returns an Entry of the standard logger with a single field.
+func WithField(key string, value interface{}) *Entry {
+	return std.WithField(key, value)
+}
+
+// WithFields returns an Entry of the standard logger with the fields.
+func WithFields(fields Fields) *Entry {
+	return std.WithFields(fields)
+}
+
+// WithError returns an Entry of the standard logger with the error as the ErrorKey field.
+func WithError(err error) *Entry {
+	return std.WithError(err)
+}
+
+// Trace logs with TRACE level using the standard logger.
+func Trace(args ...interface{}) { newEntry(std).Trace(args...) }
+
+// Debug logs with DEBUG level using the standard logger.
+func Debug(args ...interface{}) { newEntry(std).Debug(args...) }
+
+// Info logs with INFO level using the standard logger.
+func Info(args ...interface{}) { newEntry(std).Info(args...) }
+
+// Print logs with INFO level using the standard logger.
+func Print(args ...interface{}) { newEntry(std).Print(args...) }
+
+// Warn logs with WARNING level using the standard logger.
+func Warn(args ...interface{}) { newEntry(std).Warn(args...) }
+
+// Warning logs with WARNING level using the standard logger.
+func Warning(args ...interface{}) { newEntry(std).Warning(args...) }
+
+// Error logs with ERROR level using the standard logger.
+func Error(args ...interface{}) { newEntry(std).Error(args...) }
+
+// Fatal logs with FATAL level using the standard logger, then exits.
+func Fatal(args ...interface{}) { newEntry(std).Fatal(args...) }
+
+// Panic logs with ERROR level using the standard logger, then panics.
+func Panic(args ...interface{}) { newEntry(std).Panic(args...) }
+
+// Tracef logs with TRACE level using the standard logger.
+func Tracef(format string, args ...interface{}) { newEntry(std).Tracef(format, args...) }
+
+// Debugf logs with DEBUG level using the standard logger.
+func Debugf(format string, args ...interface{}) { newEntry(std).Debugf(format, args...) }
+
+// Infof logs with INFO level using the standard logger.
+func Infof(format string, args ...interface{}) { newEntry(std).Infof(format, args...) }
+
+// Printf logs with INFO level using the standard logger.
+func Printf(format string, args ...interface{}) { newEntry(std).Printf(format, args...) }
+
+// Warnf logs with WARNING level using the standard logger.
+func Warnf(format string, args ...interface{}) { newEntry(std).Warnf(format, args...) }
+
+// Warningf logs with WARNING level using the standard logger.
+func Warningf(format string, args ...interface{}) { newEntry(std).Warningf(format, args...) }
+
+// Errorf logs with ERROR level using the standard logger.
+func Errorf(format string, args ...interface{}) { newEntry(std).Errorf(format, args...) }
+
+// Fatalf logs with FATAL level using the standard logger, then exits.
+func Fatalf(format string, args ...interface{}) { newEntry(std).Fatalf(format, args...) }
+
+// Panicf logs with ERROR level using the standard logger, then panics.
+func Panicf(format string, args ...interface{}) { newEntry(std).Panicf(format, args...) }
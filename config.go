@@ -0,0 +1,720 @@
+package log4go
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math"
+	"os"
+	"reflect"
+	"regexp"
+	"sort"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// Config describes a logging configuration, e.g. loaded from a JSON file using LoadConfig:
+//
+//	{
+//		"level": "INFO",
+//		"format": "{time} {name<20} {level<8} {message}",
+//		"handlers": {
+//			"console": {"type": "stream", "target": "stderr"},
+//			"file": {"type": "file", "file": "app.log", "level": "WARNING"}
+//		},
+//		"loggers": {
+//			"root": {"handlers": ["console", "file"]},
+//			"db": {"level": "DEBUG", "rate_limit": {"rate": 100, "exempt": "ERROR"}}
+//		},
+//		"escalations": [
+//			{"logger": "payments/**", "match": "timeout", "level": "ERROR"}
+//		]
+//	}
+//
+// If no logger has any handlers, the root logger gets a default handler writing to stderr.
+type Config struct {
+	// Level is the root logger's level (unless specified in Loggers), default WARNING.
+	Level string `json:"level,omitempty"`
+	// Format is the template of handlers not specifying one.
+	Format   string                   `json:"format,omitempty"`
+	Handlers map[string]HandlerConfig `json:"handlers,omitempty"`
+	// Loggers are keyed by full name, e.g. "db/pool" ("root" is the root logger).
+	Loggers map[string]LoggerConfig `json:"loggers,omitempty"`
+	// Escalations replace the escalation rules (see SetEscalationRules), if set (or set by the previous config).
+	Escalations []EscalationConfig `json:"escalations,omitempty"`
+}
+
+// HandlerConfig describes a handler. Type is one of "stream" (writing to Target, "stderr" or "stdout"),
+// "file" and "watchedfile" (writing to File).
+type HandlerConfig struct {
+	Type   string `json:"type"`
+	Target string `json:"target,omitempty"`
+	File   string `json:"file,omitempty"`
+	Append *bool  `json:"append,omitempty"` // default true
+	Level  string `json:"level,omitempty"`
+	Format string `json:"format,omitempty"`
+	Color  bool   `json:"color,omitempty"`
+	Sync   bool   `json:"sync,omitempty"`
+}
+
+// LoggerConfig describes a logger's level and handlers (by name), and optionally its rate limit.
+type LoggerConfig struct {
+	Level     string           `json:"level,omitempty"`
+	Handlers  []string         `json:"handlers,omitempty"`
+	RateLimit *RateLimitConfig `json:"rate_limit,omitempty"`
+}
+
+// EscalationConfig describes an escalation rule (see EscalationRule), Match being a regular expression.
+type EscalationConfig struct {
+	Logger string   `json:"logger,omitempty"`
+	Match  string   `json:"match,omitempty"`
+	Tags   []string `json:"tags,omitempty"`
+	Level  string   `json:"level"`
+}
+
+// RateLimitConfig describes a logger's rate limit (see RateLimit), Exempt being a level name.
+type RateLimitConfig struct {
+	Rate   float64 `json:"rate"`
+	Burst  int     `json:"burst,omitempty"`
+	Exempt string  `json:"exempt,omitempty"`
+}
+
+// configState is the config applied last, and the handlers installed by it (by logger name).
+// Guarded by loggersLock, and reset by BasicConfig.
+var configState struct {
+	config   *Config
+	handlers map[string][]Handler
+}
+
+// ParseConfig parses a JSON config; unknown keys are errors (i.e. likely typos).
+func ParseConfig(data []byte) (*Config, error) {
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.DisallowUnknownFields()
+
+	config := &Config{}
+	if err := decoder.Decode(config); err != nil {
+		return nil, err
+	}
+	return config, nil
+}
+
+// ParseConfigTOML parses a TOML config, e.g.:
+//
+//	level = "INFO"
+//
+//	[handlers.file]
+//	type = "file"
+//	file = "app.log"
+//
+//	[loggers.root]
+//	handlers = ["file"]
+func ParseConfigTOML(data []byte) (*Config, error) {
+	doc, err := parseTOML(data)
+	if err != nil {
+		return nil, err
+	}
+	return ConfigFromMap(doc)
+}
+
+// ConfigFromMap returns the config described by the map (with the same structure as the JSON config),
+// e.g. the log4go section of a larger document already decoded by the application.
+func ConfigFromMap(m map[string]interface{}) (*Config, error) {
+	data, err := json.Marshal(m)
+	if err != nil {
+		return nil, err
+	}
+	return ParseConfig(data)
+}
+
+// LoadConfig applies the config file (see ApplyConfig): TOML if the name ends with ".toml", JSON otherwise.
+func LoadConfig(path string) error {
+	config, err := readConfig(path)
+	if err != nil {
+		return err
+	}
+	return ApplyConfig(config)
+}
+
+// readConfig reads and parses the config file (see LoadConfig).
+func readConfig(path string) (*Config, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var config *Config
+	if strings.HasSuffix(path, ".toml") {
+		config, err = ParseConfigTOML(data)
+	} else {
+		config, err = ParseConfig(data)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+	return config, nil
+}
+
+// ApplyConfig applies the config to the existing logger tree, i.e. loggers already retrieved keep working.
+// The root logger's handlers are replaced, as are the handlers installed by a previously applied config,
+// but other loggers and handlers not in the config are left as they are.
+// Handlers are only recreated if their configuration (or assignment to loggers) changed.
+// Nothing is changed if the config is invalid.
+func ApplyConfig(config *Config) error {
+	_, err := applyConfig(config)
+	return err
+}
+
+// applyConfig applies the config, returning a description of the changes.
+func applyConfig(config *Config) ([]string, error) {
+	levels, err := configLevels(config)
+	if err != nil {
+		return nil, err
+	}
+	limits, err := configRateLimits(config)
+	if err != nil {
+		return nil, err
+	}
+	escalations, err := configEscalationRules(config)
+	if err != nil {
+		return nil, err
+	}
+
+	loggersLock.Lock()
+
+	previous := configState.config
+	if previous == nil {
+		previous = &Config{}
+	}
+	var created map[string][]Handler
+	if configState.config == nil || !sameHandlers(previous, config) {
+		if created, err = configHandlersByLogger(config); err != nil {
+			loggersLock.Unlock()
+			return nil, err
+		}
+	}
+
+	var changes []string
+	var replaced []Handler
+
+	if rootLogger == nil {
+		setRootLogger(newLogger(nil, "", WARNING))
+	}
+
+	// levels of loggers no longer configured are reset
+	previousLevels, _ := configLevels(previous)
+	for name := range previousLevels {
+		if _, exists := levels[name]; !exists {
+			levels[name] = INHERIT
+		}
+	}
+	for name, level := range levels {
+		logger := configLogger(name)
+		if logger == rootLogger && level == INHERIT {
+			level = WARNING
+		}
+		if logger.level != level {
+			changes = append(changes, fmt.Sprintf("level of %s: %s -> %s",
+				configLoggerName(name), LevelName(logger.level), LevelName(level)))
+			logger.setLevel(level)
+		}
+	}
+
+	// as are rate limits
+	previousLimits, _ := configRateLimits(previous)
+	for name := range previousLimits {
+		if _, exists := limits[name]; !exists {
+			limits[name] = RateLimit{}
+		}
+	}
+	for name, limit := range limits {
+		logger := configLogger(name)
+		if current := logger.RateLimit(); current != limit {
+			changes = append(changes, fmt.Sprintf("rate limit of %s: %v -> %v", configLoggerName(name), current.Rate, limit.Rate))
+			logger.setRateLimit(limit)
+		}
+	}
+
+	if len(config.Escalations) > 0 || len(previous.Escalations) > 0 {
+		if !reflect.DeepEqual(config.Escalations, previous.Escalations) {
+			changes = append(changes, "escalation rules replaced")
+		}
+		SetEscalationRules(escalations...)
+	}
+
+	var pending preConfigReplay
+	if created != nil {
+		installed := configState.handlers
+		if configState.config == nil { // i.e. replace the root logger's handlers, like BasicConfig does
+			pending = takePreConfig()
+			installed = map[string][]Handler{"root": rootLogger.ownHandlers()}
+		}
+		for name, handlers := range installed {
+			logger := configLogger(name)
+			logger.handlers.Store(withoutHandlers(logger.ownHandlers(), handlers))
+			replaced = append(replaced, handlers...)
+		}
+		for name, handlers := range created {
+			logger := configLogger(name)
+			current := logger.ownHandlers()
+			combined := make([]Handler, 0, len(current)+len(handlers))
+			combined = append(combined, current...)
+			logger.handlers.Store(append(combined, handlers...))
+		}
+		configState.handlers = created
+		changes = append(changes, "handlers replaced")
+	}
+
+	configState.config = config
+
+	loggersLock.Unlock()
+
+	replayPreConfig(pending)
+	// shut down (i.e. flush) the replaced handlers once they're no longer used
+	shutdownHandlers(replaced)
+
+	sort.Strings(changes)
+	return changes, nil
+}
+
+// configLevels returns the configured levels by logger name.
+func configLevels(config *Config) (map[string]Level, error) {
+	levels := make(map[string]Level, len(config.Loggers)+1)
+
+	if len(config.Level) > 0 {
+		level, err := ParseLevel(config.Level)
+		if err != nil {
+			return nil, err
+		}
+		levels["root"] = level
+	}
+	for name, logger := range config.Loggers {
+		if len(logger.Level) == 0 {
+			continue
+		}
+		level, err := ParseLevel(logger.Level)
+		if err != nil {
+			return nil, fmt.Errorf("logger %s: %v", name, err)
+		}
+		levels[configLoggerName(name)] = level
+	}
+	return levels, nil
+}
+
+// configRateLimits returns the configured rate limits by logger name.
+func configRateLimits(config *Config) (map[string]RateLimit, error) {
+	limits := make(map[string]RateLimit)
+	for name, logger := range config.Loggers {
+		if logger.RateLimit == nil {
+			continue
+		}
+		limit := RateLimit{Rate: logger.RateLimit.Rate, Burst: logger.RateLimit.Burst}
+		if len(logger.RateLimit.Exempt) > 0 {
+			level, err := ParseLevel(logger.RateLimit.Exempt)
+			if err != nil {
+				return nil, fmt.Errorf("logger %s: rate limit: %v", name, err)
+			}
+			limit.Exempt = level
+		}
+		if limit.Rate <= 0 {
+			return nil, fmt.Errorf("logger %s: rate limit: invalid rate: %v", name, limit.Rate)
+		}
+		if limit.Burst < 1 {
+			limit.Burst = int(math.Ceil(limit.Rate)) // as defaulted by setRateLimit, for comparing
+		}
+		limits[configLoggerName(name)] = limit
+	}
+	return limits, nil
+}
+
+// configEscalationRules returns the configured escalation rules.
+func configEscalationRules(config *Config) ([]EscalationRule, error) {
+	rules := make([]EscalationRule, 0, len(config.Escalations))
+	for idx, escalation := range config.Escalations {
+		rule := EscalationRule{LoggerGlob: escalation.Logger, Tags: escalation.Tags}
+		var err error
+		if rule.Level, err = ParseLevel(escalation.Level); err != nil {
+			return nil, fmt.Errorf("escalation %d: %v", idx+1, err)
+		}
+		if len(escalation.Match) > 0 {
+			if rule.MatchRegex, err = regexp.Compile(escalation.Match); err != nil {
+				return nil, fmt.Errorf("escalation %d: %v", idx+1, err)
+			}
+		}
+		rules = append(rules, rule)
+	}
+	return rules, nil
+}
+
+// sameHandlers returns whether the configs have the same handlers, assigned to the same loggers.
+func sameHandlers(a, b *Config) bool {
+	if a.Format != b.Format || !reflect.DeepEqual(a.Handlers, b.Handlers) {
+		return false
+	}
+	assigned := func(config *Config) map[string][]string {
+		handlers := map[string][]string{}
+		for name, logger := range config.Loggers {
+			if len(logger.Handlers) > 0 {
+				handlers[configLoggerName(name)] = logger.Handlers
+			}
+		}
+		return handlers
+	}
+	return reflect.DeepEqual(assigned(a), assigned(b))
+}
+
+// configHandlersByLogger creates the handlers of the config, by logger name.
+func configHandlersByLogger(config *Config) (map[string][]Handler, error) {
+	created := make(map[string]Handler, len(config.Handlers))
+	shutdownCreated := func() {
+		for _, h := range created {
+			h.Shutdown()
+		}
+	}
+
+	byLogger := make(map[string][]Handler, len(config.Loggers))
+	for name, logger := range config.Loggers {
+		for _, handlerName := range logger.Handlers {
+			handler, exists := created[handlerName]
+			if !exists {
+				handlerConfig, ok := config.Handlers[handlerName]
+				if !ok {
+					shutdownCreated()
+					return nil, fmt.Errorf("logger %s: unknown handler: %s", name, handlerName)
+				}
+				var err error
+				if handler, err = newConfigHandler(handlerConfig, config.Format); err != nil {
+					shutdownCreated()
+					return nil, fmt.Errorf("handler %s: %v", handlerName, err)
+				}
+				created[handlerName] = handler
+			}
+			name = configLoggerName(name)
+			byLogger[name] = append(byLogger[name], handler)
+		}
+	}
+
+	if len(byLogger) == 0 {
+		handlers, err := configHandlers(BasicConfigOpts{Format: config.Format})
+		if err != nil {
+			return nil, err
+		}
+		byLogger["root"] = handlers
+	}
+
+	return byLogger, nil
+}
+
+// newConfigHandler creates a handler, with a formatter, as configured.
+func newConfigHandler(config HandlerConfig, format string) (Handler, error) {
+	if len(config.Format) > 0 {
+		format = config.Format
+	}
+	opts := BasicConfigOpts{
+		Format: format,
+		Sync:   config.Sync,
+		Color:  config.Color,
+	}
+	if config.Append != nil {
+		opts.FileAppend = *config.Append
+	}
+
+	switch config.Type {
+	case "stream":
+		switch config.Target {
+		case "", "stderr":
+			opts.Writer = os.Stderr
+		case "stdout":
+			opts.Writer = os.Stdout
+		default:
+			return nil, fmt.Errorf("unknown target: %s", config.Target)
+		}
+	case "file", "watchedfile":
+		if len(config.File) == 0 {
+			return nil, fmt.Errorf("no file specified")
+		}
+		opts.FileName = config.File
+		opts.WatchFile = config.Type == "watchedfile"
+	default:
+		return nil, fmt.Errorf("unknown type: %q", config.Type)
+	}
+
+	var level Level
+	if len(config.Level) > 0 {
+		var err error
+		if level, err = ParseLevel(config.Level); err != nil {
+			return nil, err
+		}
+	}
+
+	handlers, err := configHandlers(opts)
+	if err != nil {
+		return nil, err
+	}
+	handlers[0].SetLevel(level)
+
+	return handlers[0], nil
+}
+
+// configLoggerName returns the name used for the logger in the config, i.e. "root" for the root logger.
+func configLoggerName(name string) string {
+	if len(name) == 0 {
+		return "root"
+	}
+	return name
+}
+
+// configLogger returns the logger named in the config, creating it as needed (loggersLock must be held).
+func configLogger(name string) *Logger {
+	logger := rootLogger
+	if name == "root" {
+		return logger
+	}
+	for _, part := range strings.Split(name, "/") {
+		logger = logger.getLogger(part)
+	}
+	return logger
+}
+
+// withoutHandlers returns the handlers, except those to be removed.
+func withoutHandlers(handlers []Handler, remove []Handler) []Handler {
+	kept := make([]Handler, 0, len(handlers))
+next:
+	for _, h := range handlers {
+		for _, r := range remove {
+			if h == r {
+				continue next
+			}
+		}
+		kept = append(kept, h)
+	}
+	return kept
+}
+
+// DumpConfig returns the current logger tree (levels, rate limits and handlers) and escalation rules
+// as a JSON config, e.g. for support bundles. Handlers installed by a config keep their names, others are
+// named by their type (e.g. "stream1"); handlers the config can't describe (e.g. a RelayHandler) get their
+// Go type as type, i.e. such a config describes, but can't recreate, them.
+func DumpConfig() ([]byte, error) {
+	loggersLock.RLock()
+	config := currentConfig()
+	loggersLock.RUnlock()
+
+	return json.MarshalIndent(config, "", "  ")
+}
+
+// currentConfig returns the config describing the logger tree (loggersLock must be held).
+func currentConfig() *Config {
+	config := &Config{
+		Handlers: map[string]HandlerConfig{},
+		Loggers:  map[string]LoggerConfig{},
+	}
+
+	// the names of the handlers (by pointer address), initially those installed by the config
+	names := map[string]string{}
+	if configState.config != nil {
+		for name, logger := range configState.config.Loggers {
+			installed := configState.handlers[configLoggerName(name)]
+			for idx, handlerName := range logger.Handlers {
+				if idx < len(installed) {
+					names[fmt.Sprintf("%p", installed[idx])] = handlerName
+				}
+			}
+		}
+	}
+	handlerName := func(h Handler, handlerConfig HandlerConfig) string {
+		key := fmt.Sprintf("%p", h)
+		if name, exists := names[key]; exists {
+			return name
+		}
+		kind := handlerConfig.Type
+		if strings.ContainsAny(kind, "*.") {
+			kind = "handler"
+		}
+		for n := 1; ; n++ {
+			name := fmt.Sprintf("%s%d", kind, n)
+			if _, exists := config.Handlers[name]; !exists {
+				names[key] = name
+				return name
+			}
+		}
+	}
+
+	var walk func(l *Logger)
+	walk = func(l *Logger) {
+		name := configLoggerName(l.name)
+
+		var logger LoggerConfig
+		if l.level != INHERIT {
+			if l == rootLogger {
+				config.Level = LevelName(l.level)
+			} else {
+				logger.Level = LevelName(l.level)
+			}
+		}
+		if limiter, _ := l.rateLimiter.Load().(*rateLimiter); limiter != nil {
+			logger.RateLimit = &RateLimitConfig{Rate: limiter.limit.Rate, Burst: limiter.limit.Burst}
+			if limiter.limit.Exempt != INHERIT {
+				logger.RateLimit.Exempt = LevelName(limiter.limit.Exempt)
+			}
+		}
+		for _, h := range l.ownHandlers() {
+			handlerConfig := handlerDescription(h)
+			name := handlerName(h, handlerConfig)
+			config.Handlers[name] = handlerConfig
+			logger.Handlers = append(logger.Handlers, name)
+		}
+		if len(logger.Level) > 0 || logger.RateLimit != nil || len(logger.Handlers) > 0 {
+			config.Loggers[name] = logger
+		}
+
+		for _, child := range l.children {
+			walk(child)
+		}
+	}
+	if rootLogger != nil {
+		walk(rootLogger)
+	}
+
+	rules, _ := escalationRules.Load().([]EscalationRule)
+	for _, rule := range rules {
+		escalation := EscalationConfig{Logger: rule.LoggerGlob, Tags: rule.Tags, Level: LevelName(rule.Level)}
+		if rule.MatchRegex != nil {
+			escalation.Match = rule.MatchRegex.String()
+		}
+		config.Escalations = append(config.Escalations, escalation)
+	}
+
+	return config
+}
+
+// handlerDescription returns the config describing the handler (see DumpConfig).
+func handlerDescription(h Handler) HandlerConfig {
+	config := HandlerConfig{Type: fmt.Sprintf("%T", h)}
+	if level := h.Level(); level != INHERIT {
+		config.Level = LevelName(level)
+	}
+	if tf, ok := h.Formatter().(*TemplateFormatter); ok {
+		config.Format = tf.GetFormat()
+		config.Color = tf.levelColoring != nil && atomic.LoadInt32(&tf.colorDisabled) == 0
+	}
+
+	var stream *StreamHandler
+	switch h := h.(type) {
+	case *StreamHandler:
+		stream = h
+	case *WatchedFileHandler:
+		stream = h.StreamHandler
+	default:
+		return config
+	}
+	config.Sync = stream.sync
+
+	switch w := stream.writer.(type) {
+	case *fileWriter:
+		config.Type, config.File = "file", w.template
+		if w.watch {
+			config.Type = "watchedfile"
+		}
+		appendFile := !w.truncate
+		config.Append = &appendFile
+	default:
+		if w == io.Writer(os.Stderr) {
+			config.Type, config.Target = "stream", "stderr"
+		} else if w == io.Writer(os.Stdout) {
+			config.Type, config.Target = "stream", "stdout"
+		}
+	}
+	return config
+}
+
+// ConfigWatcher reloads a config file when it changes, see WatchConfig.
+type ConfigWatcher struct {
+	path     string
+	interval time.Duration
+	stop     chan struct{}
+	done     chan struct{}
+}
+
+// WatchConfig loads the config file (see LoadConfig), then checks it for changes every interval
+// (default 2 seconds), applying the changed config. A record describing the changes is logged (at INFO level)
+// by the "log4go" logger, or why the config couldn't be applied (at ERROR level).
+func WatchConfig(path string, interval ...time.Duration) (*ConfigWatcher, error) {
+	w := &ConfigWatcher{
+		path:     path,
+		interval: 2 * time.Second,
+		stop:     make(chan struct{}),
+		done:     make(chan struct{}),
+	}
+	if len(interval) > 0 && interval[0] > 0 {
+		w.interval = interval[0]
+	}
+
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, err
+	}
+	if err := LoadConfig(path); err != nil {
+		return nil, err
+	}
+
+	go w.watch(info)
+
+	return w, nil
+}
+
+// Stop stops watching the file.
+func (w *ConfigWatcher) Stop() {
+	select {
+	case <-w.stop:
+	default:
+		close(w.stop)
+	}
+	<-w.done
+}
+
+func (w *ConfigWatcher) watch(loaded os.FileInfo) {
+	defer close(w.done)
+
+	ticker := time.NewTicker(w.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-w.stop:
+			return
+		case <-ticker.C:
+		}
+
+		info, err := os.Stat(w.path)
+		if err != nil || (info.ModTime().Equal(loaded.ModTime()) && info.Size() == loaded.Size()) {
+			continue // e.g. while the file is being replaced
+		}
+		loaded = info
+
+		w.reload()
+	}
+}
+
+// reload applies the config file, logging the outcome.
+func (w *ConfigWatcher) reload() {
+	log := GetLogger("log4go")
+
+	config, err := readConfig(w.path)
+	if err != nil {
+		log.Error("config %s not applied: %v", w.path, err)
+		return
+	}
+	changes, err := applyConfig(config)
+	if err != nil {
+		log.Error("config %s not applied: %v", w.path, err)
+		return
+	}
+
+	if len(changes) == 0 {
+		changes = []string{"no changes"}
+	}
+	log.Info("config %s applied: %s", w.path, strings.Join(changes, ", "))
+}
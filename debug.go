@@ -0,0 +1,133 @@
+package log4go
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+)
+
+// LoggerState describes a logger and its descendants, as returned by State.
+type LoggerState struct {
+	Name string `json:"name"`
+	// Level is the logger's own level (INHERIT if not set), EffectiveLevel the one used.
+	Level          string `json:"level"`
+	EffectiveLevel string `json:"effective_level"`
+	// Disabled is set if the logger itself is disabled (see Logger.Disable); its descendants' effective level is OFF.
+	Disabled bool           `json:"disabled,omitempty"`
+	Handlers []HandlerState `json:"handlers,omitempty"`
+	Children []LoggerState  `json:"children,omitempty"`
+}
+
+// HandlerState describes a handler, as returned by State.
+// The queue and health fields are only reported by handlers supporting them (e.g. StreamHandler).
+type HandlerState struct {
+	Type        string `json:"type"`
+	Level       string `json:"level"`
+	Pending     int    `json:"pending"`
+	Healthy     bool   `json:"healthy"`
+	WriteErrors uint64 `json:"write_errors,omitempty"`
+	Dropped     uint64 `json:"dropped,omitempty"`
+	Reopens     uint64 `json:"reopens,omitempty"`
+	LastError   string `json:"last_error,omitempty"`
+}
+
+// State returns the state of the logger tree, for inspection in production.
+// It may also be published using expvar:
+//
+//	expvar.Publish("log4go", expvar.Func(func() interface{} { return log4go.State() }))
+func State() LoggerState {
+	loggersLock.RLock()
+	defer loggersLock.RUnlock()
+
+	if rootLogger == nil {
+		return LoggerState{Name: "root", Level: LevelName(INHERIT), EffectiveLevel: LevelName(WARNING)}
+	}
+	return rootLogger.state()
+}
+
+// state returns the state of the logger and its descendants (loggersLock must be held).
+func (l *Logger) state() LoggerState {
+	name := l.name
+	if len(name) == 0 {
+		name = "root"
+	}
+
+	state := LoggerState{
+		Name:           name,
+		Level:          LevelName(l.level),
+		EffectiveLevel: LevelName(l.Level()),
+		Disabled:       l.disabled,
+	}
+	for _, h := range l.ownHandlers() {
+		state.Handlers = append(state.Handlers, handlerState(h))
+	}
+	for _, child := range l.children {
+		state.Children = append(state.Children, child.state())
+	}
+
+	return state
+}
+
+func handlerState(h Handler) HandlerState {
+	state := HandlerState{
+		Type:    fmt.Sprintf("%T", h),
+		Level:   LevelName(h.Level()),
+		Healthy: true,
+	}
+	if p, ok := h.(interface{ Pending() int }); ok {
+		state.Pending = p.Pending()
+	}
+	if hr, ok := h.(interface{ Health() HandlerHealth }); ok {
+		health := hr.Health()
+		state.Healthy = health.Healthy
+		state.WriteErrors = health.WriteErrors
+		state.Dropped = health.Dropped
+		state.Reopens = health.Reopens
+		if health.LastError != nil {
+			state.LastError = health.LastError.Error()
+		}
+	}
+
+	return state
+}
+
+// DebugHandler returns an http.Handler writing State() as JSON, e.g.:
+//
+//	http.Handle("/debug/log4go", log4go.DebugHandler())
+//
+// POST requests with the form values "logger" (a full name, or "root") and "enabled" ("true" or "false")
+// enable or disable the logger (see Logger.Disable) first, e.g. to mute a noisy subtree in production;
+// unknown loggers are answered with 404 Not Found.
+// As this changes the logging, the endpoint should not be exposed publicly.
+func DebugHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if req.Method == http.MethodPost {
+			name := req.FormValue("logger")
+			enabled, err := strconv.ParseBool(req.FormValue("enabled"))
+			if len(name) == 0 || err != nil {
+				http.Error(w, "expected logger and enabled (true or false)", http.StatusBadRequest)
+				return
+			}
+			if name == "root" {
+				name = ""
+			}
+			// not creating loggers, i.e. not letting requests grow the logger tree
+			logger, exists := closestLogger(name)
+			if !exists {
+				http.Error(w, "unknown logger: "+name, http.StatusNotFound)
+				return
+			}
+			if enabled {
+				logger.Enable()
+			} else {
+				logger.Disable()
+			}
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		encoder := json.NewEncoder(w)
+		encoder.SetIndent("", "  ")
+		encoder.Encode(State())
+	})
+}
@@ -0,0 +1,181 @@
+package log4go
+
+import (
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+// ndcField is the field the nested diagnostic context is added as (see PushNDC).
+const ndcField = "ndc"
+
+// goroutineContext is the diagnostic context of a goroutine (see MDC and PushNDC).
+type goroutineContext struct {
+	mdc Fields
+	ndc []string
+}
+
+// diagnostics are the diagnostic contexts by goroutine ID (contents guarded by diagnosticsLock too).
+var diagnostics = map[uint64]*goroutineContext{}
+var diagnosticsLock sync.RWMutex
+var diagnosticsCount int32 // len(diagnostics), accessed atomically; records don't look up the goroutine when 0
+
+// MappedContext is the mapped diagnostic context (MDC) of a goroutine: fields added to all records it logs,
+// as in log4j. The logger's fields (see With) take precedence.
+//
+// Go doesn't expose goroutine IDs, so looking them up costs about a microsecond per record, while any goroutine
+// has a diagnostic context. Goroutines must clear their context when done (e.g. deferred), or it leaks.
+type MappedContext struct {
+	goroutine uint64
+}
+
+// MDC returns the mapped diagnostic context of the calling goroutine.
+func MDC() MappedContext {
+	return MappedContext{goroutineID()}
+}
+
+// Set sets the value of the key.
+func (m MappedContext) Set(key string, value interface{}) {
+	diagnosticsLock.Lock()
+	defer diagnosticsLock.Unlock()
+
+	ctx := goroutineContextLocked(m.goroutine)
+	// replaced, not modified (records might refer to it)
+	mdc := make(Fields, len(ctx.mdc)+1)
+	for k, v := range ctx.mdc {
+		mdc[k] = v
+	}
+	mdc[key] = value
+	ctx.mdc = mdc
+}
+
+// Get returns the value of the key (nil if not set).
+func (m MappedContext) Get(key string) interface{} {
+	diagnosticsLock.RLock()
+	defer diagnosticsLock.RUnlock()
+
+	if ctx := diagnostics[m.goroutine]; ctx != nil {
+		return ctx.mdc[key]
+	}
+	return nil
+}
+
+// Remove removes the key.
+func (m MappedContext) Remove(key string) {
+	diagnosticsLock.Lock()
+	defer diagnosticsLock.Unlock()
+
+	ctx := diagnostics[m.goroutine]
+	if ctx == nil {
+		return
+	}
+	if _, exists := ctx.mdc[key]; exists {
+		mdc := make(Fields, len(ctx.mdc))
+		for k, v := range ctx.mdc {
+			if k != key {
+				mdc[k] = v
+			}
+		}
+		ctx.mdc = mdc
+	}
+	removeIfEmptyLocked(m.goroutine, ctx)
+}
+
+// Clear removes all keys.
+func (m MappedContext) Clear() {
+	diagnosticsLock.Lock()
+	defer diagnosticsLock.Unlock()
+
+	if ctx := diagnostics[m.goroutine]; ctx != nil {
+		ctx.mdc = nil
+		removeIfEmptyLocked(m.goroutine, ctx)
+	}
+}
+
+// PushNDC pushes a message onto the nested diagnostic context (NDC) of the calling goroutine, as in log4j;
+// the messages are added to all records it logs, as the field "ndc" (separated by spaces), e.g. "request 7 step 3".
+func PushNDC(message string) {
+	goroutine := goroutineID()
+
+	diagnosticsLock.Lock()
+	defer diagnosticsLock.Unlock()
+
+	ctx := goroutineContextLocked(goroutine)
+	ctx.ndc = append(ctx.ndc[:len(ctx.ndc):len(ctx.ndc)], message)
+}
+
+// PopNDC removes the message last pushed onto the nested diagnostic context of the calling goroutine, returning it.
+func PopNDC() string {
+	goroutine := goroutineID()
+
+	diagnosticsLock.Lock()
+	defer diagnosticsLock.Unlock()
+
+	ctx := diagnostics[goroutine]
+	if ctx == nil || len(ctx.ndc) == 0 {
+		return ""
+	}
+	message := ctx.ndc[len(ctx.ndc)-1]
+	ctx.ndc = ctx.ndc[:len(ctx.ndc)-1]
+	removeIfEmptyLocked(goroutine, ctx)
+	return message
+}
+
+// ClearNDC clears the nested diagnostic context of the calling goroutine.
+func ClearNDC() {
+	goroutine := goroutineID()
+
+	diagnosticsLock.Lock()
+	defer diagnosticsLock.Unlock()
+
+	if ctx := diagnostics[goroutine]; ctx != nil {
+		ctx.ndc = nil
+		removeIfEmptyLocked(goroutine, ctx)
+	}
+}
+
+// goroutineContextLocked returns the goroutine's context, creating it as needed (diagnosticsLock must be held).
+func goroutineContextLocked(goroutine uint64) *goroutineContext {
+	ctx := diagnostics[goroutine]
+	if ctx == nil {
+		ctx = &goroutineContext{}
+		diagnostics[goroutine] = ctx
+		atomic.StoreInt32(&diagnosticsCount, int32(len(diagnostics)))
+	}
+	return ctx
+}
+
+// removeIfEmptyLocked forgets the goroutine's context once empty (diagnosticsLock must be held).
+func removeIfEmptyLocked(goroutine uint64, ctx *goroutineContext) {
+	if len(ctx.mdc) == 0 && len(ctx.ndc) == 0 {
+		delete(diagnostics, goroutine)
+		atomic.StoreInt32(&diagnosticsCount, int32(len(diagnostics)))
+	}
+}
+
+// withDiagnostics returns the fields with the calling goroutine's diagnostic context added (if any).
+func withDiagnostics(fields Fields) Fields {
+	if atomic.LoadInt32(&diagnosticsCount) == 0 {
+		return fields
+	}
+	goroutine := goroutineID()
+
+	diagnosticsLock.RLock()
+	defer diagnosticsLock.RUnlock()
+
+	ctx := diagnostics[goroutine]
+	if ctx == nil {
+		return fields
+	}
+	merged := make(Fields, len(ctx.mdc)+len(fields)+1)
+	for key, value := range ctx.mdc {
+		merged[key] = value
+	}
+	if len(ctx.ndc) > 0 {
+		merged[ndcField] = strings.Join(ctx.ndc, " ")
+	}
+	for key, value := range fields {
+		merged[key] = value
+	}
+	return merged
+}
@@ -0,0 +1,86 @@
+package log4go
+
+import (
+	"errors"
+	"fmt"
+	"sync/atomic"
+)
+
+// ErrorChainEntry describes an error of an error chain (see ErrorChain).
+type ErrorChainEntry struct {
+	Message string `json:"message"`
+	Type    string `json:"type"`
+	// Details is the "%+v" rendering of errors implementing fmt.Formatter (e.g. including a stack trace),
+	// if different from the message.
+	Details string `json:"details,omitempty"`
+}
+
+// maxErrorChain limits the length of error chains, in case of a cyclic one.
+const maxErrorChain = 32
+
+// ErrorChain returns the error and the errors it wraps (see errors.Unwrap), outermost first.
+func ErrorChain(err error) []ErrorChainEntry {
+	var chain []ErrorChainEntry
+	for ; err != nil && len(chain) < maxErrorChain; err = errors.Unwrap(err) {
+		entry := ErrorChainEntry{
+			Message: err.Error(),
+			Type:    fmt.Sprintf("%T", err),
+		}
+		if _, ok := err.(fmt.Formatter); ok {
+			if details := fmt.Sprintf("%+v", err); details != entry.Message {
+				entry.Details = details
+			}
+		}
+		chain = append(chain, entry)
+	}
+	return chain
+}
+
+// formatErrorChain renders the error with its chain, the causes (and details) on the following lines.
+func formatErrorChain(err error) string {
+	chain := ErrorChain(err)
+
+	s := chain[0].Message
+	for _, cause := range chain[1:] {
+		s += fmt.Sprintf("\ncaused by: %s (%s)", cause.Message, cause.Type)
+	}
+	for _, entry := range chain {
+		if len(entry.Details) > 0 {
+			s += "\n" + entry.Details
+			break
+		}
+	}
+	return s
+}
+
+// errorFieldsEnabled is set by EnableErrorFields, accessed atomically.
+var errorFieldsEnabled int32
+
+// EnableErrorFields makes the first error among the arguments of a logging call (e.g. err in
+// log.Error("saving failed: %v", err)) be added to the record as the field "error" as well, unless the
+// logger has such a field; false to disable (the default). Structured formatters thus get the error
+// (e.g. error.type and error.chain of ECSFormatter) without changing the logging calls.
+func EnableErrorFields(enable bool) {
+	var value int32
+	if enable {
+		value = 1
+	}
+	atomic.StoreInt32(&errorFieldsEnabled, value)
+}
+
+func errorFields() bool {
+	return atomic.LoadInt32(&errorFieldsEnabled) != 0
+}
+
+// withErrorField returns the fields with the first error of the arguments added as "error" (if any, and not set).
+func withErrorField(fields Fields, args []interface{}) Fields {
+	if _, exists := fields["error"]; exists {
+		return fields
+	}
+	for _, arg := range args {
+		if err, ok := arg.(error); ok && err != nil {
+			return fields.merged(Args{"error": err})
+		}
+	}
+	return fields
+}
@@ -0,0 +1,51 @@
+package log4go
+
+import (
+	"regexp"
+	"sync/atomic"
+)
+
+// EscalationRule raises the level of the records matching all of its (set) criteria, e.g. to treat timeouts
+// of the payments loggers as errors, tuning routing and alerting without changing the code logging them.
+type EscalationRule struct {
+	// LoggerGlob matches the logger names, as Route.LoggerGlob does. Empty matches all loggers.
+	LoggerGlob string
+	// MatchRegex matches the messages, if set.
+	MatchRegex *regexp.Regexp
+	// Tags matches the records having (at least) one of the tags, if set.
+	Tags []string
+	// Level is the level the records are escalated to; records of higher levels are left as they are.
+	Level Level
+}
+
+// escalationRules are the rules set by SetEscalationRules ([]EscalationRule, replaced on change).
+var escalationRules atomic.Value
+
+// SetEscalationRules replaces the escalation rules (none to remove them); the highest level of the
+// matching rules applies. Only records passing the level of their logger are escalated, i.e. the logger
+// must already be enabled for e.g. the INFO records to escalate. Escalated records are rate limited by their
+// escalated level, and flush the staged records (see StageInfo) if escalated to ERROR or above.
+func SetEscalationRules(rules ...EscalationRule) {
+	escalationRules.Store(append([]EscalationRule(nil), rules...))
+}
+
+// escalating returns whether there are escalation rules.
+func escalating() bool {
+	rules, _ := escalationRules.Load().([]EscalationRule)
+	return len(rules) > 0
+}
+
+// escalate raises the level of the record, as the escalation rules say.
+func escalate(rec *Record) {
+	rules, _ := escalationRules.Load().([]EscalationRule)
+	for idx := range rules {
+		rule := &rules[idx]
+		if rule.Level <= rec.Level {
+			continue
+		}
+		route := Route{LoggerGlob: rule.LoggerGlob, MatchRegex: rule.MatchRegex, Tags: rule.Tags}
+		if route.matches(rec) {
+			rec.Level = rule.Level
+		}
+	}
+}
@@ -0,0 +1,93 @@
+package log4go
+
+import (
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// exitHooks are the functions run before exiting by Fatal (and Crash), in order of registration.
+var exitHooks []func()
+var exitTimeout = 5 * time.Second
+var exitLock sync.Mutex // guards exitHooks and exitTimeout
+
+// osExit is os.Exit, replaced by tests.
+var osExit = os.Exit
+
+// exitingLock is held while exiting, so concurrent exits wait for the process to exit.
+var exitingLock sync.Mutex
+
+// hooksGoroutine is the ID of the goroutine running the exit hooks, accessed atomically.
+var hooksGoroutine uint64
+
+// nestedExit aborts an exit hook exiting itself (e.g. by logging at FATAL), recovered by runExitHook.
+type nestedExit struct{}
+
+// OnExit registers a function to run before Fatal (and its variants, and Crash with an exit code) exits,
+// e.g. to release database connections or file locks. The functions are run in order of registration,
+// before the handlers are shut down (so they may still log).
+func OnExit(hook func()) {
+	exitLock.Lock()
+	defer exitLock.Unlock()
+
+	exitHooks = append(exitHooks, hook)
+}
+
+// SetExitTimeout sets the time the functions registered by OnExit get to complete (default 5 seconds),
+// after which the process exits anyway.
+func SetExitTimeout(timeout time.Duration) {
+	exitLock.Lock()
+	defer exitLock.Unlock()
+
+	exitTimeout = timeout
+}
+
+// exit runs the exit hooks, shuts down the handlers, then exits the process with the code.
+// A hook exiting (e.g. logging at FATAL) is aborted, rather than running the hooks again.
+func exit(code int) {
+	if id := atomic.LoadUint64(&hooksGoroutine); id != 0 && id == goroutineID() {
+		panic(nestedExit{})
+	}
+	exitingLock.Lock()
+	defer exitingLock.Unlock() // osExit only returns in tests
+
+	exitLock.Lock()
+	hooks := exitHooks
+	timeout := exitTimeout
+	exitLock.Unlock()
+
+	if len(hooks) > 0 {
+		done := make(chan struct{})
+		go func() {
+			id := goroutineID()
+			atomic.StoreUint64(&hooksGoroutine, id)
+			defer atomic.CompareAndSwapUint64(&hooksGoroutine, id, 0)
+			defer close(done)
+			for _, hook := range hooks {
+				runExitHook(hook)
+			}
+		}()
+
+		select {
+		case <-done:
+		case <-time.After(timeout):
+			report(ERROR, "OnExit", "exit hooks didn't complete within %v", timeout)
+		}
+	}
+
+	// the reports queued for the internal handler are passed on before its handler is shut down
+	SetInternalHandler(nil)
+	Shutdown()
+	osExit(code)
+}
+
+// runExitHook runs the hook, reporting (rather than propagating) a panic, so the following hooks still run.
+func runExitHook(hook func()) {
+	defer func() {
+		if err := recover(); err != nil && err != (nestedExit{}) {
+			report(ERROR, "OnExit", "exit hook panicked: %v", err)
+		}
+	}()
+	hook()
+}
@@ -0,0 +1,183 @@
+package log4go
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Fields are structured key/value pairs attached to records.
+// Once attached to a record, fields must not be modified.
+type Fields map[string]interface{}
+
+// With returns a logger adding the fields to every record it emits.
+// The returned logger is part of the same tree: it uses the level and handlers of
+// the logger it was derived from (which it also forwards any configuration changes to).
+// Fields of an already derived logger are kept, unless overridden by the same key.
+func (l *Logger) With(fields Fields) *Logger {
+	merged := make(Fields, len(l.fields)+len(fields))
+	for key, value := range l.fields {
+		merged[key] = value
+	}
+	for key, value := range fields {
+		merged[key] = value
+	}
+
+	derived := l.derive()
+	derived.fields = merged
+	return derived
+}
+
+// Tagged returns a logger adding the tags (to those of this logger) to its records, e.g. log.Tagged("db", "slow"),
+// classifying them independently of the logger names (e.g. security, billing or slow queries).
+// The tags may be matched by routes (see Route.Tags) and are rendered by the formatters.
+func (l *Logger) Tagged(tags ...string) *Logger {
+	merged := make([]string, 0, len(l.tags)+len(tags))
+	merged = append(merged, l.tags...)
+	for _, tag := range tags {
+		if !containsString(merged, tag) {
+			merged = append(merged, tag)
+		}
+	}
+
+	derived := l.derive()
+	derived.tags = merged
+	return derived
+}
+
+func containsString(list []string, s string) bool {
+	for _, item := range list {
+		if item == s {
+			return true
+		}
+	}
+	return false
+}
+
+// Tags returns the tags added by this logger (see Tagged), must not be modified.
+func (l *Logger) Tags() []string {
+	return l.tags
+}
+
+// WithColor returns a logger whose records are colored (by formatters with coloring enabled) using the color,
+// rather than by level, e.g. log.WithColor(color.Green).Info("deployment complete") to highlight a milestone.
+func (l *Logger) WithColor(color string) *Logger {
+	derived := l.derive()
+	derived.color = color
+	return derived
+}
+
+// WithCallerSkip returns a logger skipping n more frames when determining the caller of the logging functions
+// (see EnableCallers, and also source levels and stack captures), for helper packages wrapping log4go to report
+// the call sites of their callers rather than their own; e.g. 1 for a helper function calling the logger directly.
+func (l *Logger) WithCallerSkip(n int) *Logger {
+	derived := l.derive()
+	derived.callerSkip += n
+	return derived
+}
+
+// Fields returns the fields added by this logger (see With), must not be modified.
+func (l *Logger) Fields() Fields {
+	return l.fields
+}
+
+// derive returns a copy of the logger's per-record attributes, bound to the same tree node.
+func (l *Logger) derive() *Logger {
+	return l.deriveAt(l.node())
+}
+
+// deriveAt returns a copy of the logger's per-record attributes, bound to the tree node.
+func (l *Logger) deriveAt(node *Logger) *Logger {
+	return &Logger{
+		name:     node.name,
+		base:     node,
+		fields:   l.fields,
+		stack:    l.stack,
+		captures: l.captures,
+		color:    l.color,
+		tags:     l.tags,
+
+		callerSkip: l.callerSkip,
+	}
+}
+
+// node returns the logger in the tree that this logger represents (itself, unless derived).
+func (l *Logger) node() *Logger {
+	if l.base != nil {
+		return l.base
+	}
+	return l
+}
+
+// Args are named values for the placeholders of a message template (instead of Printf-style arguments), e.g.:
+//
+//	log.Info("user {user} logged in from {ip}", log4go.Args{"user": u, "ip": ip})
+//
+// The values are rendered into the message (placeholders without a value are kept as they are),
+// and also added to the record's fields. Lazy values are evaluated (once).
+type Args map[string]interface{}
+
+// namedArgs returns the Args, if that's the only argument.
+func namedArgs(args []interface{}) (Args, bool) {
+	if len(args) != 1 {
+		return nil, false
+	}
+	named, ok := args[0].(Args)
+	return named, ok
+}
+
+// formatNamed renders the message template, returning the (evaluated) values as well.
+// A panic (e.g. in a lazy value) is recovered and the message describes the failure instead.
+func formatNamed(message string, named Args) (formatted string, values Args) {
+	defer func() {
+		if err := recover(); err != nil {
+			formatted = formatError(err, message, []interface{}{named})
+		}
+	}()
+
+	values = make(Args, len(named))
+	for key, value := range named {
+		switch fn := value.(type) {
+		case Lazy:
+			value = fn()
+		case func() interface{}:
+			value = fn()
+		}
+		values[key] = value
+	}
+
+	var buf strings.Builder
+	for {
+		start := strings.IndexByte(message, '{')
+		if start < 0 {
+			break
+		}
+		end := strings.IndexByte(message[start:], '}')
+		if end < 0 {
+			break
+		}
+		end += start
+
+		buf.WriteString(message[:start])
+		if value, exists := values[message[start+1:end]]; exists {
+			fmt.Fprint(&buf, value)
+		} else {
+			buf.WriteString(message[start : end+1])
+		}
+		message = message[end+1:]
+	}
+	buf.WriteString(message)
+
+	return buf.String(), values
+}
+
+// merged returns the fields with the values added (overriding fields with the same key).
+func (f Fields) merged(values Args) Fields {
+	merged := make(Fields, len(f)+len(values))
+	for key, value := range f {
+		merged[key] = value
+	}
+	for key, value := range values {
+		merged[key] = value
+	}
+	return merged
+}
@@ -0,0 +1,315 @@
+package log4go
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// RetryPolicy controls reopening a file after a write error (e.g. disk full, file deleted or an NFS hiccup).
+// Until the file has been reopened, records are dropped (and counted, see HandlerHealth).
+type RetryPolicy struct {
+	// InitialBackoff is the time to wait before the first attempt to reopen the file (default 100ms).
+	InitialBackoff time.Duration
+	// MaxBackoff limits the time between attempts, doubled after each failed one (default 1 minute).
+	MaxBackoff time.Duration
+}
+
+// HandlerHealth is the health status of a handler (see StreamHandler.Health).
+type HandlerHealth struct {
+	// Healthy is false while the handler is unable to write, e.g. waiting to reopen its file.
+	Healthy bool
+	// WriteErrors is the number of failed writes.
+	WriteErrors uint64
+	// Dropped is the number of records dropped while unable to write.
+	Dropped uint64
+	// Reopens is the number of times the file was reopened after write errors.
+	Reopens uint64
+	// LastError is the most recent write (or reopen) error.
+	LastError error
+}
+
+// fileWriter writes to a file, reopening it (with exponential backoff) after write errors,
+// or if it has been moved (e.g. by log rotation), if watched.
+// Writes are made by the committer goroutine only; the lock protects the status.
+type fileWriter struct {
+	template string // the file name, with any variables (see expandFileName)
+	filename string // the template resolved when the file was opened
+	flags    int
+	truncate bool // whether the file was truncated when opened (see DumpConfig)
+	lock     bool
+	watch    bool
+	header   bool
+	retry    RetryPolicy
+
+	fp      *os.File // nil while waiting to reopen
+	backoff time.Duration
+	retryAt time.Time
+
+	statusLock sync.Mutex
+	status     HandlerHealth
+}
+
+func newFileWriter(filename string, flags int, opts FileOpts) (*fileWriter, error) {
+	if opts.Retry.InitialBackoff <= 0 {
+		opts.Retry.InitialBackoff = 100 * time.Millisecond
+	}
+	if opts.Retry.MaxBackoff <= 0 {
+		opts.Retry.MaxBackoff = time.Minute
+	}
+
+	if _, err := expandFileName(filename, time.Now()); err != nil {
+		return nil, err
+	}
+
+	w := &fileWriter{
+		template: filename,
+		filename: filename,
+		truncate: flags&os.O_TRUNC != 0,
+		lock:     opts.Lock,
+		watch:    opts.watch,
+		header:   opts.Header,
+		retry:    opts.Retry,
+		status:   HandlerHealth{Healthy: true},
+	}
+	if err := w.open(flags); err != nil {
+		return nil, fileError("open", w.filename, err)
+	}
+	if opts.TestWrite {
+		// an empty write still checks the descriptor is writable, and syncing reaches the storage
+		_, err := w.fp.Write(nil)
+		if err == nil {
+			err = w.fp.Sync()
+		}
+		if err != nil {
+			w.fp.Close()
+			return nil, fileError("write", w.filename, err)
+		}
+	}
+	// when reopening, don't truncate what was written before
+	w.flags = flags&^os.O_TRUNC | os.O_APPEND
+
+	return w, nil
+}
+
+// fileError describes a failure to open (or write) a log file, including the likely cause;
+// the original error (e.g. an *os.PathError) is wrapped.
+func fileError(op, filename string, err error) error {
+	hint := ""
+	switch {
+	case os.IsNotExist(err):
+		if _, statErr := os.Stat(filepath.Dir(filename)); statErr != nil {
+			hint = fmt.Sprintf(" (directory %s doesn't exist)", filepath.Dir(filename))
+		}
+	case os.IsPermission(err):
+		hint = fmt.Sprintf(" (permission denied for uid %d)", os.Getuid())
+	}
+	return fmt.Errorf("log4go: cannot %s log file %s%s: %w", op, filename, hint, err)
+}
+
+// open opens the file (resolving the name's variables), and writes the header (if enabled).
+func (w *fileWriter) open(flags int) error {
+	w.filename, _ = expandFileName(w.template, time.Now())
+	fp, err := os.OpenFile(w.filename, flags, 0664)
+	if err != nil {
+		return err
+	}
+	w.fp = fp
+
+	if w.header {
+		if _, err := w.write([]byte(fileHeader() + "\n")); err != nil {
+			fp.Close()
+			w.fp = nil
+			return err
+		}
+	}
+	return nil
+}
+
+// fileNameVariables are the variables of file name templates, e.g. "app-{hostname}-{pid}-{date}.log".
+var fileNameVariables = map[string]func(now time.Time) string{
+	"hostname": func(time.Time) string { return hostname() },
+	"pid":      func(time.Time) string { return strconv.Itoa(os.Getpid()) },
+	"program":  func(time.Time) string { return filepath.Base(os.Args[0]) },
+	"date":     func(now time.Time) string { return now.Format("2006-01-02") },
+	"time":     func(now time.Time) string { return now.Format("150405") },
+}
+
+var fileNamePtn = regexp.MustCompile(`\{([a-z]+)\}`)
+
+// expandFileName returns the file name with the variables of the template resolved,
+// or an error if it has unknown variables.
+func expandFileName(template string, now time.Time) (string, error) {
+	if strings.IndexByte(template, '{') < 0 {
+		return template, nil
+	}
+	var err error
+	filename := fileNamePtn.ReplaceAllStringFunc(template, func(variable string) string {
+		if value, exists := fileNameVariables[variable[1:len(variable)-1]]; exists {
+			return value(now)
+		}
+		err = fmt.Errorf("log4go: unknown variable in log file name %s: %s", template, variable)
+		return variable
+	})
+	return filename, err
+}
+
+var hostnameOnce sync.Once
+var cachedHostname string
+
+// hostname returns the host name (looked up once).
+func hostname() string {
+	hostnameOnce.Do(func() {
+		cachedHostname, _ = os.Hostname()
+	})
+	return cachedHostname
+}
+
+// fileHeader returns the header line describing the process, written at the top of each (opened) file.
+func fileHeader() string {
+	hostname, _ := os.Hostname()
+
+	header := fmt.Sprintf("# opened=%s started=%s pid=%d host=%s",
+		time.Now().Format(time.RFC3339), processStart.Format(time.RFC3339), os.Getpid(), hostname)
+	if len(buildInfo.Version) > 0 {
+		header += " version=" + buildInfo.Version
+	}
+	if len(buildInfo.Revision) > 0 {
+		header += " revision=" + buildInfo.Revision
+	}
+	return header + fmt.Sprintf(" cmd=%q", strings.Join(os.Args, " "))
+}
+
+// moved returns whether the file has been moved or removed, since it was opened
+// (or whether its name now resolves differently, e.g. on a new date).
+func (w *fileWriter) moved() bool {
+	if w.template != w.filename {
+		if filename, _ := expandFileName(w.template, time.Now()); filename != w.filename {
+			return true
+		}
+	}
+	current, err := os.Stat(w.filename)
+	if err != nil {
+		return true
+	}
+	opened, err := w.fp.Stat()
+	return err != nil || !os.SameFile(current, opened)
+}
+
+// Write writes to the file, or drops the data if the file could not be reopened (yet).
+// Only the write error closing the file is reported, to not flood stderr with the same error.
+func (w *fileWriter) Write(p []byte) (int, error) {
+	if w.fp != nil && w.watch && w.moved() {
+		w.fp.Close()
+		if err := w.open(w.flags); err != nil {
+			report(ERROR, "WatchedFileHandler", "failed to open moved file: %v", err)
+			w.failed(err)
+		}
+	}
+	if w.fp == nil && !w.reopen() {
+		w.updateStatus(func(status *HandlerHealth) { status.Dropped++ })
+		return 0, ErrDropped
+	}
+
+	n, err := w.write(p)
+	if err != nil {
+		w.fp.Close()
+		w.failed(err)
+		return n, fmt.Errorf("%v (reopening file in %v)", err, w.backoff)
+	}
+
+	return n, nil
+}
+
+// failed closes the file after an error, scheduling it to be reopened.
+func (w *fileWriter) failed(err error) {
+	w.fp = nil
+	w.backoff = w.retry.InitialBackoff
+	w.retryAt = time.Now().Add(w.backoff)
+
+	w.updateStatus(func(status *HandlerHealth) {
+		status.Healthy = false
+		status.WriteErrors++
+		status.LastError = err
+	})
+}
+
+func (w *fileWriter) write(p []byte) (int, error) {
+	if !w.lock {
+		return w.fp.Write(p)
+	}
+
+	fd := int(w.fp.Fd())
+	if err := syscall.Flock(fd, syscall.LOCK_EX); err != nil {
+		return 0, err
+	}
+	defer syscall.Flock(fd, syscall.LOCK_UN)
+
+	return w.fp.Write(p)
+}
+
+// reopen attempts to reopen the file, if it's time to, and returns whether it's open.
+func (w *fileWriter) reopen() bool {
+	now := time.Now()
+	if now.Before(w.retryAt) {
+		return false
+	}
+
+	if err := w.open(w.flags); err != nil {
+		w.backoff *= 2
+		if w.backoff > w.retry.MaxBackoff {
+			w.backoff = w.retry.MaxBackoff
+		}
+		w.retryAt = now.Add(w.backoff)
+
+		w.updateStatus(func(status *HandlerHealth) { status.LastError = err })
+		return false
+	}
+
+	var dropped uint64
+	w.updateStatus(func(status *HandlerHealth) {
+		status.Healthy = true
+		status.Reopens++
+		dropped = status.Dropped
+	})
+	report(INFO, "FileHandler", "reopened %s (%d records dropped in total)", w.filename, dropped)
+
+	return true
+}
+
+// Sync commits the file's contents to stable storage.
+func (w *fileWriter) Sync() error {
+	if w.fp == nil {
+		return nil
+	}
+	return w.fp.Sync()
+}
+
+// Close closes the file (if open).
+func (w *fileWriter) Close() error {
+	if w.fp == nil {
+		return nil
+	}
+	err := w.fp.Close()
+	w.fp = nil
+	return err
+}
+
+func (w *fileWriter) updateStatus(update func(status *HandlerHealth)) {
+	w.statusLock.Lock()
+	update(&w.status)
+	w.statusLock.Unlock()
+}
+
+func (w *fileWriter) health() HandlerHealth {
+	w.statusLock.Lock()
+	defer w.statusLock.Unlock()
+
+	return w.status
+}
@@ -0,0 +1,62 @@
+package log4go
+
+import "flag"
+
+// Flags holds the logging options set on the command line, see RegisterFlags.
+type Flags struct {
+	Level  Level
+	File   string
+	Format string
+	Color  bool
+}
+
+// RegisterFlags defines the flags -<prefix>-level, -<prefix>-file, -<prefix>-format and -<prefix>-color
+// (e.g. -log-level using the prefix "log") in the flag set (nil means flag.CommandLine).
+// After parsing, call BasicConfig on the returned Flags, giving CLIs consistent logging options.
+func RegisterFlags(fs *flag.FlagSet, prefix string) *Flags {
+	if fs == nil {
+		fs = flag.CommandLine
+	}
+	if len(prefix) > 0 {
+		prefix += "-"
+	}
+
+	f := &Flags{Level: WARNING, Format: defaultFormat}
+	fs.Var((*levelFlag)(&f.Level), prefix+"level", "logging `level`, e.g. DEBUG, INFO or WARNING")
+	fs.StringVar(&f.File, prefix+"file", "", "log to `file` (instead of stderr)")
+	fs.StringVar(&f.Format, prefix+"format", f.Format, "log line `template`")
+	fs.BoolVar(&f.Color, prefix+"color", false, "color log lines by level")
+
+	return f
+}
+
+// BasicConfig configures the logging system according to the flags (see BasicConfig).
+func (f *Flags) BasicConfig() error {
+	return BasicConfig(f.BasicConfigOpts())
+}
+
+// BasicConfigOpts returns the options corresponding to the flags, e.g. to be amended before calling BasicConfig.
+func (f *Flags) BasicConfigOpts() BasicConfigOpts {
+	return BasicConfigOpts{
+		Level:    f.Level,
+		FileName: f.File,
+		Format:   f.Format,
+		Color:    f.Color,
+	}
+}
+
+// levelFlag is a flag.Value parsing level names.
+type levelFlag Level
+
+func (l *levelFlag) String() string {
+	return LevelName(Level(*l))
+}
+
+func (l *levelFlag) Set(name string) error {
+	level, err := ParseLevel(name)
+	if err != nil {
+		return err
+	}
+	*l = levelFlag(level)
+	return nil
+}
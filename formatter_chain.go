@@ -0,0 +1,164 @@
+package log4go
+
+import (
+	"regexp"
+	"sync/atomic"
+	"unicode/utf8"
+)
+
+// Decorator transforms the output of a formatter (see Chain).
+type Decorator interface {
+	Decorate(rec *Record, formatted []byte) ([]byte, error)
+}
+
+// DecoratorFunc is a function implementing Decorator.
+type DecoratorFunc func(rec *Record, formatted []byte) ([]byte, error)
+
+// Decorate calls the function.
+func (f DecoratorFunc) Decorate(rec *Record, formatted []byte) ([]byte, error) {
+	return f(rec, formatted)
+}
+
+// ChainFormatter applies a number of decorators to the output of a base formatter.
+type ChainFormatter struct {
+	base       Formatter
+	decorators []Decorator
+
+	colorDisabled int32 // accessed atomically, see SetColorEnabled
+}
+
+// colorizer marks the decorators returned by LevelColorizer and PatternColorizer.
+type colorizer struct {
+	Decorator
+}
+
+// Chain returns a formatter applying the decorators, in order, to the output of base; e.g.
+//
+//	Chain(jsonFormatter, Redactor(secretPattern), Truncator(4096), LevelColorizer(nil))
+func Chain(base Formatter, decorators ...Decorator) *ChainFormatter {
+	return &ChainFormatter{
+		base:       base,
+		decorators: decorators,
+	}
+}
+
+// Format formats the record using the base formatter, then applies the decorators.
+func (f *ChainFormatter) Format(rec *Record) ([]byte, error) {
+	formatted, err := f.base.Format(rec)
+	if err != nil {
+		return nil, err
+	}
+	colorEnabled := atomic.LoadInt32(&f.colorDisabled) == 0
+	for _, decorator := range f.decorators {
+		if _, coloring := decorator.(colorizer); coloring && !colorEnabled {
+			continue
+		}
+		if formatted, err = decorator.Decorate(rec, formatted); err != nil {
+			return nil, err
+		}
+	}
+	return formatted, nil
+}
+
+// SetColorEnabled switches the coloring off (skipping the LevelColorizer and PatternColorizer decorators, and that of
+// the base formatter), or back on. It's safe to call while formatting.
+func (f *ChainFormatter) SetColorEnabled(enable bool) {
+	var disabled int32
+	if !enable {
+		disabled = 1
+	}
+	atomic.StoreInt32(&f.colorDisabled, disabled)
+	if toggler, ok := f.base.(ColorToggler); ok {
+		toggler.SetColorEnabled(enable)
+	}
+}
+
+// LevelColorizer returns a decorator coloring the whole output based on the record's level
+// (nil uses the same colors as TemplateFormatter.EnableLevelColoring).
+func LevelColorizer(levelToColors map[Level]string) Decorator {
+	if levelToColors == nil {
+		levelToColors = defaultLevelColoring
+	}
+	return colorizer{DecoratorFunc(func(rec *Record, formatted []byte) ([]byte, error) {
+		lineColor, exists := levelToColors[rec.Level]
+		if !exists {
+			return formatted, nil
+		}
+		out := make([]byte, 0, len(lineColor)+len(formatted)+len(colorReset))
+		out = append(out, lineColor...)
+		out = append(out, formatted...)
+		return append(out, colorReset...), nil
+	})}
+}
+
+// PatternColorizer returns a decorator coloring matching patterns of the output
+// (nil uses the same colors & patterns as TemplateFormatter.EnablePatternColoring).
+// Place it before any LevelColorizer, as it resets to the level's color after each match.
+func PatternColorizer(colors map[string]string, patterns []PatternColor) Decorator {
+	if colors == nil {
+		colors, patterns = defaultPatternColoring, defaultPatternColoringPatterns
+	}
+	process := makeProcessor(colors, patterns)
+	return colorizer{DecoratorFunc(func(rec *Record, formatted []byte) ([]byte, error) {
+		baseColor, exists := defaultLevelColoring[rec.Level]
+		if !exists {
+			baseColor = colorReset
+		}
+		return []byte(process(string(formatted), baseColor)), nil
+	})}
+}
+
+// Redacted replaces the parts of the output removed by Redactor.
+const Redacted = "[REDACTED]"
+
+// Redactor returns a decorator replacing all matches of the patterns with Redacted.
+// If a pattern has a sub-match, only (the first) sub-match is replaced, e.g. `password=(\S+)`.
+func Redactor(patterns ...*regexp.Regexp) Decorator {
+	return DecoratorFunc(func(rec *Record, formatted []byte) ([]byte, error) {
+		for _, ptn := range patterns {
+			if ptn.NumSubexp() == 0 {
+				formatted = ptn.ReplaceAll(formatted, []byte(Redacted))
+				continue
+			}
+			formatted = ptn.ReplaceAllFunc(formatted, func(match []byte) []byte {
+				loc := ptn.FindSubmatchIndex(match)
+				if loc[2] < 0 {
+					return match
+				}
+				out := make([]byte, 0, len(match))
+				out = append(out, match[:loc[2]]...)
+				out = append(out, Redacted...)
+				return append(out, match[loc[3]:]...)
+			})
+		}
+		return formatted, nil
+	})
+}
+
+// truncationMarker is appended to truncated output.
+const truncationMarker = "…"
+
+// Truncator returns a decorator truncating the output to (at most) maxLength bytes,
+// including a trailing marker; never splitting an UTF-8 character.
+func Truncator(maxLength int) Decorator {
+	return DecoratorFunc(func(rec *Record, formatted []byte) ([]byte, error) {
+		if len(formatted) <= maxLength {
+			return formatted, nil
+		}
+		return append(truncateUTF8(formatted, maxLength-len(truncationMarker)), truncationMarker...), nil
+	})
+}
+
+// truncateUTF8 returns (at most) the first n bytes of b, without splitting a character.
+func truncateUTF8(b []byte, n int) []byte {
+	if n <= 0 {
+		return b[:0]
+	}
+	if n >= len(b) {
+		return b
+	}
+	for n > 0 && !utf8.RuneStart(b[n]) {
+		n--
+	}
+	return b[:n]
+}
@@ -0,0 +1,91 @@
+package log4go
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+)
+
+// ecsVersion is the Elastic Common Schema version the output complies with.
+const ecsVersion = "1.6.0"
+
+// ECSFormatter formats records as Elastic Common Schema (ECS) compliant JSON objects,
+// which can be ingested by e.g. Filebeat without any custom pipelines.
+//
+// Record fields are added as-is (dotted keys, e.g. "user.id", are fine), except:
+//   - "error" (if an error value): rendered as error.message and error.type, and, if it wraps other errors,
+//     error.chain (see ErrorChain)
+//   - "trace_id" (or "trace.id"): rendered as trace.id
+type ECSFormatter struct{}
+
+// NewECSFormatter returns a new ECSFormatter.
+func NewECSFormatter() *ECSFormatter {
+	return &ECSFormatter{}
+}
+
+// Format returns the record as an ECS JSON object.
+func (f *ECSFormatter) Format(r *Record) ([]byte, error) {
+	doc := make(map[string]interface{}, 8+len(r.Fields))
+
+	for key, value := range r.Fields {
+		switch key {
+		case "error":
+			if err, ok := value.(error); ok {
+				doc["error.message"] = err.Error()
+				doc["error.type"] = fmt.Sprintf("%T", err)
+				if chain := ErrorChain(err); len(chain) > 1 || len(chain[0].Details) > 0 {
+					doc["error.chain"] = chain
+				}
+				continue
+			}
+		case "trace_id":
+			key = "trace.id"
+		}
+		doc[key] = value
+	}
+
+	name := r.Name
+	if len(name) == 0 {
+		name = "root"
+	}
+
+	doc["@timestamp"] = r.Time.UTC().Format("2006-01-02T15:04:05.000Z07:00")
+	doc["log.level"] = levelToECS(r.Level)
+	doc["log.logger"] = name
+	doc["message"] = r.Message
+	doc["ecs.version"] = ecsVersion
+	if len(r.Stack) > 0 {
+		doc["error.stack_trace"] = r.Stack
+	}
+	if len(r.ID) > 0 {
+		doc["event.id"] = r.ID
+	}
+	if r.Duration != 0 {
+		doc["event.duration"] = r.Duration.Nanoseconds()
+	}
+	if version := releaseVersion(); len(version) > 0 {
+		doc["service.version"] = version
+	}
+	if r.GoroutineID != 0 {
+		doc["process.thread.id"] = r.GoroutineID
+	}
+	if len(r.Tags) > 0 {
+		doc["tags"] = r.Tags
+	}
+	if len(r.File) > 0 {
+		doc["log.origin.file.name"] = r.File
+		doc["log.origin.file.line"] = r.Line
+	}
+
+	return json.Marshal(doc)
+}
+
+func levelToECS(l Level) string {
+	switch l {
+	case WARNING:
+		return "warn"
+	case INHERIT:
+		return "unknown"
+	}
+	return strings.ToLower(LevelName(l))
+}
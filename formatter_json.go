@@ -0,0 +1,195 @@
+package log4go
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"time"
+)
+
+// StructuredOpts is used to supply options to NewJSONFormatter and NewKeyValueFormatter,
+// for downstream parsers picky about key names and order.
+type StructuredOpts struct {
+	// TimeKey, LevelKey, NameKey and MessageKey are the keys of the record's time, level, logger name
+	// and message (default "time", "level", "logger" and "msg"); "-" omits them.
+	TimeKey    string
+	LevelKey   string
+	NameKey    string
+	MessageKey string
+	// TimeFormat is the layout of the time (default "2006-01-02T15:04:05.000Z07:00").
+	TimeFormat string
+	// EpochUnit renders the time as a number instead: the time since the Unix epoch in this unit,
+	// e.g. time.Millisecond (for pipelines wanting numeric time stamps).
+	EpochUnit time.Duration
+	// KeyOrder lists the keys output first, in that order; the others follow: the record's attributes
+	// (time, level, logger, msg, id, duration, caller, tags and stack), then its fields, sorted.
+	KeyOrder []string
+	// Only limits the output to the keys in KeyOrder.
+	Only bool
+	// OmitEmpty leaves out keys whose values are nil or empty (e.g. strings, slices and maps).
+	OmitEmpty bool
+}
+
+// structuredEntry is a key/value pair of a formatted record.
+type structuredEntry struct {
+	key   string
+	value interface{}
+}
+
+func (o *StructuredOpts) setDefaults() {
+	defaultKey := func(key *string, def string) {
+		if len(*key) == 0 {
+			*key = def
+		}
+	}
+	defaultKey(&o.TimeKey, "time")
+	defaultKey(&o.LevelKey, "level")
+	defaultKey(&o.NameKey, "logger")
+	defaultKey(&o.MessageKey, "msg")
+	defaultKey(&o.TimeFormat, "2006-01-02T15:04:05.000Z07:00")
+}
+
+// entries returns the key/value pairs of the record, in output order.
+func (o *StructuredOpts) entries(r *Record) []structuredEntry {
+	name := r.Name
+	if len(name) == 0 {
+		name = "root"
+	}
+
+	attributes := make([]structuredEntry, 0, 8+len(r.Fields))
+	add := func(key string, value interface{}) {
+		if key != "-" {
+			attributes = append(attributes, structuredEntry{key, value})
+		}
+	}
+	if o.EpochUnit > 0 {
+		add(o.TimeKey, r.Time.UnixNano()/int64(o.EpochUnit))
+	} else {
+		add(o.TimeKey, r.Time.Format(o.TimeFormat))
+	}
+	add(o.LevelKey, LevelName(r.Level))
+	add(o.NameKey, name)
+	add(o.MessageKey, r.Message)
+	if len(r.ID) > 0 {
+		add("id", r.ID)
+	}
+	if r.Duration != 0 {
+		add("duration", r.Duration.String())
+	}
+	if len(r.File) > 0 {
+		add("caller", fmt.Sprintf("%s:%d", filepath.Base(r.File), r.Line))
+	}
+	if len(r.Tags) > 0 {
+		add("tags", r.Tags)
+	}
+	if len(r.Stack) > 0 {
+		add("stack", r.Stack)
+	}
+
+	// the attributes take precedence over fields of the same name
+	fieldKeys := make([]string, 0, len(r.Fields))
+	for key := range r.Fields {
+		if !hasEntry(attributes, key) {
+			fieldKeys = append(fieldKeys, key)
+		}
+	}
+	sort.Strings(fieldKeys)
+	for _, key := range fieldKeys {
+		value := r.Fields[key]
+		if err, ok := value.(error); ok {
+			value = err.Error()
+		}
+		attributes = append(attributes, structuredEntry{key, value})
+	}
+
+	entries := make([]structuredEntry, 0, len(attributes))
+	for _, key := range o.KeyOrder {
+		for _, entry := range attributes {
+			if entry.key == key {
+				entries = append(entries, entry)
+				break
+			}
+		}
+	}
+	if !o.Only {
+		for _, entry := range attributes {
+			if !containsString(o.KeyOrder, entry.key) {
+				entries = append(entries, entry)
+			}
+		}
+	}
+
+	if o.OmitEmpty {
+		kept := entries[:0]
+		for _, entry := range entries {
+			if !isEmptyValue(entry.value) {
+				kept = append(kept, entry)
+			}
+		}
+		entries = kept
+	}
+	return entries
+}
+
+func hasEntry(entries []structuredEntry, key string) bool {
+	for _, entry := range entries {
+		if entry.key == key {
+			return true
+		}
+	}
+	return false
+}
+
+func isEmptyValue(value interface{}) bool {
+	if value == nil {
+		return true
+	}
+	v := reflect.ValueOf(value)
+	switch v.Kind() {
+	case reflect.String, reflect.Slice, reflect.Map, reflect.Array:
+		return v.Len() == 0
+	case reflect.Ptr, reflect.Interface:
+		return v.IsNil()
+	}
+	return false
+}
+
+// JSONFormatter formats records as JSON objects (one per line, when written by a handler),
+// the keys in a configurable order (see StructuredOpts).
+type JSONFormatter struct {
+	opts StructuredOpts
+}
+
+// NewJSONFormatter returns a new JSONFormatter.
+func NewJSONFormatter(opts ...StructuredOpts) *JSONFormatter {
+	f := &JSONFormatter{}
+	if len(opts) > 0 {
+		f.opts = opts[0]
+	}
+	f.opts.setDefaults()
+	return f
+}
+
+// Format returns the record as a JSON object.
+func (f *JSONFormatter) Format(r *Record) ([]byte, error) {
+	var buf bytes.Buffer
+	buf.WriteByte('{')
+	for idx, entry := range f.opts.entries(r) {
+		if idx > 0 {
+			buf.WriteByte(',')
+		}
+		key, _ := json.Marshal(entry.key)
+		value, err := json.Marshal(entry.value)
+		if err != nil {
+			return nil, err
+		}
+		buf.Write(key)
+		buf.WriteByte(':')
+		buf.Write(value)
+	}
+	buf.WriteByte('}')
+	return buf.Bytes(), nil
+}
@@ -0,0 +1,53 @@
+package log4go
+
+import (
+	"bytes"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// KeyValueFormatter formats records as key=value pairs separated by spaces (i.e. logfmt),
+// the keys in a configurable order (see StructuredOpts). Values containing spaces, quotes or
+// '=' (or empty ones) are quoted, as Go strings.
+type KeyValueFormatter struct {
+	opts StructuredOpts
+}
+
+// NewKeyValueFormatter returns a new KeyValueFormatter.
+func NewKeyValueFormatter(opts ...StructuredOpts) *KeyValueFormatter {
+	f := &KeyValueFormatter{}
+	if len(opts) > 0 {
+		f.opts = opts[0]
+	}
+	f.opts.setDefaults()
+	return f
+}
+
+// Format returns the record as key=value pairs.
+func (f *KeyValueFormatter) Format(r *Record) ([]byte, error) {
+	var buf bytes.Buffer
+	for idx, entry := range f.opts.entries(r) {
+		if idx > 0 {
+			buf.WriteByte(' ')
+		}
+		buf.WriteString(entry.key)
+		buf.WriteByte('=')
+
+		var value string
+		switch v := entry.value.(type) {
+		case nil:
+		case string:
+			value = v
+		case []string:
+			value = strings.Join(v, ",")
+		default:
+			value = fmt.Sprint(v)
+		}
+		if len(value) == 0 || strings.ContainsAny(value, " \t\r\n\"=") {
+			value = strconv.Quote(value)
+		}
+		buf.WriteString(value)
+	}
+	return buf.Bytes(), nil
+}
@@ -0,0 +1,179 @@
+package log4go
+
+import (
+	"bytes"
+	"fmt"
+	"sort"
+	"strings"
+	"sync/atomic"
+	"unicode/utf8"
+
+	"github.com/neonrust/log4go/color"
+)
+
+// PrettyOpts is used to supply options to NewPrettyFormatter.
+type PrettyOpts struct {
+	// ShowTime includes the time of day (e.g. "12:34:56") in each line.
+	ShowTime bool
+	// ShowName includes the logger name in each line.
+	ShowName bool
+	// Width wraps messages at (about) this many columns, 0 means no wrapping.
+	Width int
+	// NoColor disables coloring of the level symbols.
+	NoColor bool
+	// Symbols overrides the level symbols, e.g. {ERROR: "E"}.
+	Symbols map[Level]string
+}
+
+// PrettyFormatter formats records for humans, aimed at CLI tools: by default, no time stamp,
+// just the level as a (colored) symbol and the message. Continuation lines (of multi-line or
+// wrapped messages) are indented to line up with the message. Record fields are appended as key=value.
+type PrettyFormatter struct {
+	opts PrettyOpts
+
+	noColor int32 // accessed atomically, see SetColorEnabled
+}
+
+var defaultPrettySymbols = map[Level]string{
+	FATAL:   "✗", // ballot x
+	ERROR:   "✗",
+	WARNING: "!",
+	INFO:    "i",
+	DEBUG:   "·", // middle dot
+	TRACE:   "·",
+}
+
+var prettySymbolColors = map[Level]string{
+	FATAL:   color.RedBg,
+	ERROR:   color.Red,
+	WARNING: color.Yellow,
+	INFO:    color.Blue,
+	DEBUG:   color.Faint,
+	TRACE:   color.Faint,
+}
+
+// NewPrettyFormatter returns a new PrettyFormatter.
+func NewPrettyFormatter(opts ...PrettyOpts) *PrettyFormatter {
+	f := &PrettyFormatter{}
+	if len(opts) > 0 {
+		f.opts = opts[0]
+	}
+	f.SetColorEnabled(!f.opts.NoColor)
+	return f
+}
+
+// SetColorEnabled switches the coloring of the level symbols off, or back on. It's safe to call while formatting.
+func (f *PrettyFormatter) SetColorEnabled(enable bool) {
+	var noColor int32
+	if !enable {
+		noColor = 1
+	}
+	atomic.StoreInt32(&f.noColor, noColor)
+}
+
+// Format returns the record as (one or more) human-friendly lines.
+func (f *PrettyFormatter) Format(r *Record) ([]byte, error) {
+	var buf bytes.Buffer
+
+	symbol, ok := f.opts.Symbols[r.Level]
+	if !ok {
+		symbol = defaultPrettySymbols[r.Level]
+	}
+	if len(symbol) == 0 {
+		symbol = "?"
+	}
+
+	prefix := symbol + " "
+	if f.opts.ShowTime {
+		prefix += r.Time.Format("15:04:05") + " "
+	}
+	if f.opts.ShowName {
+		name := r.Name
+		if len(name) == 0 {
+			name = "root"
+		}
+		prefix += name + ": "
+	}
+	indent := strings.Repeat(" ", utf8.RuneCountInString(prefix))
+
+	if atomic.LoadInt32(&f.noColor) == 0 {
+		buf.WriteString(prettySymbolColors[r.Level])
+		buf.WriteString(symbol)
+		buf.WriteString(colorReset)
+		buf.WriteString(prefix[len(symbol):])
+	} else {
+		buf.WriteString(prefix)
+	}
+
+	width := 0
+	if f.opts.Width > 0 {
+		width = f.opts.Width - len(indent)
+		if width < 20 {
+			width = 20
+		}
+	}
+	for idx, line := range f.lines(f.message(r), width) {
+		if idx > 0 {
+			buf.WriteByte('\n')
+			buf.WriteString(indent)
+		}
+		buf.WriteString(line)
+	}
+
+	return buf.Bytes(), nil
+}
+
+// message returns the message, with the tags (e.g. "#db") and fields (sorted by key) appended;
+// errors with their chain (see ErrorChain).
+func (f *PrettyFormatter) message(r *Record) string {
+	if len(r.Fields) == 0 && len(r.Tags) == 0 {
+		return r.Message
+	}
+
+	keys := make([]string, 0, len(r.Fields))
+	for key := range r.Fields {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	msg := r.Message
+	for _, tag := range r.Tags {
+		msg += " #" + tag
+	}
+	for _, key := range keys {
+		if err, ok := r.Fields[key].(error); ok {
+			msg += fmt.Sprintf(" %s=%s", key, formatErrorChain(err))
+		} else {
+			msg += fmt.Sprintf(" %s=%v", key, r.Fields[key])
+		}
+	}
+	return msg
+}
+
+// lines splits the message into lines, word-wrapped at width (if > 0).
+func (f *PrettyFormatter) lines(msg string, width int) []string {
+	paragraphs := strings.Split(msg, "\n")
+	if width <= 0 {
+		return paragraphs
+	}
+
+	var lines []string
+	for _, paragraph := range paragraphs {
+		line, length := "", 0
+		for _, word := range strings.Fields(paragraph) {
+			wordLength := utf8.RuneCountInString(word)
+			if length > 0 && length+1+wordLength > width {
+				lines = append(lines, line)
+				line, length = "", 0
+			}
+			if length > 0 {
+				line += " "
+				length++
+			}
+			line += word
+			length += wordLength
+		}
+		lines = append(lines, line)
+	}
+	return lines
+}
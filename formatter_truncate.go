@@ -0,0 +1,61 @@
+package log4go
+
+import "fmt"
+
+// TruncatingFormatter truncates long messages, string field values and stack traces before
+// passing the record on to another formatter, protecting downstream systems from accidental huge payloads.
+// A truncated value ends with a marker stating its original length, e.g. "…[truncated, 1048576 bytes]".
+type TruncatingFormatter struct {
+	base      Formatter
+	maxLength int
+}
+
+// NewTruncatingFormatter returns a formatter truncating values longer than maxLength bytes, then formatting using base.
+func NewTruncatingFormatter(base Formatter, maxLength int) *TruncatingFormatter {
+	return &TruncatingFormatter{
+		base:      base,
+		maxLength: maxLength,
+	}
+}
+
+// Format formats the record using the base formatter, with too long values truncated.
+func (f *TruncatingFormatter) Format(rec *Record) ([]byte, error) {
+	if !f.needsTruncation(rec) {
+		return f.base.Format(rec)
+	}
+
+	// the record (and its fields) must not be modified; truncate a copy
+	truncated := *rec
+	truncated.Message = f.truncate(rec.Message)
+	truncated.Stack = f.truncate(rec.Stack)
+	if len(rec.Fields) > 0 {
+		truncated.Fields = make(Fields, len(rec.Fields))
+		for key, value := range rec.Fields {
+			if s, ok := value.(string); ok {
+				value = f.truncate(s)
+			}
+			truncated.Fields[key] = value
+		}
+	}
+
+	return f.base.Format(&truncated)
+}
+
+func (f *TruncatingFormatter) needsTruncation(rec *Record) bool {
+	if len(rec.Message) > f.maxLength || len(rec.Stack) > f.maxLength {
+		return true
+	}
+	for _, value := range rec.Fields {
+		if s, ok := value.(string); ok && len(s) > f.maxLength {
+			return true
+		}
+	}
+	return false
+}
+
+func (f *TruncatingFormatter) truncate(s string) string {
+	if len(s) <= f.maxLength {
+		return s
+	}
+	return string(truncateUTF8([]byte(s), f.maxLength)) + fmt.Sprintf("%s[truncated, %d bytes]", truncationMarker, len(s))
+}
@@ -1,11 +1,17 @@
 package log4go
 
 import (
+	"bytes"
 	"fmt"
+	"path/filepath"
 	"regexp"
+	"regexp/syntax"
 	"strconv"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
+	"unicode/utf8"
 
 	"github.com/neonrust/log4go/color"
 )
@@ -16,6 +22,26 @@ type Formatter interface {
 	Format(rec *Record) ([]byte, error)
 }
 
+// BufferFormatter is implemented by formatters able to append to a caller-provided buffer,
+// avoiding allocating a new []byte for every record. The buffer must not be retained.
+type BufferFormatter interface {
+	// FormatTo appends the formatted Record to *buf
+	FormatTo(buf *[]byte, rec *Record) error
+}
+
+// formatTo appends the formatted record to *buf, using FormatTo if the formatter implements BufferFormatter.
+func formatTo(f Formatter, buf *[]byte, rec *Record) error {
+	if bf, ok := f.(BufferFormatter); ok {
+		return bf.FormatTo(buf, rec)
+	}
+	msg, err := f.Format(rec)
+	if err != nil {
+		return err
+	}
+	*buf = append(*buf, msg...)
+	return nil
+}
+
 // TemplateFormatter is formatting based on a string template.
 type TemplateFormatter struct {
 	formatString string
@@ -27,6 +53,14 @@ type TemplateFormatter struct {
 	patternColoring         map[string]string
 
 	processMessage func(m, c string) string
+
+	location *time.Location // nil means local time
+	iso8601  bool
+
+	colorDisabled int32 // accessed atomically, see SetColorEnabled
+
+	deltaLock            sync.Mutex // guards the times below, see the delta token
+	deltaLast, deltaPrev time.Time
 }
 
 // PatternColor pairs a color and a match pattern.
@@ -56,31 +90,84 @@ const (
 	tfTime = iota
 	tfTimeMilliseconds
 	tfTimeMicroseconds
+	tfTimeNanoseconds
+	tfEpoch
+	tfEpochMilliseconds
+	tfEpochNanoseconds
 	tfName
 	tfBaseName
 	tfLevel
 	tfMessage
+	tfDuration
+	tfMonotonic
+	tfRecordID
+	tfGoroutineID
+	tfStack
+	tfVersion
+	tfRevision
+	tfTags
+	tfFile
+	tfLine
+	tfDelta
+)
 
-	tfFieldWidth      = 0x100 // width: 0 (auto) - 254
-	tfFieldWidthMask  = 0xff00
-	tfFieldWidthShift = 8
+// fieldLayout specifies the width, alignment and padding of the token following it in the token list.
+type fieldLayout struct {
+	minWidth   int // padded up to this width
+	maxWidth   int // truncated beyond this width (0: no limit)
+	alignRight bool
+	pad        string
+}
 
-	tfAlignRight = 0x10000
-	tfAlignLeft  = 0 // i.e. the default
-)
+// writeTo writes the value, truncated and padded according to the layout (widths are counted in runes).
+func (l *fieldLayout) writeTo(buf *bytes.Buffer, s string) {
+	length := 0
+	for idx := range s {
+		if l.maxWidth > 0 && length == l.maxWidth {
+			s = s[:idx]
+			break
+		}
+		length++
+	}
+
+	padding := l.minWidth - length
+	if l.alignRight {
+		for ; padding > 0; padding-- {
+			buf.WriteString(l.pad)
+		}
+	}
+	buf.WriteString(s)
+	for ; padding > 0; padding-- {
+		buf.WriteString(l.pad)
+	}
+}
 
 // TODO: or string->func(Record) string
 var textToToken = map[string]int{
 	"time":     tfTime,
 	"timems":   tfTimeMilliseconds,
 	"timeus":   tfTimeMicroseconds,
+	"timens":   tfTimeNanoseconds,
+	"epoch":    tfEpoch,
+	"epochms":  tfEpochMilliseconds,
+	"epochns":  tfEpochNanoseconds,
 	"name":     tfName,
 	"basename": tfBaseName,
 	"level":    tfLevel,
 	"message":  tfMessage,
+	"duration": tfDuration,
+	"monotime": tfMonotonic,
+	"recordid": tfRecordID,
+	"goid":     tfGoroutineID,
+	"stack":    tfStack,
+	"version":  tfVersion,
+	"revision": tfRevision,
+	"tags":     tfTags,
+	"file":     tfFile,
+	"line":     tfLine,
+	"delta":    tfDelta,
 }
 
-var templatePtn *regexp.Regexp
 var templateSpecPtn *regexp.Regexp
 
 var defaultLevelColoring map[Level]string
@@ -122,6 +209,16 @@ func (f *TemplateFormatter) SetLevelColoring(levelToColors map[Level]string) {
 	f.levelColoring = levelToColors
 }
 
+// SetColorEnabled switches the (level and pattern) coloring off, or back on, keeping its configuration;
+// e.g. while the output is captured. It's safe to call while formatting.
+func (f *TemplateFormatter) SetColorEnabled(enable bool) {
+	var disabled int32
+	if !enable {
+		disabled = 1
+	}
+	atomic.StoreInt32(&f.colorDisabled, disabled)
+}
+
 // EnablePatternColoring sets default colors & patterns, false to disable.
 func (f *TemplateFormatter) EnablePatternColoring(enable bool) {
 	if enable {
@@ -136,74 +233,224 @@ func (f *TemplateFormatter) EnablePatternColoring(enable bool) {
 	}
 }
 
-// SetPatternColoring sets the color map and the patterns using them (any pattern matching '[' must be first).
+// SetPatternColoring sets the color map and the patterns using them.
+// The patterns are matched in a single scan of the message, the whole match being colored;
+// where several match at the same position, the first one (in order) wins.
 func (f *TemplateFormatter) SetPatternColoring(colors map[string]string, patterns []PatternColor) {
 	f.patternColoringPatterns = patterns
 	f.patternColoring = colors
 	f.processMessage = makeProcessor(f.patternColoring, f.patternColoringPatterns)
 }
 
+// makeProcessor returns a message processor coloring the patterns' matches in a single scan of the message.
+// Patterns matching single (ASCII) characters, e.g. "[-/.]", are looked up in a table;
+// the others are combined into one regular expression (compiled once), e.g. "(p1)|(p2)".
 func makeProcessor(colors map[string]string, patterns []PatternColor) func(m, c string) string {
+	var charColors [utf8.RuneSelf]int8 // index+1 of the color, by character
+	var colorList []string
+	var alternatives []string
+	var groupColors []int // index of the color by group index of the combined pattern (-1: not an alternative)
+	groupColors = append(groupColors, -1)
+	for _, colPtn := range patterns {
+		color, exists := colors[colPtn.color]
+		if !exists {
+			continue
+		}
+		colorList = append(colorList, color)
+		index := len(colorList) - 1
+
+		if chars, ok := patternChars(colPtn.pattern); ok {
+			for _, c := range chars {
+				if charColors[c] == 0 { // the first pattern wins
+					charColors[c] = int8(index + 1)
+				}
+			}
+			continue
+		}
+		alternatives = append(alternatives, "("+colPtn.pattern.String()+")")
+		groupColors = append(groupColors, index)
+		for n := colPtn.pattern.NumSubexp(); n > 0; n-- {
+			groupColors = append(groupColors, -1)
+		}
+	}
+	if len(colorList) == 0 {
+		return defaultProcessMessage
+	}
+	var combined *regexp.Regexp
+	if len(alternatives) > 0 {
+		combined = regexp.MustCompile(strings.Join(alternatives, "|"))
+	}
+
 	return func(m string, baseColor string) string {
-		repl := "$1" + baseColor
-		for _, colPtn := range patterns {
-			if color, exists := colors[colPtn.color]; exists {
-				m = colPtn.pattern.ReplaceAllString(m, color+repl)
+		var matches [][]int
+		if combined != nil {
+			matches = combined.FindAllStringSubmatchIndex(m, -1)
+		}
+
+		var out strings.Builder
+		last := 0
+		write := func(start, end, color int) {
+			if out.Len() == 0 {
+				out.Grow(len(m) + 64)
+			}
+			out.WriteString(m[last:start])
+			out.WriteString(colorList[color])
+			out.WriteString(m[start:end])
+			out.WriteString(baseColor)
+			last = end
+		}
+
+		for idx := 0; idx < len(m); idx++ {
+			charColor := -1
+			if c := m[idx]; c < utf8.RuneSelf {
+				charColor = int(charColors[c]) - 1
+			}
+
+			if len(matches) > 0 && matches[0][0] == idx {
+				match := matches[0]
+				matches = matches[1:]
+				color := -1
+				for group := 1; group < len(groupColors); group++ {
+					if groupColors[group] >= 0 && match[2*group] >= 0 {
+						color = groupColors[group]
+						break
+					}
+				}
+				if match[1] > idx && (charColor < 0 || color < charColor) {
+					write(idx, match[1], color)
+					idx = match[1] - 1
+					continue
+				}
+			}
+
+			if charColor >= 0 {
+				write(idx, idx+1, charColor)
+			}
+		}
+
+		if last == 0 {
+			return m
+		}
+		out.WriteString(m[last:])
+		return out.String()
+	}
+}
+
+// patternChars returns the characters matched by the pattern, if it only matches single ASCII characters
+// (e.g. "[-/.]" or "(a|b)").
+func patternChars(pattern *regexp.Regexp) ([]byte, bool) {
+	re, err := syntax.Parse(pattern.String(), syntax.Perl)
+	if err != nil {
+		return nil, false
+	}
+	re = re.Simplify()
+
+	var chars []byte
+	var collect func(re *syntax.Regexp) bool
+	collect = func(re *syntax.Regexp) bool {
+		switch re.Op {
+		case syntax.OpCapture:
+			return collect(re.Sub[0])
+		case syntax.OpAlternate:
+			for _, sub := range re.Sub {
+				if !collect(sub) {
+					return false
+				}
+			}
+			return true
+		case syntax.OpLiteral:
+			if len(re.Rune) != 1 || re.Rune[0] >= utf8.RuneSelf || re.Flags&syntax.FoldCase != 0 {
+				return false
 			}
+			chars = append(chars, byte(re.Rune[0]))
+			return true
+		case syntax.OpCharClass:
+			for idx := 0; idx < len(re.Rune); idx += 2 {
+				if re.Rune[idx+1] >= utf8.RuneSelf {
+					return false
+				}
+				for c := re.Rune[idx]; c <= re.Rune[idx+1]; c++ {
+					chars = append(chars, byte(c))
+				}
+			}
+			return true
 		}
-		return m
+		return false
 	}
+	if !collect(re) {
+		return nil, false
+	}
+	return chars, true
+}
+
+// templateEscapes are the escape sequences supported in templates' literal text (besides "{{" and "}}").
+var templateEscapes = map[byte]string{
+	'n':  "\n",
+	't':  "\t",
+	'\\': "\\",
 }
 
 // SetFormat sets the formatters template string format.
+// Literal braces are written as "{{" and "}}", and "\n", "\t" and "\\" are newline, tab and backslash.
 func (f *TemplateFormatter) SetFormat(template string) error {
 	var err error
-	if templatePtn == nil {
-		templatePtn, err = regexp.Compile(`\{[^}]+\}`)
-		if err != nil {
-			return err
-		}
-	}
 	if templateSpecPtn == nil {
-		templateSpecPtn, err = regexp.Compile(`^\{([^}]+?)(([<>])(\d+))?\}$`) // e.g. "{name<20}" - left align, max width 20
+		// e.g. "{name<20}" (left align, width 20), "{name<10..30}" (min width 10, max width 30) or "{level.>8}" (pad with '.')
+		templateSpecPtn, err = regexp.Compile(`^\{([a-z]+)(?:([^<>])?([<>])(\d*)(\.\.(\d*))?)?\}$`)
 		if err != nil {
 			return err
 		}
 	}
 
-	m := templatePtn.FindAllStringIndex(template, -1)
-	if m == nil {
-		return fmt.Errorf("invalid format template string: '%s'", template)
-	}
-
 	// compile the template into a token list
 	tokens := []interface{}{}
-	last := 0
-	for _, tag := range m {
-		start, end := tag[0], tag[1]
-		if start > last {
-			// part before the token
-			tokens = append(tokens, template[last:start])
+	numValues := 0
+	var literal strings.Builder
+	for idx := 0; idx < len(template); idx++ {
+		c := template[idx]
+		var next byte
+		if idx+1 < len(template) {
+			next = template[idx+1]
 		}
-		last = end
 
-		item := template[start:end]
+		switch {
+		case (c == '{' || c == '}') && next == c:
+			literal.WriteByte(c)
+			idx++
+			continue
+		case c == '\\' && len(templateEscapes[next]) > 0:
+			literal.WriteString(templateEscapes[next])
+			idx++
+			continue
+		case c != '{':
+			literal.WriteByte(c)
+			continue
+		}
+
+		end := strings.IndexByte(template[idx:], '}')
+		if end < 0 {
+			return fmt.Errorf("unterminated format template token: '%s'", template[idx:])
+		}
+		item := template[idx : idx+end+1]
+		idx += end
+
+		if literal.Len() > 0 {
+			// part before the token
+			tokens = append(tokens, literal.String())
+			literal.Reset()
+		}
 
 		spec := templateSpecPtn.FindStringSubmatch(item)
+		if spec == nil {
+			return fmt.Errorf("invalid format template token: '%s'", item)
+		}
 		token := spec[1]
-		alignment := spec[3]
-		width := spec[4]
-		if len(alignment) > 0 && len(width) > 0 {
-			w, _ := strconv.Atoi(width)
-			if w > 0 {
-				if w > 254 {
-					w = 254
-				}
-				tokens = append(tokens, tfFieldWidth+(w-1)<<tfFieldWidthShift)
-				if alignment == ">" {
-					tokens = append(tokens, tfAlignRight)
-				}
+		if len(spec[3]) > 0 {
+			layout, err := parseFieldLayout(spec[2], spec[3], spec[4], spec[5], spec[6])
+			if err != nil {
+				return fmt.Errorf("invalid format template token: '%s': %v", item, err)
 			}
+			tokens = append(tokens, layout)
 		}
 
 		value, ok := textToToken[token]
@@ -212,13 +459,64 @@ func (f *TemplateFormatter) SetFormat(template string) error {
 		}
 
 		tokens = append(tokens, value)
+		numValues++
+	}
+	if numValues == 0 {
+		return fmt.Errorf("invalid format template string: '%s'", template)
+	}
+	if literal.Len() > 0 {
+		// part after the last token
+		tokens = append(tokens, literal.String())
 	}
 
-	f.formatTokens = tokens
+	f.formatTokens = mergeLiterals(tokens)
 
 	return nil
 }
 
+// mergeLiterals concatenates adjacent string literals in the token list, so they're written at once.
+func mergeLiterals(tokens []interface{}) []interface{} {
+	merged := tokens[:0]
+	for _, token := range tokens {
+		if literal, ok := token.(string); ok && len(merged) > 0 {
+			if prev, ok := merged[len(merged)-1].(string); ok {
+				merged[len(merged)-1] = prev + literal
+				continue
+			}
+		}
+		merged = append(merged, token)
+	}
+	return merged
+}
+
+// parseFieldLayout parses the parts of a width spec: "<10" is exactly 10 wide (padded and truncated),
+// "<10.." at least 10 wide and "<10..30" between 10 and 30 wide.
+func parseFieldLayout(pad, alignment, minWidth, rangeSpec, maxWidth string) (*fieldLayout, error) {
+	layout := &fieldLayout{
+		alignRight: alignment == ">",
+		pad:        " ",
+	}
+	if len(pad) > 0 {
+		layout.pad = pad
+	}
+	if len(minWidth) > 0 {
+		layout.minWidth, _ = strconv.Atoi(minWidth)
+	}
+	if len(rangeSpec) == 0 {
+		layout.maxWidth = layout.minWidth
+	} else if len(maxWidth) > 0 {
+		layout.maxWidth, _ = strconv.Atoi(maxWidth)
+		if layout.maxWidth < layout.minWidth {
+			return nil, fmt.Errorf("max width less than min width")
+		}
+	}
+	if layout.minWidth == 0 && layout.maxWidth == 0 {
+		return nil, fmt.Errorf("no width specified")
+	}
+
+	return layout, nil
+}
+
 // GetFormat returns the formatters template string.
 func (f *TemplateFormatter) GetFormat() string {
 	return f.formatString
@@ -226,19 +524,53 @@ func (f *TemplateFormatter) GetFormat() string {
 
 const colorReset = "\x1b[0m"
 
+// formatBuffers are reused by TemplateFormatter.Format, to avoid growing a new buffer for every record.
+var formatBuffers = sync.Pool{
+	New: func() interface{} {
+		return bytes.NewBuffer(make([]byte, 0, 256))
+	},
+}
+
 // Format returns the record as a string.
 func (f *TemplateFormatter) Format(r *Record) ([]byte, error) {
-	parts := make([]string, 0, 10)
+	buf := formatBuffers.Get().(*bytes.Buffer)
+	buf.Reset()
+	defer formatBuffers.Put(buf)
+
+	f.format(buf, r)
 
-	alignFmt := ""
-	width := 0
+	// the buffer is reused, so return a copy
+	out := make([]byte, buf.Len())
+	copy(out, buf.Bytes())
+
+	return out, nil
+}
+
+// FormatTo appends the formatted record to *buf.
+func (f *TemplateFormatter) FormatTo(buf *[]byte, r *Record) error {
+	b := bytes.NewBuffer(*buf)
+	f.format(b, r)
+	*buf = b.Bytes()
+
+	return nil
+}
+
+// format writes the formatted record to buf.
+func (f *TemplateFormatter) format(buf *bytes.Buffer, r *Record) {
+	var layout *fieldLayout // of the next token
+	var scratch [64]byte    // for values not already available as strings
 
 	colorSet := false
 	var lineColor string
-	if f.levelColoring != nil {
+	colorEnabled := atomic.LoadInt32(&f.colorDisabled) == 0
+	if f.levelColoring != nil && colorEnabled {
 		var exists bool
-		if lineColor, exists = f.levelColoring[r.Level]; exists {
-			parts = append(parts, lineColor)
+		if len(r.Color) > 0 {
+			lineColor = r.Color
+			buf.WriteString(lineColor)
+			colorSet = true
+		} else if lineColor, exists = f.levelColoring[r.Level]; exists {
+			buf.WriteString(lineColor)
 			colorSet = true
 		} else {
 			lineColor = "\x1b[0m"
@@ -250,16 +582,27 @@ func (f *TemplateFormatter) Format(r *Record) ([]byte, error) {
 	for _, token := range f.formatTokens {
 		switch token := token.(type) {
 		case string:
-			parts = append(parts, token)
+			buf.WriteString(token)
+		case *fieldLayout:
+			layout = token
 		case int:
-			s := ""
+			var s string
+			var b []byte
 			switch token {
+			case tfTimeNanoseconds:
+				b = f.appendTime(scratch[:0], r.Time, Nanoseconds)
 			case tfTimeMicroseconds:
-				s = f.formatTime(r.Time, Microseconds)
+				b = f.appendTime(scratch[:0], r.Time, Microseconds)
 			case tfTimeMilliseconds:
-				s = f.formatTime(r.Time, Milliseconds)
+				b = f.appendTime(scratch[:0], r.Time, Milliseconds)
 			case tfTime:
-				s = f.formatTime(r.Time, Seconds)
+				b = f.appendTime(scratch[:0], r.Time, Seconds)
+			case tfEpoch:
+				b = strconv.AppendInt(scratch[:0], r.Time.Unix(), 10)
+			case tfEpochMilliseconds:
+				b = strconv.AppendInt(scratch[:0], r.Time.UnixNano()/int64(time.Millisecond), 10)
+			case tfEpochNanoseconds:
+				b = strconv.AppendInt(scratch[:0], r.Time.UnixNano(), 10)
 			case tfName:
 				if len(r.Name) == 0 {
 					s = "root"
@@ -270,59 +613,99 @@ func (f *TemplateFormatter) Format(r *Record) ([]byte, error) {
 				if len(r.Name) == 0 {
 					s = "root"
 				} else {
-					for idx := len(r.Name) - 1; idx >= 0; idx-- {
-						if r.Name[idx] == '/' {
-							s = r.Name[idx+1:]
-							break
-						}
-					}
-					if len(s) == 0 {
-						s = r.Name
-					}
+					s = r.Name[strings.LastIndexByte(r.Name, '/')+1:]
 				}
 			case tfLevel:
 				s = LevelName(r.Level)
+			case tfRecordID:
+				s = r.ID
+			case tfGoroutineID:
+				if r.GoroutineID != 0 {
+					b = strconv.AppendUint(scratch[:0], r.GoroutineID, 10)
+				}
+			case tfStack:
+				if len(r.Stack) > 0 {
+					s = "\n" + r.Stack
+				}
+			case tfVersion:
+				s = buildInfo.Version
+			case tfRevision:
+				s = buildInfo.Revision
+			case tfTags:
+				s = strings.Join(r.Tags, ",")
+			case tfFile:
+				if len(r.File) > 0 {
+					s = filepath.Base(r.File)
+				}
+			case tfLine:
+				if r.Line > 0 {
+					b = strconv.AppendInt(scratch[:0], int64(r.Line), 10)
+				}
+			case tfMonotonic:
+				b = strconv.AppendFloat(scratch[:0], r.Monotonic.Seconds(), 'f', 6, 64)
+			case tfDelta:
+				b = appendDelta(scratch[:0], f.delta(r.Time))
+			case tfDuration:
+				if r.Duration != 0 {
+					s = formatDuration(r.Duration)
+				}
 			case tfMessage:
 				if len(processedMessage) > 0 {
 					s = processedMessage
+				} else if !colorEnabled {
+					s = r.Message
 				} else if len(r.Message) > 0 {
 					processedMessage = f.processMessage(r.Message, lineColor)
 					s = processedMessage
 				}
 			}
 
-			// handle padding & alignment
-			if token&tfFieldWidthMask > 0 {
-				width = ((token & tfFieldWidthMask) >> tfFieldWidthShift)
-				if (token & tfAlignRight) > 0 {
-					alignFmt = fmt.Sprintf("%%%ds", width)
-				} else {
-					alignFmt = fmt.Sprintf("%%-%ds", width)
-				}
-			}
-
-			if len(s) > 0 {
-				if len(alignFmt) > 0 {
-					s = fmt.Sprintf(alignFmt, s)
-					if len(s) > width {
-						s = s[:width]
-					}
-
-					// reset align and width for next token
-					alignFmt = ""
-					width = 0
+			// handle padding & alignment (also of empty values, keeping columns aligned)
+			if layout != nil {
+				if b != nil {
+					s = string(b)
 				}
-
-				parts = append(parts, s)
+				layout.writeTo(buf, s)
+				layout = nil
+			} else if b != nil {
+				buf.Write(b)
+			} else {
+				buf.WriteString(s)
 			}
 		}
 	}
 
 	if colorSet {
-		parts = append(parts, colorReset)
+		buf.WriteString(colorReset)
 	}
+}
+
+// delta returns the time since the previous record formatted, 0 for the first one.
+// Formatting the same record again (e.g. by several handlers sharing the formatter) returns the same delta.
+// Records formatted out of order (e.g. by several asynchronous handlers sharing the formatter) get 0,
+// rather than a negative delta.
+func (f *TemplateFormatter) delta(t time.Time) time.Duration {
+	f.deltaLock.Lock()
+	defer f.deltaLock.Unlock()
 
-	return []byte(strings.Join(parts, "")), nil
+	if t.Before(f.deltaLast) {
+		return 0
+	}
+	if !t.Equal(f.deltaLast) {
+		f.deltaPrev, f.deltaLast = f.deltaLast, t
+	}
+	if f.deltaPrev.IsZero() {
+		return 0
+	}
+	return f.deltaLast.Sub(f.deltaPrev)
+}
+
+// appendDelta appends the delta in seconds, with a sign and milliseconds, e.g. "+0.042".
+func appendDelta(b []byte, delta time.Duration) []byte {
+	if delta >= 0 {
+		b = append(b, '+')
+	}
+	return strconv.AppendFloat(b, delta.Seconds(), 'f', 3, 64)
 }
 
 type TimeResolution int
@@ -331,20 +714,98 @@ const (
 	Seconds TimeResolution = iota
 	Milliseconds
 	Microseconds
+	Nanoseconds
 )
 
-const (
-	fmtSeconds      = "%4d-%02d-%02d %02d:%02d:%02d"
-	fmtMicroseconds = "%4d-%02d-%02d %02d:%02d:%02d.%06d"
-	fmtMilliseconds = "%4d-%02d-%02d %02d:%02d:%02d.%03d"
-)
+// SetLocation makes times render in the location (e.g. time.UTC), instead of local time (nil).
+func (f *TemplateFormatter) SetLocation(loc *time.Location) {
+	f.location = loc
+}
+
+// SetLocationName makes times render in the named location (e.g. "UTC" or "Europe/Stockholm").
+func (f *TemplateFormatter) SetLocationName(name string) error {
+	loc, err := time.LoadLocation(name)
+	if err != nil {
+		return err
+	}
+	f.location = loc
+	return nil
+}
+
+// SetISO8601 makes times render in ISO 8601 format, including the time zone, e.g. "2020-05-17T12:34:56.789+02:00".
+func (f *TemplateFormatter) SetISO8601(enable bool) {
+	f.iso8601 = enable
+}
+
+var isoLayouts = map[TimeResolution]string{
+	Seconds:      "2006-01-02T15:04:05Z07:00",
+	Milliseconds: "2006-01-02T15:04:05.000Z07:00",
+	Microseconds: "2006-01-02T15:04:05.000000Z07:00",
+	Nanoseconds:  "2006-01-02T15:04:05.000000000Z07:00",
+}
+
+// appendTime appends the time (in the formatter's location) to b, e.g. "2020-05-17 12:34:56.789".
+func (f *TemplateFormatter) appendTime(b []byte, t time.Time, resolution TimeResolution) []byte {
+	if f.location != nil {
+		t = t.In(f.location)
+	}
+	if f.iso8601 {
+		return t.AppendFormat(b, isoLayouts[resolution])
+	}
+
+	year, month, day := t.Date()
+	hour, min, sec := t.Clock()
+	b = appendInt(b, year, 4)
+	b = append(b, '-')
+	b = appendInt(b, int(month), 2)
+	b = append(b, '-')
+	b = appendInt(b, day, 2)
+	b = append(b, ' ')
+	b = appendInt(b, hour, 2)
+	b = append(b, ':')
+	b = appendInt(b, min, 2)
+	b = append(b, ':')
+	b = appendInt(b, sec, 2)
+	switch resolution {
+	case Milliseconds:
+		b = append(b, '.')
+		b = appendInt(b, t.Nanosecond()/1000000, 3)
+	case Microseconds:
+		b = append(b, '.')
+		b = appendInt(b, t.Nanosecond()/1000, 6)
+	case Nanoseconds:
+		b = append(b, '.')
+		b = appendInt(b, t.Nanosecond(), 9)
+	}
+	return b
+}
+
+// appendInt appends the (non-negative) value to b, zero-padded to width digits.
+func appendInt(b []byte, value int, width int) []byte {
+	var digits [20]byte
+	idx := len(digits)
+	for value >= 10 || width > 1 {
+		idx--
+		digits[idx] = byte('0' + value%10)
+		value /= 10
+		width--
+	}
+	idx--
+	digits[idx] = byte('0' + value)
+	return append(b, digits[idx:]...)
+}
 
-func (f *TemplateFormatter) formatTime(t time.Time, resolution TimeResolution) string {
-	// duplicate some code to avoid generating multiple string objects
-	if resolution == Milliseconds {
-		return fmt.Sprintf(fmtMilliseconds, t.Year(), t.Month(), t.Day(), t.Hour(), t.Minute(), t.Second(), t.Nanosecond()/1000000)
-	} else if resolution == Microseconds {
-		return fmt.Sprintf(fmtMicroseconds, t.Year(), t.Month(), t.Day(), t.Hour(), t.Minute(), t.Second(), t.Nanosecond()/1000)
+// formatDuration formats a duration using a unit suitable for its magnitude, e.g. "850ns", "12.5µs", "3.2ms", "1.25s".
+func formatDuration(d time.Duration) string {
+	switch {
+	case d < 0:
+		return "-" + formatDuration(-d)
+	case d < time.Microsecond:
+		return fmt.Sprintf("%dns", d.Nanoseconds())
+	case d < time.Millisecond:
+		return fmt.Sprintf("%.1fµs", float64(d)/float64(time.Microsecond))
+	case d < time.Second:
+		return fmt.Sprintf("%.1fms", float64(d)/float64(time.Millisecond))
 	}
-	return fmt.Sprintf(fmtSeconds, t.Year(), t.Month(), t.Day(), t.Hour(), t.Minute(), t.Second())
+	return fmt.Sprintf("%.2fs", d.Seconds())
 }
@@ -0,0 +1,609 @@
+package log4go
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/neonrust/log4go/color"
+)
+
+func TestECSFormatter(t *testing.T) {
+	rec := &Record{
+		Time:    time.Date(2020, 5, 17, 12, 34, 56, 789000000, time.UTC),
+		Name:    "db/pool",
+		Level:   WARNING,
+		Message: "connection lost",
+		Fields: Fields{
+			"error":    errors.New("broken pipe"),
+			"trace_id": "abc123",
+			"user.id":  7,
+		},
+	}
+
+	data, err := NewECSFormatter().Format(rec)
+	if err != nil {
+		t.Fatalf("Format failed: %v", err)
+	}
+
+	var doc map[string]interface{}
+	if err := json.Unmarshal(data, &doc); err != nil {
+		t.Fatalf("invalid JSON: %v: %s", err, data)
+	}
+
+	expected := map[string]interface{}{
+		"@timestamp":    "2020-05-17T12:34:56.789Z",
+		"log.level":     "warn",
+		"log.logger":    "db/pool",
+		"message":       "connection lost",
+		"ecs.version":   ecsVersion,
+		"error.message": "broken pipe",
+		"error.type":    "*errors.errorString",
+		"trace.id":      "abc123",
+		"user.id":       float64(7),
+	}
+	for key, value := range expected {
+		if doc[key] != value {
+			t.Errorf("%s: expected %v, got %v", key, value, doc[key])
+		}
+	}
+	if len(doc) != len(expected) {
+		t.Errorf("unexpected keys: %v", doc)
+	}
+}
+
+func TestChainFormatter(t *testing.T) {
+	base, _ := NewTemplateFormatter("{level} {message}")
+
+	f := Chain(base,
+		Redactor(regexp.MustCompile(`password=(\S+)`), regexp.MustCompile(`\d{4}-\d{4}-\d{4}-\d{4}`)),
+		Truncator(40),
+	)
+
+	rec := &Record{Level: INFO, Message: "login password=hunter2 card 1234-5678-9012-3456"}
+	out, err := f.Format(rec)
+	if err != nil {
+		t.Fatalf("Format failed: %v", err)
+	}
+	if s := string(out); s != "INFO login password=[REDACTED] card […" {
+		t.Errorf("unexpected output: %q", s)
+	}
+
+	colored, _ := Chain(base, LevelColorizer(map[Level]string{ERROR: "<red>"})).Format(&Record{Level: ERROR, Message: "x"})
+	if s := string(colored); s != "<red>ERROR x"+colorReset {
+		t.Errorf("unexpected colored output: %q", s)
+	}
+}
+
+func TestTruncatingFormatter(t *testing.T) {
+	base, _ := NewTemplateFormatter("{message}")
+	f := NewTruncatingFormatter(base, 10)
+
+	rec := &Record{Message: "this message is too long", Fields: Fields{"payload": "0123456789abc", "n": 1}}
+	out, _ := f.Format(rec)
+	if s := string(out); s != "this messa…[truncated, 24 bytes]" {
+		t.Errorf("unexpected output: %q", s)
+	}
+	if rec.Message != "this message is too long" || rec.Fields["payload"] != "0123456789abc" {
+		t.Errorf("original record was modified: %+v", rec)
+	}
+
+	ecs := NewTruncatingFormatter(NewECSFormatter(), 10)
+	out, _ = ecs.Format(rec)
+	var doc map[string]interface{}
+	json.Unmarshal(out, &doc)
+	if doc["payload"] != "0123456789…[truncated, 13 bytes]" || doc["n"] != float64(1) {
+		t.Errorf("unexpected fields: %v", doc)
+	}
+
+	short := &Record{Message: "short"}
+	if out, _ := f.Format(short); string(out) != "short" {
+		t.Errorf("unexpected output: %q", out)
+	}
+}
+
+func TestTimeLocation(t *testing.T) {
+	rec := &Record{Time: time.Date(2020, 5, 17, 12, 34, 56, 789000000, time.FixedZone("CEST", 2*60*60))}
+
+	f, _ := NewTemplateFormatter("{timems}")
+	f.SetLocation(time.UTC)
+	if out, _ := f.Format(rec); string(out) != "2020-05-17 10:34:56.789" {
+		t.Errorf("unexpected UTC time: %q", out)
+	}
+
+	f.SetISO8601(true)
+	if out, _ := f.Format(rec); string(out) != "2020-05-17T10:34:56.789Z" {
+		t.Errorf("unexpected ISO 8601 UTC time: %q", out)
+	}
+
+	f.SetLocation(rec.Time.Location())
+	if out, _ := f.Format(rec); string(out) != "2020-05-17T12:34:56.789+02:00" {
+		t.Errorf("unexpected ISO 8601 time: %q", out)
+	}
+
+	if err := f.SetLocationName("Nowhere/Special"); err == nil {
+		t.Errorf("expected error for unknown location")
+	}
+}
+
+func TestRecordIDs(t *testing.T) {
+	// time part example from the ULID spec
+	id := newULID(time.Unix(0, 1469918176385*int64(time.Millisecond)))
+	if len(id) != 26 || id[:10] != "01ARYZ6S41" {
+		t.Errorf("unexpected ULID: %q", id)
+	}
+	if other := newULID(time.Unix(0, 1469918176385*int64(time.Millisecond))); other == id {
+		t.Errorf("ULIDs not unique: %q", id)
+	}
+
+	handler := &recordingHandler{}
+	formatter, _ := NewTemplateFormatter("{recordid} {message}")
+	handler.SetFormatter(formatter)
+
+	BasicConfig(BasicConfigOpts{
+		Level:    INFO,
+		Handlers: []Handler{handler},
+	})
+
+	EnableRecordIDs(true)
+	GetLogger("test").Info("with id")
+	EnableRecordIDs(false)
+	GetLogger("test").Info("without id")
+
+	Shutdown()
+
+	if len(handler.records[0].ID) != 26 || len(handler.records[1].ID) != 0 {
+		t.Errorf("unexpected record IDs: %q, %q", handler.records[0].ID, handler.records[1].ID)
+	}
+	out, _ := formatter.Format(&handler.records[0])
+	if string(out) != handler.records[0].ID+" with id" {
+		t.Errorf("unexpected output: %q", out)
+	}
+}
+
+func TestFieldWidths(t *testing.T) {
+	tests := []struct {
+		template string
+		name     string
+		expected string
+	}{
+		{"[{name<6}]", "db", "[db    ]"},
+		{"[{name<6}]", "database", "[databa]"},
+		{"[{name>6}]", "db", "[    db]"},
+		{"[{name<4..}]", "database", "[database]"},
+		{"[{name<4..6}]", "db", "[db  ]"},
+		{"[{name<4..6}]", "database", "[databa]"},
+		{"[{name<..3}]", "db", "[db]"},
+		{"[{name.<6}]", "db", "[db....]"},
+		{"[{name0>4}]", "db", "[00db]"},
+		{"[{name<5}]", "dåtåbåse", "[dåtåb]"},
+	}
+	for _, test := range tests {
+		f, err := NewTemplateFormatter(test.template)
+		if err != nil {
+			t.Errorf("%s: %v", test.template, err)
+			continue
+		}
+		out, _ := f.Format(&Record{Name: test.name})
+		if string(out) != test.expected {
+			t.Errorf("%s: expected %q, got %q", test.template, test.expected, out)
+		}
+	}
+
+	for _, template := range []string{"{name<}", "{name<6..4}"} {
+		if _, err := NewTemplateFormatter(template); err == nil {
+			t.Errorf("%s: expected error", template)
+		}
+	}
+}
+
+func TestTemplateEscapes(t *testing.T) {
+	tests := []struct {
+		template string
+		expected string
+	}{
+		{"{level} {{json}} {message}", "INFO {json} hi"},
+		{`{"level": "{level}"}}`, ""},
+		{`{{"level": "{level}"}}`, `{"level": "INFO"}`},
+		{`{level}\n\t{message}`, "INFO\n\thi"},
+		{`{level} C:\\logs\x`, `INFO C:\logs\x`},
+		{"{message} }", "hi }"},
+	}
+	for _, test := range tests {
+		f, err := NewTemplateFormatter(test.template)
+		if len(test.expected) == 0 {
+			if err == nil {
+				t.Errorf("%s: expected error", test.template)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: %v", test.template, err)
+			continue
+		}
+		out, _ := f.Format(&Record{Level: INFO, Message: "hi"})
+		if string(out) != test.expected {
+			t.Errorf("%s: expected %q, got %q", test.template, test.expected, out)
+		}
+	}
+
+	for _, template := range []string{"{{level}}", "{level", "{}"} {
+		if _, err := NewTemplateFormatter(template); err == nil {
+			t.Errorf("%s: expected error", template)
+		}
+	}
+}
+
+func BenchmarkTemplateFormatter(b *testing.B) {
+	f, _ := NewTemplateFormatter("{timems} {name<10..20} {basename} {level<8} {message}")
+	rec := &Record{
+		Time:    time.Now(),
+		Name:    "app/db/pool",
+		Level:   WARNING,
+		Message: "connection lost, reconnecting",
+	}
+
+	b.ReportAllocs()
+	for n := 0; n < b.N; n++ {
+		f.Format(rec)
+	}
+}
+
+func TestFormatTo(t *testing.T) {
+	f, _ := NewTemplateFormatter("{level} {message}")
+	rec := &Record{Level: INFO, Message: "hello"}
+
+	buf := []byte("> ")
+	if err := f.FormatTo(&buf, rec); err != nil {
+		t.Fatal(err)
+	}
+	if string(buf) != "> INFO hello" {
+		t.Errorf("unexpected output: %q", buf)
+	}
+
+	// formatters not implementing FormatTo are adapted
+	pretty := NewPrettyFormatter(PrettyOpts{NoColor: true})
+	expected, _ := pretty.Format(rec)
+	buf = buf[:0]
+	if err := formatTo(pretty, &buf, rec); err != nil {
+		t.Fatal(err)
+	}
+	if string(buf) != string(expected) {
+		t.Errorf("unexpected output: %q", buf)
+	}
+}
+
+func BenchmarkTemplateFormatterTo(b *testing.B) {
+	f, _ := NewTemplateFormatter("{timems} {name<10..20} {basename} {level<8} {message}")
+	rec := &Record{
+		Time:    time.Now(),
+		Name:    "app/db/pool",
+		Level:   WARNING,
+		Message: "connection lost, reconnecting",
+	}
+	buf := make([]byte, 0, 256)
+
+	b.ReportAllocs()
+	for n := 0; n < b.N; n++ {
+		buf = buf[:0]
+		f.FormatTo(&buf, rec)
+	}
+}
+
+func TestPrettyFormatter(t *testing.T) {
+	rec := &Record{
+		Time:    time.Date(2020, 5, 17, 12, 34, 56, 0, time.UTC),
+		Name:    "cli",
+		Level:   WARNING,
+		Message: "disk almost full, please remove some files\nsee the manual",
+		Fields:  Fields{"used": "97%"},
+	}
+
+	f := NewPrettyFormatter(PrettyOpts{NoColor: true})
+	out, _ := f.Format(rec)
+	if string(out) != "! disk almost full, please remove some files\n  see the manual used=97%" {
+		t.Errorf("unexpected output: %q", out)
+	}
+
+	f = NewPrettyFormatter(PrettyOpts{NoColor: true, ShowTime: true, ShowName: true, Width: 40})
+	out, _ = f.Format(rec)
+	expected := "! 12:34:56 cli: disk almost full, please\n" +
+		"                remove some files\n" +
+		"                see the manual used=97%"
+	if string(out) != expected {
+		t.Errorf("unexpected output: %q", out)
+	}
+
+	out, _ = NewPrettyFormatter().Format(&Record{Level: ERROR, Message: "failed"})
+	if string(out) != color.Red+"✗"+colorReset+" failed" {
+		t.Errorf("unexpected output: %q", out)
+	}
+}
+
+func TestGoroutineIDs(t *testing.T) {
+	handler := &recordingHandler{}
+	formatter, _ := NewTemplateFormatter("{goid} {message}")
+	handler.SetFormatter(formatter)
+
+	BasicConfig(BasicConfigOpts{
+		Level:    INFO,
+		Handlers: []Handler{handler},
+	})
+
+	EnableGoroutineIDs(true)
+	GetLogger("test").Info("here")
+	done := make(chan bool)
+	go func() {
+		GetLogger("test").Info("there")
+		close(done)
+	}()
+	<-done
+	EnableGoroutineIDs(false)
+	GetLogger("test").Info("unknown")
+
+	Shutdown()
+
+	here, there, unknown := handler.records[0], handler.records[1], handler.records[2]
+	if here.GoroutineID == 0 || there.GoroutineID == 0 || here.GoroutineID == there.GoroutineID || unknown.GoroutineID != 0 {
+		t.Errorf("unexpected goroutine IDs: %d, %d, %d", here.GoroutineID, there.GoroutineID, unknown.GoroutineID)
+	}
+	out, _ := formatter.Format(&here)
+	if string(out) != fmt.Sprintf("%d here", here.GoroutineID) {
+		t.Errorf("unexpected output: %q", out)
+	}
+}
+
+// detailedError implements fmt.Formatter, like e.g. github.com/pkg/errors, adding details for "%+v".
+type detailedError struct{}
+
+func (e detailedError) Error() string { return "connection refused" }
+
+func (e detailedError) Format(s fmt.State, verb rune) {
+	io.WriteString(s, e.Error())
+	if s.Flag('+') {
+		io.WriteString(s, "\ndialing 10.0.0.1:5432")
+	}
+}
+
+func TestErrorChain(t *testing.T) {
+	err := fmt.Errorf("saving order: %w", fmt.Errorf("db: %w", detailedError{}))
+
+	chain := ErrorChain(err)
+	if len(chain) != 3 || chain[1].Message != "db: connection refused" || chain[2].Type != "log4go.detailedError" ||
+		chain[2].Details != "connection refused\ndialing 10.0.0.1:5432" || len(chain[0].Details) != 0 {
+		t.Errorf("unexpected chain: %+v", chain)
+	}
+
+	rec := &Record{Level: ERROR, Message: "failed", Fields: Fields{"error": err}}
+
+	out, _ := NewPrettyFormatter(PrettyOpts{NoColor: true}).Format(rec)
+	expected := "✗ failed error=saving order: db: connection refused\n" +
+		"  caused by: db: connection refused (*fmt.wrapError)\n" +
+		"  caused by: connection refused (log4go.detailedError)\n" +
+		"  connection refused\n" +
+		"  dialing 10.0.0.1:5432"
+	if string(out) != expected {
+		t.Errorf("unexpected output: %q", out)
+	}
+
+	out, _ = NewECSFormatter().Format(rec)
+	var doc struct {
+		Chain []ErrorChainEntry `json:"error.chain"`
+	}
+	if err := json.Unmarshal(out, &doc); err != nil || len(doc.Chain) != 3 {
+		t.Errorf("unexpected output: %s", out)
+	}
+}
+
+func TestWithColor(t *testing.T) {
+	handler := &recordingHandler{}
+	BasicConfig(BasicConfigOpts{
+		Level:    INFO,
+		Handlers: []Handler{handler},
+	})
+	defer Reset()
+
+	log := GetLogger("test")
+	log.WithColor(color.Green).Info("deployment complete")
+	log.Info("plain")
+	Shutdown()
+
+	f, _ := NewTemplateFormatter("{message}")
+	out, _ := f.Format(&handler.records[0])
+	if string(out) != "deployment complete" {
+		t.Errorf("expected no color without coloring enabled, got %q", out)
+	}
+
+	f.EnableLevelColoring(true)
+	out, _ = f.Format(&handler.records[0])
+	if expected := color.Green + "deployment complete" + colorReset; string(out) != expected {
+		t.Errorf("expected %q, got %q", expected, out)
+	}
+	out, _ = f.Format(&handler.records[1])
+	if expected := color.Normal + "plain" + colorReset; string(out) != expected {
+		t.Errorf("expected %q, got %q", expected, out)
+	}
+}
+
+func TestPatternColoring(t *testing.T) {
+	f, _ := NewTemplateFormatter("{message}")
+	f.SetPatternColoring(map[string]string{"num": "<N>", "punct": "<P>", "quoted": "<Q>"}, []PatternColor{
+		{"punct", regexp.MustCompile(`[.:]`)},
+		{"quoted", regexp.MustCompile(`"[^"]*"`)},
+		{"num", regexp.MustCompile(`\d+`)},
+		{"uncolored", regexp.MustCompile(`lost`)},
+	})
+
+	// quoted strings are colored as a whole (not their punctuation), the first pattern matching a position wins
+	out, _ := f.Format(&Record{Message: `lost "db.example:5432" after 3.5s`})
+	if expected := `lost <Q>"db.example:5432" after <N>3<P>.<N>5s`; string(out) != expected {
+		t.Errorf("expected %q, got %q", expected, out)
+	}
+}
+
+func BenchmarkPatternColoring(b *testing.B) {
+	f, _ := NewTemplateFormatter("{level} {message}")
+	f.EnableLevelColoring(true)
+	f.EnablePatternColoring(true)
+	rec := &Record{
+		Level:   WARNING,
+		Message: `connection to "db-1.example.com:5432" lost [attempt 3/5], retrying in 1.5s (pool: 'primary')`,
+	}
+
+	b.ReportAllocs()
+	for n := 0; n < b.N; n++ {
+		f.Format(rec)
+	}
+}
+
+func TestSetColorEnabled(t *testing.T) {
+	template, _ := NewTemplateFormatter("{message}")
+	template.EnableLevelColoring(true)
+	template.EnablePatternColoring(true)
+	pretty := NewPrettyFormatter()
+	chain := Chain(pretty, LevelColorizer(nil))
+
+	var handlers []Handler
+	for _, f := range []Formatter{template, pretty, chain} {
+		h := &recordingHandler{}
+		h.SetFormatter(f)
+		handlers = append(handlers, h)
+	}
+	BasicConfig(BasicConfigOpts{
+		Level:    INFO,
+		Handlers: []Handler{handlers[0], NewMemoryHandler(10, ERROR, handlers[1]), handlers[2]},
+	})
+	defer Reset()
+
+	rec := &Record{Level: WARNING, Message: "x: 'y'"}
+	colored := func(f Formatter) bool {
+		out, _ := f.Format(rec)
+		return strings.Contains(string(out), "\x1b[")
+	}
+
+	SetColorEnabled(false)
+	for _, f := range []Formatter{template, pretty, chain} {
+		if colored(f) {
+			t.Errorf("%T: expected no colors when disabled", f)
+		}
+	}
+	if out, _ := template.Format(rec); string(out) != "x: 'y'" {
+		t.Errorf("unexpected output: %q", out)
+	}
+
+	SetColorEnabled(true)
+	for _, f := range []Formatter{template, pretty, chain} {
+		if !colored(f) {
+			t.Errorf("%T: expected colors when enabled", f)
+		}
+	}
+
+	if !SetHandlerColorEnabled(handlers[0], false) || colored(template) {
+		t.Errorf("expected the handler's coloring disabled")
+	}
+}
+
+func TestStructuredFormatters(t *testing.T) {
+	rec := &Record{
+		Time:    time.Date(2020, 5, 17, 12, 34, 56, 789000000, time.UTC),
+		Name:    "db/pool",
+		Level:   WARNING,
+		Message: "connection lost",
+		Fields:  Fields{"user": 42, "error": errors.New("reset by peer"), "note": "", "host": "db 1"},
+	}
+
+	out, _ := NewJSONFormatter().Format(rec)
+	expected := `{"time":"2020-05-17T12:34:56.789Z","level":"WARNING","logger":"db/pool","msg":"connection lost",` +
+		`"error":"reset by peer","host":"db 1","note":"","user":42}`
+	if string(out) != expected {
+		t.Errorf("unexpected JSON output:\n%s", out)
+	}
+
+	opts := StructuredOpts{
+		TimeKey:    "ts",
+		LevelKey:   "severity",
+		NameKey:    "-",
+		MessageKey: "message",
+		KeyOrder:   []string{"severity", "message", "user", "missing"},
+		OmitEmpty:  true,
+	}
+	out, _ = NewJSONFormatter(opts).Format(rec)
+	expected = `{"severity":"WARNING","message":"connection lost","user":42,` +
+		`"ts":"2020-05-17T12:34:56.789Z","error":"reset by peer","host":"db 1"}`
+	if string(out) != expected {
+		t.Errorf("unexpected ordered JSON output:\n%s", out)
+	}
+	var decoded map[string]interface{}
+	if err := json.Unmarshal(out, &decoded); err != nil {
+		t.Errorf("invalid JSON: %v", err)
+	}
+
+	opts.Only = true
+	out, _ = NewKeyValueFormatter(opts).Format(rec)
+	if string(out) != `severity=WARNING message="connection lost" user=42` {
+		t.Errorf("unexpected key/value output: %s", out)
+	}
+	out, _ = NewKeyValueFormatter().Format(rec)
+	expected = `time=2020-05-17T12:34:56.789Z level=WARNING logger=db/pool msg="connection lost" ` +
+		`error="reset by peer" host="db 1" note="" user=42`
+	if string(out) != expected {
+		t.Errorf("unexpected key/value output:\n%s", out)
+	}
+}
+
+func TestEpochTokens(t *testing.T) {
+	rec := &Record{
+		Time:    time.Date(2020, 5, 17, 12, 34, 56, 789012345, time.UTC),
+		Level:   INFO,
+		Message: "hello",
+	}
+
+	formatter, err := NewTemplateFormatter("{epoch} {epochms} {epochns} {timens} {message}")
+	if err != nil {
+		t.Fatal(err)
+	}
+	formatter.SetLocation(time.UTC)
+	out, _ := formatter.Format(rec)
+	expected := "1589718896 1589718896789 1589718896789012345 2020-05-17 12:34:56.789012345 hello"
+	if string(out) != expected {
+		t.Errorf("unexpected output: %q", out)
+	}
+
+	out, _ = NewJSONFormatter(StructuredOpts{EpochUnit: time.Millisecond, KeyOrder: []string{"time"}, Only: true}).Format(rec)
+	if string(out) != `{"time":1589718896789}` {
+		t.Errorf("unexpected JSON output: %s", out)
+	}
+}
+
+func TestDeltaToken(t *testing.T) {
+	formatter, err := NewTemplateFormatter("{delta<8} {message}")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	start := time.Date(2020, 5, 17, 12, 34, 56, 0, time.UTC)
+	var lines []string
+	for _, offset := range []time.Duration{0, 42 * time.Millisecond, 42 * time.Millisecond, 1500 * time.Millisecond} {
+		out, _ := formatter.Format(&Record{Time: start.Add(offset), Message: "step"})
+		lines = append(lines, string(out))
+	}
+	// the same record formatted again (e.g. by another handler) gets the same delta
+	expected := "+0.000   step|+0.042   step|+0.042   step|+1.458   step"
+	if strings.Join(lines, "|") != expected {
+		t.Errorf("unexpected output: %q", lines)
+	}
+	// a record formatted out of order (e.g. by another asynchronous handler) isn't negative
+	out, _ := formatter.Format(&Record{Time: start.Add(time.Second), Message: "late"})
+	if string(out) != "+0.000   late" {
+		t.Errorf("unexpected output: %q", out)
+	}
+	out, _ = formatter.Format(&Record{Time: start.Add(2 * time.Second), Message: "next"})
+	if string(out) != "+0.500   next" {
+		t.Errorf("unexpected output: %q", out)
+	}
+}
@@ -0,0 +1,172 @@
+// Package formattertest provides a conformance suite for log4go.Formatter implementations,
+// so third-party formatters can verify the behaviors the handlers rely on:
+//
+//	func TestFormatter(t *testing.T) {
+//		formattertest.Run(t, NewMyFormatter())
+//	}
+package formattertest
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/neonrust/log4go"
+)
+
+// Run verifies that the formatter:
+//   - formats any record without error, into non-empty output; including records with an empty name,
+//     huge messages, levels outside the defined ones, non-UTF-8 bytes and unusual fields (and a zero Record)
+//   - formats the same record the same way every time
+//   - doesn't modify the record (nor its fields and tags), which is shared by all handlers
+//   - appends to the buffer passed to FormatTo (if a log4go.BufferFormatter), as Format formats
+//   - is safe for concurrent use (run the tests with -race to detect data races)
+func Run(t *testing.T, f log4go.Formatter) {
+	t.Helper()
+
+	t.Run("Record", func(t *testing.T) {
+		format(t, f, record())
+	})
+	t.Run("ZeroRecord", func(t *testing.T) {
+		format(t, f, &log4go.Record{})
+	})
+	t.Run("EmptyName", func(t *testing.T) {
+		rec := record()
+		rec.Name = ""
+		format(t, f, rec)
+	})
+	t.Run("HugeMessage", func(t *testing.T) {
+		rec := record()
+		rec.Message = strings.Repeat("0123456789abcdef", 1<<16) // 1 MiB
+		format(t, f, rec)
+	})
+	t.Run("Levels", func(t *testing.T) {
+		levels := []log4go.Level{log4go.ALL, log4go.INHERIT, log4go.TRACE, log4go.DEBUG, log4go.INFO,
+			log4go.WARNING, log4go.ERROR, log4go.FATAL, log4go.OFF, -1000, 1000}
+		for _, level := range levels {
+			rec := record()
+			rec.Level = level
+			format(t, f, rec)
+		}
+	})
+	t.Run("NonUTF8", func(t *testing.T) {
+		rec := record()
+		rec.Name = "app/\xff\xfe"
+		rec.Message = "invalid \xc3\x28 utf-8 \xed\xa0\x80 and \x00 control \x1b[31m bytes"
+		rec.Fields = log4go.Fields{"key\xff": "value\xfe\x00", "bytes": []byte{0xff, 0x00, 0x80}}
+		rec.Tags = []string{"tag\xff"}
+		format(t, f, rec)
+	})
+	t.Run("Fields", func(t *testing.T) {
+		rec := record()
+		rec.Fields = log4go.Fields{
+			"":         "empty key",
+			"nil":      nil,
+			"error":    errors.New("failed"),
+			"nested":   map[string]interface{}{"a": []int{1, 2}, "b": map[string]string{"c": "d"}},
+			"struct":   struct{ A, b int }{1, 2},
+			"pointer":  (*int)(nil),
+			"duration": time.Second,
+			"float":    1.5,
+			"newline":  "line 1\nline 2",
+			"quote":    `"quoted" = value`,
+		}
+		format(t, f, rec)
+	})
+	t.Run("Deterministic", func(t *testing.T) {
+		rec := record()
+		first := format(t, f, rec)
+		if second := format(t, f, rec); !bytes.Equal(first, second) {
+			t.Errorf("formatting the same record twice differs:\n%q\n%q", first, second)
+		}
+	})
+	t.Run("Unmodified", func(t *testing.T) {
+		rec := record()
+		original := rec.Clone()
+		format(t, f, rec)
+		if !reflect.DeepEqual(rec, original) {
+			t.Errorf("the record was modified:\n%+v\n%+v", original, rec)
+		}
+	})
+	t.Run("FormatTo", func(t *testing.T) {
+		bf, ok := f.(log4go.BufferFormatter)
+		if !ok {
+			t.Skip("not a BufferFormatter")
+		}
+		rec := record()
+		expected := format(t, f, rec)
+		buf := []byte("prefix ")
+		if err := bf.FormatTo(&buf, rec); err != nil {
+			t.Fatalf("FormatTo: %v", err)
+		}
+		if !bytes.Equal(buf, append([]byte("prefix "), expected...)) {
+			t.Errorf("FormatTo differs from Format (or overwrote the buffer):\n%q\n%q", buf, expected)
+		}
+	})
+	t.Run("Concurrent", func(t *testing.T) {
+		var wg sync.WaitGroup
+		errs := make(chan error, 8)
+		for g := 0; g < 8; g++ {
+			wg.Add(1)
+			go func(g int) {
+				defer wg.Done()
+				for idx := 0; idx < 100; idx++ {
+					rec := record()
+					rec.Message = fmt.Sprintf("goroutine %d record %d", g, idx)
+					if _, err := f.Format(rec); err != nil {
+						errs <- err
+						return
+					}
+				}
+			}(g)
+		}
+		wg.Wait()
+		close(errs)
+		for err := range errs {
+			t.Errorf("Format: %v", err)
+		}
+	})
+}
+
+// record returns a record with all attributes set.
+func record() *log4go.Record {
+	return &log4go.Record{
+		Time:        time.Date(2021, 3, 4, 5, 6, 7, 890000000, time.UTC),
+		Name:        "app/db",
+		Level:       log4go.WARNING,
+		Message:     "query took 1.2s",
+		Duration:    1200 * time.Millisecond,
+		Fields:      log4go.Fields{"table": "orders", "rows": 42},
+		Stack:       "main.query\n\t/src/app/db.go:42",
+		Monotonic:   12 * time.Second,
+		ID:          "0123456789abcdef",
+		GoroutineID: 7,
+		Tags:        []string{"slow"},
+		File:        "db.go",
+		Line:        42,
+	}
+}
+
+// format formats the record, failing the test on errors, panics and empty output.
+func format(t *testing.T, f log4go.Formatter, rec *log4go.Record) (out []byte) {
+	t.Helper()
+
+	defer func() {
+		if r := recover(); r != nil {
+			t.Fatalf("Format panicked: %v", r)
+		}
+	}()
+	out, err := f.Format(rec)
+	if err != nil {
+		t.Fatalf("Format: %v", err)
+	}
+	if len(out) == 0 {
+		t.Errorf("Format returned empty output")
+	}
+	return out
+}
@@ -0,0 +1,30 @@
+package formattertest
+
+import (
+	"regexp"
+	"testing"
+
+	"github.com/neonrust/log4go"
+)
+
+func TestFormatters(t *testing.T) {
+	template, err := log4go.NewTemplateFormatter("{time} {name} {level} {message} {tags} {delta} {stack}")
+	if err != nil {
+		t.Fatal(err)
+	}
+	formatters := map[string]log4go.Formatter{
+		"Template":   template,
+		"JSON":       log4go.NewJSONFormatter(),
+		"KeyValue":   log4go.NewKeyValueFormatter(),
+		"ECS":        log4go.NewECSFormatter(),
+		"Pretty":     log4go.NewPrettyFormatter(log4go.PrettyOpts{ShowTime: true, ShowName: true, Width: 80}),
+		"Truncating": log4go.NewTruncatingFormatter(template, 100),
+		"Chain": log4go.Chain(log4go.NewJSONFormatter(),
+			log4go.Redactor(regexp.MustCompile(`orders`)), log4go.Truncator(4096), log4go.LevelColorizer(nil)),
+	}
+	for name, formatter := range formatters {
+		t.Run(name, func(t *testing.T) {
+			Run(t, formatter)
+		})
+	}
+}
@@ -0,0 +1,46 @@
+package log4go
+
+import (
+	"runtime"
+	"sync/atomic"
+)
+
+var goroutineIDsEnabled int32 // accessed atomically
+
+// EnableGoroutineIDs makes every record get the ID of the logging goroutine (see Record.GoroutineID),
+// false to disable (the default). Correlating records by goroutine helps diagnosing concurrency bugs.
+//
+// Go doesn't expose goroutine IDs, so they're parsed from the header of the goroutine's stack trace,
+// which costs about a microsecond per record (but no allocations).
+func EnableGoroutineIDs(enable bool) {
+	var value int32
+	if enable {
+		value = 1
+	}
+	atomic.StoreInt32(&goroutineIDsEnabled, value)
+}
+
+func goroutineIDs() bool {
+	return atomic.LoadInt32(&goroutineIDsEnabled) != 0
+}
+
+// goroutineID returns the ID of the calling goroutine, parsed from its stack trace header,
+// e.g. "goroutine 123 [running]:". Only the header is captured, into a buffer on the stack.
+func goroutineID() uint64 {
+	var buf [64]byte
+	stack := buf[:runtime.Stack(buf[:], false)]
+
+	const prefix = "goroutine "
+	if len(stack) < len(prefix) || string(stack[:len(prefix)]) != prefix {
+		return 0
+	}
+
+	var id uint64
+	for _, c := range stack[len(prefix):] {
+		if c < '0' || c > '9' {
+			break
+		}
+		id = id*10 + uint64(c-'0')
+	}
+	return id
+}
@@ -0,0 +1,107 @@
+package log4go
+
+import (
+	"sync"
+	"time"
+)
+
+// batchItem is a (formatted) record, waiting to be sent.
+type batchItem struct {
+	record  Record
+	message []byte
+}
+
+// throttledBatch collects items and sends them in batches, at most one batch per interval.
+// The first item after a quiet period is sent immediately.
+type throttledBatch struct {
+	interval time.Duration
+	sendFunc func(batch []batchItem)
+
+	lock     sync.Mutex
+	pending  []batchItem
+	lastSent time.Time
+	timer    *time.Timer
+	inflight int        // sends started or scheduled (by the timer), guarded by lock
+	idle     *sync.Cond // signaled when inflight drops to zero
+}
+
+func newThrottledBatch(interval time.Duration, sendFunc func(batch []batchItem)) *throttledBatch {
+	b := &throttledBatch{
+		interval: interval,
+		sendFunc: sendFunc,
+	}
+	b.idle = sync.NewCond(&b.lock)
+	return b
+}
+
+// add queues an item, sending it right away or when the interval has passed.
+func (b *throttledBatch) add(item batchItem) {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	b.pending = append(b.pending, item)
+
+	if wait := b.interval - time.Since(b.lastSent); wait <= 0 {
+		b.lastSent = time.Now()
+		b.inflight++
+		go b.tracked()
+	} else if b.timer == nil {
+		b.inflight++
+		b.timer = time.AfterFunc(wait, b.scheduled)
+	}
+}
+
+// scheduled sends the pending items when the timer fires.
+func (b *throttledBatch) scheduled() {
+	b.lock.Lock()
+	b.timer = nil
+	b.lock.Unlock()
+
+	b.tracked()
+}
+
+// tracked sends the pending items, as one of the sends in flight.
+func (b *throttledBatch) tracked() {
+	defer func() {
+		b.lock.Lock()
+		if b.inflight--; b.inflight == 0 {
+			b.idle.Broadcast()
+		}
+		b.lock.Unlock()
+	}()
+	b.send()
+}
+
+// send sends all pending items (if any).
+func (b *throttledBatch) send() {
+	b.lock.Lock()
+	pending := b.pending
+	b.pending = nil
+	if len(pending) > 0 {
+		b.lastSent = time.Now()
+	}
+	b.lock.Unlock()
+
+	if len(pending) > 0 {
+		b.sendFunc(pending)
+	}
+}
+
+// flush cancels any scheduled send and sends all pending items immediately,
+// returning when the sends in flight have finished too.
+func (b *throttledBatch) flush() {
+	b.lock.Lock()
+	if b.timer != nil && b.timer.Stop() {
+		b.timer = nil
+		b.inflight--
+	}
+	b.lock.Unlock()
+
+	b.send()
+
+	b.lock.Lock()
+	for b.inflight > 0 {
+		b.idle.Wait()
+	}
+	b.lock.Unlock()
+}
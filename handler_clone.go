@@ -0,0 +1,62 @@
+package log4go
+
+import "sync"
+
+// writerShare serializes the writes of a StreamHandler and its clones (see Clone) to their common writer,
+// which is closed when the last of them is shut down.
+type writerShare struct {
+	lock sync.Mutex
+	refs int // the handlers open, guarded by lock
+}
+
+// writeShared writes to the writer, serialized with the handler's clones.
+func (h *StreamHandler) writeShared(p []byte) (int, error) {
+	h.share.lock.Lock()
+	defer h.share.lock.Unlock()
+
+	return h.writer.Write(p)
+}
+
+// Clone returns a new handler writing to the same writer (e.g. the file of a FileHandler), with its own queue
+// and committer; e.g. one for each worker goroutine (logging by its own logger), where a single queue becomes
+// the point of contention. The writes of the handler and its clones are merged, i.e. serialized record by record,
+// and the writer is closed (if owned) when the last of them is shut down. The clone has the handler's formatter,
+// levels, synchronous mode and queue options, but no footer nor status line. It returns ErrClosed if the handler
+// has been shut down.
+func (h *StreamHandler) Clone() (*StreamHandler, error) {
+	if h.sync {
+		h.writeLock.Lock()
+		defer h.writeLock.Unlock()
+
+		if h.closed {
+			return nil, ErrClosed
+		}
+	} else {
+		// keeps the handler from being shut down meanwhile, i.e. from closing the writer
+		h.lock.RLock()
+		defer h.lock.RUnlock()
+
+		if h.queue.isClosed() {
+			return nil, ErrClosed
+		}
+	}
+
+	h.share.lock.Lock()
+	h.share.refs++
+	h.share.lock.Unlock()
+
+	opts := StreamOpts{
+		Sync:       h.sync,
+		SlowWrite:  h.writeStats.threshold,
+		OwnsWriter: h.ownsWriter,
+	}
+	if !h.sync {
+		opts.QueueSize, opts.Wait = len(h.queue.slots), h.queue.wait
+	}
+	clone, _ := NewStreamHandler(h.writer, opts)
+	clone.share = h.share
+	clone.formatter = h.formatter
+	clone.level = h.level
+	clone.maxLevel = h.maxLevel
+	return clone, nil
+}
@@ -0,0 +1,393 @@
+package log4go
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// CloudWatchOpts is used to supply options to NewCloudWatchHandler.
+type CloudWatchOpts struct {
+	// Region of the CloudWatch Logs service, default from $AWS_REGION.
+	Region string
+	// Group is the log group name, created if missing.
+	Group string
+	// Stream is the log stream name, created if missing.
+	Stream string
+	// AccessKeyID, SecretAccessKey and SessionToken are the credentials, default from
+	// $AWS_ACCESS_KEY_ID, $AWS_SECRET_ACCESS_KEY and $AWS_SESSION_TOKEN.
+	AccessKeyID     string
+	SecretAccessKey string
+	SessionToken    string
+	// FlushInterval is the maximum age of a batch before it's sent (default 5 seconds).
+	FlushInterval time.Duration
+	// Endpoint overrides the service URL (default "https://logs.<region>.amazonaws.com/").
+	Endpoint string
+	// Client is used to send the requests (default http.DefaultClient).
+	Client *http.Client
+}
+
+// limits imposed by PutLogEvents
+const (
+	cloudWatchMaxBatchEvents = 10000
+	cloudWatchMaxBatchBytes  = 1048576
+	cloudWatchEventOverhead  = 26
+)
+
+// CloudWatchHandler sends records to AWS CloudWatch Logs.
+// Records are batched, a batch is sent when it reaches the service's size limits
+// or when its oldest record reaches the flush interval.
+type CloudWatchHandler struct {
+	opts      CloudWatchOpts
+	formatter Formatter
+	level     Level
+
+	lock          sync.RWMutex // guards commitChannel against being closed while sending
+	commitChannel chan Record
+	done          chan struct{}
+
+	// only accessed by the committer goroutine
+	batch         []cloudWatchEvent
+	batchBytes    int
+	sequenceToken string
+}
+
+type cloudWatchEvent struct {
+	Timestamp int64  `json:"timestamp"`
+	Message   string `json:"message"`
+}
+
+// NewCloudWatchHandler returns a new CloudWatchHandler instance.
+func NewCloudWatchHandler(opts CloudWatchOpts) (*CloudWatchHandler, error) {
+	if len(opts.Region) == 0 {
+		opts.Region = os.Getenv("AWS_REGION")
+	}
+	if len(opts.AccessKeyID) == 0 {
+		opts.AccessKeyID = os.Getenv("AWS_ACCESS_KEY_ID")
+		opts.SecretAccessKey = os.Getenv("AWS_SECRET_ACCESS_KEY")
+		opts.SessionToken = os.Getenv("AWS_SESSION_TOKEN")
+	}
+	if len(opts.Group) == 0 || len(opts.Stream) == 0 {
+		return nil, errors.New("log group and stream must be specified")
+	}
+	if len(opts.Region) == 0 || len(opts.AccessKeyID) == 0 {
+		return nil, errors.New("AWS region and credentials must be specified")
+	}
+	if len(opts.Endpoint) == 0 {
+		opts.Endpoint = fmt.Sprintf("https://logs.%s.amazonaws.com/", opts.Region)
+	}
+	if opts.FlushInterval <= 0 {
+		opts.FlushInterval = 5 * time.Second
+	}
+	if opts.Client == nil {
+		opts.Client = http.DefaultClient
+	}
+
+	h := &CloudWatchHandler{
+		opts:          opts,
+		commitChannel: make(chan Record, 1000),
+		done:          make(chan struct{}),
+	}
+
+	go h.committer(h.commitChannel)
+
+	return h, nil
+}
+
+// Handle queues the record for the next batch.
+func (h *CloudWatchHandler) Handle(rec *Record) error {
+	if rec.Level < h.level {
+		return nil
+	}
+
+	h.lock.RLock()
+	defer h.lock.RUnlock()
+
+	if h.commitChannel != nil {
+		h.commitChannel <- *rec
+	}
+	return nil
+}
+
+func (h *CloudWatchHandler) committer(commitChannel <-chan Record) {
+	defer close(h.done)
+
+	ticker := time.NewTicker(h.opts.FlushInterval / 2)
+	defer ticker.Stop()
+
+	var oldest time.Time
+
+	for {
+		select {
+		case rec, ok := <-commitChannel:
+			if !ok {
+				h.flush()
+				return
+			}
+
+			msg, err := h.formatter.Format(&rec)
+			if err != nil {
+				report(ERROR, "CloudWatchHandler", "formatter error %v", err)
+				continue
+			}
+			size := len(msg) + cloudWatchEventOverhead
+			if len(h.batch) >= cloudWatchMaxBatchEvents || h.batchBytes+size > cloudWatchMaxBatchBytes {
+				h.flush()
+			}
+			if len(h.batch) == 0 {
+				oldest = time.Now()
+			}
+			h.batch = append(h.batch, cloudWatchEvent{
+				Timestamp: rec.Time.UnixNano() / int64(time.Millisecond),
+				Message:   string(msg),
+			})
+			h.batchBytes += size
+
+		case <-ticker.C:
+			if len(h.batch) > 0 && time.Since(oldest) >= h.opts.FlushInterval {
+				h.flush()
+			}
+		}
+	}
+}
+
+// flush sends the current batch (creating the group/stream, or updating the sequence token, as needed).
+func (h *CloudWatchHandler) flush() {
+	if len(h.batch) == 0 {
+		return
+	}
+
+	err := h.putLogEvents()
+
+	if awsErr, ok := err.(*cloudWatchError); ok {
+		switch awsErr.Type {
+		case "ResourceNotFoundException":
+			if err = h.createGroupAndStream(); err == nil {
+				h.sequenceToken = ""
+				err = h.putLogEvents()
+			}
+		case "InvalidSequenceTokenException", "DataAlreadyAcceptedException":
+			if token := awsErr.ExpectedSequenceToken; len(token) > 0 {
+				h.sequenceToken = token
+				if awsErr.Type == "InvalidSequenceTokenException" {
+					err = h.putLogEvents()
+				} else {
+					err = nil
+				}
+			}
+		}
+	}
+	if err != nil {
+		report(ERROR, "CloudWatchHandler", "dropped %d records: %v", len(h.batch), err)
+	}
+
+	h.batch = h.batch[:0]
+	h.batchBytes = 0
+}
+
+func (h *CloudWatchHandler) putLogEvents() error {
+	request := map[string]interface{}{
+		"logGroupName":  h.opts.Group,
+		"logStreamName": h.opts.Stream,
+		"logEvents":     h.batch,
+	}
+	if len(h.sequenceToken) > 0 {
+		request["sequenceToken"] = h.sequenceToken
+	}
+
+	var response struct {
+		NextSequenceToken string `json:"nextSequenceToken"`
+	}
+	if err := h.call("PutLogEvents", request, &response); err != nil {
+		return err
+	}
+	h.sequenceToken = response.NextSequenceToken
+	return nil
+}
+
+func (h *CloudWatchHandler) createGroupAndStream() error {
+	err := h.call("CreateLogGroup", map[string]string{"logGroupName": h.opts.Group}, nil)
+	if awsErr, ok := err.(*cloudWatchError); ok && awsErr.Type == "ResourceAlreadyExistsException" {
+		err = nil
+	}
+	if err != nil {
+		return err
+	}
+
+	err = h.call("CreateLogStream", map[string]string{"logGroupName": h.opts.Group, "logStreamName": h.opts.Stream}, nil)
+	if awsErr, ok := err.(*cloudWatchError); ok && awsErr.Type == "ResourceAlreadyExistsException" {
+		err = nil
+	}
+	return err
+}
+
+// cloudWatchError is an error response from the service.
+type cloudWatchError struct {
+	Type                  string `json:"__type"`
+	Message               string `json:"message"`
+	ExpectedSequenceToken string `json:"expectedSequenceToken"`
+}
+
+func (e *cloudWatchError) Error() string {
+	return fmt.Sprintf("%s: %s", e.Type, e.Message)
+}
+
+// call invokes an action of the CloudWatch Logs API.
+func (h *CloudWatchHandler) call(action string, request interface{}, response interface{}) error {
+	payload, err := json.Marshal(request)
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequest(http.MethodPost, h.opts.Endpoint, bytes.NewReader(payload))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/x-amz-json-1.1")
+	req.Header.Set("X-Amz-Target", "Logs_20140328."+action)
+	signAWSv4(req, payload, h.opts.Region, "logs", h.opts.AccessKeyID, h.opts.SecretAccessKey, h.opts.SessionToken, time.Now())
+
+	resp, err := h.opts.Client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+
+	if resp.StatusCode >= 300 {
+		awsErr := &cloudWatchError{}
+		if json.Unmarshal(body, awsErr) != nil || len(awsErr.Type) == 0 {
+			return fmt.Errorf("request failed: %s", resp.Status)
+		}
+		// the type might be prefixed by a namespace, e.g. "com.amazonaws.logs#ResourceNotFoundException"
+		if idx := strings.LastIndexByte(awsErr.Type, '#'); idx >= 0 {
+			awsErr.Type = awsErr.Type[idx+1:]
+		}
+		return awsErr
+	}
+
+	if response != nil && len(body) > 0 {
+		return json.Unmarshal(body, response)
+	}
+	return nil
+}
+
+// signAWSv4 signs a request using AWS Signature Version 4.
+func signAWSv4(req *http.Request, payload []byte, region, service, keyID, secret, token string, now time.Time) {
+	now = now.UTC()
+	amzDate := now.Format("20060102T150405Z")
+	day := now.Format("20060102")
+
+	req.Header.Set("X-Amz-Date", amzDate)
+	if len(token) > 0 {
+		req.Header.Set("X-Amz-Security-Token", token)
+	}
+
+	headers := map[string]string{"host": req.URL.Host}
+	for name, values := range req.Header {
+		headers[strings.ToLower(name)] = strings.TrimSpace(strings.Join(values, ","))
+	}
+	names := make([]string, 0, len(headers))
+	for name := range headers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var canonicalHeaders strings.Builder
+	for _, name := range names {
+		canonicalHeaders.WriteString(name + ":" + headers[name] + "\n")
+	}
+	signedHeaders := strings.Join(names, ";")
+
+	path := req.URL.EscapedPath()
+	if len(path) == 0 {
+		path = "/"
+	}
+	payloadHash := sha256.Sum256(payload)
+	canonicalRequest := strings.Join([]string{
+		req.Method,
+		path,
+		canonicalQuery(req.URL.Query()),
+		canonicalHeaders.String(),
+		signedHeaders,
+		hex.EncodeToString(payloadHash[:]),
+	}, "\n")
+
+	scope := strings.Join([]string{day, region, service, "aws4_request"}, "/")
+	requestHash := sha256.Sum256([]byte(canonicalRequest))
+	stringToSign := strings.Join([]string{
+		"AWS4-HMAC-SHA256",
+		amzDate,
+		scope,
+		hex.EncodeToString(requestHash[:]),
+	}, "\n")
+
+	key := []byte("AWS4" + secret)
+	for _, part := range []string{day, region, service, "aws4_request"} {
+		key = hmacSHA256(key, part)
+	}
+	signature := hex.EncodeToString(hmacSHA256(key, stringToSign))
+
+	req.Header.Set("Authorization", fmt.Sprintf("AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		keyID, scope, signedHeaders, signature))
+}
+
+func canonicalQuery(query url.Values) string {
+	// url.Values.Encode sorts by key, but encodes spaces as '+', which AWS doesn't accept
+	return strings.Replace(query.Encode(), "+", "%20", -1)
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(data))
+	return mac.Sum(nil)
+}
+
+// SetFormatter sets the handler's Formatter.
+func (h *CloudWatchHandler) SetFormatter(formatter Formatter) {
+	h.formatter = formatter
+}
+
+// Formatter returns the handler's Formatter.
+func (h *CloudWatchHandler) Formatter() Formatter {
+	return h.formatter
+}
+
+// SetLevel sets the level the handler will (at least) handle.
+func (h *CloudWatchHandler) SetLevel(level Level) {
+	h.level = level
+}
+
+// Level returns the level previously set (or INHERIT if not set).
+func (h *CloudWatchHandler) Level() Level {
+	return h.level
+}
+
+// Shutdown sends any batched records and waits for the committer to finish.
+func (h *CloudWatchHandler) Shutdown() {
+	h.lock.Lock()
+	cc := h.commitChannel
+	h.commitChannel = nil
+	h.lock.Unlock()
+
+	if cc != nil {
+		close(cc)
+		<-h.done
+	}
+}
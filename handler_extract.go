@@ -0,0 +1,139 @@
+package log4go
+
+import (
+	"regexp"
+	"strconv"
+	"time"
+)
+
+// Extraction promotes the named groups of a regular expression matching a record's message into fields,
+// e.g. `status=(?P<status>\d+)` adds the field "status"; easing migration from unstructured messages.
+type Extraction struct {
+	Regex *regexp.Regexp
+	// Convert converts the values into integers, floats or durations (e.g. "1.5ms"), if they parse as such;
+	// otherwise they're strings.
+	Convert bool
+	// Strip removes the matched text from the message.
+	Strip bool
+}
+
+// ExtractHandler adds fields extracted from the records' messages (see Extraction)
+// before passing them on to a target handler.
+// Fields already present in a record are not overwritten.
+type ExtractHandler struct {
+	target      Handler
+	extractions []Extraction
+	level       Level
+}
+
+// NewExtractHandler returns a new ExtractHandler, passing the records on to the target handler.
+// The extractions are applied in order.
+func NewExtractHandler(target Handler, extractions ...Extraction) *ExtractHandler {
+	return &ExtractHandler{
+		target:      target,
+		extractions: extractions,
+	}
+}
+
+// Handle passes the record, with the extracted fields added, on to the target handler.
+func (h *ExtractHandler) Handle(rec *Record) error {
+	if !handles(h.target, rec.Level) {
+		return nil
+	}
+
+	var extracted *Record
+	for _, ex := range h.extractions {
+		message := rec.Message
+		if extracted != nil {
+			message = extracted.Message
+		}
+		match := ex.Regex.FindStringSubmatchIndex(message)
+		if match == nil {
+			continue
+		}
+
+		if extracted == nil {
+			// the record (and its fields) must not be modified; extend a copy
+			extracted = rec.Clone()
+			if extracted.Fields == nil {
+				extracted.Fields = make(Fields, len(ex.Regex.SubexpNames()))
+			}
+		}
+
+		for idx, name := range ex.Regex.SubexpNames() {
+			if idx == 0 || len(name) == 0 || match[2*idx] < 0 {
+				continue
+			}
+			if _, exists := extracted.Fields[name]; exists {
+				continue
+			}
+			value := message[match[2*idx]:match[2*idx+1]]
+			if ex.Convert {
+				extracted.Fields[name] = convertExtracted(value)
+			} else {
+				extracted.Fields[name] = value
+			}
+		}
+		if ex.Strip {
+			extracted.Message = message[:match[0]] + message[match[1]:]
+		}
+	}
+
+	if extracted == nil {
+		return h.target.Handle(rec)
+	}
+	return h.target.Handle(extracted)
+}
+
+// convertExtracted returns the value as an integer, float or duration, if it parses as one.
+func convertExtracted(value string) interface{} {
+	if n, err := strconv.ParseInt(value, 10, 64); err == nil {
+		return n
+	}
+	if f, err := strconv.ParseFloat(value, 64); err == nil {
+		return f
+	}
+	if d, err := time.ParseDuration(value); err == nil {
+		return d
+	}
+	return value
+}
+
+// Target returns the handler the records are passed on to.
+func (h *ExtractHandler) Target() Handler {
+	return h.target
+}
+
+func (h *ExtractHandler) wrappedHandlers() []Handler {
+	return []Handler{h.target}
+}
+
+// SetFormatter sets the target handler's Formatter.
+func (h *ExtractHandler) SetFormatter(formatter Formatter) {
+	h.target.SetFormatter(formatter)
+}
+
+// Formatter returns the target handler's Formatter.
+func (h *ExtractHandler) Formatter() Formatter {
+	return h.target.Formatter()
+}
+
+// SetLevel sets the level the handler will (at least) handle.
+func (h *ExtractHandler) SetLevel(level Level) {
+	h.level = level
+}
+
+// Level returns the level previously set (or INHERIT if not set).
+func (h *ExtractHandler) Level() Level {
+	return h.level
+}
+
+// Flush flushes the target handler.
+func (h *ExtractHandler) Flush() error {
+	return FlushHandler(h.target)
+}
+
+// Shutdown shuts down the target handler.
+func (h *ExtractHandler) Shutdown() {
+	h.target.Shutdown()
+}
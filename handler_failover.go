@@ -0,0 +1,162 @@
+package log4go
+
+import (
+	"errors"
+	"sync"
+	"time"
+)
+
+// errUnhealthy is the reason a handler reporting itself unhealthy is failed over from.
+var errUnhealthy = errors.New("log4go: handler unhealthy")
+
+// FailoverHandler passes records on to the first available of several handlers, in priority order;
+// e.g. a RelayHandler (to a remote collector) falling back to a local FileHandler.
+// A handler is failed over from when Handle returns an error, or when it reports itself unhealthy
+// (see StreamHandler.Health and RelayHandler.Health), and tried again after the retry interval,
+// failing back once it's healthy again. In doubt, records are also passed on to the next handler,
+// i.e. none are lost, but some might be duplicated. The last handler is used if none is available.
+type FailoverHandler struct {
+	handlers []Handler
+	level    Level
+
+	lock          sync.Mutex // guards the below
+	retryInterval time.Duration
+	retryAt       []time.Time // zero while the handler is available
+	active        int         // index of the handler used last
+}
+
+// NewFailoverHandler returns a new FailoverHandler, passing records on to the primary handler,
+// or the fallbacks (in order) while it fails.
+func NewFailoverHandler(primary Handler, fallbacks ...Handler) *FailoverHandler {
+	handlers := append([]Handler{primary}, fallbacks...)
+	return &FailoverHandler{
+		handlers:      handlers,
+		retryInterval: 30 * time.Second,
+		retryAt:       make([]time.Time, len(handlers)),
+	}
+}
+
+// SetRetryInterval sets the time after which a failed handler is tried again (default 30 seconds).
+func (h *FailoverHandler) SetRetryInterval(interval time.Duration) {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+
+	h.retryInterval = interval
+}
+
+// Handle passes the record on to the first available handler, and on to the next ones while they fail.
+// Handlers whose level (or max level) excludes the record are passed over.
+// It returns the error of the last handler tried, if all failed.
+func (h *FailoverHandler) Handle(rec *Record) error {
+	now := time.Now()
+	last := len(h.handlers) - 1
+
+	var err error
+	for idx, handler := range h.handlers {
+		h.lock.Lock()
+		skip := idx < last && now.Before(h.retryAt[idx])
+		h.lock.Unlock()
+		if skip {
+			continue
+		}
+
+		if !handles(handler, rec.Level) {
+			continue // not failing over from it, e.g. a primary for errors only
+		}
+		if err = handler.Handle(rec); err == nil && handlerHealthy(handler) {
+			h.succeeded(idx)
+			return nil
+		}
+		if err == nil {
+			err = errUnhealthy
+		}
+		h.failed(idx, now, err)
+	}
+	return err
+}
+
+// handlerHealthy returns whether the handler is healthy, if it reports its health.
+func handlerHealthy(handler Handler) bool {
+	if reporter, ok := handler.(interface{ Health() HandlerHealth }); ok {
+		return reporter.Health().Healthy
+	}
+	return true
+}
+
+// succeeded makes the handler the active one, available again (i.e. failing back to it).
+func (h *FailoverHandler) succeeded(idx int) {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+
+	h.retryAt[idx] = time.Time{}
+	if idx != h.active {
+		if idx < h.active {
+			report(INFO, "FailoverHandler", "failing back to %T", h.handlers[idx])
+		}
+		h.active = idx
+	}
+}
+
+// failed makes the handler unavailable until the retry interval has passed.
+func (h *FailoverHandler) failed(idx int, now time.Time, err error) {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+
+	if h.retryAt[idx].IsZero() && idx+1 < len(h.handlers) {
+		report(WARNING, "FailoverHandler", "%T failed (%v), failing over to %T",
+			h.handlers[idx], err, h.handlers[idx+1])
+	}
+	h.retryAt[idx] = now.Add(h.retryInterval)
+}
+
+// Active returns the handler the last record was passed on to (successfully).
+func (h *FailoverHandler) Active() Handler {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+
+	return h.handlers[h.active]
+}
+
+func (h *FailoverHandler) wrappedHandlers() []Handler {
+	return h.handlers
+}
+
+// SetFormatter sets the Formatter of all the handlers.
+func (h *FailoverHandler) SetFormatter(formatter Formatter) {
+	for _, handler := range h.handlers {
+		handler.SetFormatter(formatter)
+	}
+}
+
+// Formatter returns the primary handler's Formatter.
+func (h *FailoverHandler) Formatter() Formatter {
+	return h.handlers[0].Formatter()
+}
+
+// SetLevel sets the level the handler will (at least) handle.
+func (h *FailoverHandler) SetLevel(level Level) {
+	h.level = level
+}
+
+// Level returns the level previously set (or INHERIT if not set).
+func (h *FailoverHandler) Level() Level {
+	return h.level
+}
+
+// Flush flushes all the handlers, returning the first error.
+func (h *FailoverHandler) Flush() error {
+	var err error
+	for _, handler := range h.handlers {
+		if e := FlushHandler(handler); e != nil && err == nil {
+			err = e
+		}
+	}
+	return err
+}
+
+// Shutdown shuts down all the handlers.
+func (h *FailoverHandler) Shutdown() {
+	for _, handler := range h.handlers {
+		handler.Shutdown()
+	}
+}
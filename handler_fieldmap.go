@@ -0,0 +1,104 @@
+package log4go
+
+// FieldMap describes how FieldMapHandler transforms the fields of records.
+type FieldMap struct {
+	// Rename maps field keys to new ones, e.g. "trace_id" to "traceID".
+	Rename map[string]string
+	// Drop lists the keys of fields to remove (before renaming), e.g. large payloads.
+	Drop []string
+	// Func, if set, is called for each remaining (renamed) field, returning the key and value to use,
+	// or false to remove the field.
+	Func func(key string, value interface{}) (string, interface{}, bool)
+}
+
+// FieldMapHandler transforms the fields of records (renaming or dropping them) before passing them on
+// to a target handler, so each destination may get its own schema while e.g. a file handler keeps everything.
+type FieldMapHandler struct {
+	target   Handler
+	fieldMap FieldMap
+	drop     map[string]bool
+	level    Level
+}
+
+// NewFieldMapHandler returns a new FieldMapHandler, passing the transformed records on to the target handler.
+func NewFieldMapHandler(target Handler, fieldMap FieldMap) *FieldMapHandler {
+	drop := make(map[string]bool, len(fieldMap.Drop))
+	for _, key := range fieldMap.Drop {
+		drop[key] = true
+	}
+	return &FieldMapHandler{
+		target:   target,
+		fieldMap: fieldMap,
+		drop:     drop,
+	}
+}
+
+// Handle passes the record, with its fields transformed, on to the target handler.
+func (h *FieldMapHandler) Handle(rec *Record) error {
+	if !handles(h.target, rec.Level) {
+		return nil
+	}
+	if len(rec.Fields) == 0 {
+		return h.target.Handle(rec)
+	}
+
+	// the record (and its fields) must not be modified; transform a copy
+	mapped := *rec
+	mapped.Fields = make(Fields, len(rec.Fields))
+	for key, value := range rec.Fields {
+		if h.drop[key] {
+			continue
+		}
+		if renamed, exists := h.fieldMap.Rename[key]; exists {
+			key = renamed
+		}
+		if h.fieldMap.Func != nil {
+			var keep bool
+			if key, value, keep = h.fieldMap.Func(key, value); !keep {
+				continue
+			}
+		}
+		mapped.Fields[key] = value
+	}
+
+	return h.target.Handle(&mapped)
+}
+
+// Target returns the handler the records are passed on to.
+func (h *FieldMapHandler) Target() Handler {
+	return h.target
+}
+
+func (h *FieldMapHandler) wrappedHandlers() []Handler {
+	return []Handler{h.target}
+}
+
+// SetFormatter sets the target handler's Formatter.
+func (h *FieldMapHandler) SetFormatter(formatter Formatter) {
+	h.target.SetFormatter(formatter)
+}
+
+// Formatter returns the target handler's Formatter.
+func (h *FieldMapHandler) Formatter() Formatter {
+	return h.target.Formatter()
+}
+
+// SetLevel sets the level the handler will (at least) handle.
+func (h *FieldMapHandler) SetLevel(level Level) {
+	h.level = level
+}
+
+// Level returns the level previously set (or INHERIT if not set).
+func (h *FieldMapHandler) Level() Level {
+	return h.level
+}
+
+// Flush flushes the target handler.
+func (h *FieldMapHandler) Flush() error {
+	return FlushHandler(h.target)
+}
+
+// Shutdown shuts down the target handler.
+func (h *FieldMapHandler) Shutdown() {
+	h.target.Shutdown()
+}
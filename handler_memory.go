@@ -0,0 +1,117 @@
+package log4go
+
+import "sync"
+
+// MemoryHandler buffers records in memory and passes them on to a target handler only when
+// a record of (at least) the flush level arrives; i.e. lower level records are only written
+// as context to a more severe one.
+type MemoryHandler struct {
+	target     Handler
+	flushLevel Level
+	level      Level
+
+	lock   sync.Mutex
+	buffer []Record // ring buffer
+	next   int      // index to write the next record to
+	full   bool     // whether the buffer has wrapped
+}
+
+// NewMemoryHandler returns a new MemoryHandler, buffering (at most) capacity records.
+func NewMemoryHandler(capacity int, flushLevel Level, target Handler) *MemoryHandler {
+	if capacity < 1 {
+		capacity = 1
+	}
+	return &MemoryHandler{
+		target:     target,
+		flushLevel: flushLevel,
+		buffer:     make([]Record, capacity),
+	}
+}
+
+// Handle buffers the record, or flushes the buffer (followed by the record) if its level is high enough.
+func (h *MemoryHandler) Handle(rec *Record) error {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+
+	if rec.Level < h.flushLevel {
+		h.buffer[h.next] = *rec
+		h.next = (h.next + 1) % len(h.buffer)
+		if h.next == 0 {
+			h.full = true
+		}
+		return nil
+	}
+
+	if err := h.flush(); err != nil {
+		return err
+	}
+	return h.target.Handle(rec)
+}
+
+// Flush passes all buffered records to the target handler.
+func (h *MemoryHandler) Flush() error {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+
+	return h.flush()
+}
+
+func (h *MemoryHandler) flush() error {
+	var err error
+
+	count, start := h.next, 0
+	if h.full {
+		count, start = len(h.buffer), h.next
+	}
+	for n := 0; n < count; n++ {
+		idx := (start + n) % len(h.buffer)
+		if e := h.target.Handle(&h.buffer[idx]); e != nil && err == nil {
+			err = e
+		}
+		h.buffer[idx] = Record{}
+	}
+	h.next = 0
+	h.full = false
+
+	return err
+}
+
+// Target returns the handler records are flushed to.
+func (h *MemoryHandler) Target() Handler {
+	return h.target
+}
+
+func (h *MemoryHandler) wrappedHandlers() []Handler {
+	return []Handler{h.target}
+}
+
+// SetFormatter sets the target handler's Formatter.
+func (h *MemoryHandler) SetFormatter(formatter Formatter) {
+	h.target.SetFormatter(formatter)
+}
+
+// Formatter returns the target handler's Formatter.
+func (h *MemoryHandler) Formatter() Formatter {
+	return h.target.Formatter()
+}
+
+// SetLevel sets the level the handler will (at least) handle.
+func (h *MemoryHandler) SetLevel(level Level) {
+	h.level = level
+}
+
+// Level returns the level previously set (or INHERIT if not set).
+func (h *MemoryHandler) Level() Level {
+	return h.level
+}
+
+// Shutdown discards any buffered records and shuts down the target handler.
+func (h *MemoryHandler) Shutdown() {
+	h.lock.Lock()
+	h.buffer = make([]Record, len(h.buffer))
+	h.next = 0
+	h.full = false
+	h.lock.Unlock()
+
+	h.target.Shutdown()
+}
@@ -0,0 +1,198 @@
+package log4go
+
+import (
+	"hash/fnv"
+	"sync"
+)
+
+// ParallelOpts is used to supply options to NewParallelHandler.
+type ParallelOpts struct {
+	// Workers is the number of goroutines passing records to the target handler (default 4).
+	Workers int
+	// KeyByLogger keeps the records of each logger in order, by passing them all through the same goroutine.
+	// Otherwise records are passed on by whichever goroutine is available.
+	KeyByLogger bool
+	// QueueSize is the number of records queued (for each goroutine with KeyByLogger; default 1000).
+	QueueSize int
+}
+
+// ParallelHandler passes records on to a target handler from several goroutines, for targets whose
+// Handle benefits from parallelism, e.g. one sending each record in an HTTP request.
+// The target must be safe for concurrent use, and records might be passed on out of order
+// (unless keyed by logger). Handlers writing files are best left with their own (single) committer.
+type ParallelHandler struct {
+	target     Handler
+	level      Level
+	numWorkers int
+
+	lock     sync.RWMutex        // guards channels against being closed while sending
+	channels []chan parallelItem // one shared by all workers, or one for each with KeyByLogger
+	workers  sync.WaitGroup
+	done     chan struct{}
+
+	flushLock sync.Mutex // serializes flushes (their markers must not interleave)
+}
+
+// parallelItem is a record to pass on, or a flush marker.
+type parallelItem struct {
+	rec   Record
+	flush *sync.WaitGroup
+}
+
+// NewParallelHandler returns a new ParallelHandler, passing the records on to the target handler.
+func NewParallelHandler(target Handler, opts ...ParallelOpts) *ParallelHandler {
+	var o ParallelOpts
+	if len(opts) > 0 {
+		o = opts[0]
+	}
+	if o.Workers < 1 {
+		o.Workers = 4
+	}
+	if o.QueueSize < 1 {
+		o.QueueSize = 1000
+	}
+
+	h := &ParallelHandler{
+		target:     target,
+		numWorkers: o.Workers,
+		done:       make(chan struct{}),
+	}
+	numChannels := 1
+	if o.KeyByLogger {
+		numChannels = o.Workers
+	}
+	for idx := 0; idx < numChannels; idx++ {
+		h.channels = append(h.channels, make(chan parallelItem, o.QueueSize))
+	}
+
+	h.workers.Add(o.Workers)
+	for idx := 0; idx < o.Workers; idx++ {
+		go h.worker(h.channels[idx%numChannels])
+	}
+
+	return h
+}
+
+// Handle queues the record to be passed on.
+// It returns ErrClosed after the handler has been shut down.
+func (h *ParallelHandler) Handle(rec *Record) error {
+	if !handles(h.target, rec.Level) {
+		return nil
+	}
+
+	h.lock.RLock()
+	defer h.lock.RUnlock()
+
+	if h.channels == nil {
+		return ErrClosed
+	}
+	channel := h.channels[0]
+	if len(h.channels) > 1 {
+		key := fnv.New32a()
+		key.Write([]byte(rec.Name))
+		channel = h.channels[key.Sum32()%uint32(len(h.channels))]
+	}
+	channel <- parallelItem{rec: *rec}
+	return nil
+}
+
+func (h *ParallelHandler) worker(channel <-chan parallelItem) {
+	defer h.workers.Done()
+
+	for item := range channel {
+		if item.flush != nil {
+			// wait for all workers to reach the flush, i.e. to have passed on the records queued before it
+			// (and make sure each worker takes just one marker)
+			item.flush.Done()
+			item.flush.Wait()
+			continue
+		}
+		if err := h.target.Handle(&item.rec); err != nil && err != ErrDropped {
+			report(ERROR, "ParallelHandler", "%v", err)
+		}
+	}
+}
+
+// Flush returns when the records queued have been passed on, and the target handler flushed.
+func (h *ParallelHandler) Flush() error {
+	h.flushLock.Lock()
+	defer h.flushLock.Unlock()
+
+	flush := &sync.WaitGroup{}
+	flush.Add(h.numWorkers)
+
+	h.lock.RLock()
+	if h.channels == nil {
+		h.lock.RUnlock()
+		return ErrClosed
+	}
+	if len(h.channels) == 1 {
+		for idx := 0; idx < h.numWorkers; idx++ {
+			h.channels[0] <- parallelItem{flush: flush}
+		}
+	} else {
+		for _, channel := range h.channels {
+			channel <- parallelItem{flush: flush}
+		}
+	}
+	h.lock.RUnlock()
+
+	flush.Wait()
+	return FlushHandler(h.target)
+}
+
+// Target returns the handler the records are passed on to.
+func (h *ParallelHandler) Target() Handler {
+	return h.target
+}
+
+func (h *ParallelHandler) wrappedHandlers() []Handler {
+	return []Handler{h.target}
+}
+
+// SetFormatter sets the target handler's Formatter.
+func (h *ParallelHandler) SetFormatter(formatter Formatter) {
+	h.target.SetFormatter(formatter)
+}
+
+// Formatter returns the target handler's Formatter.
+func (h *ParallelHandler) Formatter() Formatter {
+	return h.target.Formatter()
+}
+
+// SetLevel sets the level the handler will (at least) handle.
+func (h *ParallelHandler) SetLevel(level Level) {
+	h.level = level
+}
+
+// Level returns the level previously set (or INHERIT if not set).
+func (h *ParallelHandler) Level() Level {
+	return h.level
+}
+
+// Shutdown passes on the queued records, then shuts down the target handler.
+// Subsequent calls only wait for that.
+func (h *ParallelHandler) Shutdown() {
+	h.Close()
+}
+
+// Close does the same as Shutdown, returning ErrClosed if the handler was already closed
+// (or the target handler's error).
+func (h *ParallelHandler) Close() error {
+	h.lock.Lock()
+	channels := h.channels
+	h.channels = nil
+	h.lock.Unlock()
+
+	if channels == nil {
+		<-h.done
+		return ErrClosed
+	}
+	for _, channel := range channels {
+		close(channel)
+	}
+	h.workers.Wait()
+	err := CloseHandler(h.target)
+	close(h.done)
+	return err
+}
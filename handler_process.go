@@ -0,0 +1,70 @@
+package log4go
+
+// ProcessHandler passes records on to a target handler after processing them by a function, e.g. changing
+// their level or enriching them. The function gets a copy of each record (see Record.Clone), which it may
+// modify freely (its Fields are never nil), returning false to drop the record.
+type ProcessHandler struct {
+	target  Handler
+	process func(rec *Record) bool
+	level   Level
+}
+
+// NewProcessHandler returns a new ProcessHandler, passing the processed records on to the target handler.
+func NewProcessHandler(target Handler, process func(rec *Record) bool) *ProcessHandler {
+	return &ProcessHandler{
+		target:  target,
+		process: process,
+	}
+}
+
+// Handle processes a copy of the record, and passes it on to the target handler (unless dropped).
+// The target's level applies to the processed record.
+func (h *ProcessHandler) Handle(rec *Record) error {
+	processed := rec.Clone()
+	if processed.Fields == nil {
+		processed.Fields = Fields{}
+	}
+	if !h.process(processed) || !handles(h.target, processed.Level) {
+		return nil
+	}
+	return h.target.Handle(processed)
+}
+
+// Target returns the handler the records are passed on to.
+func (h *ProcessHandler) Target() Handler {
+	return h.target
+}
+
+func (h *ProcessHandler) wrappedHandlers() []Handler {
+	return []Handler{h.target}
+}
+
+// SetFormatter sets the target handler's Formatter.
+func (h *ProcessHandler) SetFormatter(formatter Formatter) {
+	h.target.SetFormatter(formatter)
+}
+
+// Formatter returns the target handler's Formatter.
+func (h *ProcessHandler) Formatter() Formatter {
+	return h.target.Formatter()
+}
+
+// SetLevel sets the level the handler will (at least) handle.
+func (h *ProcessHandler) SetLevel(level Level) {
+	h.level = level
+}
+
+// Level returns the level previously set (or INHERIT if not set).
+func (h *ProcessHandler) Level() Level {
+	return h.level
+}
+
+// Flush flushes the target handler.
+func (h *ProcessHandler) Flush() error {
+	return FlushHandler(h.target)
+}
+
+// Shutdown shuts down the target handler.
+func (h *ProcessHandler) Shutdown() {
+	h.target.Shutdown()
+}
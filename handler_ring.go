@@ -0,0 +1,99 @@
+package log4go
+
+import (
+	"net/http"
+	"sync"
+)
+
+// RingBufferHandler keeps the most recent records in memory, to be inspected on demand, e.g. post-mortem,
+// when the file log is at WARNING but the recent DEBUG context is wanted.
+// It's also an http.Handler, writing the records as text, e.g.:
+//
+//	http.Handle("/debug/logs", ringHandler)
+type RingBufferHandler struct {
+	formatter Formatter
+	level     Level
+
+	lock   sync.Mutex
+	buffer []Record // ring buffer
+	next   int      // index to write the next record to
+	full   bool     // whether the buffer has wrapped
+}
+
+// NewRingBufferHandler returns a new RingBufferHandler, keeping the last capacity records.
+func NewRingBufferHandler(capacity int) *RingBufferHandler {
+	if capacity < 1 {
+		capacity = 1
+	}
+	return &RingBufferHandler{
+		buffer: make([]Record, capacity),
+	}
+}
+
+// Handle stores the record, replacing the oldest one if the buffer is full.
+func (h *RingBufferHandler) Handle(rec *Record) error {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+
+	h.buffer[h.next] = *rec
+	h.next = (h.next + 1) % len(h.buffer)
+	if h.next == 0 {
+		h.full = true
+	}
+
+	return nil
+}
+
+// Records returns (a copy of) the stored records, oldest first.
+func (h *RingBufferHandler) Records() []Record {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+
+	if !h.full {
+		return append([]Record(nil), h.buffer[:h.next]...)
+	}
+	records := make([]Record, 0, len(h.buffer))
+	records = append(records, h.buffer[h.next:]...)
+	return append(records, h.buffer[:h.next]...)
+}
+
+// ServeHTTP writes the stored records, formatted by the handler's formatter, oldest first.
+func (h *RingBufferHandler) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	formatter := h.Formatter()
+	if formatter == nil {
+		formatter, _ = NewTemplateFormatter(defaultFormat)
+	}
+
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	for _, rec := range h.Records() {
+		msg, err := formatter.Format(&rec)
+		if err != nil {
+			continue
+		}
+		w.Write(append(msg, '\n'))
+	}
+}
+
+// SetFormatter sets the handler's Formatter (used by ServeHTTP).
+func (h *RingBufferHandler) SetFormatter(formatter Formatter) {
+	h.formatter = formatter
+}
+
+// Formatter returns the handler's Formatter.
+func (h *RingBufferHandler) Formatter() Formatter {
+	return h.formatter
+}
+
+// SetLevel sets the level the handler will (at least) handle.
+func (h *RingBufferHandler) SetLevel(level Level) {
+	h.level = level
+}
+
+// Level returns the level previously set (or INHERIT if not set).
+func (h *RingBufferHandler) Level() Level {
+	return h.level
+}
+
+// Shutdown does nothing, the records are kept for inspection.
+func (h *RingBufferHandler) Shutdown() {
+}
@@ -0,0 +1,181 @@
+package log4go
+
+import (
+	"path"
+	"regexp"
+	"strings"
+)
+
+// Route passes the records matching all of its (set) criteria to its handler (see RouterHandler).
+type Route struct {
+	// LoggerGlob matches the logger names (see path.Match), e.g. "db/*"; a trailing "/**" also matches
+	// all descendants, e.g. "db/**" matches "db", "db/pool" and "db/pool/conn". Empty matches all loggers.
+	// The root logger is called "root".
+	LoggerGlob string
+	// MinLevel and MaxLevel bound the levels of the records (INHERIT means no bound).
+	MinLevel Level
+	MaxLevel Level
+	// MatchRegex matches the messages, if set.
+	MatchRegex *regexp.Regexp
+	// Tags matches the records having (at least) one of the tags (see Logger.Tagged), if set.
+	Tags []string
+	// Handler handles the matching records.
+	Handler Handler
+}
+
+// matches returns whether the record matches all of the route's criteria.
+func (r *Route) matches(rec *Record) bool {
+	if r.MinLevel != INHERIT && rec.Level < r.MinLevel {
+		return false
+	}
+	if r.MaxLevel != INHERIT && rec.Level > r.MaxLevel {
+		return false
+	}
+	if len(r.LoggerGlob) > 0 && !matchLoggerGlob(r.LoggerGlob, rec.Name) {
+		return false
+	}
+	if r.MatchRegex != nil && !r.MatchRegex.MatchString(rec.Message) {
+		return false
+	}
+	if len(r.Tags) > 0 {
+		for _, tag := range r.Tags {
+			if rec.HasTag(tag) {
+				return true
+			}
+		}
+		return false
+	}
+	return true
+}
+
+func matchLoggerGlob(glob, name string) bool {
+	if len(name) == 0 {
+		name = "root"
+	}
+	if !strings.HasSuffix(glob, "/**") {
+		matched, _ := path.Match(glob, name)
+		return matched
+	}
+
+	// match the logger itself, or any of its ancestors
+	base := glob[:len(glob)-3]
+	if matched, _ := path.Match(base, name); matched {
+		return true
+	}
+	for idx := 0; idx < len(name); idx++ {
+		if name[idx] == '/' {
+			if matched, _ := path.Match(base, name[:idx]); matched {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// RouterHandler passes each record to the handlers of all routes it matches, making complex fan-out declarative,
+// e.g. security events to one file, database debug records to another and everything WARNING+ to stderr.
+// Note that records must still pass the level of the logger (see InstallRoutes).
+type RouterHandler struct {
+	routes []Route
+	level  Level
+}
+
+// NewRouterHandler returns a new RouterHandler using the routes (evaluated in order).
+func NewRouterHandler(routes ...Route) *RouterHandler {
+	return &RouterHandler{routes: routes}
+}
+
+// InstallRoutes adds a RouterHandler using the routes to the root logger.
+// Route handlers without a formatter get the default one.
+func InstallRoutes(routes ...Route) error {
+	var defFormatter Formatter
+	for _, route := range routes {
+		if route.Handler.Formatter() == nil {
+			if defFormatter == nil {
+				var err error
+				if defFormatter, err = NewTemplateFormatter(defaultFormat); err != nil {
+					return err
+				}
+			}
+			route.Handler.SetFormatter(defFormatter)
+		}
+	}
+
+	return GetLogger().AddHandler(NewRouterHandler(routes...))
+}
+
+// Handle passes the record to the handlers of all matching routes, returning the first error (if any).
+func (h *RouterHandler) Handle(rec *Record) error {
+	var err error
+	for idx := range h.routes {
+		route := &h.routes[idx]
+		if route.matches(rec) && handles(route.Handler, rec.Level) {
+			if e := route.Handler.Handle(rec); e != nil && err == nil {
+				err = e
+			}
+		}
+	}
+	return err
+}
+
+// SetFormatter sets the Formatter of all route handlers.
+func (h *RouterHandler) SetFormatter(formatter Formatter) {
+	for _, route := range h.routes {
+		route.Handler.SetFormatter(formatter)
+	}
+}
+
+// Formatter returns the Formatter of the first route handler (if any).
+func (h *RouterHandler) Formatter() Formatter {
+	if len(h.routes) == 0 {
+		return nil
+	}
+	return h.routes[0].Handler.Formatter()
+}
+
+// SetLevel sets the level the handler will (at least) handle.
+func (h *RouterHandler) SetLevel(level Level) {
+	h.level = level
+}
+
+// Level returns the level previously set (or INHERIT if not set).
+func (h *RouterHandler) Level() Level {
+	return h.level
+}
+
+func (h *RouterHandler) wrappedHandlers() []Handler {
+	handlers := make([]Handler, len(h.routes))
+	for idx, route := range h.routes {
+		handlers[idx] = route.Handler
+	}
+	return handlers
+}
+
+// Flush flushes the route handlers, returning the first error.
+func (h *RouterHandler) Flush() error {
+	return h.each(FlushHandler)
+}
+
+// Shutdown shuts down the route handlers (each once, even if used by several routes).
+func (h *RouterHandler) Shutdown() {
+	h.each(func(handler Handler) error {
+		handler.Shutdown()
+		return nil
+	})
+}
+
+// each calls fn for each unique route handler, returning the first error.
+func (h *RouterHandler) each(fn func(Handler) error) error {
+	var err error
+
+	done := make(map[Handler]bool, len(h.routes))
+	for _, route := range h.routes {
+		if !done[route.Handler] {
+			done[route.Handler] = true
+			if e := fn(route.Handler); e != nil && err == nil {
+				err = e
+			}
+		}
+	}
+	return err
+}
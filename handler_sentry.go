@@ -0,0 +1,202 @@
+package log4go
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// SentryOpts is used to supply options to NewSentryHandler.
+type SentryOpts struct {
+	// DSN of the Sentry project, e.g. "https://<key>@sentry.example.com/<project>".
+	DSN string
+	// Environment reported with the events, if set.
+	Environment string
+	// Release reported with the events, if set.
+	Release string
+	// Tags lists the record fields sent as tags, all other fields are sent as extras.
+	Tags []string
+	// Client is used to send the events (default http.DefaultClient).
+	Client *http.Client
+}
+
+// SentryHandler forwards (ERROR and FATAL, by default) records to Sentry.
+// Record fields are sent as tags or extras (see SentryOpts.Tags) and a
+// stack trace (e.g. from Logger.Crash) is attached as well.
+type SentryHandler struct {
+	opts      SentryOpts
+	formatter Formatter
+	level     Level
+	batch     *throttledBatch
+
+	storeURL string
+	authKey  string
+	tags     map[string]bool
+}
+
+var levelToSentry = map[Level]string{
+	FATAL:   "fatal",
+	ERROR:   "error",
+	WARNING: "warning",
+	INFO:    "info",
+	DEBUG:   "debug",
+	TRACE:   "debug",
+}
+
+// NewSentryHandler returns a new SentryHandler instance.
+func NewSentryHandler(opts SentryOpts) (*SentryHandler, error) {
+	dsn, err := url.Parse(opts.DSN)
+	if err != nil {
+		return nil, err
+	}
+	project := strings.Trim(dsn.Path, "/")
+	if dsn.User == nil || len(project) == 0 {
+		return nil, fmt.Errorf("invalid Sentry DSN: '%s'", opts.DSN)
+	}
+	if opts.Client == nil {
+		opts.Client = http.DefaultClient
+	}
+
+	h := &SentryHandler{
+		opts:     opts,
+		level:    ERROR,
+		storeURL: fmt.Sprintf("%s://%s/api/%s/store/", dsn.Scheme, dsn.Host, project),
+		authKey:  dsn.User.Username(),
+		tags:     make(map[string]bool, len(opts.Tags)),
+	}
+	for _, tag := range opts.Tags {
+		h.tags[tag] = true
+	}
+	// events are sent in the background, as they arrive
+	h.batch = newThrottledBatch(0, h.send)
+
+	return h, nil
+}
+
+// Handle queues the record to be sent to Sentry, or sends it (before returning) if FATAL.
+func (h *SentryHandler) Handle(rec *Record) error {
+	if rec.Level < h.level {
+		return nil
+	}
+
+	if rec.Level >= FATAL {
+		// sent right away, as the process is likely about to exit
+		h.send([]batchItem{{record: *rec}})
+		return nil
+	}
+	h.batch.add(batchItem{record: *rec})
+
+	return nil
+}
+
+// send sends a batch of records, one event each.
+func (h *SentryHandler) send(batch []batchItem) {
+	for _, item := range batch {
+		if err := h.sendEvent(h.event(&item.record)); err != nil {
+			report(ERROR, "SentryHandler", "send error: %v", err)
+		}
+	}
+}
+
+// event returns the Sentry event for a record.
+func (h *SentryHandler) event(rec *Record) map[string]interface{} {
+	id := make([]byte, 16)
+	rand.Read(id)
+
+	tags := map[string]string{}
+	extra := map[string]interface{}{}
+	for key, value := range rec.Fields {
+		if h.tags[key] {
+			tags[key] = fmt.Sprint(value)
+		} else {
+			extra[key] = value
+		}
+	}
+	if len(rec.Stack) > 0 {
+		extra["stacktrace"] = rec.Stack
+	}
+
+	loggerName := rec.Name
+	if len(loggerName) == 0 {
+		loggerName = "root"
+	}
+
+	event := map[string]interface{}{
+		"event_id":  hex.EncodeToString(id),
+		"timestamp": rec.Time.UTC().Format("2006-01-02T15:04:05.000000Z"),
+		"level":     levelToSentry[rec.Level],
+		"logger":    loggerName,
+		"platform":  "go",
+		"message":   rec.Message,
+		"tags":      tags,
+		"extra":     extra,
+	}
+	if len(h.opts.Environment) > 0 {
+		event["environment"] = h.opts.Environment
+	}
+	if len(h.opts.Release) > 0 {
+		event["release"] = h.opts.Release
+	}
+
+	return event
+}
+
+func (h *SentryHandler) sendEvent(event map[string]interface{}) error {
+	payload, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequest(http.MethodPost, h.storeURL, bytes.NewReader(payload))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-Sentry-Auth", fmt.Sprintf("Sentry sentry_version=7, sentry_timestamp=%d, sentry_key=%s, sentry_client=log4go/1.0",
+		time.Now().Unix(), h.authKey))
+
+	resp, err := h.opts.Client.Do(req)
+	if err != nil {
+		return err
+	}
+	io.Copy(ioutil.Discard, resp.Body)
+	resp.Body.Close()
+
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("request failed: %s", resp.Status)
+	}
+	return nil
+}
+
+// SetFormatter sets the handler's Formatter (not used for the events, which are sent unformatted).
+func (h *SentryHandler) SetFormatter(formatter Formatter) {
+	h.formatter = formatter
+}
+
+// Formatter returns the handler's Formatter.
+func (h *SentryHandler) Formatter() Formatter {
+	return h.formatter
+}
+
+// SetLevel sets the level the handler will (at least) handle (default ERROR).
+func (h *SentryHandler) SetLevel(level Level) {
+	h.level = level
+}
+
+// Level returns the level the handler will (at least) handle.
+func (h *SentryHandler) Level() Level {
+	return h.level
+}
+
+// Shutdown sends any pending events immediately.
+func (h *SentryHandler) Shutdown() {
+	h.batch.flush()
+}
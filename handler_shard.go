@@ -0,0 +1,280 @@
+package log4go
+
+import (
+	"bufio"
+	"fmt"
+	"hash/fnv"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// ShardOpts is used to supply options to NewShardedFileHandler.
+type ShardOpts struct {
+	// Shards is the number of files (default 4).
+	Shards int
+	// ByLogger keeps the records of each logger in the same file, by the hash of its name.
+	// Otherwise the records are spread over the files round-robin.
+	ByLogger bool
+	// FileOpts are the options of each file's handler.
+	FileOpts FileOpts
+}
+
+// ShardedFileHandler spreads the records over several files, each written by its own FileHandler,
+// avoiding the contention of a single file at very high throughput.
+// Each line starts with a sequence number (16 hex digits), by which MergeShards interleaves the files again;
+// until a formatter is set, the records are formatted by the default template.
+// The sequence starts at the current time (in nanoseconds), so appended files also merge in order.
+type ShardedFileHandler struct {
+	shards    []*StreamHandler
+	files     []string
+	byLogger  bool
+	level     Level
+	formatter Formatter
+
+	seq uint64 // accessed atomically
+}
+
+// shardSeqDigits is the width of the sequence number starting each line.
+const shardSeqDigits = 16
+
+// NewShardedFileHandler returns a new ShardedFileHandler writing to files named after filename,
+// with the shard number inserted before the extension, e.g. "app.0.log", "app.1.log" etc. for "app.log".
+func NewShardedFileHandler(filename string, appendFile bool, opts ...ShardOpts) (*ShardedFileHandler, error) {
+	var opt ShardOpts
+	if len(opts) > 0 {
+		opt = opts[0]
+	}
+	if opt.Shards < 1 {
+		opt.Shards = 4
+	}
+
+	h := &ShardedFileHandler{
+		byLogger: opt.ByLogger,
+		seq:      uint64(time.Now().UnixNano()),
+	}
+	// until SetFormatter is called, the lines are numbered too (so the files can be merged)
+	defFormatter, err := NewTemplateFormatter(defaultFormat)
+	if err != nil {
+		return nil, err
+	}
+	ext := filepath.Ext(filename)
+	for idx := 0; idx < opt.Shards; idx++ {
+		file := fmt.Sprintf("%s.%d%s", strings.TrimSuffix(filename, ext), idx, ext)
+		shard, err := NewFileHandler(file, appendFile, opt.FileOpts)
+		if err != nil {
+			h.Shutdown()
+			return nil, err
+		}
+		shard.SetFormatter(shardFormatter{defFormatter})
+		h.shards = append(h.shards, shard)
+		h.files = append(h.files, file)
+	}
+
+	return h, nil
+}
+
+// Files returns the names of the files written.
+func (h *ShardedFileHandler) Files() []string {
+	return h.files
+}
+
+// Handle numbers the record, and passes it on to the handler of its file.
+func (h *ShardedFileHandler) Handle(rec *Record) error {
+	numbered := *rec
+	numbered.seq = atomic.AddUint64(&h.seq, 1)
+
+	var shard uint64
+	if h.byLogger {
+		key := fnv.New32a()
+		key.Write([]byte(rec.Name))
+		shard = uint64(key.Sum32())
+	} else {
+		shard = numbered.seq
+	}
+	return h.shards[shard%uint64(len(h.shards))].Handle(&numbered)
+}
+
+func (h *ShardedFileHandler) wrappedHandlers() []Handler {
+	handlers := make([]Handler, len(h.shards))
+	for idx, shard := range h.shards {
+		handlers[idx] = shard
+	}
+	return handlers
+}
+
+// SetFormatter sets the Formatter of the files' handlers (prefixing the lines with their sequence numbers).
+func (h *ShardedFileHandler) SetFormatter(formatter Formatter) {
+	h.formatter = formatter
+	for _, shard := range h.shards {
+		shard.SetFormatter(shardFormatter{formatter})
+	}
+}
+
+// Formatter returns the handler's Formatter.
+func (h *ShardedFileHandler) Formatter() Formatter {
+	return h.formatter
+}
+
+// SetLevel sets the level the handler will (at least) handle.
+func (h *ShardedFileHandler) SetLevel(level Level) {
+	h.level = level
+}
+
+// Level returns the level previously set (or INHERIT if not set).
+func (h *ShardedFileHandler) Level() Level {
+	return h.level
+}
+
+// Flush returns when the records queued for all files have been written.
+func (h *ShardedFileHandler) Flush() error {
+	var err error
+	for _, shard := range h.shards {
+		if e := shard.Flush(); e != nil && err == nil {
+			err = e
+		}
+	}
+	return err
+}
+
+// Shutdown shuts down the handlers of all files.
+func (h *ShardedFileHandler) Shutdown() {
+	for _, shard := range h.shards {
+		shard.Shutdown()
+	}
+}
+
+// shardFormatter prefixes the formatted records with their sequence numbers.
+type shardFormatter struct {
+	formatter Formatter
+}
+
+func (f shardFormatter) Format(rec *Record) ([]byte, error) {
+	var buf []byte
+	err := f.FormatTo(&buf, rec)
+	return buf, err
+}
+
+func (f shardFormatter) FormatTo(buf *[]byte, rec *Record) error {
+	*buf = appendShardSeq(*buf, rec.seq)
+	return formatTo(f.formatter, buf, rec)
+}
+
+// appendShardSeq appends the sequence number, zero-padded, and a space.
+func appendShardSeq(b []byte, seq uint64) []byte {
+	var digits [shardSeqDigits]byte
+	hex := strconv.AppendUint(digits[:0], seq, 16)
+	for pad := shardSeqDigits - len(hex); pad > 0; pad-- {
+		b = append(b, '0')
+	}
+	b = append(b, hex...)
+	return append(b, ' ')
+}
+
+// parseShardSeq returns the sequence number starting the line, if it does.
+func parseShardSeq(line string) (uint64, bool) {
+	if len(line) <= shardSeqDigits || line[shardSeqDigits] != ' ' {
+		return 0, false
+	}
+	seq, err := strconv.ParseUint(line[:shardSeqDigits], 16, 64)
+	return seq, err == nil
+}
+
+// shardReader reads the records (including any continuation lines) of a file written by ShardedFileHandler.
+type shardReader struct {
+	reader *bufio.Reader
+	next   string // the line read ahead, starting the next record
+	rec    string // the current record, without its sequence number
+	seq    uint64
+	done   bool
+	err    error
+}
+
+// advance reads the next record; lines preceding the first record (e.g. a header) get sequence number 0.
+func (r *shardReader) advance() bool {
+	if r.done {
+		return false
+	}
+	var rec strings.Builder
+	first := true
+	for {
+		line := r.next
+		if len(line) == 0 {
+			var err error
+			line, err = r.reader.ReadString('\n')
+			if err != nil && err != io.EOF {
+				r.err = err
+			}
+			if len(line) == 0 {
+				r.done = true
+				break
+			}
+			if line[len(line)-1] != '\n' {
+				line += "\n"
+			}
+		}
+		r.next = ""
+
+		seq, numbered := parseShardSeq(line)
+		if numbered && !first {
+			r.next = line // the start of the following record
+			break
+		}
+		if first {
+			r.seq = 0
+			if numbered {
+				r.seq = seq
+				line = line[shardSeqDigits+1:]
+			}
+			first = false
+		}
+		rec.WriteString(line)
+	}
+	r.rec = rec.String()
+	return rec.Len() > 0
+}
+
+// MergeShards writes the records of the files written by a ShardedFileHandler to w, in sequence
+// (i.e. logging) order, without their sequence numbers.
+func MergeShards(w io.Writer, filenames ...string) error {
+	var readers []*shardReader
+	for _, filename := range filenames {
+		fp, err := os.Open(filename)
+		if err != nil {
+			return err
+		}
+		defer fp.Close()
+
+		reader := &shardReader{reader: bufio.NewReader(fp)}
+		if reader.advance() {
+			readers = append(readers, reader)
+		} else if reader.err != nil {
+			return fmt.Errorf("%s: %v", filename, reader.err)
+		}
+	}
+
+	// the files are few, so the next record is found by a linear scan
+	for len(readers) > 0 {
+		next := 0
+		for idx, reader := range readers[1:] {
+			if reader.seq < readers[next].seq {
+				next = idx + 1
+			}
+		}
+		reader := readers[next]
+		if _, err := io.WriteString(w, reader.rec); err != nil {
+			return err
+		}
+		if !reader.advance() {
+			if reader.err != nil {
+				return reader.err
+			}
+			readers = append(readers[:next], readers[next+1:]...)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,128 @@
+package log4go
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"net/smtp"
+	"strings"
+	"time"
+)
+
+// SMTPOpts is used to supply options to NewSMTPHandler.
+type SMTPOpts struct {
+	// Addr is the SMTP server address, "host:port".
+	Addr string
+	// Auth is used to authenticate with the server, if set.
+	Auth smtp.Auth
+	// From is the sender address.
+	From string
+	// To are the recipient addresses.
+	To []string
+	// Subject of the emails (default: "log4go: <count> messages").
+	Subject string
+	// Interval is the minimum time between two emails, records arriving meanwhile are aggregated (default 5 minutes).
+	Interval time.Duration
+}
+
+// SMTPHandler emails (ERROR and FATAL, by default) records to a list of addresses.
+// To avoid flooding the recipients, at most one email is sent per interval, aggregating all records since the previous one.
+type SMTPHandler struct {
+	opts      SMTPOpts
+	formatter Formatter
+	level     Level
+	batch     *throttledBatch
+
+	// sendMail is smtp.SendMail (replaceable for testing)
+	sendMail func(addr string, a smtp.Auth, from string, to []string, msg []byte) error
+}
+
+// ErrNoRecipients is returned by NewSMTPHandler if no recipients were specified.
+var ErrNoRecipients = errors.New("no recipients specified")
+
+// NewSMTPHandler returns a new SMTPHandler instance.
+func NewSMTPHandler(opts SMTPOpts) (*SMTPHandler, error) {
+	if len(opts.To) == 0 {
+		return nil, ErrNoRecipients
+	}
+	if opts.Interval <= 0 {
+		opts.Interval = 5 * time.Minute
+	}
+
+	h := &SMTPHandler{
+		opts:     opts,
+		level:    ERROR,
+		sendMail: smtp.SendMail,
+	}
+	h.batch = newThrottledBatch(opts.Interval, h.send)
+
+	return h, nil
+}
+
+// Handle formats the record and queues it for the next email.
+func (h *SMTPHandler) Handle(rec *Record) error {
+	if rec.Level < h.level {
+		return nil
+	}
+
+	msg, err := h.formatter.Format(rec)
+	if err != nil {
+		return err
+	}
+
+	h.batch.add(batchItem{*rec, msg})
+
+	return nil
+}
+
+// send emails a batch of records.
+func (h *SMTPHandler) send(batch []batchItem) {
+	if err := h.sendMail(h.opts.Addr, h.opts.Auth, h.opts.From, h.opts.To, h.compose(batch)); err != nil {
+		report(ERROR, "SMTPHandler", "send error: %v", err)
+	}
+}
+
+func (h *SMTPHandler) compose(batch []batchItem) []byte {
+	subject := h.opts.Subject
+	if len(subject) == 0 {
+		subject = fmt.Sprintf("log4go: %d messages", len(batch))
+	}
+
+	var mail bytes.Buffer
+	fmt.Fprintf(&mail, "From: %s\r\n", h.opts.From)
+	fmt.Fprintf(&mail, "To: %s\r\n", strings.Join(h.opts.To, ", "))
+	fmt.Fprintf(&mail, "Subject: %s\r\n", subject)
+	fmt.Fprintf(&mail, "Date: %s\r\n", time.Now().Format(time.RFC1123Z))
+	mail.WriteString("Content-Type: text/plain; charset=utf-8\r\n\r\n")
+	for _, item := range batch {
+		mail.Write(item.message)
+		mail.WriteString("\r\n")
+	}
+
+	return mail.Bytes()
+}
+
+// SetFormatter sets the handler's Formatter.
+func (h *SMTPHandler) SetFormatter(formatter Formatter) {
+	h.formatter = formatter
+}
+
+// Formatter returns the handler's Formatter.
+func (h *SMTPHandler) Formatter() Formatter {
+	return h.formatter
+}
+
+// SetLevel sets the level the handler will (at least) handle (default ERROR).
+func (h *SMTPHandler) SetLevel(level Level) {
+	h.level = level
+}
+
+// Level returns the level the handler will (at least) handle.
+func (h *SMTPHandler) Level() Level {
+	return h.level
+}
+
+// Shutdown sends any pending records immediately.
+func (h *SMTPHandler) Shutdown() {
+	h.batch.flush()
+}
@@ -0,0 +1,98 @@
+package log4go
+
+import (
+	"fmt"
+	"io"
+	"os"
+)
+
+// SplitHandler routes records to one of two StreamHandlers depending on their level,
+// with a shared formatter; e.g. the conventional Unix console split (see NewConsoleSplitHandler).
+type SplitHandler struct {
+	low   *StreamHandler
+	high  *StreamHandler
+	level Level
+}
+
+// NewSplitHandler returns a new SplitHandler writing records below the threshold level to low,
+// and the others to high. The threshold must be above TRACE (or a level added below it): there would be
+// no level below it for low (a max level of INHERIT meaning none).
+func NewSplitHandler(low, high io.Writer, threshold Level) (*SplitHandler, error) {
+	if threshold == INHERIT || threshold == ALL || threshold-1 == INHERIT {
+		return nil, fmt.Errorf("invalid split threshold: %s", LevelName(threshold))
+	}
+	lowHandler, err := NewStreamHandler(low)
+	if err != nil {
+		return nil, err
+	}
+	highHandler, err := NewStreamHandler(high)
+	if err != nil {
+		lowHandler.Shutdown()
+		return nil, err
+	}
+
+	lowHandler.SetMaxLevel(threshold - 1)
+	highHandler.SetLevel(threshold)
+
+	return &SplitHandler{
+		low:  lowHandler,
+		high: highHandler,
+	}, nil
+}
+
+// NewConsoleSplitHandler returns a new SplitHandler writing records below WARNING to stdout,
+// and WARNING and above to stderr.
+func NewConsoleSplitHandler() (*SplitHandler, error) {
+	return NewSplitHandler(os.Stdout, os.Stderr, WARNING)
+}
+
+// Handle passes the record on to the handler for its level.
+func (h *SplitHandler) Handle(rec *Record) error {
+	if handles(h.low, rec.Level) {
+		return h.low.Handle(rec)
+	}
+	if handles(h.high, rec.Level) {
+		return h.high.Handle(rec)
+	}
+	return nil
+}
+
+func (h *SplitHandler) wrappedHandlers() []Handler {
+	return []Handler{h.low, h.high}
+}
+
+// SetFormatter sets the Formatter of both handlers.
+func (h *SplitHandler) SetFormatter(formatter Formatter) {
+	h.low.SetFormatter(formatter)
+	h.high.SetFormatter(formatter)
+}
+
+// Formatter returns the handlers' Formatter.
+func (h *SplitHandler) Formatter() Formatter {
+	return h.low.Formatter()
+}
+
+// SetLevel sets the level the handler will (at least) handle.
+func (h *SplitHandler) SetLevel(level Level) {
+	h.level = level
+}
+
+// Level returns the level previously set (or INHERIT if not set).
+func (h *SplitHandler) Level() Level {
+	return h.level
+}
+
+// Flush returns when the records queued by both handlers have been written.
+func (h *SplitHandler) Flush() error {
+	err := h.low.Flush()
+	if e := h.high.Flush(); e != nil && err == nil {
+		err = e
+	}
+	return err
+}
+
+// Shutdown shuts down both handlers.
+func (h *SplitHandler) Shutdown() {
+	h.low.Shutdown()
+	h.high.Shutdown()
+}
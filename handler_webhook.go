@@ -0,0 +1,206 @@
+package log4go
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// WebhookFlavor selects the payload format of a WebhookHandler.
+type WebhookFlavor int
+
+// Supported webhook flavors.
+const (
+	// Slack incoming webhook.
+	Slack WebhookFlavor = iota
+	// Discord webhook.
+	Discord
+	// Teams (Microsoft) incoming webhook.
+	Teams
+)
+
+// WebhookOpts is used to supply options to NewWebhookHandler.
+type WebhookOpts struct {
+	// URL of the incoming webhook.
+	URL string
+	// Flavor of the webhook (default Slack).
+	Flavor WebhookFlavor
+	// Interval is the minimum time between two posts, records arriving meanwhile are aggregated (default 1 minute).
+	Interval time.Duration
+	// Client is used to post the messages (default http.DefaultClient).
+	Client *http.Client
+	// Codec, if set, encodes (e.g. compresses, see GzipCodec) the posts, naming it as their Content-Encoding;
+	// the receiving end must support it.
+	Codec Codec
+}
+
+// WebhookHandler posts (ERROR and FATAL, by default) records to a chat webhook (Slack, Discord or Teams).
+// To avoid flooding the channel, at most one post is made per interval, aggregating all records since the previous one.
+type WebhookHandler struct {
+	opts      WebhookOpts
+	formatter Formatter
+	level     Level
+	batch     *throttledBatch
+}
+
+// ErrNoURL is returned if a handler requiring an URL was not given one.
+var ErrNoURL = errors.New("no URL specified")
+
+var levelToEmoji = map[Level]string{
+	FATAL:   "\U0001F4A5",   // collision
+	ERROR:   "\u274C",       // cross mark
+	WARNING: "\u26A0\uFE0F", // warning sign
+	INFO:    "\u2139\uFE0F", // information source
+	DEBUG:   "\U0001F50D",   // magnifying glass
+	TRACE:   "\U0001F50E",   // magnifying glass (right)
+}
+
+var levelToRGB = map[Level]int{
+	FATAL:   0x8b0000,
+	ERROR:   0xd50200,
+	WARNING: 0xe8a800,
+	INFO:    0x2eb67d,
+	DEBUG:   0x808080,
+	TRACE:   0x808080,
+}
+
+// NewWebhookHandler returns a new WebhookHandler instance.
+func NewWebhookHandler(opts WebhookOpts) (*WebhookHandler, error) {
+	if len(opts.URL) == 0 {
+		return nil, ErrNoURL
+	}
+	if opts.Interval <= 0 {
+		opts.Interval = time.Minute
+	}
+	if opts.Client == nil {
+		opts.Client = http.DefaultClient
+	}
+
+	h := &WebhookHandler{
+		opts:  opts,
+		level: ERROR,
+	}
+	h.batch = newThrottledBatch(opts.Interval, h.send)
+
+	return h, nil
+}
+
+// Handle formats the record and queues it for the next post.
+func (h *WebhookHandler) Handle(rec *Record) error {
+	if rec.Level < h.level {
+		return nil
+	}
+
+	msg, err := h.formatter.Format(rec)
+	if err != nil {
+		return err
+	}
+
+	h.batch.add(batchItem{*rec, msg})
+
+	return nil
+}
+
+// send posts a batch of records.
+func (h *WebhookHandler) send(batch []batchItem) {
+	payload, err := json.Marshal(h.payload(batch))
+	if err != nil {
+		report(ERROR, "WebhookHandler", "encode error: %v", err)
+		return
+	}
+
+	if h.opts.Codec != nil {
+		if payload, err = h.opts.Codec.Encode(payload); err != nil {
+			report(ERROR, "WebhookHandler", "encode error: %v", err)
+			return
+		}
+	}
+
+	req, err := http.NewRequest(http.MethodPost, h.opts.URL, bytes.NewReader(payload))
+	if err != nil {
+		report(ERROR, "WebhookHandler", "post error: %v", err)
+		return
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if h.opts.Codec != nil {
+		req.Header.Set("Content-Encoding", h.opts.Codec.Name())
+	}
+	resp, err := h.opts.Client.Do(req)
+	if err != nil {
+		report(ERROR, "WebhookHandler", "post error: %v", err)
+		return
+	}
+	io.Copy(ioutil.Discard, resp.Body)
+	resp.Body.Close()
+
+	if resp.StatusCode >= 300 {
+		report(ERROR, "WebhookHandler", "post failed: %s", resp.Status)
+	}
+}
+
+// payload returns the flavor-specific message for the batch, colored by the most severe level in it.
+func (h *WebhookHandler) payload(batch []batchItem) interface{} {
+	maxLevel := INHERIT
+	lines := make([]string, 0, len(batch))
+	for _, item := range batch {
+		if item.record.Level > maxLevel {
+			maxLevel = item.record.Level
+		}
+		lines = append(lines, levelToEmoji[item.record.Level]+" "+string(item.message))
+	}
+	text := strings.Join(lines, "\n")
+	rgb := levelToRGB[maxLevel]
+
+	switch h.opts.Flavor {
+	case Discord:
+		return map[string]interface{}{
+			"embeds": []map[string]interface{}{
+				{"description": text, "color": rgb},
+			},
+		}
+	case Teams:
+		return map[string]interface{}{
+			"@type":      "MessageCard",
+			"@context":   "http://schema.org/extensions",
+			"summary":    fmt.Sprintf("%d log messages", len(batch)),
+			"themeColor": fmt.Sprintf("%06x", rgb),
+			"text":       strings.Join(lines, "\n\n"),
+		}
+	}
+	return map[string]interface{}{
+		"attachments": []map[string]interface{}{
+			{"color": fmt.Sprintf("#%06x", rgb), "fallback": text, "text": text},
+		},
+	}
+}
+
+// SetFormatter sets the handler's Formatter.
+func (h *WebhookHandler) SetFormatter(formatter Formatter) {
+	h.formatter = formatter
+}
+
+// Formatter returns the handler's Formatter.
+func (h *WebhookHandler) Formatter() Formatter {
+	return h.formatter
+}
+
+// SetLevel sets the level the handler will (at least) handle (default ERROR).
+func (h *WebhookHandler) SetLevel(level Level) {
+	h.level = level
+}
+
+// Level returns the level the handler will (at least) handle.
+func (h *WebhookHandler) Level() Level {
+	return h.level
+}
+
+// Shutdown posts any pending records immediately.
+func (h *WebhookHandler) Shutdown() {
+	h.batch.flush()
+}
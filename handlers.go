@@ -1,13 +1,20 @@
 package log4go
 
 import (
+	"errors"
 	"fmt"
 	"io"
 	"os"
-	"syscall"
+	"sync"
+	"sync/atomic"
+	"time"
 )
 
 // Handler handles the formatted log events.
+//
+// The record passed to Handle is shared by all handlers, and reused once the logging call returns:
+// Handle must not modify it (nor its Fields and Tags), and must copy it to keep it. Handlers transforming
+// records pass a modified copy on instead (see Record.Clone and ProcessHandler).
 type Handler interface {
 	Handle(rec *Record) error
 	SetFormatter(formatter Formatter)
@@ -17,40 +24,201 @@ type Handler interface {
 	Shutdown()
 }
 
+// MaxLeveler is implemented by handlers supporting an upper level bound (see StreamHandler.SetMaxLevel).
+type MaxLeveler interface {
+	MaxLevel() Level
+}
+
+// Flusher is implemented by handlers queueing or buffering records (see FlushHandler).
+type Flusher interface {
+	// Flush writes (or passes on) the queued or buffered records, returning when done.
+	Flush() error
+}
+
+// Closer is implemented by handlers able to report errors when shut down (see CloseHandler).
+type Closer interface {
+	// Close does the same as Shutdown, returning ErrClosed if the handler was already closed.
+	Close() error
+}
+
+// ErrClosed is returned (e.g. by Handle) when the handler has been shut down.
+var ErrClosed = errors.New("log4go: handler closed")
+
+// ErrDropped is returned by Handle when the record was dropped, e.g. while the file is being reopened.
+// Asynchronous handlers can only report this when the record is written (see HandlerHealth.Dropped).
+var ErrDropped = errors.New("log4go: record dropped")
+
+// FlushHandler flushes the handler, if it's a Flusher.
+func FlushHandler(h Handler) error {
+	if f, ok := h.(Flusher); ok {
+		return f.Flush()
+	}
+	return nil
+}
+
+// CloseHandler shuts down the handler, using Close if it's a Closer (i.e. the error is nil for other handlers).
+func CloseHandler(h Handler) error {
+	if c, ok := h.(Closer); ok {
+		return c.Close()
+	}
+	h.Shutdown()
+	return nil
+}
+
+// handles returns whether the handler accepts records of the level, i.e. whether the level is within
+// the handler's level (if set) and its max level (if it has one).
+func handles(h Handler, lvl Level) bool {
+	if lvl < h.Level() {
+		return false
+	}
+	if ml, ok := h.(MaxLeveler); ok {
+		if max := ml.MaxLevel(); max != INHERIT && lvl > max {
+			return false
+		}
+	}
+	return true
+}
+
 // StreamHandler handles stream-based output.
 type StreamHandler struct {
-	writer        io.Writer
-	formatter     Formatter
-	level         Level
-	commitChannel chan Record
+	writer    io.Writer
+	formatter Formatter
+	level     Level
+	maxLevel  Level
+	queue     *recordQueue
+	lock      sync.RWMutex  // guards queue against being closed while pushing
+	done      chan struct{} // closed when the committer has exited
+
+	// synchronous mode (see StreamOpts.Sync)
+	sync      bool
+	writeLock sync.Mutex
+	closed    bool
+
+	footer     bool
+	ownsWriter bool
+	written    uint64 // accessed atomically
+
+	writeStats writeStats
+
+	// status line (see StreamOpts.Status), statusLock serializing writes with RefreshStatus
+	status       func() string
+	statusLock   sync.Mutex
+	statusClosed bool
+
+	share *writerShare // shared with the handler's clones (see Clone)
+}
+
+// StreamOpts is used to supply options to NewStreamHandler.
+type StreamOpts struct {
+	// Sync makes the handler write in the logging goroutine (under a mutex) instead of in a committer goroutine,
+	// i.e. records are written when the logging call returns. E.g. for short-lived CLI tools and tests.
+	Sync bool
+	// Footer makes a final line be written when the handler is shut down, stating the number of records
+	// written and dropped, e.g. "# closed=2020-05-17T12:34:56Z written=1234 dropped=0".
+	// This distinguishes cleanly stopped logs from truncated ones.
+	Footer bool
+	// SlowWrite makes a warning be printed (to stderr, at most once a minute) when the 99th percentile
+	// of the write latency exceeds it, e.g. revealing a slow NFS mount or disk backing up the queue.
+	SlowWrite time.Duration
+	// OwnsWriter makes the writer be closed (if an io.Closer) when the handler is shut down, after syncing it
+	// (if it has a Sync method, e.g. an *os.File). Handlers opening their own files always do so.
+	OwnsWriter bool
+	// Status makes the handler cooperate with a status (e.g. progress) line of a CLI, drawn below the records
+	// by calling Status: the line is cleared before writing a record, and redrawn afterwards. Call RefreshStatus
+	// when the status changes. The line is cleared when the handler is shut down. For terminals only.
+	Status func() string
+	// QueueSize is the capacity of the queue of records waiting to be written (default 1024), rounded up
+	// to a power of two. Logging goroutines wait (see Wait) when it's full.
+	QueueSize int
+	// Wait selects how the committer waits for records, and logging goroutines for room in a full queue
+	// (default BlockingWait).
+	Wait WaitStrategy
 }
 
 // NewStreamHandler returns a new StreamHandler instance using the specified writer.
-func NewStreamHandler(w io.Writer) (*StreamHandler, error) {
+func NewStreamHandler(w io.Writer, opts ...StreamOpts) (*StreamHandler, error) {
 	handler := &StreamHandler{
-		writer:        w,
-		commitChannel: make(chan Record, 1000),
+		writer: w,
+		done:   make(chan struct{}),
+		share:  &writerShare{refs: 1},
+	}
+	if len(opts) > 0 {
+		handler.footer = opts[0].Footer
+		handler.writeStats.threshold = opts[0].SlowWrite
+		handler.ownsWriter = opts[0].OwnsWriter
+		handler.status = opts[0].Status
+	}
+	if len(opts) > 0 && opts[0].Sync {
+		handler.sync = true
+		close(handler.done)
+		return handler, nil
 	}
 
+	size, wait := defaultQueueSize, BlockingWait
+	if len(opts) > 0 {
+		if opts[0].QueueSize > 0 {
+			size = opts[0].QueueSize
+		}
+		wait = opts[0].Wait
+	}
+	handler.queue = newRecordQueue(size, wait)
 	go handler.committer()
 
 	return handler, nil
 }
 
+// FileOpts is used to supply options to NewFileHandler.
+type FileOpts struct {
+	// Lock makes every write hold an advisory lock (flock) on the file, so multiple processes
+	// writing to the same file don't interleave partial lines. Implies appending writes.
+	Lock bool
+	// Retry controls reopening the file after write errors.
+	Retry RetryPolicy
+	// Sync makes the handler write synchronously (see StreamOpts.Sync).
+	Sync bool
+	// Footer makes a final line be written when the handler is shut down (see StreamOpts.Footer).
+	Footer bool
+	// Header makes a header line, describing the process (e.g. pid, version and command line),
+	// be written whenever the file is opened (or reopened), making each file self-describing.
+	Header bool
+	// SlowWrite makes slow writes be warned about (see StreamOpts.SlowWrite).
+	SlowWrite time.Duration
+	// QueueSize is the capacity of the queue (see StreamOpts.QueueSize).
+	QueueSize int
+	// Wait selects how to wait on the queue (see StreamOpts.Wait).
+	Wait WaitStrategy
+	// TestWrite makes the handler test writing to (and syncing) the file at creation, so e.g. a read-only
+	// or failing mount is reported then, rather than when the first record is written.
+	TestWrite bool
+
+	watch bool // see WatchedFileHandler
+}
+
 // NewFileHandler returns a new StreamHandler instance writing to the specified file name.
-func NewFileHandler(filename string, appendFile bool) (*StreamHandler, error) {
+// The name may have variables, resolved whenever the file is (re)opened: {hostname}, {pid}, {program} (the base
+// name of the executable), {date} (e.g. 2020-05-17) and {time} (e.g. 123456); e.g. "app-{hostname}-{pid}.log",
+// so multiple instances on one host don't clobber each other's files.
+// After a write error (e.g. disk full or the file deleted) the file is reopened, see RetryPolicy.
+func NewFileHandler(filename string, appendFile bool, opts ...FileOpts) (*StreamHandler, error) {
+	var opt FileOpts
+	if len(opts) > 0 {
+		opt = opts[0]
+	}
+
 	flags := os.O_WRONLY | os.O_CREATE
-	if appendFile {
+	if appendFile || opt.Lock {
 		flags |= os.O_APPEND
-	} else {
+	}
+	if !appendFile {
 		flags |= os.O_TRUNC
 	}
 
-	writer, err := os.OpenFile(filename, flags, 0664)
+	writer, err := newFileWriter(filename, flags, opt)
 	if err != nil {
 		return nil, err
 	}
-	return NewStreamHandler(writer)
+	return NewStreamHandler(writer, StreamOpts{Sync: opt.Sync, Footer: opt.Footer, SlowWrite: opt.SlowWrite, OwnsWriter: true,
+		QueueSize: opt.QueueSize, Wait: opt.Wait})
 }
 
 // SetLevel sets the level the handler will (at least) handle.
@@ -63,147 +231,293 @@ func (h *StreamHandler) Level() Level {
 	return h.level
 }
 
+// SetMaxLevel sets the level the handler will (at most) handle, e.g. INFO for a stdout handler
+// leaving WARNING and above to a stderr handler. INHERIT means no upper bound (the default).
+func (h *StreamHandler) SetMaxLevel(level Level) {
+	h.maxLevel = level
+}
+
+// MaxLevel returns the max level previously set (or INHERIT if not set).
+func (h *StreamHandler) MaxLevel() Level {
+	return h.maxLevel
+}
+
 // Handle handles the formatted message.
+// It returns ErrClosed after the handler has been shut down, and (in synchronous mode) ErrDropped
+// if the record couldn't be written.
 func (h *StreamHandler) Handle(rec *Record) error {
-	if h.commitChannel != nil {
-		h.commitChannel <- *rec
+	if h.sync {
+		h.writeLock.Lock()
+		defer h.writeLock.Unlock()
+
+		if h.closed {
+			return ErrClosed
+		}
+		if !h.write(rec) {
+			return ErrDropped
+		}
+		return nil
 	}
+
+	h.lock.RLock()
+	defer h.lock.RUnlock()
+
+	if h.queue.isClosed() {
+		return ErrClosed
+	}
+	h.queue.push(rec)
 	return nil
 }
 
-// Shutdown shuts down the handler.
-func (h *StreamHandler) Shutdown() {
-	if h.commitChannel != nil {
-		cc := h.commitChannel
-		// set to nil before closing
-		h.commitChannel = nil
+// Flush returns when the records queued have been written.
+func (h *StreamHandler) Flush() error {
+	if h.sync {
+		h.writeLock.Lock()
+		defer h.writeLock.Unlock()
+
+		if h.closed {
+			return ErrClosed
+		}
+		return nil
+	}
 
-		close(cc)
+	h.lock.RLock()
+	if h.queue.isClosed() {
+		h.lock.RUnlock()
+		return ErrClosed
 	}
+	written := h.queue.flush()
+	h.lock.RUnlock()
+
+	<-written
+	return nil
 }
 
-func (h *StreamHandler) onPreWrite() {
-	// default does nothing
+// Shutdown shuts down the handler, returning when all queued records have been written.
+// Subsequent calls only wait for that.
+func (h *StreamHandler) Shutdown() {
+	h.Close()
 }
 
-func (h *StreamHandler) committer() {
-	for rec := range h.commitChannel {
-		msg, err := h.Formatter().Format(&rec)
-		if err != nil {
-			fmt.Fprintf(os.Stderr, "log4go.StreamHandler: formatter error %v\n", err)
-			continue
+// Close does the same as Shutdown, returning ErrClosed if the handler was already closed
+// (or the error closing an owned writer, see StreamOpts.OwnsWriter).
+func (h *StreamHandler) Close() error {
+	if h.sync {
+		h.writeLock.Lock()
+		defer h.writeLock.Unlock()
+
+		if h.closed {
+			return ErrClosed
 		}
+		h.closed = true
+		h.clearStatus()
+		h.writeFooter()
+		return h.closeWriter()
+	}
 
-		msg = append(msg, '\n')
+	h.lock.Lock()
+	closed := h.queue.isClosed()
+	h.queue.close()
+	h.lock.Unlock()
 
-		h.onPreWrite()
+	<-h.done
+	if closed {
+		return ErrClosed
+	}
+	h.clearStatus()
+	h.writeFooter()
+	return h.closeWriter()
+}
 
-		if _, err = h.writer.Write(msg); err != nil {
-			fmt.Fprintf(os.Stderr, "log4go.StreamHandler: write error: %v\n", err)
-		}
+// writeFooter writes the footer line, if enabled (the committer must have exited).
+func (h *StreamHandler) writeFooter() {
+	if !h.footer {
+		return
+	}
+	footer := fmt.Sprintf("# closed=%s written=%d dropped=%d\n",
+		time.Now().Format(time.RFC3339), atomic.LoadUint64(&h.written), h.Health().Dropped)
+	if _, err := h.writeShared([]byte(footer)); err != nil && err != ErrDropped {
+		report(ERROR, "StreamHandler", "write error: %v", err)
 	}
 }
 
-// SetFormatter sets the handler's Formatter.
-func (h *StreamHandler) SetFormatter(formatter Formatter) {
-	if formatter == nil {
-		fmt.Fprintln(os.Stderr, "log4go.StreamHandler: setting nil formatter")
+// statusClear returns the cursor to the start of the line, and clears it.
+const statusClear = "\r\x1b[K"
+
+// RefreshStatus redraws the status line (see StreamOpts.Status), e.g. after the progress changed.
+func (h *StreamHandler) RefreshStatus() {
+	if h.status == nil {
+		return
 	}
+	h.statusLock.Lock()
+	defer h.statusLock.Unlock()
 
-	h.formatter = formatter
+	if !h.statusClosed {
+		h.writeShared([]byte(statusClear + h.status()))
+	}
 }
 
-// Formatter resutns the handler's Formatter.
-func (h *StreamHandler) Formatter() Formatter {
-	return h.formatter
+// clearStatus clears the status line for good, if any (the committer must have exited).
+func (h *StreamHandler) clearStatus() {
+	if h.status == nil {
+		return
+	}
+	h.statusLock.Lock()
+	defer h.statusLock.Unlock()
+
+	h.statusClosed = true
+	h.writeShared([]byte(statusClear))
 }
 
-// WatchedFileHandler watches the log file: if file is moved the filename is re-opened.
-type WatchedFileHandler struct {
-	*StreamHandler
+// closeWriter syncs and closes the writer, if owned and not shared with clones still open
+// (the committer must have exited).
+func (h *StreamHandler) closeWriter() error {
+	h.share.lock.Lock()
+	h.share.refs--
+	shared := h.share.refs > 0
+	h.share.lock.Unlock()
 
-	fp       *os.File // we want to use Sync()
-	filename string
-	append   bool
-	inode    uint64
-	dev      uint64
+	if !h.ownsWriter || shared {
+		return nil
+	}
+	if syncer, ok := h.writer.(interface{ Sync() error }); ok {
+		syncer.Sync() // best effort, e.g. pipes can't be synced
+	}
+	if closer, ok := h.writer.(io.Closer); ok {
+		if err := closer.Close(); err != nil {
+			report(ERROR, "StreamHandler", "close error: %v", err)
+			return err
+		}
+	}
+	return nil
 }
 
-// NewWatchedFileHandler returns a new WatchedFileHandler instance writing to the specified file name.
-func NewWatchedFileHandler(filename string, append bool) (*WatchedFileHandler, error) {
-	wfh := &WatchedFileHandler{
-		filename: filename,
-		append:   append,
-	}
+// committer writes the queued records until the queue is closed (and drained), then exits.
+func (h *StreamHandler) committer() {
+	defer close(h.done)
 
-	if err := wfh.open(); err != nil {
-		return nil, err
+	var rec Record
+	for {
+		if h.queue.pop(&rec) {
+			h.write(&rec)
+			h.queue.written(false)
+			continue
+		}
+		// closed while no record is being pushed (see Close), i.e. drained if still empty
+		if h.queue.isClosed() && h.queue.empty() {
+			h.queue.written(true)
+			return
+		}
+		h.queue.written(false)
+		h.queue.idle()
 	}
+}
 
-	s, err := NewStreamHandler(wfh.fp)
+// writeBuffers are reused by StreamHandler.write, formatting records into them (see BufferFormatter).
+var writeBuffers = sync.Pool{
+	New: func() interface{} {
+		buf := make([]byte, 0, 256)
+		return &buf
+	},
+}
+
+// maxPooledBuffer is the capacity beyond which a buffer isn't returned to the pool (i.e. after a huge record).
+const maxPooledBuffer = 64 << 10
+
+// write formats and writes a record (from the committer, or the logging goroutine in synchronous mode),
+// returning whether it was written.
+func (h *StreamHandler) write(rec *Record) bool {
+	buf := writeBuffers.Get().(*[]byte)
+	defer func() {
+		if cap(*buf) <= maxPooledBuffer {
+			writeBuffers.Put(buf)
+		}
+	}()
+
+	msg := (*buf)[:0]
+	if h.status != nil {
+		msg = append(msg, statusClear...)
+	}
+	err := formatTo(h.Formatter(), &msg, rec)
 	if err != nil {
-		return nil, err
+		report(ERROR, "StreamHandler", "formatter error %v", err)
+		return false
 	}
 
-	wfh.StreamHandler = s
+	msg = append(msg, '\n')
+	if h.status != nil {
+		// written at once with the record, so the line doesn't flicker
+		h.statusLock.Lock()
+		defer h.statusLock.Unlock()
+		msg = append(msg, h.status()...)
+	}
+	*buf = msg
 
-	return wfh, nil
+	h.share.lock.Lock()
+	start := time.Now()
+	_, err = h.writer.Write(msg)
+	h.writeStats.add(time.Since(start), "StreamHandler", h.queue.len())
+	h.share.lock.Unlock()
+	if err != nil {
+		if err != ErrDropped {
+			report(ERROR, "StreamHandler", "write error: %v", err)
+		}
+		return false
+	}
+	atomic.AddUint64(&h.written, 1)
+	return true
 }
 
-// called when committer is about to write a message
-func (h *WatchedFileHandler) onPreWrite() {
-	if h.fileHasMoved() {
-		// just re-open, with same filename
-		h.close()
-		if err := h.open(); err == nil {
-			h.writer = h.fp
-		} else {
-			fmt.Fprintf(os.Stderr, "log4go.WatchedFileHandler: failed to open moved file: %v\n", err)
-		}
+// SetFormatter sets the handler's Formatter.
+func (h *StreamHandler) SetFormatter(formatter Formatter) {
+	if formatter == nil {
+		report(WARNING, "StreamHandler", "setting nil formatter")
 	}
+
+	h.formatter = formatter
 }
 
-func (h *WatchedFileHandler) fileHasMoved() bool {
-	// TODO: use fsnotify to detect when the file has moved?
+// Formatter resutns the handler's Formatter.
+func (h *StreamHandler) Formatter() Formatter {
+	return h.formatter
+}
+
+// Pending returns the number of records queued, waiting to be written.
+func (h *StreamHandler) Pending() int {
+	return h.queue.len()
+}
 
-	dev, ino := h.statFile()
-	// in case statFile() returns (0, 0) this will return true also
-	return dev != h.dev || ino != h.inode
+// WriteStats returns the latency statistics of the handler's writes.
+func (h *StreamHandler) WriteStats() WriteStats {
+	return h.writeStats.stats()
 }
 
-func (h *WatchedFileHandler) close() {
-	if h.fp != nil {
-		h.fp.Sync()
-		h.fp.Close()
-		h.fp = nil
-		h.writer = nil
+// Health returns the health status of the handler's writer, if it reports one (e.g. the file of a FileHandler).
+func (h *StreamHandler) Health() HandlerHealth {
+	if reporter, ok := h.writer.(interface{ health() HandlerHealth }); ok {
+		return reporter.health()
 	}
+	return HandlerHealth{Healthy: true}
 }
 
-func (h *WatchedFileHandler) open() error {
-	flags := os.O_WRONLY | os.O_CREATE
-	if h.append {
-		flags |= os.O_APPEND
-	} else {
-		flags |= os.O_TRUNC
+// WatchedFileHandler watches the log file: if file is moved the filename is re-opened.
+// Likewise when its name resolves differently (see NewFileHandler), e.g. at midnight with {date}.
+type WatchedFileHandler struct {
+	*StreamHandler
+}
+
+// NewWatchedFileHandler returns a new WatchedFileHandler instance writing to the specified file name.
+func NewWatchedFileHandler(filename string, append bool, opts ...FileOpts) (*WatchedFileHandler, error) {
+	var opt FileOpts
+	if len(opts) > 0 {
+		opt = opts[0]
 	}
+	opt.watch = true
 
-	fp, err := os.OpenFile(h.filename, flags, 0664)
+	s, err := NewFileHandler(filename, append, opt)
 	if err != nil {
-		return err
+		return nil, err
 	}
-	h.writer = fp
 
-	h.dev, h.inode = h.statFile()
-
-	return nil
-}
-
-func (h *WatchedFileHandler) statFile() (uint64, uint64) {
-	info, _ := os.Stat(h.filename)
-	if stat, ok := info.Sys().(*syscall.Stat_t); !ok {
-		return 0, 0
-	} else {
-		return stat.Dev, stat.Ino
-	}
+	return &WatchedFileHandler{s}, nil
 }
@@ -0,0 +1,1916 @@
+package log4go
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/smtp"
+	"os"
+	"path/filepath"
+	"reflect"
+	"regexp"
+	"runtime"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestMemoryHandler(t *testing.T) {
+	var buf bytes.Buffer
+
+	target, _ := NewStreamHandler(&buf)
+	formatter, _ := NewTemplateFormatter("{level} {message}")
+	target.SetFormatter(formatter)
+
+	BasicConfig(BasicConfigOpts{
+		Level:    DEBUG,
+		Handlers: []Handler{NewMemoryHandler(2, ERROR, target)},
+	})
+
+	log := GetLogger("test")
+
+	log.Debug("dropped, buffer too small")
+	log.Debug("context 1")
+	log.Info("context 2")
+	log.Error("failure")
+	log.Info("not flushed")
+
+	Shutdown()
+
+	if out := buf.String(); out != "DEBUG context 1\nINFO context 2\nERROR failure\n" {
+		t.Errorf("unexpected output: %q", out)
+	}
+}
+
+func TestSMTPHandler(t *testing.T) {
+	handler, err := NewSMTPHandler(SMTPOpts{
+		Addr:     "localhost:25",
+		From:     "app@example.com",
+		To:       []string{"ops@example.com"},
+		Interval: time.Hour,
+	})
+	if err != nil {
+		t.Fatalf("NewSMTPHandler failed: %v", err)
+	}
+
+	var lock sync.Mutex
+	var mails []string
+	handler.sendMail = func(addr string, a smtp.Auth, from string, to []string, msg []byte) error {
+		lock.Lock()
+		defer lock.Unlock()
+		mails = append(mails, string(msg))
+		return nil
+	}
+
+	BasicConfig(BasicConfigOpts{
+		Level:    DEBUG,
+		Handlers: []Handler{handler},
+		Format:   "{level} {message}",
+	})
+
+	log := GetLogger("test")
+	log.Info("not mailed")
+	log.Error("first failure")
+	log.Error("second failure")
+
+	Shutdown()
+
+	lock.Lock()
+	defer lock.Unlock()
+
+	all := strings.Join(mails, "")
+	if len(mails) == 0 || len(mails) > 2 {
+		t.Fatalf("expected one or two mails, got %d", len(mails))
+	}
+	if !strings.Contains(mails[0], "To: ops@example.com\r\n") {
+		t.Errorf("recipient header missing: %q", mails[0])
+	}
+	if strings.Contains(all, "not mailed") {
+		t.Errorf("INFO record was mailed: %q", all)
+	}
+	if !strings.Contains(all, "ERROR first failure") || !strings.Contains(all, "ERROR second failure") {
+		t.Errorf("ERROR records missing: %q", all)
+	}
+}
+
+func TestSMTPHandlerShutdown(t *testing.T) {
+	handler, _ := NewSMTPHandler(SMTPOpts{Addr: "localhost:25", From: "app@example.com", To: []string{"ops@example.com"}})
+	formatter, _ := NewTemplateFormatter("{message}")
+	handler.SetFormatter(formatter)
+
+	var delivered int64
+	sending := make(chan struct{}, 1)
+	handler.sendMail = func(addr string, a smtp.Auth, from string, to []string, msg []byte) error {
+		sending <- struct{}{}
+		time.Sleep(20 * time.Millisecond) // e.g. a slow server
+		atomic.AddInt64(&delivered, 1)
+		return nil
+	}
+
+	handler.Handle(&Record{Level: FATAL, Message: "last words"})
+	<-sending // in flight when shutting down
+	handler.Shutdown()
+	if n := atomic.LoadInt64(&delivered); n != 1 {
+		t.Errorf("expected the mail delivered when shut down, got %d mails", n)
+	}
+}
+
+func TestWebhookHandler(t *testing.T) {
+	var lock sync.Mutex
+	var posts []map[string]interface{}
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		var payload map[string]interface{}
+		if err := json.Unmarshal(body, &payload); err != nil {
+			t.Errorf("invalid payload: %v", err)
+		}
+		lock.Lock()
+		posts = append(posts, payload)
+		lock.Unlock()
+	}))
+	defer server.Close()
+
+	handler, err := NewWebhookHandler(WebhookOpts{
+		URL:    server.URL,
+		Flavor: Discord,
+	})
+	if err != nil {
+		t.Fatalf("NewWebhookHandler failed: %v", err)
+	}
+
+	BasicConfig(BasicConfigOpts{
+		Level:    DEBUG,
+		Handlers: []Handler{handler},
+		Format:   "{message}",
+	})
+
+	GetLogger("test").Warning("not posted")
+	GetLogger("test").Error("database unreachable")
+
+	Shutdown()
+
+	lock.Lock()
+	defer lock.Unlock()
+
+	if len(posts) != 1 {
+		t.Fatalf("expected 1 post, got %d", len(posts))
+	}
+	embed := posts[0]["embeds"].([]interface{})[0].(map[string]interface{})
+	if embed["description"] != "\u274C database unreachable" {
+		t.Errorf("unexpected description: %q", embed["description"])
+	}
+	if embed["color"] != float64(0xd50200) {
+		t.Errorf("unexpected color: %v", embed["color"])
+	}
+}
+
+func TestThrottledBatchFlush(t *testing.T) {
+	var sent int64
+	sending := make(chan struct{}, 2)
+	batch := newThrottledBatch(time.Hour, func(items []batchItem) {
+		sending <- struct{}{}
+		time.Sleep(20 * time.Millisecond) // e.g. a slow server
+		atomic.AddInt64(&sent, int64(len(items)))
+	})
+
+	batch.add(batchItem{record: Record{Message: "sent right away"}})
+	<-sending // in flight when flushing
+	batch.add(batchItem{record: Record{Message: "scheduled"}})
+	batch.flush()
+	if n := atomic.LoadInt64(&sent); n != 2 {
+		t.Errorf("expected 2 items sent when flushed, got %d", n)
+	}
+
+	// both sent by the first send, racing with scheduling the second: the timer is still cancelled
+	batch = newThrottledBatch(time.Hour, func(items []batchItem) {
+		atomic.AddInt64(&sent, int64(len(items)))
+	})
+	batch.add(batchItem{record: Record{Message: "sent right away"}})
+	batch.add(batchItem{record: Record{Message: "scheduled"}})
+	for atomic.LoadInt64(&sent) != 4 {
+		time.Sleep(time.Millisecond)
+	}
+	flushed := make(chan struct{})
+	go func() {
+		batch.flush()
+		close(flushed)
+	}()
+	select {
+	case <-flushed:
+	case <-time.After(time.Second):
+		t.Errorf("flush waited for the cancelled send")
+	}
+}
+
+func TestSentryHandler(t *testing.T) {
+	var lock sync.Mutex
+	var events []map[string]interface{}
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/api/42/store/" {
+			t.Errorf("unexpected path: %s", r.URL.Path)
+		}
+		if auth := r.Header.Get("X-Sentry-Auth"); !strings.Contains(auth, "sentry_key=public") {
+			t.Errorf("unexpected auth header: %s", auth)
+		}
+		body, _ := ioutil.ReadAll(r.Body)
+		var event map[string]interface{}
+		if err := json.Unmarshal(body, &event); err != nil {
+			t.Errorf("invalid event: %v", err)
+		}
+		lock.Lock()
+		events = append(events, event)
+		lock.Unlock()
+	}))
+	defer server.Close()
+
+	handler, err := NewSentryHandler(SentryOpts{
+		DSN:  strings.Replace(server.URL, "://", "://public@", 1) + "/42",
+		Tags: []string{"user"},
+	})
+	if err != nil {
+		t.Fatalf("NewSentryHandler failed: %v", err)
+	}
+
+	BasicConfig(BasicConfigOpts{
+		Level:    DEBUG,
+		Handlers: []Handler{handler},
+	})
+
+	log := GetLogger("test").With(Fields{"user": "bob", "request": 17})
+	log.Info("not sent")
+	log.Error("payment failed")
+
+	Shutdown()
+
+	lock.Lock()
+	defer lock.Unlock()
+
+	if len(events) != 1 {
+		t.Fatalf("expected 1 event, got %d", len(events))
+	}
+	event := events[0]
+	if event["message"] != "payment failed" || event["level"] != "error" || event["logger"] != "test" {
+		t.Errorf("unexpected event: %v", event)
+	}
+	if tags := event["tags"].(map[string]interface{}); tags["user"] != "bob" {
+		t.Errorf("unexpected tags: %v", tags)
+	}
+	if extra := event["extra"].(map[string]interface{}); extra["request"] != float64(17) {
+		t.Errorf("unexpected extra: %v", extra)
+	}
+}
+
+func TestSentryHandlerShutdown(t *testing.T) {
+	var events int64
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(20 * time.Millisecond) // e.g. a slow server
+		atomic.AddInt64(&events, 1)
+	}))
+	defer server.Close()
+
+	handler, _ := NewSentryHandler(SentryOpts{DSN: strings.Replace(server.URL, "://", "://public@", 1) + "/42"})
+	handler.Handle(&Record{Name: "test", Level: ERROR, Message: "payment failed"})
+	handler.Handle(&Record{Name: "test", Level: FATAL, Message: "out of memory"})
+	handler.Shutdown()
+	if n := atomic.LoadInt64(&events); n != 2 {
+		t.Errorf("expected 2 events sent when shut down, got %d", n)
+	}
+}
+
+func TestSignAWSv4(t *testing.T) {
+	// example from the AWS Signature Version 4 documentation
+	req, _ := http.NewRequest(http.MethodGet, "https://iam.amazonaws.com/?Action=ListUsers&Version=2010-05-08", nil)
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded; charset=utf-8")
+
+	now, _ := time.Parse("20060102T150405Z", "20150830T123600Z")
+	signAWSv4(req, nil, "us-east-1", "iam", "AKIDEXAMPLE", "wJalrXUtnFEMI/K7MDENG+bPxRfiCYEXAMPLEKEY", "", now)
+
+	expected := "AWS4-HMAC-SHA256 Credential=AKIDEXAMPLE/20150830/us-east-1/iam/aws4_request, " +
+		"SignedHeaders=content-type;host;x-amz-date, " +
+		"Signature=5d672d79c15b13162d9279b0855cfba6789a8edb4c82c400e06b5924a6f2b5d7"
+	if auth := req.Header.Get("Authorization"); auth != expected {
+		t.Errorf("unexpected signature:\n%s\nexpected:\n%s", auth, expected)
+	}
+}
+
+func TestCloudWatchHandler(t *testing.T) {
+	var lock sync.Mutex
+	var actions []string
+	var events []interface{}
+	streamExists := false
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		lock.Lock()
+		defer lock.Unlock()
+
+		action := strings.TrimPrefix(r.Header.Get("X-Amz-Target"), "Logs_20140328.")
+		actions = append(actions, action)
+
+		body, _ := ioutil.ReadAll(r.Body)
+		var request map[string]interface{}
+		json.Unmarshal(body, &request)
+
+		switch action {
+		case "PutLogEvents":
+			if !streamExists {
+				w.WriteHeader(http.StatusBadRequest)
+				w.Write([]byte(`{"__type":"ResourceNotFoundException","message":"The specified log group does not exist."}`))
+				return
+			}
+			events = append(events, request["logEvents"].([]interface{})...)
+			w.Write([]byte(`{"nextSequenceToken":"42"}`))
+		case "CreateLogStream":
+			streamExists = true
+		}
+	}))
+	defer server.Close()
+
+	handler, err := NewCloudWatchHandler(CloudWatchOpts{
+		Region:          "eu-north-1",
+		Group:           "app",
+		Stream:          "instance-1",
+		AccessKeyID:     "AKID",
+		SecretAccessKey: "secret",
+		Endpoint:        server.URL + "/",
+	})
+	if err != nil {
+		t.Fatalf("NewCloudWatchHandler failed: %v", err)
+	}
+
+	BasicConfig(BasicConfigOpts{
+		Level:    INFO,
+		Handlers: []Handler{handler},
+		Format:   "{level} {message}",
+	})
+
+	GetLogger("test").Info("first")
+	GetLogger("test").Warning("second")
+
+	Shutdown()
+
+	lock.Lock()
+	defer lock.Unlock()
+
+	if got := strings.Join(actions, ","); got != "PutLogEvents,CreateLogGroup,CreateLogStream,PutLogEvents" {
+		t.Errorf("unexpected actions: %s", got)
+	}
+	if len(events) != 2 || events[1].(map[string]interface{})["message"] != "WARNING second" {
+		t.Errorf("unexpected events: %v", events)
+	}
+}
+
+func TestStatsdHook(t *testing.T) {
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Skipf("cannot listen on UDP: %v", err)
+	}
+	defer conn.Close()
+
+	hook, err := NewStatsdHook(conn.LocalAddr().String(), "app.log")
+	if err != nil {
+		t.Fatalf("NewStatsdHook failed: %v", err)
+	}
+
+	hook("db/pool", ERROR)
+
+	buf := make([]byte, 100)
+	conn.SetReadDeadline(time.Now().Add(time.Second))
+	n, _, err := conn.ReadFrom(buf)
+	if err != nil {
+		t.Fatalf("no metric received: %v", err)
+	}
+	if metric := string(buf[:n]); metric != "app.log.db.pool.error:1|c" {
+		t.Errorf("unexpected metric: %q", metric)
+	}
+}
+
+func TestRecordBinaryEncoding(t *testing.T) {
+	rec := Record{
+		Time:        time.Unix(1600000000, 123456789),
+		Name:        "db/pool",
+		Level:       ERROR,
+		Message:     "connection lost",
+		Duration:    1500 * time.Millisecond,
+		Fields:      Fields{"host": "db1", "attempt": 3, "error": fmt.Errorf("dial: %w", io.EOF)},
+		Stack:       "main.go:17",
+		Monotonic:   42 * time.Second,
+		ID:          "01ARYZ6S41TSV4RRFFQ69G5FAV",
+		GoroutineID: 17,
+	}
+
+	data, err := rec.MarshalBinary()
+	if err != nil {
+		t.Fatalf("MarshalBinary failed: %v", err)
+	}
+
+	var decoded Record
+	if err := decoded.UnmarshalBinary(data); err != nil {
+		t.Fatalf("UnmarshalBinary failed: %v", err)
+	}
+
+	if !decoded.Time.Equal(rec.Time) || decoded.Name != rec.Name || decoded.Level != rec.Level ||
+		decoded.Message != rec.Message || decoded.Duration != rec.Duration || decoded.Stack != rec.Stack ||
+		decoded.Monotonic != rec.Monotonic || decoded.ID != rec.ID || decoded.GoroutineID != rec.GoroutineID {
+		t.Errorf("decoded record differs:\n%+v\n%+v", decoded, rec)
+	}
+	if decoded.Fields["host"] != "db1" || decoded.Fields["attempt"] != float64(3) || decoded.Fields["error"] != "dial: EOF" {
+		t.Errorf("decoded fields differ: %v", decoded.Fields)
+	}
+
+	if err := decoded.UnmarshalBinary(data[:len(data)-3]); err != ErrInvalidRecord {
+		t.Errorf("expected ErrInvalidRecord for truncated data, got %v", err)
+	}
+	if err := decoded.UnmarshalBinary(append([]byte{recordEncodingVersion - 1}, data[1:]...)); err != ErrInvalidRecord {
+		t.Errorf("expected ErrInvalidRecord for another version, got %v", err)
+	}
+}
+
+func TestRelay(t *testing.T) {
+	recorder := &recordingHandler{}
+	formatter, _ := NewTemplateFormatter("{message}")
+	recorder.SetFormatter(formatter)
+
+	BasicConfig(BasicConfigOpts{
+		Level:    INFO,
+		Handlers: []Handler{recorder},
+	})
+
+	server, err := NewRelayServer("127.0.0.1:0")
+	if err != nil {
+		t.Skipf("cannot listen: %v", err)
+	}
+	go server.Serve()
+
+	relay, err := NewRelayHandler(server.Addr().String())
+	if err != nil {
+		t.Fatalf("NewRelayHandler failed: %v", err)
+	}
+
+	// as if logged in another process
+	relay.Handle(&Record{Time: time.Now(), Name: "remote/app", Level: WARNING, Message: "relayed"})
+	relay.Handle(&Record{Time: time.Now(), Name: "remote/app", Level: DEBUG, Message: "filtered by the server's level"})
+	relay.Shutdown()
+
+	server.Close()
+	Shutdown()
+
+	recorder.lock.Lock()
+	defer recorder.lock.Unlock()
+
+	if len(recorder.records) != 1 {
+		t.Fatalf("expected 1 record, got %d", len(recorder.records))
+	}
+	if rec := recorder.records[0]; rec.Name != "remote/app" || rec.Message != "relayed" {
+		t.Errorf("unexpected record: %+v", rec)
+	}
+}
+
+func TestRelayAckTimeout(t *testing.T) {
+	defer func(max int, timeout time.Duration) {
+		maxRelayUnacked, relayAckTimeout = max, timeout
+	}(maxRelayUnacked, relayAckTimeout)
+	maxRelayUnacked, relayAckTimeout = 2, 20*time.Millisecond
+
+	// a server never acknowledging
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Skipf("cannot listen: %v", err)
+	}
+	defer listener.Close()
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go io.Copy(ioutil.Discard, conn)
+		}
+	}()
+
+	dir, err := ioutil.TempDir("", "log4go-spill")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	queue, _ := NewSpillQueue(dir)
+
+	relay, err := NewRelayHandler(listener.Addr().String(), RelayOpts{Acks: true, Spill: queue})
+	if err != nil {
+		t.Fatalf("NewRelayHandler failed: %v", err)
+	}
+	for n := 0; n < 3; n++ {
+		relay.Handle(&Record{Time: time.Now(), Level: WARNING, Message: fmt.Sprintf("record %d", n)})
+	}
+	relay.Shutdown()
+
+	// the unacknowledged records are spilled once each
+	queue, _ = NewSpillQueue(dir)
+	defer queue.Close()
+	var spilled []string
+	queue.Replay(func(rec *Record) error {
+		spilled = append(spilled, rec.Message)
+		return nil
+	})
+	expected := []string{"record 0", "record 1", "record 2"}
+	if !reflect.DeepEqual(spilled, expected) {
+		t.Errorf("expected %v spilled, got %v", expected, spilled)
+	}
+}
+
+func TestRelayServerSessions(t *testing.T) {
+	defer func(max int) { maxRelaySessions = max }(maxRelaySessions)
+	maxRelaySessions = 2
+
+	server := &RelayServer{sessions: map[uint64]*relaySession{}}
+	for session := uint64(1); session <= 5; session++ {
+		server.received(session, 1)
+	}
+	if len(server.sessions) != 2 {
+		t.Errorf("expected 2 sessions tracked, got %d", len(server.sessions))
+	}
+	if server.received(5, 1) {
+		t.Errorf("expected the last session still tracked, discarding a resent record")
+	}
+}
+
+func TestRelayServerLoggers(t *testing.T) {
+	defer func(max int) { maxRelayLoggers = max }(maxRelayLoggers)
+	maxRelayLoggers = 3 // i.e. relayed, relayed/a and relayed/b
+
+	server := &RelayServer{}
+	for _, name := range []string{"relayed/a", "relayed/b", "relayed/b"} {
+		if logger := server.relayLogger(name); logger.name != name {
+			t.Errorf("expected logger %s, got %s", name, logger.name)
+		}
+	}
+	// too many names received, the closest existing logger is used
+	if logger := server.relayLogger("relayed/c/d"); logger.name != "relayed" {
+		t.Errorf("expected logger relayed, got %s", logger.name)
+	}
+	if _, exists := closestLogger("relayed/c"); exists {
+		t.Errorf("logger created beyond the limit")
+	}
+
+	// the ancestors created count too, e.g. of a single deep name
+	Reset()
+	server = &RelayServer{}
+	deep := strings.Repeat("x/", 100) + "y"
+	if logger := server.relayLogger(deep); logger.name != "" {
+		t.Errorf("expected the root logger, got %s", logger.name)
+	}
+	if _, exists := closestLogger("x"); exists {
+		t.Errorf("logger created beyond the limit")
+	}
+}
+
+func TestLevelBands(t *testing.T) {
+	var low, high bytes.Buffer
+
+	lowHandler, _ := NewStreamHandler(&low)
+	lowHandler.SetLevel(DEBUG)
+	lowHandler.SetMaxLevel(INFO)
+	highHandler, _ := NewStreamHandler(&high)
+	highHandler.SetLevel(WARNING)
+
+	BasicConfig(BasicConfigOpts{
+		Level:    TRACE,
+		Handlers: []Handler{lowHandler, highHandler},
+		Format:   "{level}",
+	})
+
+	log := GetLogger("test")
+	log.Log(TRACE, "trace")
+	log.Debug("debug")
+	log.Info("info")
+	log.Warning("warning")
+	log.Error("error")
+
+	Shutdown()
+
+	if out := low.String(); out != "DEBUG\nINFO\n" {
+		t.Errorf("unexpected low band output: %q", out)
+	}
+	if out := high.String(); out != "WARNING\nERROR\n" {
+		t.Errorf("unexpected high band output: %q", out)
+	}
+}
+
+func TestSplitHandler(t *testing.T) {
+	var low, high bytes.Buffer
+
+	handler, _ := NewSplitHandler(&low, &high, WARNING)
+
+	BasicConfig(BasicConfigOpts{
+		Level:    DEBUG,
+		Handlers: []Handler{handler},
+		Format:   "{level}",
+	})
+
+	log := GetLogger("test")
+	log.Debug("debug")
+	log.Info("info")
+	log.Warning("warning")
+	log.Error("error")
+
+	Shutdown()
+
+	if out := low.String(); out != "DEBUG\nINFO\n" {
+		t.Errorf("unexpected low output: %q", out)
+	}
+	if out := high.String(); out != "WARNING\nERROR\n" {
+		t.Errorf("unexpected high output: %q", out)
+	}
+}
+
+func TestSplitHandlerThreshold(t *testing.T) {
+	for _, threshold := range []Level{TRACE, INHERIT, ALL} {
+		if _, err := NewSplitHandler(ioutil.Discard, ioutil.Discard, threshold); err == nil {
+			t.Errorf("expected an error for threshold %s", LevelName(threshold))
+		}
+	}
+	handler, err := NewSplitHandler(ioutil.Discard, ioutil.Discard, DEBUG)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	defer handler.Shutdown()
+	if !handles(handler.low, TRACE) || handles(handler.low, DEBUG) || handles(handler.high, TRACE) {
+		t.Errorf("expected only TRACE to be handled by low")
+	}
+}
+
+func TestFileHandlerLock(t *testing.T) {
+	dir, err := ioutil.TempDir("", "log4go")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	filename := filepath.Join(dir, "locked.log")
+
+	formatter, _ := NewTemplateFormatter("{name} {message}")
+	payload := strings.Repeat("x", 8192)
+
+	// two handlers on the same file, as if in two processes
+	var handlers []*StreamHandler
+	for idx := 0; idx < 2; idx++ {
+		h, err := NewFileHandler(filename, true, FileOpts{Lock: true})
+		if err != nil {
+			t.Fatal(err)
+		}
+		h.SetFormatter(formatter)
+		handlers = append(handlers, h)
+	}
+	for n := 0; n < 100; n++ {
+		for idx, h := range handlers {
+			h.Handle(&Record{Name: fmt.Sprint(idx), Message: payload})
+		}
+	}
+	for _, h := range handlers {
+		h.Shutdown()
+	}
+
+	data, _ := ioutil.ReadFile(filename)
+	lines := strings.Split(strings.TrimSuffix(string(data), "\n"), "\n")
+	if len(lines) != 200 {
+		t.Fatalf("expected 200 lines, got %d", len(lines))
+	}
+	for _, line := range lines {
+		if len(line) != 2+len(payload) {
+			t.Fatalf("interleaved line (length %d)", len(line))
+		}
+	}
+}
+
+func TestFileReopen(t *testing.T) {
+	dir, err := ioutil.TempDir("", "log4go")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	filename := filepath.Join(dir, "reopen.log")
+
+	w, err := newFileWriter(filename, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, FileOpts{
+		Retry: RetryPolicy{InitialBackoff: 20 * time.Millisecond},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	w.Write([]byte("first\n"))
+
+	// simulate a failure
+	w.fp.Close()
+	if _, err := w.Write([]byte("failed\n")); err == nil {
+		t.Error("expected write error")
+	}
+	if _, err := w.Write([]byte("dropped\n")); err != ErrDropped {
+		t.Errorf("unexpected error while waiting to reopen: %v", err)
+	}
+	if health := w.health(); health.Healthy || health.WriteErrors != 1 || health.Dropped != 1 {
+		t.Errorf("unexpected health: %+v", health)
+	}
+
+	time.Sleep(30 * time.Millisecond)
+	w.Write([]byte("reopened\n"))
+
+	if health := w.health(); !health.Healthy || health.Reopens != 1 {
+		t.Errorf("unexpected health: %+v", health)
+	}
+	data, _ := ioutil.ReadFile(filename)
+	if string(data) != "first\nreopened\n" {
+		t.Errorf("unexpected file content: %q", data)
+	}
+}
+
+func TestRingBufferHandler(t *testing.T) {
+	h := NewRingBufferHandler(3)
+	formatter, _ := NewTemplateFormatter("{level} {message}")
+	h.SetFormatter(formatter)
+
+	for idx := 1; idx <= 5; idx++ {
+		h.Handle(&Record{Level: DEBUG, Message: fmt.Sprint("message ", idx)})
+	}
+
+	records := h.Records()
+	if len(records) != 3 || records[0].Message != "message 3" || records[2].Message != "message 5" {
+		t.Errorf("unexpected records: %+v", records)
+	}
+
+	resp := httptest.NewRecorder()
+	h.ServeHTTP(resp, httptest.NewRequest(http.MethodGet, "/debug/logs", nil))
+	if body := resp.Body.String(); body != "DEBUG message 3\nDEBUG message 4\nDEBUG message 5\n" {
+		t.Errorf("unexpected response: %q", body)
+	}
+}
+
+func TestCommitterExit(t *testing.T) {
+	before := runtime.NumGoroutine()
+
+	var buf bytes.Buffer
+	for idx := 0; idx < 10; idx++ {
+		BasicConfig(BasicConfigOpts{
+			Level:  INFO,
+			Writer: &buf,
+		})
+		GetLogger("test").Info("cycle %d", idx)
+		Shutdown()
+	}
+
+	// Shutdown returns when the records are written, i.e. without waiting
+	if !strings.HasSuffix(buf.String(), "cycle 9\n") {
+		t.Errorf("last record not written: %q", buf.String())
+	}
+
+	// give exiting goroutines a chance to be accounted for
+	for n := 0; n < 10 && runtime.NumGoroutine() > before; n++ {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if after := runtime.NumGoroutine(); after > before {
+		t.Errorf("leaked goroutines: %d before, %d after", before, after)
+	}
+}
+
+func TestSyncStreamHandler(t *testing.T) {
+	var buf bytes.Buffer
+
+	BasicConfig(BasicConfigOpts{
+		Level:  INFO,
+		Writer: &buf,
+		Format: "{message}",
+		Sync:   true,
+	})
+
+	GetLogger("test").Info("written at once")
+	if buf.String() != "written at once\n" {
+		t.Errorf("unexpected output: %q", buf.String())
+	}
+
+	Shutdown()
+	GetLogger("test").Info("after shutdown")
+	if buf.String() != "written at once\n" {
+		t.Errorf("unexpected output after shutdown: %q", buf.String())
+	}
+}
+
+func TestWatchedFileHeader(t *testing.T) {
+	dir, err := ioutil.TempDir("", "log4go")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	filename := filepath.Join(dir, "watched.log")
+
+	h, err := NewWatchedFileHandler(filename, true, FileOpts{Header: true, Sync: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	formatter, _ := NewTemplateFormatter("{message}")
+	h.SetFormatter(formatter)
+
+	h.Handle(&Record{Message: "before rotation"})
+	os.Rename(filename, filename+".1")
+	h.Handle(&Record{Message: "after rotation"})
+	h.Shutdown()
+
+	for name, message := range map[string]string{filename + ".1": "before rotation", filename: "after rotation"} {
+		data, _ := ioutil.ReadFile(name)
+		lines := strings.Split(strings.TrimSuffix(string(data), "\n"), "\n")
+		if len(lines) != 2 || !strings.HasPrefix(lines[0], "# opened=") || !strings.Contains(lines[0], fmt.Sprintf(" pid=%d ", os.Getpid())) || lines[1] != message {
+			t.Errorf("unexpected content of %s: %q", name, data)
+		}
+	}
+}
+
+func TestStreamHandlerFooter(t *testing.T) {
+	var buf bytes.Buffer
+
+	h, _ := NewStreamHandler(&buf, StreamOpts{Footer: true})
+	formatter, _ := NewTemplateFormatter("{message}")
+	h.SetFormatter(formatter)
+
+	h.Handle(&Record{Message: "one"})
+	h.Handle(&Record{Message: "two"})
+	h.Shutdown()
+	h.Shutdown()
+
+	lines := strings.Split(strings.TrimSuffix(buf.String(), "\n"), "\n")
+	if len(lines) != 3 || !strings.HasPrefix(lines[2], "# closed=") || !strings.HasSuffix(lines[2], " written=2 dropped=0") {
+		t.Errorf("unexpected output: %q", buf.String())
+	}
+}
+
+func TestRouterHandler(t *testing.T) {
+	security, db, alerts := &recordingHandler{}, &recordingHandler{}, &recordingHandler{}
+
+	BasicConfig(BasicConfigOpts{
+		Level:    TRACE,
+		Handlers: []Handler{NewMemoryHandler(1, FATAL+1, &recordingHandler{})}, // i.e. discard
+	})
+	err := InstallRoutes(
+		Route{MatchRegex: regexp.MustCompile(`^auth: `), Handler: security},
+		Route{LoggerGlob: "db/**", MaxLevel: DEBUG, Handler: db},
+		Route{MinLevel: WARNING, Handler: alerts},
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	GetLogger("web").Info("auth: login failed for bob")
+	GetLogger("db").Debug("connecting")
+	GetLogger("db/pool").GetLogger("conn").Debug("sent query")
+	GetLogger("dbx").Debug("not db")
+	GetLogger("db").Error("connection lost")
+
+	Shutdown()
+
+	if len(security.records) != 1 || security.Formatter() == nil {
+		t.Errorf("unexpected security records: %+v", security.records)
+	}
+	if len(db.records) != 2 || db.records[1].Name != "db/pool/conn" {
+		t.Errorf("unexpected db records: %+v", db.records)
+	}
+	if len(alerts.records) != 1 || alerts.records[0].Message != "connection lost" {
+		t.Errorf("unexpected alert records: %+v", alerts.records)
+	}
+}
+
+func TestHandlerFlushClose(t *testing.T) {
+	var buf bytes.Buffer
+
+	BasicConfig(BasicConfigOpts{
+		Level:  INFO,
+		Writer: &buf,
+		Format: "{message}",
+	})
+
+	log := GetLogger("test")
+	for n := 0; n < 100; n++ {
+		log.Info("record %d", n)
+	}
+	if err := Flush(); err != nil {
+		t.Fatal(err)
+	}
+	if lines := strings.Count(buf.String(), "\n"); lines != 100 {
+		t.Errorf("expected 100 lines after flush, got %d", lines)
+	}
+
+	h := GetLogger().ownHandlers()[0].(*StreamHandler)
+	if err := h.Close(); err != nil {
+		t.Errorf("unexpected close error: %v", err)
+	}
+	if err := h.Close(); err != ErrClosed {
+		t.Errorf("expected ErrClosed, got %v", err)
+	}
+	if err := h.Handle(&Record{Message: "after close"}); err != ErrClosed {
+		t.Errorf("expected ErrClosed, got %v", err)
+	}
+	if err := h.Flush(); err != ErrClosed {
+		t.Errorf("expected ErrClosed, got %v", err)
+	}
+
+	// handlers without Close are shut down
+	if err := CloseHandler(&recordingHandler{}); err != nil {
+		t.Errorf("unexpected close error: %v", err)
+	}
+	Shutdown()
+}
+
+func TestFieldMapHandler(t *testing.T) {
+	target := &recordingHandler{}
+	handler := NewFieldMapHandler(target, FieldMap{
+		Rename: map[string]string{"trace_id": "traceID"},
+		Drop:   []string{"payload"},
+		Func: func(key string, value interface{}) (string, interface{}, bool) {
+			return key, value, key != "secret"
+		},
+	})
+
+	fields := Fields{"trace_id": "abc", "payload": "...", "secret": "hunter2", "user": "bob"}
+	handler.Handle(&Record{Level: INFO, Fields: fields})
+
+	if len(target.records) != 1 || !reflect.DeepEqual(target.records[0].Fields, Fields{"traceID": "abc", "user": "bob"}) {
+		t.Errorf("unexpected records: %+v", target.records)
+	}
+	if len(fields) != 4 {
+		t.Errorf("the record's fields were modified: %v", fields)
+	}
+}
+
+func TestExtractHandler(t *testing.T) {
+	target := &recordingHandler{}
+	handler := NewExtractHandler(target,
+		Extraction{Regex: regexp.MustCompile(`status=(?P<status>\d+)`), Convert: true},
+		Extraction{Regex: regexp.MustCompile(` in (?P<elapsed>\S+)$`), Convert: true, Strip: true},
+		Extraction{Regex: regexp.MustCompile(`user (?P<user>\w+)`)},
+	)
+
+	handler.Handle(&Record{Level: INFO, Message: "GET / status=404 in 1.5ms", Fields: Fields{"user": "kept"}})
+	handler.Handle(&Record{Level: INFO, Message: "no match"})
+
+	if len(target.records) != 2 {
+		t.Fatalf("unexpected records: %+v", target.records)
+	}
+	expected := Fields{"status": int64(404), "elapsed": 1500 * time.Microsecond, "user": "kept"}
+	if rec := target.records[0]; rec.Message != "GET / status=404" || !reflect.DeepEqual(rec.Fields, expected) {
+		t.Errorf("unexpected record: %q %v", rec.Message, rec.Fields)
+	}
+	if rec := target.records[1]; rec.Message != "no match" || rec.Fields != nil {
+		t.Errorf("unexpected record: %q %v", rec.Message, rec.Fields)
+	}
+}
+
+func TestSpillQueue(t *testing.T) {
+	dir, err := ioutil.TempDir("", "log4go-spill")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	queue, err := NewSpillQueue(dir, SpillOpts{SegmentSize: 100, MaxAge: time.Hour})
+	if err != nil {
+		t.Fatalf("NewSpillQueue failed: %v", err)
+	}
+	queue.Push(&Record{Time: time.Now().Add(-2 * time.Hour), Message: "expired"})
+	for n := 0; n < 5; n++ {
+		queue.Push(&Record{Time: time.Now(), Message: fmt.Sprintf("record %d", n)})
+	}
+	queue.Close()
+
+	// simulate a crash while writing: a truncated record at the end
+	names, _ := filepath.Glob(filepath.Join(dir, "*.spill"))
+	if len(names) < 2 {
+		t.Fatalf("expected several segments, got %v", names)
+	}
+	f, _ := os.OpenFile(names[len(names)-1], os.O_WRONLY|os.O_APPEND, 0)
+	f.Write([]byte{0, 0, 1})
+	f.Close()
+
+	// records left by a previous instance are replayed
+	queue, err = NewSpillQueue(dir, SpillOpts{SegmentSize: 100, MaxAge: time.Hour})
+	if err != nil {
+		t.Fatalf("NewSpillQueue failed: %v", err)
+	}
+	defer queue.Close()
+
+	var replayed []string
+	failing := errors.New("connection lost")
+	count, err := queue.Replay(func(rec *Record) error {
+		if rec.Message == "record 2" {
+			return failing
+		}
+		replayed = append(replayed, rec.Message)
+		return nil
+	})
+	if count != 2 || err != failing {
+		t.Errorf("expected 2 records replayed, then the error, got %d, %v", count, err)
+	}
+	if _, err = queue.Replay(func(rec *Record) error {
+		replayed = append(replayed, rec.Message)
+		return nil
+	}); err != nil {
+		t.Errorf("Replay failed: %v", err)
+	}
+
+	expected := []string{"record 0", "record 1", "record 2", "record 3", "record 4"}
+	if !reflect.DeepEqual(replayed, expected) {
+		t.Errorf("expected %v, got %v", expected, replayed)
+	}
+	if size := queue.Size(); size != 0 {
+		t.Errorf("expected an empty queue, got size %d", size)
+	}
+	if names, _ = filepath.Glob(filepath.Join(dir, "*")); len(names) != 0 {
+		t.Errorf("expected no files left, got %v", names)
+	}
+}
+
+func TestSpillQueueFlush(t *testing.T) {
+	dir, err := ioutil.TempDir("", "log4go-spill")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	queue, err := NewSpillQueue(dir)
+	if err != nil {
+		t.Fatalf("NewSpillQueue failed: %v", err)
+	}
+	defer queue.Close()
+	for n := 0; n < 5; n++ {
+		queue.Push(&Record{Time: time.Now(), Message: fmt.Sprintf("record %d", n)})
+	}
+
+	// the records are only buffered by send, the connection dropping when flushing
+	var buffered, sent []string
+	failing := errors.New("connection lost")
+	send := func(rec *Record) error {
+		buffered = append(buffered, rec.Message)
+		return nil
+	}
+	count, err := queue.Replay(send, ReplayOpts{Flush: func() error { return failing }})
+	if count != 0 || err != failing {
+		t.Errorf("expected no records replayed, then the error, got %d, %v", count, err)
+	}
+
+	buffered = nil
+	flush := func() error {
+		sent = append(sent, buffered...)
+		buffered = nil
+		return nil
+	}
+	if count, err = queue.Replay(send, ReplayOpts{Flush: flush}); count != 5 || err != nil {
+		t.Errorf("expected 5 records replayed, got %d, %v", count, err)
+	}
+	expected := []string{"record 0", "record 1", "record 2", "record 3", "record 4"}
+	if !reflect.DeepEqual(sent, expected) {
+		t.Errorf("expected %v, got %v", expected, sent)
+	}
+	if size := queue.Size(); size != 0 {
+		t.Errorf("expected an empty queue, got size %d", size)
+	}
+}
+
+func TestRelaySpill(t *testing.T) {
+	recorder := &recordingHandler{}
+	formatter, _ := NewTemplateFormatter("{message}")
+	recorder.SetFormatter(formatter)
+
+	BasicConfig(BasicConfigOpts{
+		Level:    INFO,
+		Handlers: []Handler{recorder},
+	})
+
+	dir, err := ioutil.TempDir("", "log4go-spill")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	// queued during an outage
+	queue, err := NewSpillQueue(dir)
+	if err != nil {
+		t.Fatalf("NewSpillQueue failed: %v", err)
+	}
+	queue.Push(&Record{Time: time.Now(), Name: "remote/app", Level: WARNING, Message: "spilled"})
+
+	server, err := NewRelayServer("127.0.0.1:0")
+	if err != nil {
+		t.Skipf("cannot listen: %v", err)
+	}
+	go server.Serve()
+
+	relay, err := NewRelayHandler(server.Addr().String(), RelayOpts{Spill: queue})
+	if err != nil {
+		t.Fatalf("NewRelayHandler failed: %v", err)
+	}
+	relay.Handle(&Record{Time: time.Now(), Name: "remote/app", Level: WARNING, Message: "live"})
+	relay.Shutdown()
+
+	server.Close()
+	Shutdown()
+
+	recorder.lock.Lock()
+	defer recorder.lock.Unlock()
+
+	var messages []string
+	for _, rec := range recorder.records {
+		messages = append(messages, rec.Message)
+	}
+	if !reflect.DeepEqual(messages, []string{"spilled", "live"}) {
+		t.Errorf("unexpected records: %v", messages)
+	}
+}
+
+func TestRelayAcks(t *testing.T) {
+	recorder := &recordingHandler{}
+	formatter, _ := NewTemplateFormatter("{message}")
+	recorder.SetFormatter(formatter)
+
+	BasicConfig(BasicConfigOpts{
+		Level:    INFO,
+		Handlers: []Handler{recorder},
+	})
+
+	server, err := NewRelayServer("127.0.0.1:0")
+	if err != nil {
+		t.Skipf("cannot listen: %v", err)
+	}
+	go server.Serve()
+	defer server.Close()
+
+	var lock sync.Mutex
+	acked := 0
+	relay, err := NewRelayHandler(server.Addr().String(), RelayOpts{
+		Acks: true,
+		OnAck: func(count int) {
+			lock.Lock()
+			acked += count
+			lock.Unlock()
+		},
+	})
+	if err != nil {
+		t.Fatalf("NewRelayHandler failed: %v", err)
+	}
+	for n := 0; n < 3; n++ {
+		relay.Handle(&Record{Time: time.Now(), Name: "remote/app", Level: WARNING, Message: fmt.Sprintf("record %d", n)})
+	}
+	relay.Shutdown()
+
+	lock.Lock()
+	defer lock.Unlock()
+	if delivery := relay.Delivery(); delivery.Sent != 3 || delivery.Acked != 3 || delivery.Unacked != 0 || acked != 3 {
+		t.Errorf("unexpected delivery status: %+v (%d acked)", delivery, acked)
+	}
+
+	// a resent record (from the same session) is acknowledged, but not logged again
+	conn, err := net.Dial("tcp", server.Addr().String())
+	if err != nil {
+		t.Fatalf("Dial failed: %v", err)
+	}
+	defer conn.Close()
+	data, _ := (&Record{Time: time.Now(), Name: "remote/app", Level: WARNING, Message: "resent"}).MarshalBinary()
+	for _, seq := range []uint64{1, 1, 2} {
+		var header [20]byte
+		binary.BigEndian.PutUint32(header[:], uint32(len(data))|relayAckFlag)
+		binary.BigEndian.PutUint64(header[4:], 42)
+		binary.BigEndian.PutUint64(header[12:], seq)
+		conn.Write(append(header[:], data...))
+
+		var ack [8]byte
+		conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+		if _, err := io.ReadFull(conn, ack[:]); err != nil || binary.BigEndian.Uint64(ack[:]) != seq {
+			t.Fatalf("expected ack %d, got %d, %v", seq, binary.BigEndian.Uint64(ack[:]), err)
+		}
+	}
+	Shutdown()
+
+	recorder.lock.Lock()
+	defer recorder.lock.Unlock()
+
+	var messages []string
+	for _, rec := range recorder.records {
+		messages = append(messages, rec.Message)
+	}
+	expected := []string{"record 0", "record 1", "record 2", "resent", "resent"}
+	if !reflect.DeepEqual(messages, expected) {
+		t.Errorf("expected %v, got %v", expected, messages)
+	}
+}
+
+// slowHandler records records (and the highest number handled concurrently), taking a while for each.
+type slowHandler struct {
+	recordingHandler
+	active, maxActive int32
+}
+
+func (h *slowHandler) Handle(rec *Record) error {
+	active := atomic.AddInt32(&h.active, 1)
+	defer atomic.AddInt32(&h.active, -1)
+	for {
+		max := atomic.LoadInt32(&h.maxActive)
+		if active <= max || atomic.CompareAndSwapInt32(&h.maxActive, max, active) {
+			break
+		}
+	}
+	time.Sleep(5 * time.Millisecond)
+	return h.recordingHandler.Handle(rec)
+}
+
+func TestParallelHandler(t *testing.T) {
+	target := &slowHandler{}
+	handler := NewParallelHandler(target, ParallelOpts{Workers: 4})
+	for n := 0; n < 20; n++ {
+		handler.Handle(&Record{Level: INFO, Message: fmt.Sprint(n)})
+	}
+	if err := handler.Flush(); err != nil {
+		t.Errorf("Flush failed: %v", err)
+	}
+	if count := len(target.records); count != 20 {
+		t.Errorf("expected 20 records after Flush, got %d", count)
+	}
+	if max := atomic.LoadInt32(&target.maxActive); max < 2 {
+		t.Errorf("expected records to be handled in parallel, at most %d were", max)
+	}
+	if err := handler.Close(); err != nil {
+		t.Errorf("Close failed: %v", err)
+	}
+	if err := handler.Handle(&Record{Level: INFO}); err != ErrClosed {
+		t.Errorf("expected ErrClosed after Close, got %v", err)
+	}
+
+	// keyed by logger, the records of each logger stay in order
+	target = &slowHandler{}
+	handler = NewParallelHandler(target, ParallelOpts{Workers: 4, KeyByLogger: true})
+	for n := 0; n < 10; n++ {
+		for _, name := range []string{"a", "b", "c"} {
+			handler.Handle(&Record{Name: name, Level: INFO, Message: fmt.Sprint(n)})
+		}
+	}
+	handler.Shutdown()
+
+	next := map[string]int{}
+	for _, rec := range target.records {
+		if rec.Message != fmt.Sprint(next[rec.Name]) {
+			t.Errorf("logger %s: expected record %d, got %s", rec.Name, next[rec.Name], rec.Message)
+		}
+		next[rec.Name]++
+	}
+	if len(target.records) != 30 {
+		t.Errorf("expected 30 records, got %d", len(target.records))
+	}
+}
+
+type slowWriter struct {
+	delay time.Duration
+}
+
+func (w *slowWriter) Write(p []byte) (int, error) {
+	time.Sleep(w.delay)
+	return len(p), nil
+}
+
+func TestWriteStats(t *testing.T) {
+	handler, _ := NewStreamHandler(&slowWriter{delay: 2 * time.Millisecond}, StreamOpts{SlowWrite: time.Millisecond})
+	formatter, _ := NewTemplateFormatter("{message}")
+	handler.SetFormatter(formatter)
+
+	for idx := 0; idx < slowWriteWindow; idx++ {
+		handler.Handle(&Record{Level: INFO, Message: "slow"})
+	}
+	handler.Shutdown()
+
+	stats := handler.WriteStats()
+	if stats.Writes != slowWriteWindow || stats.P50 < 2*time.Millisecond || stats.P99 > stats.Max ||
+		stats.Total < slowWriteWindow*2*time.Millisecond {
+		t.Errorf("unexpected stats: %+v", stats)
+	}
+	if handler.writeStats.warned.IsZero() {
+		t.Error("slow writes not warned about")
+	}
+
+	fast, _ := NewStreamHandler(ioutil.Discard, StreamOpts{Sync: true, SlowWrite: time.Second})
+	fast.SetFormatter(formatter)
+	for idx := 0; idx < slowWriteWindow; idx++ {
+		fast.Handle(&Record{Level: INFO, Message: "fast"})
+	}
+	if stats := fast.WriteStats(); stats.Writes != slowWriteWindow || stats.P99 > time.Millisecond || !fast.writeStats.warned.IsZero() {
+		t.Errorf("unexpected stats: %+v", stats)
+	}
+}
+
+func TestProcessHandler(t *testing.T) {
+	plain := &recordingHandler{}
+	processed := &recordingHandler{}
+	processed.SetLevel(ERROR)
+	BasicConfig(BasicConfigOpts{
+		Level: INFO,
+		Handlers: []Handler{
+			NewProcessHandler(processed, func(rec *Record) bool {
+				if strings.Contains(rec.Message, "ignore") {
+					return false
+				}
+				if strings.Contains(rec.Message, "timeout") {
+					rec.Level = ERROR
+				}
+				rec.Fields["processed"] = true
+				rec.Tags = append(rec.Tags, "processed")
+				return true
+			}),
+			plain,
+		},
+	})
+	defer Reset()
+
+	log := GetLogger("test").With(Fields{"user": 42}).Tagged("api")
+	log.Warning("upstream timeout")
+	log.Info("ignore me")
+	log.Info("not escalated")
+	Shutdown()
+
+	if len(processed.records) != 1 || processed.records[0].Level != ERROR ||
+		!reflect.DeepEqual(processed.records[0].Fields, Fields{"user": 42, "processed": true}) ||
+		!reflect.DeepEqual(processed.records[0].Tags, []string{"api", "processed"}) {
+		t.Errorf("unexpected processed records: %+v", processed.records)
+	}
+	for _, rec := range plain.records {
+		if rec.Level == ERROR || len(rec.Fields) != 1 || len(rec.Tags) != 1 {
+			t.Errorf("original record modified: %+v", rec)
+		}
+	}
+	if len(plain.records) != 3 {
+		t.Errorf("unexpected records: %+v", plain.records)
+	}
+}
+
+type closingWriter struct {
+	bytes.Buffer
+	synced, closed bool
+}
+
+func (w *closingWriter) Sync() error  { w.synced = true; return nil }
+func (w *closingWriter) Close() error { w.closed = true; return nil }
+
+func TestOwnedWriterClosed(t *testing.T) {
+	owned := &closingWriter{}
+	handler, _ := NewStreamHandler(owned, StreamOpts{OwnsWriter: true})
+	notOwned := &closingWriter{}
+	other, _ := NewStreamHandler(notOwned, StreamOpts{Sync: true})
+	handler.Shutdown()
+	other.Shutdown()
+	if !owned.synced || !owned.closed || notOwned.synced || notOwned.closed {
+		t.Errorf("unexpected sync/close: owned %+v, not owned %+v", owned, notOwned)
+	}
+
+	dir, _ := ioutil.TempDir("", "log4go")
+	defer os.RemoveAll(dir)
+	fileHandler, err := NewFileHandler(filepath.Join(dir, "test.log"), false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	writer := fileHandler.writer.(*fileWriter)
+	fileHandler.Shutdown()
+	if writer.fp != nil {
+		t.Error("file not closed")
+	}
+}
+
+func TestFileHandlerErrors(t *testing.T) {
+	dir, _ := ioutil.TempDir("", "log4go")
+	defer os.RemoveAll(dir)
+
+	filename := filepath.Join(dir, "missing", "app.log")
+	_, err := NewFileHandler(filename, true)
+	var pathErr *os.PathError
+	if err == nil || !errors.As(err, &pathErr) || !strings.Contains(err.Error(), "directory "+filepath.Dir(filename)+" doesn't exist") {
+		t.Errorf("unexpected error: %v", err)
+	}
+
+	err = BasicConfig(BasicConfigOpts{FileName: filename, TestWrite: true})
+	defer Reset()
+	if err == nil || !strings.Contains(err.Error(), "cannot open log file") {
+		t.Errorf("unexpected BasicConfig error: %v", err)
+	}
+
+	handler, err := NewFileHandler(filepath.Join(dir, "app.log"), true, FileOpts{TestWrite: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	handler.Shutdown()
+	if info, err := os.Stat(filepath.Join(dir, "app.log")); err != nil || info.Size() != 0 {
+		t.Errorf("test write left data: %v, %v", info, err)
+	}
+
+	if _, err := NewRelayHandler("127.0.0.1:1"); err == nil || !strings.Contains(err.Error(), "cannot connect to relay server 127.0.0.1:1") {
+		t.Errorf("unexpected relay error: %v", err)
+	}
+}
+
+func TestRelayCodec(t *testing.T) {
+	recorder := &recordingHandler{}
+	BasicConfig(BasicConfigOpts{
+		Level:    INFO,
+		Handlers: []Handler{recorder},
+	})
+	defer Reset()
+
+	server, err := NewRelayServer("127.0.0.1:0")
+	if err != nil {
+		t.Skipf("cannot listen: %v", err)
+	}
+	go server.Serve()
+	defer server.Close()
+
+	relay, err := NewRelayHandler(server.Addr().String(), RelayOpts{Acks: true, Codec: GzipCodec{}})
+	if err != nil {
+		t.Fatalf("NewRelayHandler failed: %v", err)
+	}
+	for n := 0; n < 5000; n++ {
+		relay.Handle(&Record{Time: time.Now(), Name: "remote/app", Level: WARNING, Message: fmt.Sprintf("record %d", n)})
+	}
+	relay.Shutdown()
+	Shutdown()
+
+	if delivery := relay.Delivery(); delivery.Acked != 5000 || delivery.Unacked != 0 {
+		t.Errorf("unexpected delivery status: %+v", delivery)
+	}
+	recorder.lock.Lock()
+	defer recorder.lock.Unlock()
+	if len(recorder.records) != 5000 {
+		t.Fatalf("expected 5000 records, got %d", len(recorder.records))
+	}
+	for n, rec := range recorder.records {
+		if rec.Message != fmt.Sprintf("record %d", n) {
+			t.Fatalf("unexpected record %d: %+v", n, rec)
+		}
+	}
+}
+
+func TestDecodeLimited(t *testing.T) {
+	bomb, _ := GzipCodec{Level: 9}.Encode(make([]byte, 1<<20)) // compressing to about 1 kB
+	if _, err := decodeLimited(GzipCodec{}, bomb, 64<<10); err == nil {
+		t.Errorf("expected an error decoding beyond the limit")
+	}
+	if decoded, err := decodeLimited(GzipCodec{}, bomb, 1<<20); err != nil || len(decoded) != 1<<20 {
+		t.Errorf("expected %d bytes decoded, got %d, %v", 1<<20, len(decoded), err)
+	}
+}
+
+func TestWebhookCodec(t *testing.T) {
+	received := make(chan string, 1)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		decoded, err := GzipCodec{}.Decode(body)
+		if r.Header.Get("Content-Encoding") != "gzip" || err != nil {
+			t.Errorf("unexpected encoding %q: %v", r.Header.Get("Content-Encoding"), err)
+		}
+		received <- string(decoded)
+	}))
+	defer server.Close()
+
+	handler, _ := NewWebhookHandler(WebhookOpts{URL: server.URL, Codec: GzipCodec{Level: 9}})
+	formatter, _ := NewTemplateFormatter("{message}")
+	handler.SetFormatter(formatter)
+	handler.Handle(&Record{Level: ERROR, Message: "compressed"})
+	handler.Shutdown()
+
+	if body := <-received; !strings.Contains(body, "compressed") {
+		t.Errorf("unexpected payload: %s", body)
+	}
+}
+
+func TestStreamHandlerStatus(t *testing.T) {
+	buf := &bytes.Buffer{}
+	progress := 0
+	handler, _ := NewStreamHandler(buf, StreamOpts{Sync: true, Status: func() string { return fmt.Sprintf("[%d/2]", progress) }})
+	formatter, _ := NewTemplateFormatter("{message}")
+	handler.SetFormatter(formatter)
+
+	handler.Handle(&Record{Message: "starting"})
+	progress++
+	handler.RefreshStatus()
+	handler.Handle(&Record{Message: "halfway"})
+	progress++
+	handler.RefreshStatus()
+	handler.Shutdown()
+	handler.RefreshStatus() // no-op once shut down
+
+	expected := "\r\x1b[Kstarting\n[0/2]\r\x1b[K[1/2]\r\x1b[Khalfway\n[1/2]\r\x1b[K[2/2]\r\x1b[K"
+	if buf.String() != expected {
+		t.Errorf("unexpected output: %q", buf.String())
+	}
+}
+
+// flakyHandler records the records passed to it, failing while err is set.
+type flakyHandler struct {
+	recordingHandler
+	err error
+}
+
+func (h *flakyHandler) Handle(rec *Record) error {
+	h.lock.Lock()
+	err := h.err
+	h.lock.Unlock()
+	if err != nil {
+		return err
+	}
+	return h.recordingHandler.Handle(rec)
+}
+
+func (h *flakyHandler) setErr(err error) {
+	h.lock.Lock()
+	h.err = err
+	h.lock.Unlock()
+}
+
+func TestFailoverHandler(t *testing.T) {
+	primary, fallback := &flakyHandler{}, &flakyHandler{}
+	handler := NewFailoverHandler(primary, fallback)
+	handler.SetRetryInterval(50 * time.Millisecond)
+
+	handler.Handle(&Record{Message: "1"})
+	primary.setErr(errors.New("connection refused"))
+	handler.Handle(&Record{Message: "2"})
+	handler.Handle(&Record{Message: "3"})
+	if handler.Active() != fallback {
+		t.Errorf("expected the fallback to be active")
+	}
+
+	// failing back once the primary works again, and the retry interval has passed
+	primary.setErr(nil)
+	handler.Handle(&Record{Message: "4"})
+	time.Sleep(60 * time.Millisecond)
+	handler.Handle(&Record{Message: "5"})
+	if handler.Active() != primary {
+		t.Errorf("expected the primary to be active")
+	}
+
+	// all failing: the last handler's error is returned
+	primary.setErr(errors.New("connection refused"))
+	fallback.setErr(ErrDropped)
+	if err := handler.Handle(&Record{Message: "6"}); err != ErrDropped {
+		t.Errorf("unexpected error: %v", err)
+	}
+
+	messages := func(h *flakyHandler) (s string) {
+		for _, rec := range h.records {
+			s += rec.Message
+		}
+		return s
+	}
+	if messages(primary) != "15" || messages(fallback) != "234" {
+		t.Errorf("unexpected records: %q, %q", messages(primary), messages(fallback))
+	}
+
+	// records below the primary's level go to the fallback, without failing over
+	primary, fallback = &flakyHandler{}, &flakyHandler{}
+	primary.SetLevel(ERROR)
+	handler = NewFailoverHandler(primary, fallback)
+	handler.Handle(&Record{Level: INFO, Message: "1"})
+	handler.Handle(&Record{Level: ERROR, Message: "2"})
+	if messages(primary) != "2" || messages(fallback) != "1" || handler.Active() != primary {
+		t.Errorf("unexpected records: %q, %q", messages(primary), messages(fallback))
+	}
+
+	// unhealthy handlers are failed over from too, e.g. a relay without its server
+	dir, err := ioutil.TempDir("", "log4go")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	spill, err := NewSpillQueue(filepath.Join(dir, "spill"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	relay, err := NewRelayHandler("127.0.0.1:1", RelayOpts{Spill: spill})
+	if err != nil {
+		t.Fatal(err)
+	}
+	local := &flakyHandler{}
+	handler = NewFailoverHandler(relay, local)
+	handler.Handle(&Record{Message: "7"})
+	handler.Shutdown()
+	if messages(local) != "7" || relay.Health().Healthy {
+		t.Errorf("expected the record to be failed over: %q %+v", messages(local), relay.Health())
+	}
+}
+
+func TestShardedFileHandler(t *testing.T) {
+	dir, err := ioutil.TempDir("", "log4go")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	handler, err := NewShardedFileHandler(filepath.Join(dir, "app.log"), false, ShardOpts{Shards: 3})
+	if err != nil {
+		t.Fatal(err)
+	}
+	formatter, _ := NewTemplateFormatter("{name} {message}{stack}")
+	handler.SetFormatter(formatter)
+
+	var expected strings.Builder
+	for n := 0; n < 100; n++ {
+		rec := &Record{Name: fmt.Sprintf("worker%d", n%7), Message: fmt.Sprintf("record %d", n)}
+		if n%10 == 0 {
+			rec.Stack = "main.go:12\nmain.go:34"
+		}
+		handler.Handle(rec)
+		out, _ := formatter.Format(rec)
+		expected.Write(out)
+		expected.WriteByte('\n')
+	}
+	handler.Shutdown()
+
+	files := handler.Files()
+	if len(files) != 3 || filepath.Base(files[1]) != "app.1.log" {
+		t.Fatalf("unexpected files: %v", files)
+	}
+	for _, file := range files {
+		if data, _ := ioutil.ReadFile(file); strings.Count(string(data), "record") < 33 {
+			t.Errorf("%s: expected a third of the records:\n%s", file, data)
+		}
+	}
+
+	var merged bytes.Buffer
+	if err := MergeShards(&merged, files...); err != nil {
+		t.Fatal(err)
+	}
+	if merged.String() != expected.String() {
+		t.Errorf("unexpected merged output:\n%s", merged.String())
+	}
+
+	// by logger: each logger's records in the same file
+	handler, err = NewShardedFileHandler(filepath.Join(dir, "app.log"), false, ShardOpts{Shards: 3, ByLogger: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	handler.SetFormatter(formatter)
+	for n := 0; n < 30; n++ {
+		handler.Handle(&Record{Name: fmt.Sprintf("worker%d", n%3), Message: "x"})
+	}
+	handler.Shutdown()
+	for _, file := range handler.Files() {
+		data, _ := ioutil.ReadFile(file)
+		for n := 0; n < 3; n++ {
+			if count := strings.Count(string(data), fmt.Sprintf("worker%d ", n)); count != 0 && count != 10 {
+				t.Errorf("%s: records of worker%d split: %d", file, n, count)
+			}
+		}
+	}
+
+	// without a formatter set: the default one, still numbered
+	handler, err = NewShardedFileHandler(filepath.Join(dir, "app.log"), false, ShardOpts{Shards: 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for n := 0; n < 10; n++ {
+		handler.Handle(&Record{Name: "worker", Message: fmt.Sprintf("record %d", n)})
+	}
+	handler.Shutdown()
+	merged.Reset()
+	if err := MergeShards(&merged, handler.Files()...); err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Split(strings.TrimSpace(merged.String()), "\n")
+	for n, line := range lines {
+		if !strings.HasSuffix(line, fmt.Sprintf(" record %d", n)) || !strings.HasPrefix(line, "0001-01-01") {
+			t.Errorf("unexpected merged line %d: %q", n, line)
+		}
+	}
+	if len(lines) != 10 {
+		t.Errorf("unexpected merged output:\n%s", merged.String())
+	}
+}
+
+func TestFileNameTemplate(t *testing.T) {
+	dir, err := ioutil.TempDir("", "log4go")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	now := time.Date(2020, 5, 17, 12, 34, 56, 0, time.UTC)
+	name, err := expandFileName("app-{hostname}-{pid}-{date}-{time}.log", now)
+	host, _ := os.Hostname()
+	if expected := fmt.Sprintf("app-%s-%d-2020-05-17-123456.log", host, os.Getpid()); err != nil || name != expected {
+		t.Errorf("unexpected name: %s (%v)", name, err)
+	}
+	if _, err := NewFileHandler(filepath.Join(dir, "app-{host}.log"), true); err == nil || !strings.Contains(err.Error(), "{host}") {
+		t.Errorf("expected unknown variable error: %v", err)
+	}
+
+	handler, err := NewFileHandler(filepath.Join(dir, "{program}-{pid}.log"), true, FileOpts{Sync: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	formatter, _ := NewTemplateFormatter("{message}")
+	handler.SetFormatter(formatter)
+	handler.Handle(&Record{Message: "hello"})
+	handler.Shutdown()
+
+	filename := filepath.Join(dir, fmt.Sprintf("%s-%d.log", filepath.Base(os.Args[0]), os.Getpid()))
+	if data, err := ioutil.ReadFile(filename); err != nil || string(data) != "hello\n" {
+		t.Errorf("unexpected file contents: %q (%v)", data, err)
+	}
+}
+
+type failingWriter struct{}
+
+func (failingWriter) Write(p []byte) (int, error) {
+	return 0, errors.New("disk full")
+}
+
+func TestInternalHandler(t *testing.T) {
+	recorder := &recordingHandler{}
+	SetInternalHandler(recorder)
+	defer SetInternalHandler(nil)
+
+	handler, _ := NewStreamHandler(failingWriter{}, StreamOpts{Sync: true})
+	formatter, _ := NewTemplateFormatter("{message}")
+	handler.SetFormatter(formatter)
+	handler.Handle(&Record{Level: ERROR, Message: "lost"})
+
+	SetInternalHandler(nil) // i.e. wait for the report to be passed on
+	if len(recorder.records) != 1 {
+		t.Fatalf("expected 1 report, got %d", len(recorder.records))
+	}
+	rec := recorder.records[0]
+	if rec.Name != "log4go/StreamHandler" || rec.Level != ERROR || rec.Message != "write error: disk full" {
+		t.Errorf("unexpected report: %s %s %q", rec.Name, LevelName(rec.Level), rec.Message)
+	}
+}
+
+func TestStreamHandlerClone(t *testing.T) {
+	dir, err := ioutil.TempDir("", "clone")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	filename := filepath.Join(dir, "workers.log")
+
+	handler, err := NewFileHandler(filename, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	formatter, _ := NewTemplateFormatter("{name} {message}")
+	handler.SetFormatter(formatter)
+	handlers := []*StreamHandler{handler}
+	for idx := 0; idx < 3; idx++ {
+		clone, err := handler.Clone()
+		if err != nil {
+			t.Fatal(err)
+		}
+		handlers = append(handlers, clone)
+	}
+
+	var wg sync.WaitGroup
+	for idx, h := range handlers {
+		wg.Add(1)
+		go func(idx int, h *StreamHandler) {
+			defer wg.Done()
+			for n := 0; n < 500; n++ {
+				h.Handle(&Record{Name: fmt.Sprintf("worker%d", idx), Level: INFO, Message: strings.Repeat("x", 100)})
+			}
+		}(idx, h)
+	}
+	wg.Wait()
+
+	// the file is closed when the last handler is shut down
+	handler.Shutdown()
+	for _, clone := range handlers[1:] {
+		if err := clone.Handle(&Record{Name: "late", Level: INFO, Message: "still open"}); err != nil {
+			t.Errorf("unexpected error: %v", err)
+		}
+		clone.Shutdown()
+	}
+	if _, err := handler.Clone(); err != ErrClosed {
+		t.Errorf("expected ErrClosed cloning a closed handler, got %v", err)
+	}
+
+	data, _ := ioutil.ReadFile(filename)
+	lines := strings.Split(strings.TrimSuffix(string(data), "\n"), "\n")
+	if len(lines) != 4*500+3 {
+		t.Fatalf("expected %d lines, got %d", 4*500+3, len(lines))
+	}
+	for _, line := range lines {
+		if !strings.HasSuffix(line, strings.Repeat("x", 100)) && line != "late still open" {
+			t.Errorf("interleaved line: %q", line)
+			break
+		}
+	}
+}
+
+// countingWriter counts the writes.
+type countingWriter struct {
+	writes int64 // accessed atomically
+}
+
+func (w *countingWriter) Write(p []byte) (int, error) {
+	atomic.AddInt64(&w.writes, 1)
+	return len(p), nil
+}
+
+func TestStreamHandlerWaitStrategies(t *testing.T) {
+	for name, wait := range map[string]WaitStrategy{"block": BlockingWait, "yield": YieldingWait, "sleep": SleepingWait} {
+		t.Run(name, func(t *testing.T) {
+			w := &countingWriter{}
+			// a small queue, so it's full most of the time, and wraps around
+			handler, _ := NewStreamHandler(w, StreamOpts{QueueSize: 3, Wait: wait})
+			formatter, _ := NewTemplateFormatter("{message}")
+			handler.SetFormatter(formatter)
+			if size := len(handler.queue.slots); size != 4 {
+				t.Errorf("expected the queue size rounded up to 4, got %d", size)
+			}
+
+			var wg sync.WaitGroup
+			for g := 0; g < 8; g++ {
+				wg.Add(1)
+				go func() {
+					defer wg.Done()
+					for idx := 0; idx < 300; idx++ {
+						handler.Handle(&Record{Level: INFO, Message: "record"})
+					}
+				}()
+			}
+			wg.Wait()
+			if err := handler.Flush(); err != nil {
+				t.Errorf("unexpected error: %v", err)
+			}
+			if writes := atomic.LoadInt64(&w.writes); writes != 8*300 {
+				t.Errorf("expected %d records written when flushed, got %d", 8*300, writes)
+			}
+			if pending := handler.Pending(); pending != 0 {
+				t.Errorf("expected no records pending, got %d", pending)
+			}
+
+			// the committer idles meanwhile
+			time.Sleep(5 * time.Millisecond)
+			handler.Handle(&Record{Level: INFO, Message: "last"})
+			handler.Shutdown()
+			if writes := atomic.LoadInt64(&w.writes); writes != 8*300+1 {
+				t.Errorf("expected %d records written when shut down, got %d", 8*300+1, writes)
+			}
+			if err := handler.Handle(&Record{Level: INFO, Message: "closed"}); err != ErrClosed {
+				t.Errorf("expected ErrClosed, got %v", err)
+			}
+			if err := handler.Flush(); err != ErrClosed {
+				t.Errorf("expected ErrClosed flushing, got %v", err)
+			}
+		})
+	}
+}
+
+// benchmarkQueue measures pushing records concurrently (see b.SetParallelism), drained by a single consumer
+// until stopped.
+func benchmarkQueue(b *testing.B, push func(rec *Record), drain func(), stop func()) {
+	drained := make(chan struct{})
+	go func() {
+		drain()
+		close(drained)
+	}()
+
+	rec := &Record{Name: "bench", Level: INFO, Message: "record"}
+	b.SetParallelism(8)
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		for pb.Next() {
+			push(rec)
+		}
+	})
+	b.StopTimer()
+	stop()
+	<-drained
+}
+
+// BenchmarkStreamQueue compares the queue of StreamHandler with a buffered channel (used before),
+// at high concurrency.
+func BenchmarkStreamQueue(b *testing.B) {
+	b.Run("channel", func(b *testing.B) {
+		records := make(chan Record, defaultQueueSize)
+		benchmarkQueue(b, func(rec *Record) { records <- *rec }, func() {
+			for range records {
+			}
+		}, func() { close(records) })
+	})
+	for name, wait := range map[string]WaitStrategy{"block": BlockingWait, "yield": YieldingWait, "sleep": SleepingWait} {
+		b.Run(name, func(b *testing.B) {
+			q := newRecordQueue(defaultQueueSize, wait)
+			benchmarkQueue(b, q.push, func() {
+				var rec Record
+				for !q.isClosed() || !q.empty() {
+					if !q.pop(&rec) {
+						q.idle()
+					}
+				}
+			}, q.close)
+		})
+	}
+}
@@ -0,0 +1,232 @@
+// Package handlertest provides a conformance suite for log4go.Handler implementations,
+// so third-party handlers (and the built-in ones) share the same correctness bar:
+//
+//	func TestHandler(t *testing.T) {
+//		handlertest.Run(t, func() log4go.Handler { return NewMyHandler() })
+//	}
+//
+// The suite observes the records handled by the formatter it sets: the handler must format
+// each record it handles (once), at the latest when shut down; or, for handlers not formatting
+// the records themselves (e.g. log4go.RelayHandler), at the receiving end (see Opts.Delivered).
+package handlertest
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/neonrust/log4go"
+)
+
+// Opts is used to supply options to Run.
+type Opts struct {
+	// Delivered, if set, returns the messages of the records delivered by the handler created last, once it's
+	// shut down; for handlers not formatting the records themselves, e.g. observed by a test server.
+	Delivered func() []string
+}
+
+// Run verifies that handlers returned by newHandler (a new one for each test):
+//   - format (or deliver) every record handled (once), by the time Shutdown returns, i.e. Shutdown drains any queue
+//   - may be used concurrently
+//   - keep the level and formatter set, and only get the records of (at least) their level from loggers
+//   - keep handling records after the formatter fails (for other ones)
+//   - may be shut down more than once, and don't panic when handling records after being shut down
+//
+// Some tests log through the logger named "handlertest", with the root logger's handlers detached meanwhile
+// (restored afterwards), so the suite shouldn't run in parallel with other tests logging.
+// log4go's reports of the failing formatter (see log4go.SetInternalHandler) are discarded.
+func Run(t *testing.T, newHandler func() log4go.Handler, opts ...Opts) {
+	t.Helper()
+	var opt Opts
+	if len(opts) > 0 {
+		opt = opts[0]
+	}
+	setup := func() (log4go.Handler, *recordingFormatter) {
+		formatter := &recordingFormatter{formatted: map[string]int{}, delivered: opt.Delivered}
+		handler := newHandler()
+		handler.SetFormatter(formatter)
+		return handler, formatter
+	}
+
+	t.Run("Drain", func(t *testing.T) {
+		handler, formatter := setup()
+		for idx := 0; idx < 1000; idx++ {
+			handle(t, handler, fmt.Sprintf("record %d", idx))
+		}
+		handler.Shutdown()
+		formatter.expect(t, 1000)
+	})
+	t.Run("Concurrent", func(t *testing.T) {
+		handler, formatter := setup()
+		var wg sync.WaitGroup
+		for g := 0; g < 8; g++ {
+			wg.Add(1)
+			go func(g int) {
+				defer wg.Done()
+				for idx := 0; idx < 200; idx++ {
+					handle(t, handler, fmt.Sprintf("goroutine %d record %d", g, idx))
+				}
+			}(g)
+		}
+		wg.Wait()
+		handler.Shutdown()
+		formatter.expect(t, 8*200)
+	})
+	t.Run("Formatter", func(t *testing.T) {
+		handler, formatter := setup()
+		defer handler.Shutdown()
+		if handler.Formatter() != log4go.Formatter(formatter) {
+			t.Errorf("Formatter doesn't return the formatter set")
+		}
+	})
+	t.Run("Level", func(t *testing.T) {
+		handler, formatter := setup()
+		handler.SetLevel(log4go.WARNING)
+		if handler.Level() != log4go.WARNING {
+			t.Errorf("expected level WARNING, got %s", log4go.LevelName(handler.Level()))
+		}
+
+		log, restore := isolate(handler)
+		log.Debug("filtered debug")
+		log.Info("filtered info")
+		log.Warning("passed warning")
+		log.Error("passed error")
+		restore()
+		handler.Shutdown()
+		formatter.expect(t, 2)
+		if formatter.count("filtered debug")+formatter.count("filtered info") > 0 {
+			t.Errorf("records below the handler's level were handled")
+		}
+	})
+	t.Run("FormatterError", func(t *testing.T) {
+		log4go.SetInternalHandler(discardHandler{})
+		defer log4go.SetInternalHandler(nil)
+
+		handler, formatter := setup()
+		formatter.fail = "fail"
+		for idx := 0; idx < 10; idx++ {
+			handler.Handle(record("fail")) // may return the error
+			handle(t, handler, fmt.Sprintf("record %d", idx))
+		}
+		handler.Shutdown()
+		formatter.expect(t, 10)
+	})
+	t.Run("Shutdown", func(t *testing.T) {
+		handler, _ := setup()
+		handle(t, handler, "record")
+		handler.Shutdown()
+		handler.Shutdown()
+
+		defer func() {
+			if r := recover(); r != nil {
+				t.Errorf("Handle panicked after Shutdown: %v", r)
+			}
+		}()
+		handler.Handle(record("after shutdown")) // may return an error, e.g. log4go.ErrClosed
+	})
+}
+
+// isolate returns the logger named "handlertest", passing its records (of any level) on to the handler only,
+// i.e. with the root logger's handlers detached; until the returned function is called.
+func isolate(handler log4go.Handler) (*log4go.Logger, func()) {
+	root := log4go.GetLogger()
+	detached := root.Handlers()
+	root.RemoveHandlers()
+
+	log := log4go.GetLogger("handlertest")
+	log.SetLevel(log4go.DEBUG)
+	log.AddHandler(handler)
+	return log, func() {
+		log.RemoveHandlers()
+		log.SetLevel(log4go.INHERIT)
+		for _, h := range detached {
+			root.AddHandler(h)
+		}
+	}
+}
+
+func record(message string) *log4go.Record {
+	return &log4go.Record{Name: "handlertest", Level: log4go.ERROR, Message: message}
+}
+
+// handle passes a record to the handler, failing the test on errors.
+func handle(t *testing.T, handler log4go.Handler, message string) {
+	if err := handler.Handle(record(message)); err != nil {
+		t.Errorf("Handle: %v", err)
+	}
+}
+
+var errFormat = errors.New("handlertest: formatter failure")
+
+// recordingFormatter counts the records formatted, by message, failing those with the message fail.
+// With delivered set, the records delivered are counted instead (see Opts.Delivered).
+type recordingFormatter struct {
+	fail      string
+	delivered func() []string
+
+	lock      sync.Mutex
+	formatted map[string]int
+}
+
+func (f *recordingFormatter) Format(rec *log4go.Record) ([]byte, error) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+
+	f.formatted[rec.Message]++
+	if len(f.fail) > 0 && rec.Message == f.fail {
+		return nil, errFormat
+	}
+	return []byte(rec.Message + "\n"), nil
+}
+
+func (f *recordingFormatter) count(message string) int {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+
+	return f.formatted[message]
+}
+
+// expect fails the test unless the number of records were formatted (not counting failed ones), each once.
+func (f *recordingFormatter) expect(t *testing.T, records int) {
+	t.Helper()
+
+	f.lock.Lock()
+	defer f.lock.Unlock()
+
+	if f.delivered != nil {
+		f.formatted = map[string]int{}
+		for _, message := range f.delivered() {
+			f.formatted[message]++
+		}
+	}
+
+	total := 0
+	var repeated []string
+	for message, count := range f.formatted {
+		if len(f.fail) > 0 && message == f.fail {
+			continue
+		}
+		total += count
+		if count > 1 {
+			repeated = append(repeated, message)
+		}
+	}
+	if total != records {
+		t.Errorf("expected %d records formatted, got %d", records, total)
+	}
+	if len(repeated) > 0 {
+		t.Errorf("records formatted more than once: %s", strings.Join(repeated, ", "))
+	}
+}
+
+// discardHandler discards the records, e.g. log4go's reports of the failing formatter.
+type discardHandler struct{}
+
+func (discardHandler) Handle(rec *log4go.Record) error         { return nil }
+func (discardHandler) SetFormatter(formatter log4go.Formatter) {}
+func (discardHandler) Formatter() log4go.Formatter             { return nil }
+func (discardHandler) SetLevel(level log4go.Level)             {}
+func (discardHandler) Level() log4go.Level                     { return log4go.INHERIT }
+func (discardHandler) Shutdown()                               {}
@@ -0,0 +1,264 @@
+package handlertest
+
+import (
+	"bufio"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/neonrust/log4go"
+)
+
+func TestHandlers(t *testing.T) {
+	dir, err := ioutil.TempDir("", "handlertest")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	files := 0
+	filename := func() string {
+		files++
+		return filepath.Join(dir, fmt.Sprintf("test%d.log", files))
+	}
+	stream := func(opts ...log4go.StreamOpts) func() log4go.Handler {
+		return func() log4go.Handler {
+			handler, _ := log4go.NewStreamHandler(ioutil.Discard, opts...)
+			return handler
+		}
+	}
+
+	handlers := map[string]func() log4go.Handler{
+		"Stream":     stream(),
+		"SyncStream": stream(log4go.StreamOpts{Sync: true}),
+		"File": func() log4go.Handler {
+			handler, err := log4go.NewFileHandler(filename(), false)
+			if err != nil {
+				t.Fatal(err)
+			}
+			return handler
+		},
+		"ShardedFile": func() log4go.Handler {
+			handler, err := log4go.NewShardedFileHandler(filename(), false)
+			if err != nil {
+				t.Fatal(err)
+			}
+			return handler
+		},
+		"Clone": func() log4go.Handler {
+			handler, _ := log4go.NewStreamHandler(ioutil.Discard)
+			clone, _ := handler.Clone()
+			handler.Shutdown()
+			return clone
+		},
+		"Parallel": func() log4go.Handler {
+			return log4go.NewParallelHandler(stream(log4go.StreamOpts{Sync: true})())
+		},
+		"Failover": func() log4go.Handler {
+			return log4go.NewFailoverHandler(stream()(), stream()())
+		},
+	}
+	for name, newHandler := range handlers {
+		t.Run(name, func(t *testing.T) {
+			Run(t, newHandler)
+		})
+	}
+
+	// the notifying handlers, posting to test servers
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		ioutil.ReadAll(req.Body)
+	}))
+	defer server.Close()
+	mail := smtpServer(t)
+	defer mail.Close()
+
+	notifying := map[string]func() log4go.Handler{
+		"Webhook": func() log4go.Handler {
+			handler, _ := log4go.NewWebhookHandler(log4go.WebhookOpts{URL: server.URL})
+			return handler
+		},
+		"SMTP": func() log4go.Handler {
+			handler, _ := log4go.NewSMTPHandler(log4go.SMTPOpts{Addr: mail.Addr().String(), From: "app@example.com", To: []string{"ops@example.com"}})
+			return handler
+		},
+	}
+	for name, newHandler := range notifying {
+		t.Run(name, func(t *testing.T) {
+			Run(t, newHandler)
+		})
+	}
+
+	// the Sentry and relay handlers don't format the records, they're observed at the receiving end
+	var lock sync.Mutex
+	var events []string
+	sentry := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		var event struct{ Message string }
+		if err := json.NewDecoder(req.Body).Decode(&event); err != nil {
+			t.Errorf("invalid event: %v", err)
+		}
+		lock.Lock()
+		events = append(events, event.Message)
+		lock.Unlock()
+	}))
+	defer sentry.Close()
+	t.Run("Sentry", func(t *testing.T) {
+		Run(t, func() log4go.Handler {
+			lock.Lock()
+			events = nil
+			lock.Unlock()
+			handler, err := log4go.NewSentryHandler(log4go.SentryOpts{DSN: strings.Replace(sentry.URL, "://", "://key@", 1) + "/1"})
+			if err != nil {
+				t.Fatal(err)
+			}
+			return handler
+		}, Opts{Delivered: func() []string {
+			lock.Lock()
+			defer lock.Unlock()
+			return events
+		}})
+	})
+
+	relay := &relayReceiver{}
+	t.Run("Relay", func(t *testing.T) {
+		Run(t, func() log4go.Handler {
+			handler, err := log4go.NewRelayHandler(relay.listen(t))
+			if err != nil {
+				t.Fatal(err)
+			}
+			return handler
+		}, Opts{Delivered: relay.delivered})
+	})
+}
+
+// smtpServer returns a listener accepting mails (of a single recipient), discarding them.
+func smtpServer(t *testing.T) net.Listener {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				reader := bufio.NewReader(conn)
+				io.WriteString(conn, "220 localhost\r\n")
+				for data := false; ; {
+					line, err := reader.ReadString('\n')
+					if err != nil {
+						return
+					}
+					switch {
+					case data && line == ".\r\n":
+						data = false
+						io.WriteString(conn, "250 queued\r\n")
+					case data:
+					case strings.HasPrefix(line, "DATA"):
+						data = true
+						io.WriteString(conn, "354 go ahead\r\n")
+					case strings.HasPrefix(line, "QUIT"):
+						io.WriteString(conn, "221 bye\r\n")
+						return
+					default:
+						io.WriteString(conn, "250 ok\r\n")
+					}
+				}
+			}()
+		}
+	}()
+	return listener
+}
+
+// relayReceiver decodes the records sent by a RelayHandler (without acknowledgements or a codec).
+type relayReceiver struct {
+	lock     sync.Mutex
+	messages []string
+	done     chan struct{} // closed when the connection has been closed
+}
+
+// listen starts receiving (the records of a new handler) on a new listener, returning its address.
+func (r *relayReceiver) listen(t *testing.T) string {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	r.lock.Lock()
+	r.messages = nil
+	r.done = make(chan struct{})
+	done := r.done
+	r.lock.Unlock()
+
+	go func() {
+		defer close(done)
+		conn, err := listener.Accept()
+		listener.Close()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+
+		reader := bufio.NewReader(conn)
+		var size [4]byte
+		for {
+			if _, err := io.ReadFull(reader, size[:]); err != nil {
+				return
+			}
+			data := make([]byte, binary.BigEndian.Uint32(size[:]))
+			if _, err := io.ReadFull(reader, data); err != nil {
+				return
+			}
+			var rec log4go.Record
+			if err := rec.UnmarshalBinary(data); err != nil {
+				t.Errorf("invalid record: %v", err)
+				return
+			}
+			r.lock.Lock()
+			r.messages = append(r.messages, rec.Message)
+			r.lock.Unlock()
+		}
+	}()
+	return listener.Addr().String()
+}
+
+// delivered returns the messages received, once the handler has closed the connection.
+func (r *relayReceiver) delivered() []string {
+	r.lock.Lock()
+	done := r.done
+	r.lock.Unlock()
+	<-done
+
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	return r.messages
+}
+
+func TestRunKeepsConfig(t *testing.T) {
+	var out strings.Builder
+	log4go.BasicConfig(log4go.BasicConfigOpts{Writer: &out, Sync: true})
+	defer log4go.Reset()
+	configured := log4go.GetLogger().Handlers()
+
+	Run(t, func() log4go.Handler {
+		handler, _ := log4go.NewStreamHandler(ioutil.Discard)
+		return handler
+	})
+	if handlers := log4go.GetLogger().Handlers(); len(handlers) != 1 || handlers[0] != configured[0] {
+		t.Errorf("the root logger's handlers weren't restored: %v", handlers)
+	}
+	if out.Len() > 0 {
+		t.Errorf("the suite's records were passed on to the configured handlers:\n%s", out.String())
+	}
+}
@@ -0,0 +1,113 @@
+package log4go
+
+import (
+	"fmt"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// internalQueueSize is the number of reports queued for the internal handler,
+// before further ones are printed to stderr instead.
+const internalQueueSize = 100
+
+// internalSink passes the reports on to the handler, from its own goroutine: the reporting
+// goroutine might be the committer of the handler (reporting its own write error), which would deadlock
+// handing the record to itself.
+type internalSink struct {
+	handler Handler
+	lock    sync.RWMutex // guards queue against being closed while sending
+	queue   chan Record
+	done    chan struct{}
+
+	delivering int32 // non-zero while the handler handles a report, accessed atomically
+}
+
+var internalReports atomic.Value // *internalSink, nil while printing to stderr
+var internalLock sync.Mutex      // serializes SetInternalHandler
+
+// SetInternalHandler makes log4go report its own problems (e.g. write errors, dropped records and
+// formatter errors) as records of the "log4go" logger, passed on to the handler, instead of printing them
+// to stderr; e.g. to capture them by the same pipeline as the application's records.
+// The records are named after their source, e.g. "log4go/StreamHandler". nil restores printing to stderr.
+// The records are passed on from a separate goroutine; if it falls behind, the reports are printed to stderr,
+// as are the problems the handler reports while handling one. The handler should not fail along with the handlers
+// it reports about (e.g. writing to another disk), or its own reports keep it reporting.
+func SetInternalHandler(handler Handler) {
+	internalLock.Lock()
+	defer internalLock.Unlock()
+
+	var sink *internalSink
+	if handler != nil {
+		sink = &internalSink{
+			handler: handler,
+			queue:   make(chan Record, internalQueueSize),
+			done:    make(chan struct{}),
+		}
+		go sink.deliver(sink.queue)
+	}
+	previous, _ := internalReports.Load().(*internalSink)
+	internalReports.Store(sink)
+	if previous != nil {
+		previous.close()
+	}
+}
+
+// report reports a problem of log4go itself, e.g. report(ERROR, "StreamHandler", "write error: %v", err).
+func report(level Level, source string, format string, args ...interface{}) {
+	message := fmt.Sprintf(format, args...)
+
+	sink, _ := internalReports.Load().(*internalSink)
+	if sink != nil && atomic.LoadInt32(&sink.delivering) == 0 && sink.send(level, source, message) {
+		return
+	}
+	fmt.Fprintf(os.Stderr, "log4go.%s: %s\n", source, message)
+}
+
+// send queues the record, returning false if the queue is full (or the sink closed).
+func (s *internalSink) send(level Level, source, message string) bool {
+	now := time.Now()
+	rec := Record{
+		Time:      now,
+		Name:      "log4go/" + source,
+		Level:     level,
+		Message:   message,
+		Monotonic: now.Sub(processStart),
+	}
+
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+
+	if s.queue == nil {
+		return false
+	}
+	select {
+	case s.queue <- rec:
+		return true
+	default:
+		return false
+	}
+}
+
+func (s *internalSink) deliver(queue <-chan Record) {
+	defer close(s.done)
+
+	for rec := range queue {
+		if handles(s.handler, rec.Level) {
+			atomic.StoreInt32(&s.delivering, 1)
+			s.handler.Handle(&rec)
+			atomic.StoreInt32(&s.delivering, 0)
+		}
+	}
+}
+
+// close returns when the queued records have been passed on.
+func (s *internalSink) close() {
+	s.lock.Lock()
+	close(s.queue)
+	s.queue = nil
+	s.lock.Unlock()
+
+	<-s.done
+}
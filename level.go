@@ -1,6 +1,10 @@
 package log4go
 
-import "fmt"
+import (
+	"fmt"
+	"math"
+	"strings"
+)
 
 // Level is a typed logging level.
 type Level int
@@ -21,8 +25,13 @@ const (
 	ERROR
 	// FATAL log level - globally unrecoverable error (also does os.Exit(1)).
 	FATAL
+	// OFF level, above all others, i.e. nothing is logged (see also Logger.Disable).
+	OFF
 )
 
+// ALL level, below all others (including any added below TRACE), i.e. everything is logged.
+const ALL Level = math.MinInt32
+
 var levelToName = map[Level]string{
 	INHERIT: "INHERIT",
 	TRACE:   "TRACE",
@@ -31,6 +40,8 @@ var levelToName = map[Level]string{
 	WARNING: "WARNING",
 	ERROR:   "ERROR",
 	FATAL:   "FATAL",
+	OFF:     "OFF",
+	ALL:     "ALL",
 }
 
 // LevelName returns the textual representation of the level.
@@ -40,3 +51,18 @@ func LevelName(l Level) string {
 	}
 	return fmt.Sprintf("<Level:%d>", l)
 }
+
+// ParseLevel returns the level named (case-insensitively), e.g. "info" or "WARNING" ("WARN" is also accepted),
+// including the OFF and ALL sentinels (e.g. for loggers and handlers).
+func ParseLevel(name string) (Level, error) {
+	upper := strings.ToUpper(name)
+	if upper == "WARN" {
+		return WARNING, nil
+	}
+	for level, levelName := range levelToName {
+		if levelName == upper {
+			return level, nil
+		}
+	}
+	return INHERIT, fmt.Errorf("unknown level: %q", name)
+}
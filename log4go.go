@@ -5,13 +5,16 @@ import (
 	"fmt"
 	"io"
 	"os"
+	"path"
 	"runtime"
+	"strings"
 	"sync"
+	"sync/atomic"
 	"syscall"
 	"time"
 )
 
-// BasicConfigOpts is used to supply options to BasicConfig.
+// BasicConfigOpts is used to supply options to BasicConfig and ExtendConfig.
 type BasicConfigOpts struct {
 	FileName   string
 	FileAppend interface{}
@@ -20,14 +23,28 @@ type BasicConfigOpts struct {
 	Format     string
 	Level      Level
 	Handlers   []Handler
+	// Logger is the name of the logger to configure (used by ExtendConfig), default is the root logger.
+	Logger string
+	// Merge makes BasicConfig extend an existing configuration (see ExtendConfig) instead of replacing it.
+	Merge bool
+	// Sync makes the default handler write synchronously (see StreamOpts.Sync).
+	Sync bool
+	// Color enables level coloring (see TemplateFormatter.EnableLevelColoring) of the default formatter.
+	Color bool
+	// TestWrite makes the default file handler test the file at creation (see FileOpts.TestWrite).
+	TestWrite bool
 }
 
 var rootLogger *Logger
-var loggersLock = &sync.Mutex{}
+var currentRoot atomic.Value // *Logger, the rootLogger for lock-free reads (see setRootLogger)
+var loggersLock = &sync.RWMutex{}
 var loggers map[string]*Logger
 
 var recordPool sync.Pool
 
+// processStart is the reference for Record.Monotonic (it includes a monotonic clock reading).
+var processStart = time.Now()
+
 func init() {
 	recordPool = sync.Pool{
 		New: func() interface{} {
@@ -40,21 +57,95 @@ func init() {
 
 // BasicConfig sets up a simple configuration of the logging system.
 func BasicConfig(opts BasicConfigOpts) error {
+	if opts.Merge {
+		return ExtendConfig(opts)
+	}
+
 	loggersLock.Lock()
-	defer loggersLock.Unlock()
+	pending := takePreConfig()
+	err := basicConfig(opts)
+	loggersLock.Unlock()
+
+	replayPreConfig(pending)
+	return err
+}
 
+// basicConfig does the actual work of BasicConfig (loggersLock must be held).
+func basicConfig(opts BasicConfigOpts) error {
 	// remove any/all created Logger, Handler and Formatter instances
-	Shutdown()
+	shutdown()
 	loggers = map[string]*Logger{}
-	rootLogger = nil
-
-	var err error
+	setRootLogger(nil)
+	configState.config, configState.handlers = nil, nil
 
 	if opts.Level == INHERIT {
 		opts.Level = WARNING
 	}
+
+	handlers, err := configHandlers(opts)
+	if err != nil {
+		return err
+	}
+
+	setRootLogger(createRootLogger(handlers...))
+	rootLogger.setLevel(opts.Level)
+
+	return nil
+}
+
+// ExtendConfig adds to the existing configuration, instead of replacing it.
+// Handlers are added (to the logger named by opts.Logger) only if any of Handlers, Writer or FileName
+// is specified, and the logger's level is only set if Level is specified.
+// If the logging system has not been configured yet, this is the same as BasicConfig.
+func ExtendConfig(opts BasicConfigOpts) error {
+	var pending preConfigReplay
+	defer func() { replayPreConfig(pending) }()
+
+	loggersLock.Lock()
+	defer loggersLock.Unlock()
+
+	if rootLogger == nil {
+		return basicConfig(opts)
+	}
+
+	logger := rootLogger
+	if len(opts.Logger) > 0 && opts.Logger != "root" {
+		logger = rootLogger.getLogger(opts.Logger)
+	}
+
+	if len(opts.Handlers) > 0 || opts.Writer != nil || len(opts.FileName) > 0 {
+		handlers, err := configHandlers(opts)
+		if err != nil {
+			return err
+		}
+
+		if logger == rootLogger {
+			pending = takePreConfig()
+		}
+		current := logger.ownHandlers()
+		combined := make([]Handler, 0, len(current)+len(handlers))
+		combined = append(combined, current...)
+		logger.handlers.Store(append(combined, handlers...))
+
+		warnDuplicateFiles("ExtendConfig")
+	}
+
+	if opts.Level != INHERIT {
+		logger.setLevel(opts.Level)
+	}
+
+	return nil
+}
+
+// defaultFormat is the template used if none is specified.
+const defaultFormat = "{timems} {name<20} {level<8} {message}"
+
+// configHandlers returns opts.Handlers, or a default handler created from the options, all with a formatter.
+func configHandlers(opts BasicConfigOpts) ([]Handler, error) {
+	var err error
+
 	if len(opts.Format) == 0 {
-		opts.Format = "{timems} {name<20} {level<8} {message}"
+		opts.Format = defaultFormat
 	}
 
 	if len(opts.Handlers) == 0 {
@@ -65,20 +156,21 @@ func BasicConfig(opts BasicConfigOpts) error {
 		}
 
 		if opts.Writer != nil {
-			defHandler, err = NewStreamHandler(opts.Writer)
+			defHandler, err = NewStreamHandler(opts.Writer, StreamOpts{Sync: opts.Sync})
 		} else if len(opts.FileName) > 0 {
 			appendFile := opts.FileAppend == nil || opts.FileAppend.(bool)
 
+			fileOpts := FileOpts{Sync: opts.Sync, TestWrite: opts.TestWrite}
 			if opts.WatchFile {
-				defHandler, err = NewWatchedFileHandler(opts.FileName, appendFile)
+				defHandler, err = NewWatchedFileHandler(opts.FileName, appendFile, fileOpts)
 			} else {
-				defHandler, err = NewFileHandler(opts.FileName, appendFile)
+				defHandler, err = NewFileHandler(opts.FileName, appendFile, fileOpts)
 			}
 		} else {
-			defHandler, err = NewStreamHandler(os.Stderr)
+			defHandler, err = NewStreamHandler(os.Stderr, StreamOpts{Sync: opts.Sync})
 		}
 		if err != nil {
-			return err
+			return nil, err
 		}
 		opts.Handlers = []Handler{defHandler}
 	}
@@ -88,23 +180,76 @@ func BasicConfig(opts BasicConfigOpts) error {
 	for _, handler := range opts.Handlers {
 		if handler.Formatter() == nil {
 			if defFormatter == nil { // create a default formatter
-				defFormatter, err = NewTemplateFormatter(opts.Format)
+				tf, err := NewTemplateFormatter(opts.Format)
 				if err != nil {
-					return err
+					return nil, err
 				}
+				tf.EnableLevelColoring(opts.Color)
+				defFormatter = tf
 			}
 			handler.SetFormatter(defFormatter)
 		}
 	}
 
-	rootLogger = createRootLogger(opts.Handlers...)
-	rootLogger.SetLevel(opts.Level)
-
-	return nil
+	return opts.Handlers, nil
 }
 
 // Shutdown shuts down all internals of log4go.
+// It may be called any number of times; handlers already shut down are left as they are.
 func Shutdown() {
+	loggersLock.Lock()
+	defer loggersLock.Unlock()
+
+	shutdown()
+}
+
+// Flush flushes all handlers (see Flusher), e.g. before a fork or a crash report,
+// returning the first error.
+func Flush() error {
+	loggersLock.RLock()
+	defer loggersLock.RUnlock()
+
+	uniqueHandlers := make(map[string]Handler, 10)
+	collectHandlers(rootLogger, uniqueHandlers)
+
+	var err error
+	for _, h := range uniqueHandlers {
+		if e := FlushHandler(h); e != nil && err == nil {
+			err = e
+		}
+	}
+	return err
+}
+
+// Reset shuts down the logging system and returns the package to its unconfigured state, e.g. between tests.
+// All loggers are removed (those retrieved before are detached), and the global settings are restored
+// to their defaults: no level resolver, metrics hook, rate ceiling, source levels, escalation rules
+// or internal handler, the optional record attributes disabled, and sanitizing enabled.
+func Reset() {
+	SetInternalHandler(nil)
+
+	loggersLock.Lock()
+	defer loggersLock.Unlock()
+
+	shutdown()
+	loggers = map[string]*Logger{}
+	setRootLogger(nil)
+	configState.config, configState.handlers = nil, nil
+	levelResolver = nil
+
+	metricsHook.Store(metricsHookEntry{})
+	SetRateCeiling(RateCeiling{})
+	sourceLevels.Store((*sourceRules)(nil))
+	SetEscalationRules()
+	EnableRecordIDs(false)
+	EnableGoroutineIDs(false)
+	EnableErrorFields(false)
+	EnableCallers(false)
+	EnableSanitizing(true)
+}
+
+// shutdown does the actual work of Shutdown (loggersLock must be held).
+func shutdown() {
 	// close all commit channels
 
 	// first collect all unique handlers
@@ -114,16 +259,13 @@ func Shutdown() {
 	for _, h := range uniqueHandlers {
 		allHandlers = append(allHandlers, h)
 	}
-	// then shut them all down
+	if len(allHandlers) == 0 {
+		return
+	}
+	// then shut them all down (each returning when its queued records are written)
 	shutdownHandlers(allHandlers)
 
-	runtime.Gosched()
-	runtime.GC()
 	syscall.Sync()
-
-	// TODO: wait for the commiter goroutines to finish (somehow)
-	// nice synchronization there, Bob!
-	time.Sleep(100 * time.Millisecond)
 }
 
 func collectHandlers(log *Logger, uniqueHandlers map[string]Handler) {
@@ -136,18 +278,22 @@ func collectHandlers(log *Logger, uniqueHandlers map[string]Handler) {
 		}
 	}
 
-	if log.handlers != nil {
-		for _, h := range log.handlers {
-			// use the pointer address as the unique key
-			hkey := fmt.Sprintf("%p", h)
+	handlers := log.ownHandlers()
+	if entry, _ := log.stagedTo.Load().(stagedHandlerEntry); entry.handler != nil {
+		handlers = append(handlers[:len(handlers):len(handlers)], entry.handler)
+	}
+	for _, h := range handlers {
+		// use the pointer address as the unique key
+		hkey := fmt.Sprintf("%p", h)
 
-			uniqueHandlers[hkey] = h // might already exists, but it'll be the same handler
-		}
+		uniqueHandlers[hkey] = h // might already exists, but it'll be the same handler
 	}
 }
 func shutdownHandlers(allHandlers []Handler) {
 	for _, h := range allHandlers {
-		h.Shutdown()
+		if err := CloseHandler(h); err != nil && err != ErrClosed {
+			report(ERROR, "Shutdown", "%v", err)
+		}
 	}
 }
 
@@ -157,25 +303,84 @@ func GetLogger(name ...string) *Logger {
 		return GetLogger().GetLogger(name[0])
 	}
 
-	// get/create the root logger
+	if root, _ := currentRoot.Load().(*Logger); root != nil {
+		return root
+	}
+
+	// create the root logger
 	loggersLock.Lock()
 	defer loggersLock.Unlock()
 
 	if rootLogger == nil {
-		rootLogger = createRootLogger()
+		setRootLogger(createRootLogger())
 	}
 
 	return rootLogger
 }
 
+// setRootLogger sets the root logger (loggersLock must be held).
+func setRootLogger(root *Logger) {
+	rootLogger = root
+	currentRoot.Store(root)
+}
+
+// GetLoggerAuto returns the logger named after the caller's package, e.g. "app/db" for the package
+// github.com/acme/app/db (in the main module github.com/acme/app), so libraries don't hardcode names.
+// The hosting domain (and for e.g. github.com the owner) are dropped; the package main is named after
+// the main module. Loggers of subpackages are descendants of their parent packages' loggers.
+func GetLoggerAuto() *Logger {
+	pkg := "main"
+	if pc, _, _, ok := runtime.Caller(1); ok {
+		if fn := runtime.FuncForPC(pc); fn != nil {
+			pkg = funcPackage(fn.Name())
+		}
+	}
+	return loggerByName(packageLoggerName(pkg, buildInfo.Path))
+}
+
+// funcPackage returns the package path of a function name, e.g. "github.com/acme/app/db.(*Store).Open".
+func funcPackage(name string) string {
+	slash := strings.LastIndexByte(name, '/') + 1
+	if dot := strings.IndexByte(name[slash:], '.'); dot >= 0 {
+		return name[:slash+dot]
+	}
+	return name
+}
+
+// ownerHosts are hosting domains whose paths start with the owner (e.g. github.com/owner/repo).
+var ownerHosts = map[string]bool{
+	"github.com":    true,
+	"gitlab.com":    true,
+	"bitbucket.org": true,
+}
+
+// packageLoggerName returns the logger name for a package path, relative to the main module's parent.
+func packageLoggerName(pkg, mainModule string) string {
+	if pkg == "main" {
+		pkg = mainModule
+	}
+	if parent := path.Dir(mainModule); len(mainModule) > 0 && parent != "." &&
+		(pkg == mainModule || strings.HasPrefix(pkg, mainModule+"/")) {
+		return strings.TrimPrefix(pkg, parent+"/")
+	}
+
+	parts := strings.Split(pkg, "/")
+	if len(parts) > 1 && strings.Contains(parts[0], ".") {
+		drop := 1
+		if ownerHosts[parts[0]] && len(parts) > 2 {
+			drop = 2
+		}
+		parts = parts[drop:]
+	}
+	return strings.Join(parts, "/")
+}
+
 func createRootLogger(handlers ...Handler) *Logger {
 	//fmt.Println("creating root logger: %d handlers", len(handlers))
 
 	if len(handlers) == 0 {
-		handler, _ := NewStreamHandler(os.Stderr)
-		formatter, _ := NewTemplateFormatter("{time} {name} {level} {message}")
-		handler.SetFormatter(formatter)
-		handlers = []Handler{handler}
+		// not configured (yet), see preConfigHandler
+		handlers = []Handler{newPreConfigHandler()}
 	}
 
 	//fmt.Printf("root logger, h = %p\n", handler)
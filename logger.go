@@ -1,23 +1,54 @@
 package log4go
 
 import (
-	"bufio"
 	"errors"
 	"fmt"
-	"os"
+	"runtime"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
 )
 
 // Logger objects.
+//
+// The logger tree (levels, handlers and children) is mutated under loggersLock.
+// The logging path never takes that lock: it only reads the atomically cached
+// effective level and the current (immutable) handler slice.
 type Logger struct {
 	name     string
 	level    Level
-	handlers []Handler
+	handlers atomic.Value // []Handler, replaced (never modified) on change
 	parent   *Logger
 	children []*Logger
 
-	staged []Record
+	childByName atomic.Value // map[string]*Logger by sub name, replaced (never modified) on change
+
+	// effective level (i.e. level with inheritance resolved), accessed atomically.
+	// kept up-to-date by SetLevel, so the level check never needs to walk the ancestors.
+	effective int32
+
+	disabled bool // see Disable (loggersLock must be held to access it)
+	muted    bool // disabled, or having a disabled ancestor (loggersLock must be held to access it)
+
+	stagedLock  sync.Mutex // guards staged
+	staged      []Record
+	stagedCount int32        // len(staged), accessed atomically; clearStaged doesn't lock when nothing is staged
+	stagedTo    atomic.Value // stagedHandlerEntry, see SetStagedHandler
+
+	stackPolicy atomic.Value // *stackPolicy, see SetStackCapture
+	rateLimiter atomic.Value // *rateLimiter, see SetRateLimit
+
+	prefix, suffix atomic.Value // string, see SetPrefix and SetSuffix
+
+	// derived loggers (see With) share the tree node of base, adding attributes to the records
+	base       *Logger
+	fields     Fields
+	stack      string
+	captures   captures
+	color      string
+	tags       []string
+	callerSkip int
 }
 
 func newLogger(parent *Logger, name string, lvl Level, handlers ...Handler) *Logger {
@@ -34,24 +65,49 @@ func newLogger(parent *Logger, name string, lvl Level, handlers ...Handler) *Log
 		parent.children = append(parent.children, log)
 	}
 
-	if len(handlers) > 0 {
-		log.handlers = handlers
-	}
+	log.handlers.Store(handlers)
+
+	log.updateEffective()
 
 	return log
 }
 
 // GetLogger returns a sub-logger (inherits traits from parent).
+// Once created, it's looked up without locking (or building its full name).
+// The attributes of a derived logger (e.g. its fields, tags and color, see With) are inherited: the sub-logger
+// (and its descendants retrieved from it) adds them to its records too, while fields added to it (by With)
+// override them by key.
 func (l *Logger) GetLogger(subName string) *Logger {
-	// get/create a sub-logger
+	child := l.node().subLogger(subName)
+	if l.base == nil {
+		return child
+	}
+	return l.deriveAt(child)
+}
 
-	loggerName := l.name
-	if len(loggerName) > 0 {
-		loggerName += "/"
+// subLogger gets/creates a sub-logger of the logger in the tree.
+func (l *Logger) subLogger(subName string) *Logger {
+	if children, _ := l.childByName.Load().(map[string]*Logger); children != nil {
+		if logger, exists := children[subName]; exists {
+			return logger
+		}
 	}
-	loggerName += subName
 
 	loggersLock.Lock()
+	defer loggersLock.Unlock()
+
+	// someone else might've created it while we weren't holding the lock
+	return l.getLogger(subName)
+}
+
+// getLogger gets/creates a sub-logger (loggersLock must be held).
+func (l *Logger) getLogger(subName string) *Logger {
+	children, _ := l.childByName.Load().(map[string]*Logger)
+	if logger, exists := children[subName]; exists {
+		return logger
+	}
+
+	loggerName := l.childName(subName)
 
 	logger, exists := loggers[loggerName]
 	if !exists {
@@ -61,27 +117,181 @@ func (l *Logger) GetLogger(subName string) *Logger {
 		loggers[loggerName] = logger
 	}
 
-	loggersLock.Unlock()
+	// cache it for lock-free lookups
+	updated := make(map[string]*Logger, len(children)+1)
+	for name, child := range children {
+		updated[name] = child
+	}
+	updated[subName] = logger
+	l.childByName.Store(updated)
 
 	return logger
 }
 
+func (l *Logger) childName(subName string) string {
+	if len(l.name) > 0 {
+		return l.name + "/" + subName
+	}
+	return subName
+}
+
 // SetLevel sets the logging level of the logger.
 func (l *Logger) SetLevel(lvl Level) {
+	l = l.node()
+
+	loggersLock.Lock()
+	defer loggersLock.Unlock()
+
+	l.setLevel(lvl)
+}
+
+// setLevel sets the level and refreshes the effective level of the whole subtree (loggersLock must be held).
+func (l *Logger) setLevel(lvl Level) {
 	l.level = lvl
+	l.updateEffective()
+}
+
+// Disable mutes the logger and its descendants, regardless of their levels (which are kept, unlike when
+// setting the level to OFF), until enabled again.
+func (l *Logger) Disable() {
+	l.setDisabled(true)
+}
+
+// Enable unmutes the logger (see Disable); its descendants stay muted if disabled themselves.
+func (l *Logger) Enable() {
+	l.setDisabled(false)
+}
+
+func (l *Logger) setDisabled(disabled bool) {
+	l = l.node()
+
+	loggersLock.Lock()
+	defer loggersLock.Unlock()
+
+	l.disabled = disabled
+	l.updateEffective()
+}
+
+// Disabled returns whether the logger is muted, i.e. it or an ancestor is disabled (see Disable).
+func (l *Logger) Disabled() bool {
+	l = l.node()
+
+	loggersLock.RLock()
+	defer loggersLock.RUnlock()
+
+	return l.muted
+}
+
+// SetPrefix sets a string prepended to the messages logged by the logger or its descendants (unless set on them),
+// e.g. a module tag. An empty string unsets it.
+func (l *Logger) SetPrefix(prefix string) {
+	l.node().prefix.Store(prefix)
+}
+
+// SetSuffix sets a string appended to the messages logged by the logger or its descendants (unless set on them).
+// An empty string unsets it.
+func (l *Logger) SetSuffix(suffix string) {
+	l.node().suffix.Store(suffix)
+}
+
+// decorate returns the message with the prefix and suffix of the logger (or its nearest ancestors having them).
+func (l *Logger) decorate(message string) string {
+	var prefix, suffix string
+	for logger := l; logger != nil && (len(prefix) == 0 || len(suffix) == 0); logger = logger.parent {
+		if len(prefix) == 0 {
+			prefix, _ = logger.prefix.Load().(string)
+		}
+		if len(suffix) == 0 {
+			suffix, _ = logger.suffix.Load().(string)
+		}
+	}
+	if len(prefix) == 0 && len(suffix) == 0 {
+		return message
+	}
+	return prefix + message + suffix
+}
+
+// LevelResolver returns the level of a logger (by full name), or false if it has none,
+// e.g. looked up in a feature-flag service or a config map.
+type LevelResolver func(name string) (Level, bool)
+
+// levelResolver is consulted by updateEffective (loggersLock must be held to access it).
+var levelResolver LevelResolver
+
+// SetLevelResolver installs a resolver consulted for loggers without a level of their own (i.e. INHERIT),
+// before inheriting the level from the parent. Use nil to remove the resolver.
+// The resolver is called while the logger tree is locked; it must not call back into log4go.
+func SetLevelResolver(resolver LevelResolver) {
+	loggersLock.Lock()
+	defer loggersLock.Unlock()
+
+	levelResolver = resolver
+	if rootLogger != nil {
+		rootLogger.updateEffective()
+	}
+}
+
+// RefreshLevels consults the level resolver again for all loggers, e.g. after its source changed.
+func RefreshLevels() {
+	loggersLock.Lock()
+	defer loggersLock.Unlock()
+
+	if rootLogger != nil {
+		rootLogger.updateEffective()
+	}
+}
+
+// updateEffective re-resolves the effective level of this logger and all its descendants.
+func (l *Logger) updateEffective() {
+	lvl := l.level
+	if lvl == INHERIT && levelResolver != nil {
+		if resolved, ok := levelResolver(l.name); ok {
+			lvl = resolved
+		}
+	}
+	if lvl == INHERIT && l.parent != nil {
+		lvl = l.parent.Level()
+	}
+	l.muted = l.disabled || (l.parent != nil && l.parent.muted)
+	if l.muted {
+		lvl = OFF
+	}
+	atomic.StoreInt32(&l.effective, int32(lvl))
+
+	for _, child := range l.children {
+		child.updateEffective()
+	}
 }
 
 // Level returns the logger's (effective) level.
 func (l *Logger) Level() Level {
-	// as long as level is not set, ascend the ancestors
-	for l.level == INHERIT {
-		if l.parent != nil {
-			l = l.parent
-		} else { // no parent, use this logger's level
-			break
-		}
+	return Level(atomic.LoadInt32(&l.node().effective))
+}
+
+// IsEnabled returns whether records of the level would be logged (or captured, see WithCapture),
+// e.g. to guard building expensive arguments. It's an atomic load of the cached effective level
+// (unless source levels are set, see SetSourceLevel).
+func (l *Logger) IsEnabled(lvl Level) bool {
+	effective := Level(atomic.LoadInt32(&l.node().effective))
+	if lvl >= effective {
+		return true
 	}
-	return l.level
+	return (effective != OFF && sourceEnabled(lvl, l.callerSkip)) || l.captures.wants(lvl, false)
+}
+
+// IsTraceEnabled returns whether TRACE records would be logged (see IsEnabled).
+func (l *Logger) IsTraceEnabled() bool {
+	return l.IsEnabled(TRACE)
+}
+
+// IsDebugEnabled returns whether DEBUG records would be logged (see IsEnabled).
+func (l *Logger) IsDebugEnabled() bool {
+	return l.IsEnabled(DEBUG)
+}
+
+// IsInfoEnabled returns whether INFO records would be logged (see IsEnabled).
+func (l *Logger) IsInfoEnabled() bool {
+	return l.IsEnabled(INFO)
 }
 
 var ErrNoFormatter = errors.New("handler has no formatter")
@@ -92,7 +302,17 @@ func (l *Logger) AddHandler(handler Handler) error {
 		return ErrNoFormatter
 	}
 
-	l.handlers = append(l.handlers, handler)
+	l = l.node()
+
+	loggersLock.Lock()
+	defer loggersLock.Unlock()
+
+	current := l.ownHandlers()
+	handlers := make([]Handler, len(current), len(current)+1)
+	copy(handlers, current)
+	l.handlers.Store(append(handlers, handler))
+
+	warnDuplicateFiles("AddHandler")
 	return nil
 }
 
@@ -104,17 +324,27 @@ func (l *Logger) ReplaceHandlers(handler Handler) {
 
 // RemoveHandlers removes all handlers from the Logger.
 func (l *Logger) RemoveHandlers() {
-	l.handlers = []Handler{}
+	l = l.node()
+
+	loggersLock.Lock()
+	defer loggersLock.Unlock()
+
+	stopPreConfig(l.ownHandlers())
+	l.handlers.Store([]Handler{})
+}
+
+// ownHandlers returns the handlers added to this logger (the returned slice must not be modified).
+func (l *Logger) ownHandlers() []Handler {
+	handlers, _ := l.node().handlers.Load().([]Handler)
+	return handlers
 }
 
 // Handlers returns all handlers used by this logger (i.e. this and all its parents' handlers).
 func (l *Logger) Handlers() []Handler {
 	handlers := make([]Handler, 0, 10)
-	logger := l
+	logger := l.node()
 	for logger != nil {
-		if logger.handlers != nil && len(logger.handlers) > 0 {
-			handlers = append(handlers, logger.handlers...)
-		}
+		handlers = append(handlers, logger.ownHandlers()...)
 		logger = logger.parent
 	}
 	return handlers
@@ -122,35 +352,89 @@ func (l *Logger) Handlers() []Handler {
 
 // Log submits a Log message using specific level and message.
 func (l *Logger) log(lvl Level, stage bool, message string, args ...interface{}) {
-	if lvl < l.Level() {
+	l.logRecord(lvl, stage, 0, message, args)
+}
+
+// logRecord creates a Record (if the level is enabled) and passes it to the handlers (or stages it).
+func (l *Logger) logRecord(lvl Level, stage bool, duration time.Duration, message string, args []interface{}) {
+	node := l.node()
+
+	// fast path: atomic loads (of the level, and any source levels), before anything is allocated or formatted
+	if effective := Level(atomic.LoadInt32(&node.effective)); lvl < effective && (effective == OFF || !sourceEnabled(lvl, l.callerSkip)) {
+		// captures may want more than the handlers get
+		if !stage && l.captures.wants(lvl, false) {
+			rec := l.newRecord(node, lvl, duration, message, args)
+			l.captures.add(rec, false)
+			recordPool.Put(rec)
+		}
+		if !stage && lvl < ERROR {
+			l.clearStaged()
+		}
 		return
 	}
 
+	if stage {
+		if staged := node.stagedHandler(); staged != nil {
+			// staged once, flushed to the staged handler only
+			rec := l.newRecord(node, lvl, duration, message, args)
+			node.stage(rec)
+			recordPool.Put(rec)
+			return
+		}
+	}
+
 	var rec *Record // a record will be created if & when it's necessary
 
+	if !stage && l.captures.wants(lvl, true) {
+		rec = l.newRecord(node, lvl, duration, message, args)
+		l.captures.add(rec, true)
+	}
+
+	if !stage {
+		// the rate limits, and the staged records, go by the escalated level
+		if rec == nil && escalating() {
+			rec = l.newRecord(node, lvl, duration, message, args)
+		}
+		escalated := lvl
+		if rec != nil {
+			escalated = rec.Level
+		}
+		// cleared (by the logging methods below ERROR), unless escalated to ERROR
+		if lvl < ERROR {
+			if escalated >= ERROR {
+				l.flushStaged()
+			} else {
+				l.clearStaged()
+			}
+		}
+		lvl = escalated
+		callMetricsHook(node.name, lvl)
+	}
+
+	if !stage && (node.rateLimited(lvl) || ceilingExceeded(lvl)) {
+		if rec != nil {
+			recordPool.Put(rec)
+		}
+		return
+	}
+
 	// traverse up this logger's ancestors, calling all handlers along the way
-	logger := l
+	logger := node
 	for logger != nil {
-		if len(logger.handlers) > 0 { // we need handlers!
+		if handlers := logger.ownHandlers(); len(handlers) > 0 { // we need handlers!
 			// ok, now we need to construct a Record for this message
 			if rec == nil {
-				rec = recordPool.Get().(*Record)
-
-				rec.Time = time.Now()
-				rec.Name = l.name
-				rec.Level = lvl
-				rec.Message = fmt.Sprintf(message, args...)
+				rec = l.newRecord(node, lvl, duration, message, args)
 			}
 
 			if stage {
-				if logger.staged == nil {
-					logger.staged = make([]Record, 0, 10)
-				}
-				logger.staged = append(logger.staged, *rec)
+				logger.stage(rec)
 			} else {
 				// invoke all handlers
-				for _, handler := range logger.handlers {
-					handler.Handle(rec)
+				for _, handler := range handlers {
+					if handles(handler, rec.Level) { // possibly escalated
+						handler.Handle(rec)
+					}
 				}
 			}
 		}
@@ -163,19 +447,220 @@ func (l *Logger) log(lvl Level, stage bool, message string, args ...interface{})
 	}
 }
 
+// newRecord returns a record (from the pool) for the message, with the attributes of the logger (of the tree node).
+func (l *Logger) newRecord(node *Logger, lvl Level, duration time.Duration, message string, args []interface{}) *Record {
+	rec := recordPool.Get().(*Record)
+
+	rec.Time = time.Now()
+	rec.Monotonic = rec.Time.Sub(processStart)
+	rec.Name = l.name
+	rec.Level = lvl
+	rec.Fields = l.fields
+	if named, ok := namedArgs(args); ok {
+		var values Args
+		message, values = formatNamed(message, named)
+		if sanitizing() {
+			message = sanitize(message)
+		}
+		rec.Message = node.decorate(message)
+		rec.Fields = l.fields.merged(values)
+	} else {
+		message = formatMessage(message, args)
+		if sanitizing() {
+			message = sanitize(message)
+		}
+		rec.Message = node.decorate(message)
+		if errorFields() {
+			rec.Fields = withErrorField(rec.Fields, args)
+		}
+	}
+	rec.Fields = withDiagnostics(rec.Fields)
+	rec.Duration = duration
+	rec.Color = l.color
+	rec.Tags = l.tags
+	rec.Stack = l.stack
+	if len(rec.Stack) == 0 {
+		rec.Stack = node.capturedStack(lvl, l.callerSkip)
+	}
+	rec.ID = ""
+	if recordIDs() {
+		rec.ID = newULID(rec.Time)
+	}
+	rec.GoroutineID = 0
+	if goroutineIDs() {
+		rec.GoroutineID = goroutineID()
+	}
+	rec.File, rec.Line = "", 0
+	if callers() {
+		if frames := callerFrames(l.callerSkip, 1); len(frames) > 0 {
+			rec.File, rec.Line = frames[0].File, frames[0].Line
+		}
+	}
+	escalate(rec)
+
+	return rec
+}
+
+// dispatch passes an already created record (e.g. received from elsewhere) to the handlers, if its level is enabled.
+func (l *Logger) dispatch(rec *Record) {
+	node := l.node()
+
+	if rec.Level < Level(atomic.LoadInt32(&node.effective)) {
+		return
+	}
+
+	callMetricsHook(node.name, rec.Level)
+	node.forward(rec)
+}
+
+// forward passes the record on to the handlers of the logger and its ancestors.
+func (l *Logger) forward(rec *Record) {
+	for logger := l; logger != nil; logger = logger.parent {
+		for _, handler := range logger.ownHandlers() {
+			if handles(handler, rec.Level) {
+				handler.Handle(rec)
+			}
+		}
+	}
+}
+
+// Lazy wraps a function whose result is used as a format argument.
+// The function is only called if the message is actually emitted, e.g.:
+//
+//	log.Debug("state: %v", log4go.Lazy(func() interface{} { return expensiveDump() }))
+//
+// A plain func() interface{} argument is treated the same way.
+type Lazy func() interface{}
+
+// formatMessage renders the message, evaluating any lazy arguments.
+// A panic while formatting (e.g. in a lazy argument or a misbehaving
+// formatter method) is recovered and the message describes the failure instead.
+func formatMessage(message string, args []interface{}) (formatted string) {
+	if len(args) == 0 {
+		return message
+	}
+
+	defer func() {
+		if err := recover(); err != nil {
+			formatted = formatError(err, message, args)
+		}
+	}()
+
+	resolved := args
+	for idx, arg := range args {
+		var value interface{}
+		switch fn := arg.(type) {
+		case Lazy:
+			value = fn()
+		case func() interface{}:
+			value = fn()
+		default:
+			continue
+		}
+		if &resolved[0] == &args[0] { // don't modify the caller's slice
+			resolved = append([]interface{}(nil), args...)
+		}
+		resolved[idx] = value
+	}
+
+	return fmt.Sprintf(message, resolved...)
+}
+
+// formatError describes a failed message formatting, including the template and the argument types.
+func formatError(err interface{}, message string, args []interface{}) string {
+	types := make([]string, len(args))
+	for idx, arg := range args {
+		types[idx] = fmt.Sprintf("%T", arg)
+	}
+	return fmt.Sprintf("log4go: formatting failed: %v (template: %q, args: [%s])", err, message, strings.Join(types, ", "))
+}
+
+func (l *Logger) clearStaged() {
+	node := l.node()
+	if atomic.LoadInt32(&node.stagedCount) == 0 {
+		return
+	}
+
+	node.stagedLock.Lock()
+	node.staged = node.staged[:0]
+	atomic.StoreInt32(&node.stagedCount, 0)
+	node.stagedLock.Unlock()
+}
+
+// stage adds a copy of the record to the logger's staged records.
+func (l *Logger) stage(rec *Record) {
+	l.stagedLock.Lock()
+	if l.staged == nil {
+		l.staged = make([]Record, 0, 10)
+	}
+	l.staged = append(l.staged, *rec)
+	atomic.StoreInt32(&l.stagedCount, int32(len(l.staged)))
+	l.stagedLock.Unlock()
+}
+
+// stagedHandlerEntry wraps the staged handler, since an atomic.Value must always store the same type.
+type stagedHandlerEntry struct {
+	handler Handler
+}
+
+// SetStagedHandler makes the staged records of the logger or its descendants (unless set on them) be flushed
+// to the handler, instead of to the normal handlers; e.g. a separate breadcrumb file or a RingBufferHandler,
+// so the context of errors doesn't inflate the primary log. Records staged after this are staged once
+// (rather than for each logger having handlers). nil unsets it.
+func (l *Logger) SetStagedHandler(handler Handler) error {
+	if handler != nil && handler.Formatter() == nil {
+		return ErrNoFormatter
+	}
+
+	l = l.node()
+
+	loggersLock.Lock()
+	defer loggersLock.Unlock()
+
+	l.stagedTo.Store(stagedHandlerEntry{handler})
+	return nil
+}
+
+// stagedHandler returns the staged handler of the logger, or its nearest ancestor having one.
+func (l *Logger) stagedHandler() Handler {
+	for logger := l; logger != nil; logger = logger.parent {
+		if entry, _ := logger.stagedTo.Load().(stagedHandlerEntry); entry.handler != nil {
+			return entry.handler
+		}
+	}
+	return nil
+}
+
 func (l *Logger) flushStaged() {
 
 	// flush staged messages for this logger and all its ancestors
+	// (to the staged handler, if set)
+
+	target := l.node().stagedHandler()
 
-	logger := l
+	logger := l.node()
 	for logger != nil {
-		if len(logger.staged) > 0 {
-			for _, rec := range logger.staged {
-				for _, h := range logger.handlers {
-					h.Handle(&rec)
+		if atomic.LoadInt32(&logger.stagedCount) > 0 {
+			// take the records, so the handlers aren't called while holding the lock
+			logger.stagedLock.Lock()
+			staged := logger.staged
+			logger.staged = nil
+			atomic.StoreInt32(&logger.stagedCount, 0)
+			logger.stagedLock.Unlock()
+
+			for _, rec := range staged {
+				if target != nil {
+					if handles(target, rec.Level) {
+						target.Handle(&rec)
+					}
+					continue
+				}
+				for _, h := range logger.ownHandlers() {
+					if handles(h, rec.Level) {
+						h.Handle(&rec)
+					}
 				}
 			}
-			logger.staged = logger.staged[:0]
 		}
 		logger = logger.parent
 	}
@@ -185,22 +670,19 @@ func (l *Logger) flushStaged() {
 type CrashOpts struct {
 	// BuildPath strips this prefix from all source file references in the stack trace.
 	BuildPath string
-	// ExitCode makes os.Exit(ExitCode), if set.
+	// ExitCode makes os.Exit(ExitCode), if set (after running the OnExit functions).
 	ExitCode int
-	// PlainStack instructs Crash to print the stack without path stripping or log formatting
+	// PlainStack instructs Crash to print the whole stack without path stripping or log formatting
 	PlainStack bool
+	// FormatFrame formats each frame of the stack trace, instead of the default formatting
+	// (the function, followed by the indented file:line).
+	FormatFrame func(frame runtime.Frame) string
 }
 
-// Crash is similar to Fatal but also prints a stack trace.
+// Crash is similar to Fatal but also prints a stack trace, of the panic if called from a deferred function
+// which recovered it. The stack is captured by Crash itself, unless passed as stack (formatted by debug.Stack,
+// e.g. captured where the panic was recovered, in another goroutine).
 func (l *Logger) Crash(err interface{}, stack []byte, opts ...CrashOpts) {
-	// stack will always contain "useless" levels, e.g.:
-	// runtime/debug.Stack(0xc4200ed7f0, 0x6aeee0, 0xc420101120)
-	//    (location of call to debug.Stack())
-	// main.main.func1(0xc420016f00)
-	//    (location of deferred function that called recover())
-	// panic(0x6aeee0, 0xc420101120)
-	//    (location of call of panic())
-
 	l.flushStaged()
 
 	if len(opts) == 0 {
@@ -211,61 +693,82 @@ func (l *Logger) Crash(err interface{}, stack []byte, opts ...CrashOpts) {
 	exitCode := opts[0].ExitCode
 	plainStack := opts[0].PlainStack
 
-	lines := make([]string, 0, 20)
-	skipped := 0
-
-	// skip until we find "panic("
-	reader := strings.NewReader(string(stack))
-	for scanner := bufio.NewScanner(reader); scanner.Scan(); {
-		line := scanner.Text()
-
-		if plainStack {
-			lines = append(lines, line)
-
-		} else if skipped > 0 || strings.HasPrefix(line, "panic(") {
-			skipped++
-
-			if skipped >= 3 {
-				if len(opts[0].BuildPath) > 0 && strings.HasPrefix(line, "\t"+buildPath) {
-					line = "   " + line[2+len(buildPath):]
-				} else if !strings.HasPrefix(line, "\t") {
-					if parts := strings.SplitN(line, "/", -1); len(parts) > 1 {
-						line = parts[len(parts)-1]
-					}
-				}
+	var frames []runtime.Frame
+	if len(stack) > 0 {
+		frames = parseStack(stack)
+	}
+	if len(frames) == 0 {
+		frames = callerFrames(l.callerSkip, 0)
+	}
+	if !plainStack {
+		frames = panickingFrames(frames)
+	}
 
-				lines = append(lines, line)
+	formatFrame := opts[0].FormatFrame
+	if formatFrame == nil {
+		formatFrame = func(frame runtime.Frame) string {
+			if plainStack {
+				return fmt.Sprintf("%s()\n\t%s:%d", frame.Function, frame.File, frame.Line)
 			}
+			function := frame.Function[strings.LastIndexByte(frame.Function, '/')+1:]
+			if len(buildPath) > 0 && strings.HasPrefix(frame.File, buildPath) {
+				return fmt.Sprintf("%s()\n   %s:%d", function, strings.TrimPrefix(frame.File[len(buildPath):], "/"), frame.Line)
+			}
+			return fmt.Sprintf("%s()\n\t%s:%d", function, frame.File, frame.Line)
 		}
 	}
 
-	if plainStack {
-		l.Error("CRASH: %v\n%s", err, strings.Join(lines, "\n"))
+	lines := make([]string, 0, len(frames))
+	for _, frame := range frames {
+		lines = append(lines, formatFrame(frame))
+	}
 
-	} else {
-		l.Error("CRASH: %v\n   %s", err, strings.Join(lines, "\n   "))
+	// the stack is also attached to the record, for handlers treating it separately
+	crashLog := l.derive()
+	crashLog.stack = strings.Join(lines, "\n")
 
-		//for _, line := range lines {
-		//	l.Error(line)
-		//}
+	if plainStack {
+		crashLog.Error("CRASH: %v\n%s", err, crashLog.stack)
+	} else {
+		crashLog.Error("CRASH: %v\n   %s", err, strings.Replace(crashLog.stack, "\n", "\n   ", -1))
 	}
 
 	if exitCode != 0 {
-		Shutdown()
-		os.Exit(exitCode)
+		exit(exitCode)
 	}
 }
 
+// panickingFrames returns the frames from the function that panicked, i.e. skipping the deferred function
+// (and the runtime frames) above it, or all frames if there's no panic in progress.
+func panickingFrames(frames []runtime.Frame) []runtime.Frame {
+	for idx, frame := range frames {
+		if frame.Function == "runtime.gopanic" {
+			frames = frames[idx+1:]
+			// e.g. runtime.panicmem and runtime.sigpanic of a nil pointer dereference
+			for len(frames) > 1 && strings.HasPrefix(frames[0].Function, "runtime.") {
+				frames = frames[1:]
+			}
+			return frames
+		}
+	}
+	return frames
+}
+
 // ------------------------------------------------
 
 // Fatal logs message with FATAL level (also does os.Exit(1)), after flushing staged messages.
+// The functions registered by OnExit are run before exiting.
 func (l *Logger) Fatal(message string, args ...interface{}) {
+	l.FatalExit(1, message, args...)
+}
+
+// FatalExit is like Fatal, but exits with the code.
+func (l *Logger) FatalExit(code int, message string, args ...interface{}) {
 	l.flushStaged()
 
 	l.log(FATAL, false, message, args...)
 
-	Shutdown()
-	os.Exit(1)
+	exit(code)
 }
 
 // Error logs message with ERROR level, after flushing staged messages.
@@ -276,28 +779,45 @@ func (l *Logger) Error(message string, args ...interface{}) {
 
 // Warning logs message with WARNING level (clears staged messages).
 func (l *Logger) Warning(message string, args ...interface{}) {
-	l.staged = l.staged[:0]
 	l.log(WARNING, false, message, args...)
 }
 
 // Info logs message with INFO level (clears staged messages).
 func (l *Logger) Info(message string, args ...interface{}) {
-	l.staged = l.staged[:0]
 	l.log(INFO, false, message, args...)
 }
 
 // Debug logs message with DEBUG level (clears staged messages).
 func (l *Logger) Debug(message string, args ...interface{}) {
-	l.staged = l.staged[:0]
 	l.log(DEBUG, false, message, args...)
 }
 
 // Log logs message with given level (clears staged messages).
 func (l *Logger) Log(lvl Level, message string, args ...interface{}) {
-	l.staged = l.staged[:0]
+	if lvl >= ERROR {
+		l.clearStaged() // (below ERROR, by logRecord: unless escalated)
+	}
 	l.log(lvl, false, message, args...)
 }
 
+// LogTimed logs message with given level and the duration of an operation (clears staged messages).
+func (l *Logger) LogTimed(lvl Level, d time.Duration, message string, args ...interface{}) {
+	if lvl >= ERROR {
+		l.clearStaged() // (below ERROR, by logRecord: unless escalated)
+	}
+	l.logRecord(lvl, false, d, message, args)
+}
+
+// InfoTimed logs message with INFO level and the duration of an operation (clears staged messages).
+func (l *Logger) InfoTimed(d time.Duration, message string, args ...interface{}) {
+	l.LogTimed(INFO, d, message, args...)
+}
+
+// DebugTimed logs message with DEBUG level and the duration of an operation (clears staged messages).
+func (l *Logger) DebugTimed(d time.Duration, message string, args ...interface{}) {
+	l.LogTimed(DEBUG, d, message, args...)
+}
+
 // ------------------------------------------------
 
 // StageWarning stages a message with WARNING level, flushed by Error() or Fatal().
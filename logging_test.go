@@ -3,9 +3,21 @@ package log4go
 import (
 	"bufio"
 	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
 	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
 	"os"
+	"os/exec"
+	"path/filepath"
+	"reflect"
 	"regexp"
+	"runtime"
+	"runtime/debug"
 	"strings"
 	"sync"
 	"testing"
@@ -188,6 +200,34 @@ func TestTimeFormatUS(t *testing.T) {
 	}
 }
 
+func TestMonotonicTime(t *testing.T) {
+	handler := &recordingHandler{}
+	formatter, _ := NewTemplateFormatter("{monotime} {message}")
+	handler.SetFormatter(formatter)
+
+	BasicConfig(BasicConfigOpts{
+		Level:    INFO,
+		Handlers: []Handler{handler},
+	})
+
+	log := GetLogger("test")
+	log.Info("first")
+	time.Sleep(time.Millisecond)
+	log.Info("second")
+
+	Shutdown()
+
+	first, second := handler.records[0], handler.records[1]
+	if first.Monotonic <= 0 || second.Monotonic-first.Monotonic < time.Millisecond {
+		t.Errorf("unexpected monotonic times: %v, %v", first.Monotonic, second.Monotonic)
+	}
+
+	out, _ := formatter.Format(&Record{Monotonic: 1234567891 * time.Nanosecond, Message: "x"})
+	if string(out) != "1.234568 x" {
+		t.Errorf("unexpected output: %q", out)
+	}
+}
+
 func TestStaged(t *testing.T) {
 	var buf bytes.Buffer
 
@@ -227,6 +267,77 @@ func TestStaged(t *testing.T) {
 	}
 }
 
+func TestStagedConcurrent(t *testing.T) {
+	handler := &recordingHandler{}
+
+	BasicConfig(BasicConfigOpts{
+		Level:    DEBUG,
+		Handlers: []Handler{handler},
+	})
+
+	log := GetLogger("test")
+
+	var wg sync.WaitGroup
+	for n := 0; n < 8; n++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := 0; i < 100; i++ {
+				log.StageDebug("staged %d", i)
+				if i%10 == 9 {
+					log.Error("failed")
+				}
+			}
+			log.StageInfo("cleared")
+			log.Info("done")
+		}()
+	}
+	wg.Wait()
+	log.Error("flush remaining")
+
+	Shutdown()
+
+	// every record is either flushed (once) or cleared
+	counts := map[string]int{}
+	for _, rec := range handler.records {
+		counts[strings.Fields(rec.Message)[0]]++
+	}
+	if counts["staged"] > 800 || counts["failed"] != 80 || counts["done"] != 8 || counts["cleared"] > 8 {
+		t.Errorf("unexpected records: %v", counts)
+	}
+}
+
+func TestStagedHandler(t *testing.T) {
+	handler, context := &recordingHandler{}, NewRingBufferHandler(10)
+	formatter, _ := NewTemplateFormatter("{message}")
+	context.SetFormatter(formatter)
+
+	BasicConfig(BasicConfigOpts{
+		Level:    DEBUG,
+		Handlers: []Handler{handler},
+	})
+	GetLogger("test").AddHandler(handler) // i.e. records are normally staged twice
+
+	log := GetLogger("test").GetLogger("child")
+	if err := GetLogger("test").SetStagedHandler(context); err != nil {
+		t.Fatal(err)
+	}
+
+	log.StageInfo("connecting")
+	log.StageDebug("sending")
+	log.Error("failed")
+
+	Shutdown()
+
+	if len(handler.records) != 2 || handler.records[0].Message != "failed" {
+		t.Errorf("unexpected records: %+v", handler.records)
+	}
+	records := context.Records()
+	if len(records) != 2 || records[0].Message != "connecting" || records[1].Message != "sending" {
+		t.Errorf("unexpected staged records: %+v", records)
+	}
+}
+
 func TestStagedUnflushed(t *testing.T) {
 	var buf bytes.Buffer
 
@@ -282,6 +393,348 @@ func TestLevelFilter(t *testing.T) {
 	}
 }
 
+func TestLevelInheritance(t *testing.T) {
+	BasicConfig(BasicConfigOpts{
+		Level:  WARNING,
+		Writer: &bytes.Buffer{},
+	})
+
+	parent := GetLogger("parent")
+	child := parent.GetLogger("child")
+
+	if child.Level() != WARNING {
+		t.Errorf("expected child to inherit WARNING, got %s", LevelName(child.Level()))
+	}
+
+	// changing an ancestor's level must be seen by already created descendants
+	parent.SetLevel(DEBUG)
+	if child.Level() != DEBUG {
+		t.Errorf("expected child to inherit DEBUG, got %s", LevelName(child.Level()))
+	}
+
+	child.SetLevel(ERROR)
+	GetLogger().SetLevel(TRACE)
+	if child.Level() != ERROR {
+		t.Errorf("expected child to keep ERROR, got %s", LevelName(child.Level()))
+	}
+	if parent.Level() != DEBUG {
+		t.Errorf("expected parent to keep DEBUG, got %s", LevelName(parent.Level()))
+	}
+
+	child.SetLevel(INHERIT)
+	if child.Level() != DEBUG {
+		t.Errorf("expected child to inherit DEBUG again, got %s", LevelName(child.Level()))
+	}
+
+	Shutdown()
+}
+
+func TestLevelResolver(t *testing.T) {
+	BasicConfig(BasicConfigOpts{
+		Level:  WARNING,
+		Writer: &bytes.Buffer{},
+	})
+
+	levels := map[string]Level{"db": DEBUG}
+	SetLevelResolver(func(name string) (Level, bool) {
+		lvl, ok := levels[name]
+		return lvl, ok
+	})
+	defer SetLevelResolver(nil)
+
+	db := GetLogger("db")
+	pool := db.GetLogger("pool")
+	if db.Level() != DEBUG || pool.Level() != DEBUG {
+		t.Errorf("expected resolved DEBUG, got %s, %s", LevelName(db.Level()), LevelName(pool.Level()))
+	}
+	if GetLogger("web").Level() != WARNING {
+		t.Errorf("expected unresolved logger to inherit WARNING, got %s", LevelName(GetLogger("web").Level()))
+	}
+
+	// an explicitly set level takes precedence
+	pool.SetLevel(ERROR)
+	if pool.Level() != ERROR {
+		t.Errorf("expected ERROR, got %s", LevelName(pool.Level()))
+	}
+
+	levels["db"] = TRACE
+	RefreshLevels()
+	if db.Level() != TRACE {
+		t.Errorf("expected refreshed TRACE, got %s", LevelName(db.Level()))
+	}
+
+	Shutdown()
+}
+
+func TestLazyArgs(t *testing.T) {
+	var buf bytes.Buffer
+
+	BasicConfig(BasicConfigOpts{
+		Level:  INFO,
+		Writer: &buf,
+		Format: "{message}",
+	})
+
+	log := GetLogger("test")
+
+	evaluated := 0
+	expensive := func() interface{} {
+		evaluated++
+		return "expensive"
+	}
+
+	log.Debug("not emitted: %v", Lazy(expensive))
+	log.Info("emitted: %v %v", Lazy(expensive), expensive)
+
+	Shutdown()
+
+	if evaluated != 2 {
+		t.Errorf("expected 2 evaluations, got %d", evaluated)
+	}
+	if line := strings.TrimSpace(buf.String()); line != "emitted: expensive expensive" {
+		t.Errorf("unexpected output: %q", line)
+	}
+}
+
+func TestFormattingPanic(t *testing.T) {
+	var buf bytes.Buffer
+
+	BasicConfig(BasicConfigOpts{
+		Level:  INFO,
+		Writer: &buf,
+		Format: "{message}",
+	})
+
+	log := GetLogger("test")
+
+	log.Info("value: %d %s", 42, Lazy(func() interface{} { panic("boom") }))
+	log.Info("still alive")
+
+	Shutdown()
+
+	output := buf.String()
+	if !strings.Contains(output, "formatting failed: boom") || !strings.Contains(output, `"value: %d %s"`) {
+		t.Errorf("formatting error not reported: %q", output)
+	}
+	if !strings.Contains(output, "int, log4go.Lazy") {
+		t.Errorf("argument types not reported: %q", output)
+	}
+	if !strings.Contains(output, "still alive") {
+		t.Errorf("logging did not continue: %q", output)
+	}
+}
+
+func TestExtendConfig(t *testing.T) {
+	var appBuf, libBuf bytes.Buffer
+
+	BasicConfig(BasicConfigOpts{
+		Level:  WARNING,
+		Writer: &appBuf,
+		Format: "{name} {message}",
+	})
+
+	err := ExtendConfig(BasicConfigOpts{
+		Logger: "lib",
+		Level:  DEBUG,
+		Writer: &libBuf,
+		Format: "{name} {message}",
+	})
+	if err != nil {
+		t.Fatalf("ExtendConfig failed: %v", err)
+	}
+
+	GetLogger("lib").Debug("lib message")
+	GetLogger("app").Debug("app message")
+	GetLogger("app").Warning("app warning")
+
+	Shutdown()
+
+	if app := appBuf.String(); app != "lib lib message\napp app warning\n" {
+		t.Errorf("unexpected root output: %q", app)
+	}
+	if lib := libBuf.String(); lib != "lib lib message\n" {
+		t.Errorf("unexpected lib output: %q", lib)
+	}
+}
+
+func TestDurationFormat(t *testing.T) {
+	for _, tc := range []struct {
+		d        time.Duration
+		expected string
+	}{
+		{850 * time.Nanosecond, "850ns"},
+		{12500 * time.Nanosecond, "12.5µs"},
+		{3200 * time.Microsecond, "3.2ms"},
+		{1250 * time.Millisecond, "1.25s"},
+		{-2 * time.Millisecond, "-2.0ms"},
+	} {
+		if s := formatDuration(tc.d); s != tc.expected {
+			t.Errorf("formatDuration(%v): expected %q, got %q", tc.d, tc.expected, s)
+		}
+	}
+
+	var buf bytes.Buffer
+
+	BasicConfig(BasicConfigOpts{
+		Level:  INFO,
+		Writer: &buf,
+		Format: "{message} {duration}",
+	})
+
+	GetLogger("test").InfoTimed(42*time.Millisecond, "request %d done", 7)
+
+	Shutdown()
+
+	if line := buf.String(); line != "request 7 done 42.0ms\n" {
+		t.Errorf("unexpected output: %q", line)
+	}
+}
+
+func TestTimeOperation(t *testing.T) {
+	var buf bytes.Buffer
+
+	BasicConfig(BasicConfigOpts{
+		Level:  DEBUG,
+		Writer: &buf,
+		Format: "{level} {message}",
+	})
+
+	log := GetLogger("test")
+
+	func() {
+		defer log.TimeOperation("rebuild index", TimeOpts{FinishLevel: WARNING})()
+
+		sw := log.Stopwatch("import")
+		sw.Lap("parsed")
+		sw.Stop()
+	}()
+
+	Shutdown()
+
+	ptn := regexp.MustCompile(`^DEBUG rebuild index: started
+DEBUG import: started
+INFO import: parsed \([0-9.]+(ns|µs|ms|s)\)
+INFO import: finished \([0-9.]+(ns|µs|ms|s)\)
+WARNING rebuild index: finished \([0-9.]+(ns|µs|ms|s)\)
+$`)
+	if !ptn.MatchString(buf.String()) {
+		t.Errorf("unexpected output: %q", buf.String())
+	}
+}
+
+func TestWithFields(t *testing.T) {
+	handler := &recordingHandler{}
+	formatter, _ := NewTemplateFormatter("{message}")
+	handler.SetFormatter(formatter)
+
+	BasicConfig(BasicConfigOpts{
+		Level:    INFO,
+		Handlers: []Handler{handler},
+	})
+
+	log := GetLogger("test")
+	withUser := log.With(Fields{"user": "bob", "id": 1})
+	withBoth := withUser.With(Fields{"id": 2, "request": "abc"})
+
+	withBoth.Info("first")
+	log.Info("second")
+
+	// configuration changes made through a derived logger apply to the original
+	withUser.SetLevel(ERROR)
+	if log.Level() != ERROR {
+		t.Errorf("expected level ERROR, got %s", LevelName(log.Level()))
+	}
+
+	Shutdown()
+
+	if len(handler.records) != 2 {
+		t.Fatalf("expected 2 records, got %d", len(handler.records))
+	}
+	first := handler.records[0]
+	if first.Name != "test" || first.Fields["user"] != "bob" || first.Fields["id"] != 2 || first.Fields["request"] != "abc" {
+		t.Errorf("unexpected first record: %+v", first)
+	}
+	if second := handler.records[1]; second.Fields != nil {
+		t.Errorf("unexpected fields in second record: %+v", second.Fields)
+	}
+	if withUser.Fields()["id"] != 1 {
+		t.Errorf("fields of a derived logger were modified: %v", withUser.Fields())
+	}
+}
+
+func TestInheritedFields(t *testing.T) {
+	handler := &recordingHandler{}
+	BasicConfig(BasicConfigOpts{
+		Level:    INFO,
+		Handlers: []Handler{handler},
+	})
+	defer Reset()
+
+	parent := GetLogger("svc").With(Fields{"request": "abc", "user": "bob"})
+	child := parent.GetLogger("db").With(Fields{"user": "alice", "table": "orders"})
+	child.GetLogger("pool").Info("grandchild")
+	child.Info("child")
+	GetLogger("svc").GetLogger("db").Info("plain")
+
+	if child.Level() != INFO || GetLogger("svc/db").Fields() != nil {
+		t.Errorf("expected the sub-logger to be the one in the tree")
+	}
+	if len(handler.records) != 3 {
+		t.Fatalf("expected 3 records, got %d", len(handler.records))
+	}
+	grandchild := handler.records[0]
+	if grandchild.Name != "svc/db/pool" || grandchild.Fields["request"] != "abc" || grandchild.Fields["user"] != "alice" {
+		t.Errorf("unexpected grandchild record: %s %v", grandchild.Name, grandchild.Fields)
+	}
+	if fields := handler.records[1].Fields; fields["request"] != "abc" || fields["user"] != "alice" || fields["table"] != "orders" {
+		t.Errorf("unexpected child fields: %v", fields)
+	}
+	if fields := handler.records[2].Fields; fields != nil {
+		t.Errorf("unexpected fields: %v", fields)
+	}
+	if parent.Fields()["user"] != "bob" {
+		t.Errorf("fields of the parent were modified: %v", parent.Fields())
+	}
+
+	// the other attributes are inherited too
+	handler.records = nil
+	tagged := GetLogger("svc").Tagged("billing").WithColor("green").WithCallerSkip(1)
+	sub := tagged.GetLogger("db")
+	sub.Info("tagged")
+	if sub.callerSkip != 1 || sub.node() != GetLogger("svc/db") {
+		t.Errorf("unexpected sub-logger: %d %s", sub.callerSkip, sub.node().name)
+	}
+	if rec := handler.records[0]; rec.Name != "svc/db" || !rec.HasTag("billing") || rec.Color != "green" {
+		t.Errorf("unexpected record: %s %v %q", rec.Name, rec.Tags, rec.Color)
+	}
+}
+
+func TestMetricsHook(t *testing.T) {
+	BasicConfig(BasicConfigOpts{
+		Level:  DEBUG,
+		Writer: &bytes.Buffer{},
+	})
+
+	counts := map[string]int{}
+	SetMetricsHook(func(logger string, level Level) {
+		counts[logger+":"+LevelName(level)]++
+	})
+	defer SetMetricsHook(nil)
+
+	log := GetLogger("test").GetLogger("sub")
+	log.Info("not counted")
+	log.Warning("counted")
+	log.Error("counted")
+	log.Error("counted")
+	log.StageDebug("not counted")
+
+	Shutdown()
+
+	if len(counts) != 2 || counts["test/sub:WARNING"] != 1 || counts["test/sub:ERROR"] != 2 {
+		t.Errorf("unexpected counts: %v", counts)
+	}
+}
+
 func TestNoHandlers(t *testing.T) {
 	var buf bytes.Buffer
 
@@ -347,6 +800,48 @@ func TestMulti(t *testing.T) {
 	}
 }
 
+func TestConcurrentReconfigure(t *testing.T) {
+	var buf bytes.Buffer
+
+	BasicConfig(BasicConfigOpts{
+		Level:  DEBUG,
+		Writer: &buf,
+	})
+
+	width := 20
+
+	wg := &sync.WaitGroup{}
+	wg.Add(width + 1)
+
+	for idx := 0; idx < width; idx++ {
+		go func(idx int) {
+			log := GetLogger("test").GetLogger(fmt.Sprintf("sub%d", idx))
+			for n := 0; n < 100; n++ {
+				log.Debug("test message %d", n)
+			}
+			wg.Done()
+		}(idx)
+	}
+
+	// meanwhile, reconfigure the tree
+	go func() {
+		log := GetLogger("test")
+		handler, _ := NewStreamHandler(&bytes.Buffer{})
+		formatter, _ := NewTemplateFormatter("{message}")
+		handler.SetFormatter(formatter)
+		for n := 0; n < 100; n++ {
+			log.SetLevel(Level(n%int(FATAL) + 1))
+			log.AddHandler(handler)
+			log.RemoveHandlers()
+		}
+		wg.Done()
+	}()
+
+	wg.Wait()
+
+	Shutdown()
+}
+
 func BenchmarkAllLogged(b *testing.B) {
 	BasicConfig(BasicConfigOpts{
 		Level:    WARNING, // thus all info-logs below will not be output
@@ -387,6 +882,40 @@ func BenchmarkNoneLogged(b *testing.B) {
 	//printPerf(b.N, duration)
 }
 
+func BenchmarkGetLogger(b *testing.B) {
+	BasicConfig(BasicConfigOpts{
+		FileName: "/dev/null",
+	})
+	GetLogger("test").GetLogger("child")
+
+	b.ReportAllocs()
+	b.RunParallel(func(pb *testing.PB) {
+		for pb.Next() {
+			GetLogger("test").GetLogger("child")
+		}
+	})
+
+	Shutdown()
+}
+
+func BenchmarkIsEnabled(b *testing.B) {
+	BasicConfig(BasicConfigOpts{
+		Level:    WARNING,
+		FileName: "/dev/null",
+	})
+
+	log := GetLogger("test")
+
+	b.ReportAllocs()
+	for idx := 0; idx < b.N; idx++ {
+		if log.IsDebugEnabled() {
+			b.Fatal("DEBUG is not enabled")
+		}
+	}
+
+	Shutdown()
+}
+
 func BenchmarkMultiAllLogged(b *testing.B) {
 	BasicConfig(BasicConfigOpts{
 		Level:    DEBUG,
@@ -420,6 +949,27 @@ func BenchmarkMultiAllLogged(b *testing.B) {
 	printPerf(width*b.N, duration)
 }
 
+// recordingHandler keeps all handled records (synchronously).
+type recordingHandler struct {
+	lock      sync.Mutex
+	records   []Record
+	formatter Formatter
+	level     Level
+}
+
+func (h *recordingHandler) Handle(rec *Record) error {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	h.records = append(h.records, *rec)
+	return nil
+}
+
+func (h *recordingHandler) SetFormatter(formatter Formatter) { h.formatter = formatter }
+func (h *recordingHandler) Formatter() Formatter             { return h.formatter }
+func (h *recordingHandler) SetLevel(level Level)             { h.level = level }
+func (h *recordingHandler) Level() Level                     { return h.level }
+func (h *recordingHandler) Shutdown()                        {}
+
 func printPerf(n int, d time.Duration) {
 	secs := d.Seconds()
 
@@ -450,3 +1000,1387 @@ func print_last_lines(t *testing.T, buf bytes.Buffer, count int) {
 	}
 	t.Error("END content")
 }
+
+func TestRunCommand(t *testing.T) {
+	if _, err := exec.LookPath("sh"); err != nil {
+		t.Skip("no shell available")
+	}
+
+	var buf bytes.Buffer
+
+	BasicConfig(BasicConfigOpts{
+		Level:  DEBUG,
+		Writer: &buf,
+		Format: "{level} {message}",
+	})
+
+	cmd := exec.Command("sh", "-c", `echo "[12:00] one"; echo "[12:01] two" >&2; printf "three"`)
+	err := GetLogger("child").RunCommand(cmd, CaptureOpts{
+		Strip: regexp.MustCompile(`^\[[0-9:]+\] `),
+	})
+	if err != nil {
+		t.Fatalf("RunCommand failed: %v", err)
+	}
+
+	Shutdown()
+
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	seen := map[string]bool{}
+	for _, line := range lines {
+		seen[line] = true
+	}
+	if len(lines) != 3 || !seen["INFO one"] || !seen["WARNING two"] || !seen["INFO three"] {
+		t.Errorf("unexpected output: %q", buf.String())
+	}
+}
+
+func TestPrintCompat(t *testing.T) {
+	var buf bytes.Buffer
+
+	BasicConfig(BasicConfigOpts{
+		Level:  INFO,
+		Writer: &buf,
+		Format: "{level} {message}",
+	})
+
+	log := GetLogger("test")
+	log.Print("a", 1, 2, "b")
+	log.Printf("%d%%", 50)
+	log.Println("a", 1, 2, "b")
+
+	func() {
+		defer func() {
+			if r := recover(); r != "failed: 42" {
+				t.Errorf("unexpected panic value: %v", r)
+			}
+		}()
+		log.Panicf("failed: %d", 42)
+	}()
+
+	Shutdown()
+
+	if out := buf.String(); out != "INFO a1 2b\nINFO 50%\nINFO a 1 2 b\nERROR failed: 42\n" {
+		t.Errorf("unexpected output: %q", out)
+	}
+}
+
+func TestDebugHandler(t *testing.T) {
+	BasicConfig(BasicConfigOpts{
+		Level:  INFO,
+		Writer: &bytes.Buffer{},
+	})
+	GetLogger("db").GetLogger("pool").SetLevel(DEBUG)
+
+	resp := httptest.NewRecorder()
+	DebugHandler().ServeHTTP(resp, httptest.NewRequest(http.MethodGet, "/debug/log4go", nil))
+
+	var state LoggerState
+	if err := json.Unmarshal(resp.Body.Bytes(), &state); err != nil {
+		t.Fatal(err)
+	}
+	if state.Name != "root" || state.EffectiveLevel != "INFO" || len(state.Handlers) != 1 || !state.Handlers[0].Healthy {
+		t.Errorf("unexpected root state: %+v", state)
+	}
+	if len(state.Children) != 1 || len(state.Children[0].Children) != 1 {
+		t.Fatalf("unexpected tree: %+v", state)
+	}
+	if pool := state.Children[0].Children[0]; pool.Name != "db/pool" || pool.Level != "DEBUG" {
+		t.Errorf("unexpected child state: %+v", pool)
+	}
+
+	Shutdown()
+}
+
+func TestShutdownReset(t *testing.T) {
+	var buf bytes.Buffer
+
+	BasicConfig(BasicConfigOpts{
+		Level:  INFO,
+		Writer: &buf,
+	})
+	log := GetLogger("test")
+	log.Info("before")
+
+	Shutdown()
+	Shutdown()
+	log.Info("after shutdown") // dropped, must not panic
+
+	if !strings.Contains(buf.String(), "before") || strings.Contains(buf.String(), "after shutdown") {
+		t.Errorf("unexpected output: %q", buf.String())
+	}
+
+	SetLevelResolver(func(string) (Level, bool) { return ERROR, true })
+	Reset()
+	Reset()
+
+	if GetLogger("test") == log {
+		t.Error("expected a new logger after Reset")
+	}
+	if GetLogger("test").Level() != WARNING {
+		t.Errorf("expected default level after Reset, got %s", LevelName(GetLogger("test").Level()))
+	}
+
+	for idx := 0; idx < 3; idx++ {
+		BasicConfig(BasicConfigOpts{Writer: &bytes.Buffer{}})
+		GetLogger("test").Warning("cycle %d", idx)
+		Shutdown()
+	}
+}
+
+func TestStackCapture(t *testing.T) {
+	handler := &recordingHandler{}
+	formatter, _ := NewTemplateFormatter("{message}{stack}")
+	handler.SetFormatter(formatter)
+
+	BasicConfig(BasicConfigOpts{
+		Level:    INFO,
+		Handlers: []Handler{handler},
+	})
+
+	parent := GetLogger("parent")
+	parent.SetStackCapture(ERROR, 2)
+	child := parent.GetLogger("child")
+
+	child.Warning("no stack")
+	child.Error("with stack")
+	parent.SetStackCapture(INHERIT, 0)
+	child.Error("no stack either")
+
+	Shutdown()
+
+	if len(handler.records[0].Stack) != 0 || len(handler.records[2].Stack) != 0 {
+		t.Errorf("unexpected stacks: %q, %q", handler.records[0].Stack, handler.records[2].Stack)
+	}
+	stack := handler.records[1].Stack
+	lines := strings.Split(stack, "\n")
+	if len(lines) != 4 || !strings.HasSuffix(lines[0], ".TestStackCapture()") || !strings.Contains(lines[1], "logging_test.go:") {
+		t.Errorf("unexpected stack: %q", stack)
+	}
+	out, _ := formatter.Format(&handler.records[1])
+	if string(out) != "with stack\n"+stack {
+		t.Errorf("unexpected output: %q", out)
+	}
+}
+
+func TestCrash(t *testing.T) {
+	handler := &recordingHandler{}
+	handler.SetFormatter(&ECSFormatter{})
+
+	BasicConfig(BasicConfigOpts{
+		Level:    INFO,
+		Handlers: []Handler{handler},
+	})
+
+	crashing := func() {
+		defer func() {
+			if err := recover(); err != nil {
+				GetLogger("test").Crash(err, nil, CrashOpts{
+					FormatFrame: func(frame runtime.Frame) string {
+						return frame.Function[strings.LastIndexByte(frame.Function, '.')+1:]
+					},
+				})
+			}
+		}()
+		var m map[string]int
+		m["boom"] = 1
+	}
+	crashing()
+
+	Shutdown()
+
+	rec := handler.records[0]
+	lines := strings.Split(rec.Stack, "\n")
+	// the stack starts at the function that panicked (i.e. an anonymous function in the test function)
+	if len(lines) < 2 || lines[0] != "func1" || lines[1] != "TestCrash" {
+		t.Errorf("unexpected stack: %q", rec.Stack)
+	}
+	if !strings.HasPrefix(rec.Message, "CRASH: assignment to entry in nil map\n   func1\n   TestCrash") {
+		t.Errorf("unexpected message: %q", rec.Message)
+	}
+}
+
+func TestCrashStack(t *testing.T) {
+	handler := &recordingHandler{}
+	handler.SetFormatter(&ECSFormatter{})
+
+	BasicConfig(BasicConfigOpts{
+		Level:    INFO,
+		Handlers: []Handler{handler},
+	})
+
+	// recovered in another goroutine, crashing in this one
+	stacks := make(chan []byte)
+	go func() {
+		defer func() {
+			recover()
+			stacks <- debug.Stack()
+		}()
+		var m map[string]int
+		m["boom"] = 1
+	}()
+	GetLogger("test").Crash("worker failed", <-stacks, CrashOpts{
+		FormatFrame: func(frame runtime.Frame) string {
+			return fmt.Sprintf("%s:%t", frame.Function[strings.LastIndexByte(frame.Function, '.')+1:], frame.Line > 0)
+		},
+	})
+
+	Shutdown()
+
+	// the stack of the goroutine, starting at the function that panicked
+	if stack := handler.records[0].Stack; stack != "func1:true" {
+		t.Errorf("unexpected stack: %q", stack)
+	}
+}
+
+func TestBuildInfo(t *testing.T) {
+	if GetBuildInfo().GoVersion != runtime.Version() {
+		t.Errorf("unexpected build info: %+v", GetBuildInfo())
+	}
+
+	saved := buildInfo
+	defer func() { buildInfo = saved }()
+	buildInfo = BuildInfo{Path: "example.com/app", Version: "v1.2.3", Revision: "0123abc", Dirty: true, GoVersion: "go1.20"}
+
+	handler := &recordingHandler{}
+	formatter, _ := NewTemplateFormatter("{version} {revision} {message}")
+	handler.SetFormatter(formatter)
+
+	BasicConfig(BasicConfigOpts{
+		Level:    INFO,
+		Handlers: []Handler{handler},
+	})
+
+	GetLogger("app").LogStartup()
+
+	Shutdown()
+
+	out, _ := formatter.Format(&handler.records[0])
+	if string(out) != "v1.2.3 0123abc starting example.com/app v1.2.3 (revision 0123abc (dirty), go1.20)" {
+		t.Errorf("unexpected output: %q", out)
+	}
+	if handler.records[0].Fields["revision"] != "0123abc" {
+		t.Errorf("unexpected fields: %v", handler.records[0].Fields)
+	}
+}
+
+func TestParseLevel(t *testing.T) {
+	for name, expected := range map[string]Level{"info": INFO, "WARN": WARNING, "Error": ERROR} {
+		if level, err := ParseLevel(name); err != nil || level != expected {
+			t.Errorf("%s: unexpected level %v (%v)", name, LevelName(level), err)
+		}
+	}
+	if _, err := ParseLevel("loud"); err == nil {
+		t.Error("expected error")
+	}
+}
+
+func TestFlags(t *testing.T) {
+	fs := flag.NewFlagSet("test", flag.ContinueOnError)
+	fs.SetOutput(ioutil.Discard)
+	flags := RegisterFlags(fs, "log")
+
+	if err := fs.Parse([]string{"-log-level", "debug", "-log-color", "-log-format", "{message}"}); err != nil {
+		t.Fatal(err)
+	}
+	opts := flags.BasicConfigOpts()
+	if opts.Level != DEBUG || !opts.Color || opts.Format != "{message}" || opts.FileName != "" {
+		t.Errorf("unexpected options: %+v", opts)
+	}
+
+	if err := fs.Parse([]string{"-log-level", "loud"}); err == nil {
+		t.Error("expected error")
+	}
+}
+
+func TestWatchConfig(t *testing.T) {
+	dir, err := ioutil.TempDir("", "log4go")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	configFile := filepath.Join(dir, "log4go.json")
+	logFile := filepath.Join(dir, "app.log")
+
+	writeConfig := func(dbLevel string) {
+		config := fmt.Sprintf(`{
+			"level": "INFO",
+			"format": "{name} {level} {message}",
+			"handlers": {"file": {"type": "file", "file": %q, "sync": true}},
+			"loggers": {"root": {"handlers": ["file"]}, "db": {"level": %q}}
+		}`, logFile, dbLevel)
+		if err := ioutil.WriteFile(configFile, []byte(config), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	BasicConfig(BasicConfigOpts{Writer: ioutil.Discard})
+	db := GetLogger("db") // retrieved before, i.e. must keep working
+	defer Reset()
+
+	writeConfig("DEBUG")
+	watcher, err := WatchConfig(configFile, 10*time.Millisecond)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer watcher.Stop()
+
+	db.Debug("connecting")
+
+	writeConfig("ERROR")
+	future := time.Now().Add(time.Minute) // make sure the change is noticed
+	os.Chtimes(configFile, future, future)
+
+	expected := "db DEBUG connecting\n" +
+		"log4go INFO config " + configFile + " applied: level of db: DEBUG -> ERROR\n"
+	var content []byte
+	for start := time.Now(); time.Since(start) < 2*time.Second; time.Sleep(10 * time.Millisecond) {
+		if content, _ = ioutil.ReadFile(logFile); string(content) == expected {
+			break
+		}
+	}
+	if string(content) != expected {
+		t.Errorf("unexpected log: %q", content)
+	}
+
+	db.Info("filtered")
+	if level := db.Level(); level != ERROR {
+		t.Errorf("unexpected level: %s", LevelName(level))
+	}
+
+	// invalid configs are not applied
+	if err := ApplyConfig(&Config{Loggers: map[string]LoggerConfig{"db": {Handlers: []string{"missing"}}}}); err == nil {
+		t.Error("expected error")
+	}
+	if level := db.Level(); level != ERROR {
+		t.Errorf("unexpected level: %s", LevelName(level))
+	}
+}
+
+func TestPrefixSuffix(t *testing.T) {
+	handler := &recordingHandler{}
+
+	BasicConfig(BasicConfigOpts{
+		Level:    INFO,
+		Handlers: []Handler{handler},
+	})
+
+	parent := GetLogger("parent")
+	parent.SetPrefix("[billing] ")
+	child := parent.GetLogger("child")
+	child.SetSuffix(" (beta)")
+
+	parent.Info("invoice sent")
+	child.With(Fields{"id": 1}).Info("refund issued")
+	parent.SetPrefix("")
+	child.Info("undecorated")
+	GetLogger("other").Info("plain")
+
+	Shutdown()
+
+	var messages []string
+	for _, rec := range handler.records {
+		messages = append(messages, rec.Message)
+	}
+	expected := []string{"[billing] invoice sent", "[billing] refund issued (beta)", "undecorated (beta)", "plain"}
+	if strings.Join(messages, "|") != strings.Join(expected, "|") {
+		t.Errorf("unexpected messages: %q", messages)
+	}
+}
+
+func TestGetLoggerAuto(t *testing.T) {
+	for _, test := range []struct{ pkg, module, expected string }{
+		{"github.com/acme/app/db", "github.com/acme/app", "app/db"},
+		{"main", "github.com/acme/app", "app"},
+		{"github.com/other/lib/cache", "github.com/acme/app", "lib/cache"},
+		{"example.com/lib/cache", "github.com/acme/app", "lib/cache"},
+		{"app/db", "app", "app/db"},
+	} {
+		if name := packageLoggerName(test.pkg, test.module); name != test.expected {
+			t.Errorf("%s (in %s): unexpected name %q", test.pkg, test.module, name)
+		}
+	}
+	if pkg := funcPackage("github.com/acme/app/db.(*Store).Open"); pkg != "github.com/acme/app/db" {
+		t.Errorf("unexpected package: %q", pkg)
+	}
+
+	logger := GetLoggerAuto()
+	if logger.name != "log4go" || logger != GetLogger("log4go") {
+		t.Errorf("unexpected logger: %q", logger.name)
+	}
+}
+
+func TestSourceLevel(t *testing.T) {
+	handler := &recordingHandler{}
+
+	BasicConfig(BasicConfigOpts{
+		Level:    WARNING,
+		Handlers: []Handler{handler},
+	})
+	defer Reset()
+
+	log := GetLogger("test")
+	log.Debug("disabled")
+
+	SetSourceLevel("neonrust/log4go", INFO)
+	log.Debug("still disabled")
+	log.Info("enabled by package")
+
+	SetSourceLevel("neonrust/log4go", INHERIT)
+	SetSourceLevel("other.go", DEBUG)
+	log.Info("disabled again")
+
+	SetSourceLevel("logging_test.go", DEBUG)
+	log.Debug("enabled by file")
+
+	Shutdown()
+
+	var messages []string
+	for _, rec := range handler.records {
+		messages = append(messages, rec.Message)
+	}
+	if strings.Join(messages, "|") != "enabled by package|enabled by file" {
+		t.Errorf("unexpected messages: %q", messages)
+	}
+}
+
+func TestGetLoggerConcurrent(t *testing.T) {
+	BasicConfig(BasicConfigOpts{Writer: ioutil.Discard})
+	defer Shutdown()
+
+	var wg sync.WaitGroup
+	found := make([]*Logger, 8)
+	for n := range found {
+		wg.Add(1)
+		go func(n int) {
+			defer wg.Done()
+			for i := 0; i < 100; i++ {
+				found[n] = GetLogger("a").GetLogger(fmt.Sprint(i % 10)).GetLogger("b")
+			}
+		}(n)
+	}
+	wg.Wait()
+
+	for _, logger := range found {
+		if logger != found[0] || logger.name != "a/9/b" || logger != loggerByName("a/9/b") {
+			t.Errorf("unexpected logger: %q", logger.name)
+		}
+	}
+}
+
+func TestNamedArgs(t *testing.T) {
+	handler := &recordingHandler{}
+
+	BasicConfig(BasicConfigOpts{
+		Level:    INFO,
+		Handlers: []Handler{handler},
+	})
+
+	log := GetLogger("test").With(Fields{"service": "auth", "ip": "overridden"})
+	log.Info("user {user} logged in from {ip} ({unknown})", Args{
+		"user": "bob",
+		"ip":   Lazy(func() interface{} { return "10.0.0.1" }),
+	})
+	log.Info("panicking {value}", Args{"value": Lazy(func() interface{} { panic("oops") })})
+
+	Shutdown()
+
+	rec := handler.records[0]
+	if rec.Message != "user bob logged in from 10.0.0.1 ({unknown})" {
+		t.Errorf("unexpected message: %q", rec.Message)
+	}
+	if len(rec.Fields) != 3 || rec.Fields["user"] != "bob" || rec.Fields["ip"] != "10.0.0.1" || rec.Fields["service"] != "auth" {
+		t.Errorf("unexpected fields: %v", rec.Fields)
+	}
+	if !strings.Contains(handler.records[1].Message, "oops") {
+		t.Errorf("unexpected message: %q", handler.records[1].Message)
+	}
+}
+
+func TestValidate(t *testing.T) {
+	dir, err := ioutil.TempDir("", "log4go")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	filename := filepath.Join(dir, "app.log")
+
+	BasicConfig(BasicConfigOpts{Level: INFO, FileName: filename})
+	defer Shutdown()
+
+	if problems := Validate(); len(problems) != 0 {
+		t.Errorf("unexpected problems: %q", problems)
+	}
+
+	// the same file again, on another logger
+	ExtendConfig(BasicConfigOpts{Logger: "db", FileName: filename})
+
+	// a handler without formatter, at WARNING
+	noFormatter := &recordingHandler{level: WARNING}
+	audit := GetLogger("audit")
+	audit.SetLevel(DEBUG)
+	audit.handlers.Store([]Handler{noFormatter})
+
+	// a cycle
+	router := NewRouterHandler()
+	memory := NewMemoryHandler(10, ERROR, router)
+	router.routes = []Route{{Handler: memory}}
+	GetLogger("cycle").handlers.Store([]Handler{memory})
+
+	// unreachable levels
+	GetLogger().ownHandlers()[0].SetLevel(WARNING)
+
+	expected := []string{
+		"*log4go.MemoryHandler (on logger cycle) passes records on to itself",
+		"*log4go.recordingHandler (on logger audit) has no formatter",
+		"file " + filename + " is written by 2 handlers (on loggers root, db)",
+		"logger audit (level DEBUG): records below WARNING are dropped by all its handlers",
+		"logger root (level INFO): records below WARNING are dropped by all its handlers",
+	}
+	problems := Validate()
+	if strings.Join(problems, "\n") != strings.Join(expected, "\n") {
+		t.Errorf("unexpected problems: %q", problems)
+	}
+	GetLogger("cycle").handlers.Store([]Handler{})
+}
+
+func TestConfigTOML(t *testing.T) {
+	config, err := ParseConfigTOML([]byte(`
+# logging
+level = "INFO"
+format = '{time} {level} {message}'
+
+[handlers.console]
+type = "stream"
+target = "stdout"
+color = true
+
+[handlers.file]
+type = "file"
+file = "app.log"
+append = false
+level = "WARNING"
+
+[loggers]
+root = {handlers = ["console", "file"]}
+"db/pool".level = "DEBUG"
+audit = { level = "ERROR", handlers = [
+	"file",  # also on file
+] }
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	noAppend := false
+	expected := &Config{
+		Level:  "INFO",
+		Format: "{time} {level} {message}",
+		Handlers: map[string]HandlerConfig{
+			"console": {Type: "stream", Target: "stdout", Color: true},
+			"file":    {Type: "file", File: "app.log", Append: &noAppend, Level: "WARNING"},
+		},
+		Loggers: map[string]LoggerConfig{
+			"root":    {Handlers: []string{"console", "file"}},
+			"db/pool": {Level: "DEBUG"},
+			"audit":   {Level: "ERROR", Handlers: []string{"file"}},
+		},
+	}
+	if !reflect.DeepEqual(config, expected) {
+		t.Errorf("unexpected config: %+v", config)
+	}
+
+	// embedded in a larger document
+	config, err = ConfigFromMap(map[string]interface{}{"level": "DEBUG", "loggers": map[string]interface{}{"db": map[string]interface{}{"level": "INFO"}}})
+	if err != nil || config.Level != "DEBUG" || config.Loggers["db"].Level != "INFO" {
+		t.Errorf("unexpected config: %+v (%v)", config, err)
+	}
+
+	for _, invalid := range []string{"level = ", "[handlers", "level = \"INFO\"\nlevel = \"DEBUG\"", "levle = \"INFO\"", "[[handlers]]", "x = 1979-05-27"} {
+		if _, err := ParseConfigTOML([]byte(invalid)); err == nil {
+			t.Errorf("%q: expected error", invalid)
+		}
+	}
+}
+
+func TestCapture(t *testing.T) {
+	handler := &recordingHandler{}
+
+	BasicConfig(BasicConfigOpts{
+		Level:    WARNING,
+		Handlers: []Handler{handler},
+	})
+
+	capture := NewCapture(DEBUG, 3)
+	ctx := NewContext(context.Background(), GetLogger("http").WithCapture(capture))
+
+	// e.g. in the request handler
+	log := FromContext(ctx).With(Fields{"request": 1})
+	log.Debug("parsing")
+	log.Warning("slow backend")
+	GetLogger("http").Warning("not captured")
+	log.StageInfo("staged, not captured")
+	log.Info("validating")
+	log.Info("beyond max")
+
+	emitted := NewCapture(INHERIT, 0)
+	GetLogger("http").WithCapture(emitted).Info("filtered")
+	GetLogger("http").WithCapture(emitted).Error("failed")
+
+	Shutdown()
+
+	var messages []string
+	for _, rec := range capture.Records() {
+		messages = append(messages, rec.Message)
+	}
+	if strings.Join(messages, "|") != "parsing|slow backend|validating" || capture.Records()[0].Fields["request"] != 1 {
+		t.Errorf("unexpected captured records: %q", messages)
+	}
+	if records := emitted.Records(); len(records) != 1 || records[0].Message != "failed" {
+		t.Errorf("unexpected captured records: %+v", records)
+	}
+	if len(handler.records) != 3 {
+		t.Errorf("unexpected handled records: %+v", handler.records)
+	}
+	if FromContext(context.Background()) != GetLogger() {
+		t.Error("expected the root logger")
+	}
+}
+
+func TestIsEnabled(t *testing.T) {
+	BasicConfig(BasicConfigOpts{
+		Level:    INFO,
+		Handlers: []Handler{&recordingHandler{}},
+	})
+	defer Reset()
+
+	log := GetLogger("test")
+	if !log.IsInfoEnabled() || log.IsDebugEnabled() || log.IsTraceEnabled() || !log.IsEnabled(ERROR) {
+		t.Errorf("unexpected levels enabled at INFO")
+	}
+
+	log.SetLevel(TRACE)
+	if !log.IsTraceEnabled() || !log.With(Fields{"k": "v"}).IsDebugEnabled() {
+		t.Errorf("expected TRACE and DEBUG enabled")
+	}
+	log.SetLevel(INHERIT)
+
+	SetSourceLevel("logging_test.go", DEBUG)
+	if !log.IsDebugEnabled() || log.IsTraceEnabled() {
+		t.Errorf("expected DEBUG enabled by the source level")
+	}
+	SetSourceLevel("logging_test.go", INHERIT)
+
+	if !log.WithCapture(NewCapture(DEBUG, 0)).IsDebugEnabled() {
+		t.Errorf("expected DEBUG enabled by the capture")
+	}
+}
+
+func TestTags(t *testing.T) {
+	all := &recordingHandler{}
+	slow := &recordingHandler{}
+	formatter, _ := NewTemplateFormatter("{message} [{tags}]")
+	all.SetFormatter(formatter)
+
+	BasicConfig(BasicConfigOpts{
+		Level:    INFO,
+		Handlers: []Handler{all},
+	})
+	defer Reset()
+	InstallRoutes(Route{Tags: []string{"slow"}, Handler: slow})
+
+	log := GetLogger("test")
+	db := log.Tagged("db")
+	db.Tagged("slow", "db").Warning("query took 3s")
+	db.Info("connected")
+	log.Info("untagged")
+	Shutdown()
+
+	if len(all.records) != 3 || len(slow.records) != 1 || slow.records[0].Message != "query took 3s" {
+		t.Fatalf("unexpected records: %+v, routed: %+v", all.records, slow.records)
+	}
+	rec := &all.records[0]
+	if !reflect.DeepEqual(rec.Tags, []string{"db", "slow"}) || !rec.HasTag("slow") || rec.HasTag("security") {
+		t.Errorf("unexpected tags: %v", rec.Tags)
+	}
+	if out, _ := formatter.Format(rec); string(out) != "query took 3s [db,slow]" {
+		t.Errorf("unexpected output: %q", out)
+	}
+	if out, _ := NewPrettyFormatter(PrettyOpts{NoColor: true}).Format(rec); string(out) != "! query took 3s #db #slow" {
+		t.Errorf("unexpected pretty output: %q", out)
+	}
+	if out, _ := NewECSFormatter().Format(rec); !strings.Contains(string(out), `"tags":["db","slow"]`) {
+		t.Errorf("unexpected ECS output: %s", out)
+	}
+
+	data, _ := rec.MarshalBinary()
+	var decoded Record
+	if err := decoded.UnmarshalBinary(data); err != nil || !reflect.DeepEqual(decoded.Tags, rec.Tags) {
+		t.Errorf("tags not decoded: %v, %v", decoded.Tags, err)
+	}
+}
+
+func TestDiagnosticContext(t *testing.T) {
+	handler := &recordingHandler{}
+	BasicConfig(BasicConfigOpts{
+		Level:    INFO,
+		Handlers: []Handler{handler},
+	})
+	defer Reset()
+
+	log := GetLogger("test")
+	MDC().Set("txid", 42)
+	MDC().Set("user", "mdc")
+	PushNDC("request 7")
+	PushNDC("step 3")
+	log.With(Fields{"user": "logger"}).Info("first")
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		log.Info("other goroutine")
+	}()
+	<-done
+
+	if message := PopNDC(); message != "step 3" {
+		t.Errorf("unexpected popped message: %q", message)
+	}
+	if MDC().Get("txid") != 42 {
+		t.Errorf("unexpected MDC value: %v", MDC().Get("txid"))
+	}
+	log.Info("second")
+	MDC().Clear()
+	ClearNDC()
+	log.Info("cleared")
+	Shutdown()
+
+	if len(handler.records) != 4 {
+		t.Fatalf("unexpected records: %+v", handler.records)
+	}
+	expected := []Fields{
+		{"txid": 42, "user": "logger", "ndc": "request 7 step 3"},
+		nil,
+		{"txid": 42, "user": "mdc", "ndc": "request 7"},
+		nil,
+	}
+	for idx, rec := range handler.records {
+		if len(rec.Fields) != len(expected[idx]) || (len(rec.Fields) > 0 && !reflect.DeepEqual(rec.Fields, expected[idx])) {
+			t.Errorf("unexpected fields of %q: %v", rec.Message, rec.Fields)
+		}
+	}
+	if len(diagnostics) != 0 || diagnosticsCount != 0 {
+		t.Errorf("context not removed: %v", diagnostics)
+	}
+}
+
+func TestFatalExit(t *testing.T) {
+	handler := &recordingHandler{}
+	BasicConfig(BasicConfigOpts{
+		Level:    INFO,
+		Handlers: []Handler{handler},
+	})
+	defer Reset()
+
+	var exitCode int
+	osExit = func(code int) { exitCode = code }
+	defer func() { osExit = os.Exit }()
+	defer func(hooks []func()) { exitHooks, exitTimeout = hooks, 5*time.Second }(exitHooks)
+
+	internal := &recordingHandler{}
+	SetInternalHandler(internal)
+	defer SetInternalHandler(nil)
+
+	var ran []string
+	OnExit(func() {
+		ran = append(ran, "first")
+		GetLogger("db").Info("closing")
+	})
+	OnExit(func() { panic("broken hook") })
+	OnExit(func() { ran = append(ran, "third") })
+
+	GetLogger("test").FatalExit(3, "cannot continue: %s", "disk full")
+
+	if exitCode != 3 || !reflect.DeepEqual(ran, []string{"first", "third"}) {
+		t.Errorf("unexpected exit code %d, hooks run: %v", exitCode, ran)
+	}
+	if len(handler.records) != 2 || handler.records[0].Level != FATAL || handler.records[1].Message != "closing" {
+		t.Errorf("unexpected records: %+v", handler.records)
+	}
+	if len(internal.records) != 1 || internal.records[0].Name != "log4go/OnExit" ||
+		internal.records[0].Message != "exit hook panicked: broken hook" {
+		t.Errorf("unexpected reports: %+v", internal.records)
+	}
+
+	// a hook exiting itself is aborted, without running the hooks again
+	exitHooks, ran = nil, nil
+	OnExit(func() {
+		ran = append(ran, "nested")
+		GetLogger("db").Fatal("cannot close")
+		ran = append(ran, "not reached")
+	})
+	OnExit(func() { ran = append(ran, "last") })
+	GetLogger("test").FatalExit(4, "again")
+	if exitCode != 4 || !reflect.DeepEqual(ran, []string{"nested", "last"}) {
+		t.Errorf("unexpected exit code %d, hooks run: %v", exitCode, ran)
+	}
+
+	// hooks not completing in time are abandoned
+	block := make(chan struct{})
+	defer close(block)
+	exitHooks = nil
+	OnExit(func() { <-block })
+	SetExitTimeout(10 * time.Millisecond)
+	GetLogger("test").Fatal("again")
+	if exitCode != 1 {
+		t.Errorf("unexpected exit code %d", exitCode)
+	}
+}
+
+func TestRateLimit(t *testing.T) {
+	handler := &recordingHandler{}
+	BasicConfig(BasicConfigOpts{
+		Level:    INFO,
+		Handlers: []Handler{handler},
+	})
+	defer Reset()
+	defer func(delay time.Duration) { rateLimitSummaryDelay = delay }(rateLimitSummaryDelay)
+	rateLimitSummaryDelay = 10 * time.Millisecond
+
+	db := GetLogger("db")
+	db.SetRateLimit(RateLimit{Rate: 0.01, Burst: 3, Exempt: ERROR})
+	pool := db.GetLogger("pool")
+	for idx := 0; idx < 10; idx++ {
+		pool.Info("storm %d", idx)
+	}
+	db.Error("still logged")
+	GetLogger("other").Info("not limited")
+	time.Sleep(100 * time.Millisecond)
+
+	handler.lock.Lock()
+	var messages []string
+	for _, rec := range handler.records {
+		messages = append(messages, rec.Message)
+	}
+	handler.lock.Unlock()
+	expected := []string{"storm 0", "storm 1", "storm 2", "still logged", "not limited", "rate limit exceeded, dropped 7 records"}
+	if !reflect.DeepEqual(messages, expected) {
+		t.Errorf("unexpected records: %q", messages)
+	}
+
+	// configured
+	config, err := ParseConfig([]byte(`{"loggers": {"db": {"rate_limit": {"rate": 5, "exempt": "WARNING"}}}}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := ApplyConfig(config); err != nil {
+		t.Fatal(err)
+	}
+	if limit := db.RateLimit(); limit != (RateLimit{Rate: 5, Burst: 5, Exempt: WARNING}) {
+		t.Errorf("unexpected configured limit: %+v", limit)
+	}
+	if err := ApplyConfig(&Config{}); err != nil {
+		t.Fatal(err)
+	}
+	if limit := db.RateLimit(); limit.Rate != 0 {
+		t.Errorf("limit not removed: %+v", limit)
+	}
+}
+
+func TestEscalationRules(t *testing.T) {
+	all := &recordingHandler{}
+	errors := &recordingHandler{}
+	errors.SetLevel(ERROR)
+	BasicConfig(BasicConfigOpts{
+		Level:    INFO,
+		Handlers: []Handler{all, errors},
+	})
+	defer Reset()
+
+	SetEscalationRules(
+		EscalationRule{LoggerGlob: "payments/**", MatchRegex: regexp.MustCompile("timeout"), Level: ERROR},
+		EscalationRule{Tags: []string{"security"}, Level: WARNING},
+	)
+	GetLogger("payments/card").Info("gateway timeout")
+	GetLogger("payments/card").Info("charged")
+	GetLogger("shipping").Info("gateway timeout")
+	GetLogger("auth").Tagged("security").Info("login failed")
+	GetLogger("auth").Tagged("security").Error("lockout") // never lowered
+
+	levels := func(h *recordingHandler) []Level {
+		var levels []Level
+		for _, rec := range h.records {
+			levels = append(levels, rec.Level)
+		}
+		return levels
+	}
+	if l := levels(all); !reflect.DeepEqual(l, []Level{ERROR, INFO, INFO, WARNING, ERROR}) {
+		t.Errorf("unexpected levels: %v", l)
+	}
+	if len(errors.records) != 2 || errors.records[0].Message != "gateway timeout" {
+		t.Errorf("unexpected escalated records: %+v", errors.records)
+	}
+
+	// rate limited, and flushing the staged records, by the escalated level
+	all.records = nil
+	card := GetLogger("payments/card")
+	card.SetRateLimit(RateLimit{Rate: 0.01, Burst: 1, Exempt: ERROR})
+	card.Info("charged") // taking the burst
+	card.StageInfo("retrying")
+	card.Info("gateway timeout")
+	card.Info("gateway timeout")
+	var messages []string
+	for _, rec := range all.records {
+		messages = append(messages, rec.Message)
+	}
+	expected := []string{"charged", "retrying", "gateway timeout", "gateway timeout"}
+	if !reflect.DeepEqual(messages, expected) {
+		t.Errorf("expected %v, got %v", expected, messages)
+	}
+	card.SetRateLimit(RateLimit{})
+
+	// counted by the metrics hook by the escalated level
+	counts := map[string]int{}
+	SetMetricsHook(func(logger string, level Level) {
+		counts[logger+":"+LevelName(level)]++
+	}, ERROR)
+	card.Info("gateway timeout")
+	card.Info("charged")
+	SetMetricsHook(nil)
+	if !reflect.DeepEqual(counts, map[string]int{"payments/card:ERROR": 1}) {
+		t.Errorf("unexpected counts: %v", counts)
+	}
+
+	// configured
+	config, err := ParseConfig([]byte(`{"escalations": [{"logger": "db", "match": "dead+lock", "level": "WARNING"}]}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := ApplyConfig(config); err != nil {
+		t.Fatal(err)
+	}
+	rules, _ := escalationRules.Load().([]EscalationRule)
+	if len(rules) != 1 || rules[0].Level != WARNING || !rules[0].MatchRegex.MatchString("deadlock") {
+		t.Errorf("unexpected configured rules: %+v", rules)
+	}
+	if err := ApplyConfig(&Config{Escalations: []EscalationConfig{{Match: "(", Level: "ERROR"}}}); err == nil {
+		t.Error("invalid regex accepted")
+	}
+}
+
+func TestErrorFields(t *testing.T) {
+	handler := &recordingHandler{}
+	BasicConfig(BasicConfigOpts{
+		Level:    INFO,
+		Handlers: []Handler{handler},
+	})
+	defer Reset()
+
+	cause := errors.New("connection refused")
+	wrapped := fmt.Errorf("saving order: %w", cause)
+	log := GetLogger("test")
+	log.Error("before enabling: %v", wrapped)
+	EnableErrorFields(true)
+	log.Error("failed after %d attempts: %v (%v)", 3, wrapped, cause)
+	log.With(Fields{"error": "explicit"}).Error("failed: %v", wrapped)
+	log.Info("no error: %v", nil)
+	Shutdown()
+
+	if len(handler.records) != 4 {
+		t.Fatalf("unexpected records: %+v", handler.records)
+	}
+	if _, exists := handler.records[0].Fields["error"]; exists {
+		t.Errorf("error field added while disabled: %v", handler.records[0].Fields)
+	}
+	rec := &handler.records[1]
+	if rec.Fields["error"] != wrapped || rec.Message != "failed after 3 attempts: saving order: connection refused (connection refused)" {
+		t.Errorf("unexpected record: %+v", rec)
+	}
+	if out, _ := NewECSFormatter().Format(rec); !strings.Contains(string(out), `"error.message":"saving order: connection refused"`) {
+		t.Errorf("unexpected ECS output: %s", out)
+	}
+	if handler.records[2].Fields["error"] != "explicit" || len(handler.records[3].Fields) != 0 {
+		t.Errorf("unexpected fields: %v, %v", handler.records[2].Fields, handler.records[3].Fields)
+	}
+}
+
+// logViaHelper is a helper as wrapper libraries have, reporting its caller's location.
+func logViaHelper(log *Logger, message string) {
+	log.WithCallerSkip(1).Info(message)
+}
+
+func TestCallerSkip(t *testing.T) {
+	handler := &recordingHandler{}
+	BasicConfig(BasicConfigOpts{
+		Level:    INFO,
+		Handlers: []Handler{handler},
+	})
+	defer Reset()
+
+	log := GetLogger("test")
+	log.Info("disabled")
+	EnableCallers(true)
+	_, _, line, _ := runtime.Caller(0)
+	log.Info("direct")
+	logViaHelper(log, "via helper")
+	Shutdown()
+
+	if len(handler.records) != 3 || handler.records[0].File != "" {
+		t.Fatalf("unexpected records: %+v", handler.records)
+	}
+	for idx, rec := range handler.records[1:] {
+		if filepath.Base(rec.File) != "logging_test.go" || rec.Line != line+1+idx {
+			t.Errorf("unexpected caller of %q: %s:%d", rec.Message, rec.File, rec.Line)
+		}
+	}
+
+	rec := &handler.records[2]
+	formatter, _ := NewTemplateFormatter("{file}:{line} {message}")
+	if out, _ := formatter.Format(rec); string(out) != fmt.Sprintf("logging_test.go:%d via helper", line+2) {
+		t.Errorf("unexpected output: %q", out)
+	}
+	data, _ := rec.MarshalBinary()
+	var decoded Record
+	if err := decoded.UnmarshalBinary(data); err != nil || decoded.File != rec.File || decoded.Line != rec.Line {
+		t.Errorf("caller not decoded: %+v, %v", decoded, err)
+	}
+}
+
+func TestDumpConfig(t *testing.T) {
+	dir, err := ioutil.TempDir("", "log4go")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	defer Reset()
+
+	filename := filepath.Join(dir, "app.log")
+	noAppend := false
+	applied := &Config{
+		Level: "INFO",
+		Handlers: map[string]HandlerConfig{
+			"console": {Type: "stream", Target: "stdout", Format: "{level} {message}", Color: true},
+			"file":    {Type: "file", File: filename, Append: &noAppend, Level: "WARNING", Format: "{time} {message}", Sync: true},
+		},
+		Loggers: map[string]LoggerConfig{
+			"root":    {Handlers: []string{"console", "file"}},
+			"db/pool": {Level: "DEBUG", RateLimit: &RateLimitConfig{Rate: 5, Burst: 10, Exempt: "ERROR"}},
+			"audit":   {Level: "ERROR", Handlers: []string{"file"}},
+		},
+		Escalations: []EscalationConfig{{Logger: "db/**", Match: "dead+lock", Level: "WARNING"}},
+	}
+	if err := ApplyConfig(applied); err != nil {
+		t.Fatal(err)
+	}
+
+	data, err := DumpConfig()
+	if err != nil {
+		t.Fatal(err)
+	}
+	dumped, err := ParseConfig(data)
+	if err != nil {
+		t.Fatalf("dumped config not parsed: %v\n%s", err, data)
+	}
+	if !reflect.DeepEqual(dumped, applied) {
+		t.Errorf("unexpected config:\n%s", data)
+	}
+
+	// handlers not installed by a config are named by their type
+	handler, _ := NewStreamHandler(&bytes.Buffer{})
+	handler.SetFormatter(NewJSONFormatter())
+	GetLogger("db").AddHandler(handler)
+	GetLogger("db/pool").AddHandler(handler)
+	if data, err = DumpConfig(); err != nil {
+		t.Fatal(err)
+	}
+	if dumped, err = ParseConfig(data); err != nil {
+		t.Fatal(err)
+	}
+	if h := dumped.Handlers["handler1"]; h.Type != "*log4go.StreamHandler" || len(dumped.Handlers) != 3 ||
+		!reflect.DeepEqual(dumped.Loggers["db/pool"].Handlers, []string{"handler1"}) {
+		t.Errorf("unexpected config:\n%s", data)
+	}
+}
+
+func TestDisableLogger(t *testing.T) {
+	recorder := &recordingHandler{}
+	BasicConfig(BasicConfigOpts{
+		Level:    INFO,
+		Handlers: []Handler{recorder},
+	})
+	defer Reset()
+
+	db := GetLogger("db")
+	pool := db.GetLogger("pool")
+	pool.SetLevel(DEBUG)
+
+	db.Disable()
+	db.Error("muted")
+	pool.Error("muted")
+	pool.With(Fields{"key": 1}).Error("muted")
+	if !pool.Disabled() || pool.IsEnabled(FATAL) || pool.Level() != OFF {
+		t.Errorf("expected the subtree to be disabled")
+	}
+	GetLogger("api").Info("logged")
+
+	db.Enable()
+	pool.Debug("logged")
+	if pool.Disabled() || pool.Level() != DEBUG || db.Level() != INFO {
+		t.Errorf("expected the levels to be kept")
+	}
+
+	// OFF is a level: descendants having their own level still log
+	db.SetLevel(OFF)
+	db.Error("muted")
+	pool.Debug("logged")
+
+	recorder.lock.Lock()
+	for _, rec := range recorder.records {
+		if rec.Message != "logged" {
+			t.Errorf("unexpected record: %+v", rec)
+		}
+	}
+	if len(recorder.records) != 3 {
+		t.Errorf("expected 3 records, got %d", len(recorder.records))
+	}
+	recorder.lock.Unlock()
+
+	if level, err := ParseLevel("off"); err != nil || level != OFF {
+		t.Errorf("unexpected level: %v (%v)", level, err)
+	}
+
+	// toggled from the debug endpoint
+	resp := httptest.NewRecorder()
+	DebugHandler().ServeHTTP(resp, httptest.NewRequest(http.MethodPost, "/debug/log4go?logger=db/pool&enabled=false", nil))
+	var state LoggerState
+	if err := json.Unmarshal(resp.Body.Bytes(), &state); err != nil {
+		t.Fatal(err)
+	}
+	if !pool.Disabled() || db.Disabled() || !state.Children[0].Children[0].Disabled || state.Children[0].Children[0].EffectiveLevel != "OFF" {
+		t.Errorf("expected db/pool to be disabled: %+v", state)
+	}
+	resp = httptest.NewRecorder()
+	DebugHandler().ServeHTTP(resp, httptest.NewRequest(http.MethodPost, "/debug/log4go?logger=db/pool&enabled=maybe", nil))
+	if resp.Code != http.StatusBadRequest {
+		t.Errorf("unexpected status: %d", resp.Code)
+	}
+
+	resp = httptest.NewRecorder()
+	DebugHandler().ServeHTTP(resp, httptest.NewRequest(http.MethodPost, "/debug/log4go?logger=db/unknown&enabled=false", nil))
+	if _, exists := closestLogger("db/unknown"); resp.Code != http.StatusNotFound || exists {
+		t.Errorf("unexpected status for an unknown logger: %d (created: %t)", resp.Code, exists)
+	}
+}
+
+func TestLevelSentinels(t *testing.T) {
+	all, off := &recordingHandler{level: ALL}, &recordingHandler{level: OFF}
+	BasicConfig(BasicConfigOpts{
+		Level:    ALL,
+		Handlers: []Handler{all, off},
+	})
+	defer Reset()
+
+	log := GetLogger("app")
+	log.Log(TRACE, "logged")
+	log.Error("logged")
+	if !log.IsTraceEnabled() || log.Level() != ALL {
+		t.Errorf("expected everything to be enabled")
+	}
+
+	all.lock.Lock()
+	if len(all.records) != 2 {
+		t.Errorf("expected 2 records, got %d", len(all.records))
+	}
+	all.lock.Unlock()
+	off.lock.Lock()
+	if len(off.records) != 0 {
+		t.Errorf("expected no records, got %d", len(off.records))
+	}
+	off.lock.Unlock()
+
+	for name, expected := range map[string]Level{"off": OFF, "ALL": ALL, "All": ALL} {
+		if level, err := ParseLevel(name); err != nil || level != expected {
+			t.Errorf("%s: unexpected level: %s (%v)", name, LevelName(level), err)
+		}
+	}
+	if LevelName(ALL) != "ALL" || LevelName(OFF) != "OFF" {
+		t.Errorf("unexpected names: %s %s", LevelName(ALL), LevelName(OFF))
+	}
+
+	// also in configs, e.g. a handler switched off
+	if err := ApplyConfig(&Config{Level: "all", Handlers: map[string]HandlerConfig{"off": {Type: "stream", Level: "OFF"}},
+		Loggers: map[string]LoggerConfig{"root": {Handlers: []string{"off"}}}}); err != nil {
+		t.Fatal(err)
+	}
+	if GetLogger().Level() != ALL || GetLogger().Handlers()[0].Level() != OFF {
+		t.Errorf("unexpected levels: %s %s", LevelName(GetLogger().Level()), LevelName(GetLogger().Handlers()[0].Level()))
+	}
+}
+
+func TestPreConfigBuffering(t *testing.T) {
+	Reset()
+	defer Reset()
+
+	// logged before configuring: buffered, and replayed into the configured handlers
+	GetLogger("app").Warning("starting")
+	GetLogger("app").Info("filtered by the implicit level")
+
+	recorder := &recordingHandler{}
+	BasicConfig(BasicConfigOpts{
+		Level:    INFO,
+		Handlers: []Handler{recorder},
+	})
+	GetLogger("app").Info("configured")
+
+	var messages []string
+	for _, rec := range recorder.records {
+		messages = append(messages, rec.Name+": "+rec.Message)
+	}
+	if strings.Join(messages, ", ") != "app: starting, app: configured" {
+		t.Errorf("unexpected records: %q", messages)
+	}
+
+	// replayed only into the handlers replacing the pre-config handler, not again into the others
+	Reset()
+	audit := &recordingHandler{}
+	audit.SetFormatter(NewJSONFormatter())
+	GetLogger("audit").AddHandler(audit)
+	GetLogger("audit").Warning("early")
+	recorder = &recordingHandler{}
+	ExtendConfig(BasicConfigOpts{Handlers: []Handler{recorder}})
+	if len(audit.records) != 1 || len(recorder.records) != 1 {
+		t.Errorf("unexpected records: %d, %d", len(audit.records), len(recorder.records))
+	}
+
+	// written to stderr right away, and not again when configured to write to stderr
+	Reset()
+	stderr, err := ioutil.TempFile("", "stderr")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(stderr.Name())
+	defer func(saved *os.File) { os.Stderr = saved }(os.Stderr)
+	os.Stderr = stderr
+
+	defer func(saved time.Duration) { preConfigTimeout = saved }(preConfigTimeout)
+	preConfigTimeout = 10 * time.Millisecond
+
+	GetLogger().Error("before configuring")
+	if data, _ := ioutil.ReadFile(stderr.Name()); !strings.Contains(string(data), "before configuring") {
+		t.Errorf("expected the record on stderr, got %q", data)
+	}
+	BasicConfig(BasicConfigOpts{Sync: true})
+	if data, _ := ioutil.ReadFile(stderr.Name()); strings.Count(string(data), "before configuring") != 1 {
+		t.Errorf("expected the record on stderr once, got %q", data)
+	}
+
+	// not configured in time: no longer buffered
+	Reset()
+	GetLogger().Error("never configured")
+	time.Sleep(100 * time.Millisecond)
+	recorder = &recordingHandler{}
+	BasicConfig(BasicConfigOpts{Handlers: []Handler{recorder}})
+	if len(recorder.records) != 0 {
+		t.Errorf("unexpected records: %d", len(recorder.records))
+	}
+
+	// the handlers removed: no longer waiting for the configuration
+	Reset()
+	GetLogger().Error("removed")
+	pre := GetLogger().Handlers()[0].(*preConfigHandler)
+	GetLogger().RemoveHandlers()
+	if pre.timer.Stop() {
+		t.Errorf("expected the timer stopped")
+	}
+}
+
+func TestSanitizing(t *testing.T) {
+	handler := &recordingHandler{}
+	BasicConfig(BasicConfigOpts{
+		Level:    INFO,
+		Handlers: []Handler{handler},
+	})
+	defer Reset()
+
+	log := GetLogger("app")
+	log.Info("user %s logged in", "bob\x1b[2J\rroot\u202e\u0085\x00")
+	log.Info("multi-line\n\tmessage, caf\u00e9 \xff")
+	log.Info("user {user} logged in", Args{"user": "\x1b]0;title\x07"})
+	EnableSanitizing(false)
+	log.Info("raw \x1b[1m")
+
+	expected := []string{
+		`user bob\x1b[2J\rroot\u202e\u0085\x00 logged in`,
+		"multi-line\n\tmessage, caf\u00e9 \xff",
+		`user \x1b]0;title\x07 logged in`,
+		"raw \x1b[1m",
+	}
+	for idx, rec := range handler.records {
+		if rec.Message != expected[idx] {
+			t.Errorf("expected %q, got %q", expected[idx], rec.Message)
+		}
+	}
+	if len(handler.records) != len(expected) {
+		t.Errorf("expected %d records, got %d", len(expected), len(handler.records))
+	}
+}
+
+func TestRateCeiling(t *testing.T) {
+	handler := &recordingHandler{}
+	BasicConfig(BasicConfigOpts{
+		Level:    INFO,
+		Handlers: []Handler{handler},
+	})
+	defer Reset()
+	defer func(saved time.Duration) { ceilingWindow = saved }(ceilingWindow)
+
+	// all records counted in the same window (i.e. a ceiling of 10 records)
+	ceilingWindow = 10 * time.Second
+	SetRateCeiling(RateCeiling{Rate: 1, SampleEvery: 5})
+
+	log := GetLogger("app")
+	for idx := 0; idx < 30; idx++ {
+		log.Info("record %d", idx)
+	}
+	log.Error("error")
+	if !EmergencyMode() {
+		t.Errorf("expected emergency mode")
+	}
+
+	counts := map[string]int{}
+	for _, rec := range handler.records {
+		counts[rec.Name+":"+LevelName(rec.Level)]++
+	}
+	// 10 records below the ceiling, then 1 in 5 of the 20 others, the error and the alert
+	if counts["app:INFO"] != 10+4 || counts["app:ERROR"] != 1 || counts["log4go:ERROR"] != 1 {
+		t.Errorf("unexpected records: %v", counts)
+	}
+
+	// leaving emergency mode, once below the ceiling for the cooldown
+	ceilingWindow = 10 * time.Millisecond
+	SetRateCeiling(RateCeiling{Rate: 100, Cooldown: time.Nanosecond})
+	log.Info("exceeding")
+	log.Info("exceeding")
+	for idx := 0; idx < 5 && EmergencyMode(); idx++ {
+		time.Sleep(15 * time.Millisecond)
+		log.Info("calm")
+	}
+	if EmergencyMode() {
+		t.Errorf("expected emergency mode to have ended")
+	}
+	alert := handler.records[len(handler.records)-2] // followed by the record ending it
+	if alert.Name != "log4go" || alert.Level != WARNING || !strings.Contains(alert.Message, "emergency mode ended: dropped") {
+		t.Errorf("unexpected alert: %s %s %q", alert.Name, LevelName(alert.Level), alert.Message)
+	}
+	// the windows without records are calm too, e.g. after a burst followed by silence
+	SetRateCeiling(RateCeiling{Rate: 100, Cooldown: 30 * time.Millisecond})
+	for idx := 0; idx < 3; idx++ { // exceeding, even if straddling two windows
+		log.Info("exceeding")
+	}
+	if !EmergencyMode() {
+		t.Errorf("expected emergency mode")
+	}
+	time.Sleep(60 * time.Millisecond)
+	log.Info("after the silence")
+	if EmergencyMode() {
+		t.Errorf("expected emergency mode to have ended after the silence")
+	}
+}
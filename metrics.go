@@ -0,0 +1,57 @@
+package log4go
+
+import (
+	"fmt"
+	"net"
+	"strings"
+	"sync/atomic"
+)
+
+// MetricsHook is called for each emitted record of (at least) the level given to SetMetricsHook,
+// e.g. to increment statsd or Prometheus counters, for alerting on error rates.
+// It's called synchronously in the logging goroutine, and should be fast.
+type MetricsHook func(logger string, level Level)
+
+type metricsHookEntry struct {
+	hook     MetricsHook
+	minLevel Level
+}
+
+var metricsHook atomic.Value // metricsHookEntry
+
+// SetMetricsHook installs a hook called for every emitted record of (at least) minLevel (default WARNING).
+// Use nil to remove the hook.
+func SetMetricsHook(hook MetricsHook, minLevel ...Level) {
+	entry := metricsHookEntry{hook: hook, minLevel: WARNING}
+	if len(minLevel) > 0 {
+		entry.minLevel = minLevel[0]
+	}
+	metricsHook.Store(entry)
+}
+
+func callMetricsHook(name string, lvl Level) {
+	if entry, ok := metricsHook.Load().(metricsHookEntry); ok && entry.hook != nil && lvl >= entry.minLevel {
+		entry.hook(name, lvl)
+	}
+}
+
+// NewStatsdHook returns a MetricsHook incrementing a statsd counter (over UDP) per logger and level,
+// named "<prefix>.<logger>.<level>", e.g. "myapp.log.db.pool.error" (the root logger is called "root").
+func NewStatsdHook(addr string, prefix string) (MetricsHook, error) {
+	conn, err := net.Dial("udp", addr)
+	if err != nil {
+		return nil, err
+	}
+	if len(prefix) > 0 && !strings.HasSuffix(prefix, ".") {
+		prefix += "."
+	}
+
+	return func(logger string, level Level) {
+		if len(logger) == 0 {
+			logger = "root"
+		}
+		metric := prefix + strings.Replace(logger, "/", ".", -1) + "." + strings.ToLower(LevelName(level))
+		// statsd is fire-and-forget, errors are ignored
+		fmt.Fprintf(conn, "%s:1|c", metric)
+	}, nil
+}
@@ -0,0 +1,204 @@
+package log4go
+
+import (
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// preConfigLimit is the number of records buffered before the logging system is configured,
+// and preConfigTimeout the time waited for the configuration, before no longer buffering them.
+const preConfigLimit = 1000
+
+var preConfigTimeout = 5 * time.Second
+
+// preConfigHandler is the handler of the implicitly created root logger, i.e. when logging before the logging
+// system is configured (e.g. by BasicConfig). It writes the records to stderr, and buffers them to be replayed
+// into the configured handlers once configured (see takePreConfig), so early startup records aren't lost;
+// unless the configuration takes too long (or too many records are logged), when they're only written to stderr.
+type preConfigHandler struct {
+	formatter Formatter
+	level     Level
+	stderr    *StreamHandler
+
+	lock     sync.Mutex // guards the below
+	records  []Record
+	timer    *time.Timer
+	expired  bool // given up waiting for the configuration
+	detached bool
+	handled  []Handler // the root logger's other handlers, which got the records already
+}
+
+func newPreConfigHandler() *preConfigHandler {
+	formatter, _ := NewTemplateFormatter("{time} {name} {level} {message}")
+	stderr, _ := NewStreamHandler(os.Stderr, StreamOpts{Sync: true})
+	stderr.SetFormatter(formatter)
+	return &preConfigHandler{formatter: formatter, stderr: stderr}
+}
+
+// Handle writes the record to stderr, and buffers it (unless given up waiting for the configuration).
+// Records racing with the configuration are passed on to the configured handlers.
+func (h *preConfigHandler) Handle(rec *Record) error {
+	h.lock.Lock()
+	if h.detached {
+		handled := h.handled
+		h.lock.Unlock()
+		redispatch(rec, handled)
+		return nil
+	}
+	defer h.lock.Unlock()
+
+	if h.expired {
+		return h.stderr.Handle(rec)
+	}
+	h.records = append(h.records, *rec.Clone())
+	if len(h.records) == 1 {
+		h.timer = time.AfterFunc(preConfigTimeout, h.expire)
+	}
+	if len(h.records) >= preConfigLimit {
+		h.expireLocked()
+	}
+	return h.stderr.Handle(rec)
+}
+
+// expire stops waiting for the configuration, dropping the records buffered.
+func (h *preConfigHandler) expire() {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+
+	h.expireLocked()
+}
+
+func (h *preConfigHandler) expireLocked() {
+	if h.timer != nil {
+		h.timer.Stop()
+	}
+	h.expired = true
+	h.records = nil
+}
+
+// detach returns the records buffered, passing any later ones on to the configured handlers
+// (other than those handled, which got them already).
+func (h *preConfigHandler) detach(handled []Handler) []Record {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+
+	h.detached = true
+	h.handled = handled
+	if h.timer != nil {
+		h.timer.Stop()
+	}
+	records := h.records
+	h.records = nil
+	return records
+}
+
+// preConfigReplay is the records to be replayed once configured, see replayPreConfig.
+type preConfigReplay struct {
+	records []Record
+	handled []Handler
+}
+
+// takePreConfig detaches the pre-config handler (if any) from the root logger, returning its records
+// to be replayed (see replayPreConfig) once configured (loggersLock must be held).
+func takePreConfig() preConfigReplay {
+	if rootLogger == nil {
+		return preConfigReplay{}
+	}
+	handlers := rootLogger.ownHandlers()
+	kept := make([]Handler, 0, len(handlers))
+	var pres []*preConfigHandler
+	for _, handler := range handlers {
+		if pre, ok := handler.(*preConfigHandler); ok {
+			pres = append(pres, pre)
+			continue
+		}
+		kept = append(kept, handler)
+	}
+	if len(pres) == 0 {
+		return preConfigReplay{}
+	}
+	rootLogger.handlers.Store(kept)
+
+	// as did those writing to stderr
+	pending := preConfigReplay{handled: append(append([]Handler(nil), kept...), pres[0].stderr)}
+	for _, pre := range pres {
+		pending.records = append(pending.records, pre.detach(pending.handled)...)
+	}
+	return pending
+}
+
+// stopPreConfig stops the pre-config handlers among the handlers waiting for the configuration.
+func stopPreConfig(handlers []Handler) {
+	for _, handler := range handlers {
+		if pre, ok := handler.(*preConfigHandler); ok {
+			pre.expire()
+		}
+	}
+}
+
+// replayPreConfig passes the records logged before the configuration on to the handlers replacing
+// the pre-config handler on the root logger, whose levels apply (loggersLock must not be held).
+func replayPreConfig(pending preConfigReplay) {
+	for idx := range pending.records {
+		redispatch(&pending.records[idx], pending.handled)
+	}
+}
+
+// redispatch passes a record (already counted by the metrics hook, and passed on to the other loggers' handlers)
+// on to the root logger's handlers, except those handled.
+func redispatch(rec *Record, handled []Handler) {
+	logger := loggerByName(rec.Name).node()
+	if rec.Level < Level(atomic.LoadInt32(&logger.effective)) {
+		return
+	}
+	for _, handler := range GetLogger().ownHandlers() {
+		if !handles(handler, rec.Level) || containsHandler(handled, handler) {
+			continue
+		}
+		handler.Handle(rec)
+	}
+}
+
+// containsHandler returns whether the handler is one of handlers, or writes to the same file as one of them.
+func containsHandler(handlers []Handler, handler Handler) bool {
+	file := streamFile(handler)
+	for _, h := range handlers {
+		if h == handler || file != nil && streamFile(h) == file {
+			return true
+		}
+	}
+	return false
+}
+
+// streamFile returns the file the handler writes to, if a StreamHandler writing to an *os.File.
+func streamFile(handler Handler) *os.File {
+	if stream, ok := handler.(*StreamHandler); ok {
+		file, _ := stream.writer.(*os.File)
+		return file
+	}
+	return nil
+}
+
+func (h *preConfigHandler) SetFormatter(formatter Formatter) {
+	h.formatter = formatter
+	h.stderr.SetFormatter(formatter)
+}
+
+func (h *preConfigHandler) Formatter() Formatter {
+	return h.formatter
+}
+
+func (h *preConfigHandler) SetLevel(level Level) {
+	h.level = level
+}
+
+func (h *preConfigHandler) Level() Level {
+	return h.level
+}
+
+// Shutdown stops waiting for the configuration.
+func (h *preConfigHandler) Shutdown() {
+	h.expire()
+}
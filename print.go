@@ -0,0 +1,67 @@
+package log4go
+
+import (
+	"fmt"
+	"strings"
+)
+
+// The Print, Panic and (some of the) Fatal functions below have the same semantics as those of the
+// standard library's log package, easing migration. The Print functions log with INFO level.
+
+// Print logs the arguments (formatted as by fmt.Sprint) with INFO level.
+func (l *Logger) Print(v ...interface{}) {
+	l.print(INFO, fmt.Sprint, v)
+}
+
+// Printf logs message with INFO level (same as Info).
+func (l *Logger) Printf(message string, args ...interface{}) {
+	l.Info(message, args...)
+}
+
+// Println logs the arguments (formatted as by fmt.Sprintln) with INFO level.
+func (l *Logger) Println(v ...interface{}) {
+	l.print(INFO, fmt.Sprintln, v)
+}
+
+// Panic logs the arguments (formatted as by fmt.Sprint) with ERROR level, then panics.
+func (l *Logger) Panic(v ...interface{}) {
+	l.panic(fmt.Sprint(v...))
+}
+
+// Panicf logs message with ERROR level, then panics.
+func (l *Logger) Panicf(message string, args ...interface{}) {
+	l.panic(fmt.Sprintf(message, args...))
+}
+
+// Panicln logs the arguments (formatted as by fmt.Sprintln) with ERROR level, then panics.
+func (l *Logger) Panicln(v ...interface{}) {
+	l.panic(fmt.Sprintln(v...))
+}
+
+// Fatalf logs message with FATAL level (same as Fatal), then does os.Exit(1).
+func (l *Logger) Fatalf(message string, args ...interface{}) {
+	l.Fatal(message, args...)
+}
+
+// Fatalln logs the arguments (formatted as by fmt.Sprintln) with FATAL level, then does os.Exit(1).
+func (l *Logger) Fatalln(v ...interface{}) {
+	l.flushStaged()
+	l.print(FATAL, fmt.Sprintln, v)
+
+	exit(1)
+}
+
+// print logs the arguments, formatted by sprint (only if the level is enabled).
+func (l *Logger) print(lvl Level, sprint func(...interface{}) string, v []interface{}) {
+	if lvl < l.Level() {
+		return
+	}
+	// the newline added by fmt.Sprintln is not part of the message
+	l.log(lvl, false, "%s", strings.TrimSuffix(sprint(v...), "\n"))
+}
+
+func (l *Logger) panic(s string) {
+	l.flushStaged()
+	l.log(ERROR, false, "%s", strings.TrimSuffix(s, "\n"))
+	panic(s)
+}
@@ -0,0 +1,117 @@
+package log4go
+
+import (
+	"math"
+	"sync"
+	"time"
+)
+
+// RateLimit limits the records a logger (and its descendants) passes to its handlers, see SetRateLimit.
+type RateLimit struct {
+	// Rate is the number of records per second.
+	Rate float64
+	// Burst is the number of records passed on in quick succession (default Rate, rounded up).
+	Burst int
+	// Exempt is the level of records not limited, e.g. ERROR (default none are exempt).
+	Exempt Level
+}
+
+// rateLimitSummaryDelay is how long after the first dropped record the number dropped is logged (shortened by tests).
+var rateLimitSummaryDelay = time.Second
+
+// rateLimiter is a token bucket, set by SetRateLimit.
+type rateLimiter struct {
+	limit  RateLimit
+	logger *Logger
+
+	lock    sync.Mutex
+	tokens  float64
+	last    time.Time
+	dropped int
+}
+
+// SetRateLimit limits the records logged by the logger and its descendants (unless set on them), together,
+// to defend against log storms from a noisy subsystem. Records exceeding the limit are dropped; the number
+// dropped is logged (as a WARNING, with the field "dropped") a second after the first. Captures
+// (see WithCapture) and staged records are not limited. A zero Rate removes the limit.
+func (l *Logger) SetRateLimit(limit RateLimit) {
+	l = l.node()
+
+	loggersLock.Lock()
+	defer loggersLock.Unlock()
+
+	l.setRateLimit(limit)
+}
+
+// setRateLimit does the actual work of SetRateLimit (loggersLock must be held).
+func (l *Logger) setRateLimit(limit RateLimit) {
+	if limit.Rate <= 0 {
+		l.rateLimiter.Store((*rateLimiter)(nil))
+		return
+	}
+	if limit.Burst < 1 {
+		limit.Burst = int(math.Ceil(limit.Rate))
+	}
+	l.rateLimiter.Store(&rateLimiter{
+		limit:  limit,
+		logger: l,
+		tokens: float64(limit.Burst),
+		last:   time.Now(),
+	})
+}
+
+// RateLimit returns the rate limit set on the logger (a zero Rate if none).
+func (l *Logger) RateLimit() RateLimit {
+	if limiter, _ := l.node().rateLimiter.Load().(*rateLimiter); limiter != nil {
+		return limiter.limit
+	}
+	return RateLimit{}
+}
+
+// rateLimited returns whether a record of the level is to be dropped, according to the rate limit
+// of the logger (or its nearest ancestor having one).
+func (l *Logger) rateLimited(lvl Level) bool {
+	for logger := l; logger != nil; logger = logger.parent {
+		if limiter, _ := logger.rateLimiter.Load().(*rateLimiter); limiter != nil {
+			return !limiter.allow(lvl)
+		}
+	}
+	return false
+}
+
+// allow takes a token for a record of the level, returning false (and counting it as dropped) if there's none.
+func (r *rateLimiter) allow(lvl Level) bool {
+	if r.limit.Exempt != INHERIT && lvl >= r.limit.Exempt {
+		return true
+	}
+
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	now := time.Now()
+	r.tokens = math.Min(r.tokens+now.Sub(r.last).Seconds()*r.limit.Rate, float64(r.limit.Burst))
+	r.last = now
+	if r.tokens >= 1 {
+		r.tokens--
+		return true
+	}
+
+	r.dropped++
+	if r.dropped == 1 {
+		time.AfterFunc(rateLimitSummaryDelay, r.summarize)
+	}
+	return false
+}
+
+// summarize logs the number of records dropped since the last summary.
+func (r *rateLimiter) summarize() {
+	r.lock.Lock()
+	dropped := r.dropped
+	r.dropped = 0
+	r.lock.Unlock()
+
+	rec := r.logger.newRecord(r.logger, WARNING, 0, "rate limit exceeded, dropped %d records", []interface{}{dropped})
+	rec.Fields = rec.Fields.merged(Args{"dropped": dropped})
+	r.logger.dispatch(rec)
+	recordPool.Put(rec)
+}
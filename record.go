@@ -1,6 +1,12 @@
 package log4go
 
-import "time"
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"time"
+)
 
 // Record is a log message container.
 type Record struct {
@@ -8,4 +14,169 @@ type Record struct {
 	Name    string
 	Level   Level
 	Message string
+	// Duration of a timed operation, if any (see Logger.LogTimed).
+	Duration time.Duration
+	// Fields are structured key/value pairs (see Logger.With), must not be modified.
+	Fields Fields
+	// Stack is the stack trace, if one was captured (e.g. by Logger.Crash).
+	Stack string
+	// Monotonic is the time since the process started (i.e. since log4go was initialized),
+	// from the monotonic clock; unlike Time, it's not affected by wall clock adjustments.
+	Monotonic time.Duration
+	// ID uniquely identifies the record, if enabled (see EnableRecordIDs).
+	ID string
+	// GoroutineID is the ID of the logging goroutine, if enabled (see EnableGoroutineIDs).
+	GoroutineID uint64
+	// Color overrides the level coloring of the record, if set (see Logger.WithColor); it's not encoded.
+	Color string
+	// Tags classify the record (see Logger.Tagged), must not be modified.
+	Tags []string
+	// File and Line are the location of the logging call, if enabled (see EnableCallers).
+	File string
+	Line int
+
+	seq uint64 // sequence number, only set for (and not encoded by) ShardedFileHandler
+}
+
+// Clone returns a copy of the record, with its own Fields and Tags, which may be modified (unlike the records
+// passed to handlers and formatters: they're shared by all handlers, and reused once the logging call returns).
+func (r *Record) Clone() *Record {
+	clone := *r
+	if r.Fields != nil {
+		clone.Fields = make(Fields, len(r.Fields))
+		for key, value := range r.Fields {
+			clone.Fields[key] = value
+		}
+	}
+	if r.Tags != nil {
+		clone.Tags = append([]string(nil), r.Tags...)
+	}
+	return &clone
+}
+
+// HasTag returns whether the record has the tag.
+func (r *Record) HasTag(tag string) bool {
+	return containsString(r.Tags, tag)
+}
+
+// recordEncodingVersion is the first byte of an encoded Record.
+const recordEncodingVersion = 4
+
+// ErrInvalidRecord is returned when decoding a malformed Record.
+var ErrInvalidRecord = errors.New("invalid encoded record")
+
+// MarshalBinary encodes the record into a compact binary form (see UnmarshalBinary).
+// Fields are encoded as JSON, i.e. after decoding, values are of the corresponding JSON types
+// (errors are encoded as their messages).
+func (r *Record) MarshalBinary() ([]byte, error) {
+	var fields []byte
+	if len(r.Fields) > 0 {
+		var err error
+		if fields, err = json.Marshal(encodableFields(r.Fields)); err != nil {
+			return nil, err
+		}
+	}
+
+	var tags []byte
+	if len(r.Tags) > 0 {
+		var err error
+		if tags, err = json.Marshal(r.Tags); err != nil {
+			return nil, err
+		}
+	}
+
+	var buf bytes.Buffer
+	buf.Grow(64 + len(r.Name) + len(r.Message) + len(r.Stack) + len(fields) + len(tags) + len(r.File))
+	buf.WriteByte(recordEncodingVersion)
+
+	varint := make([]byte, binary.MaxVarintLen64)
+	for _, value := range []int64{r.Time.UnixNano(), int64(r.Level), int64(r.Duration), int64(r.Monotonic), int64(r.GoroutineID), int64(r.Line)} {
+		buf.Write(varint[:binary.PutVarint(varint, value)])
+	}
+	for _, s := range [][]byte{[]byte(r.Name), []byte(r.Message), []byte(r.Stack), []byte(r.ID), fields, tags, []byte(r.File)} {
+		buf.Write(varint[:binary.PutUvarint(varint, uint64(len(s)))])
+		buf.Write(s)
+	}
+
+	return buf.Bytes(), nil
+}
+
+// encodableFields returns the fields, with errors replaced by their messages (which json.Marshal would drop).
+func encodableFields(fields Fields) Fields {
+	var replaced Fields
+	for key, value := range fields {
+		if err, ok := value.(error); ok {
+			if replaced == nil {
+				replaced = make(Fields, len(fields))
+				for key, value := range fields {
+					replaced[key] = value
+				}
+			}
+			replaced[key] = err.Error()
+		}
+	}
+	if replaced == nil {
+		return fields
+	}
+	return replaced
+}
+
+// UnmarshalBinary decodes a record encoded by MarshalBinary.
+func (r *Record) UnmarshalBinary(data []byte) error {
+	reader := bytes.NewReader(data)
+
+	version, err := reader.ReadByte()
+	if err != nil || version != recordEncodingVersion {
+		return ErrInvalidRecord
+	}
+
+	var ints [6]int64
+	for idx := range ints {
+		value, err := binary.ReadVarint(reader)
+		if err != nil {
+			return ErrInvalidRecord
+		}
+		ints[idx] = value
+	}
+
+	var strs [7][]byte
+	for idx := range strs {
+		size, err := binary.ReadUvarint(reader)
+		if err != nil || size > uint64(reader.Len()) {
+			return ErrInvalidRecord
+		}
+		strs[idx] = make([]byte, size)
+		reader.Read(strs[idx])
+	}
+
+	var fields Fields
+	if len(strs[4]) > 0 {
+		if err := json.Unmarshal(strs[4], &fields); err != nil {
+			return err
+		}
+	}
+	var tags []string
+	if len(strs[5]) > 0 {
+		if err := json.Unmarshal(strs[5], &tags); err != nil {
+			return err
+		}
+	}
+
+	*r = Record{
+		Time:        time.Unix(0, ints[0]),
+		Level:       Level(ints[1]),
+		Duration:    time.Duration(ints[2]),
+		Monotonic:   time.Duration(ints[3]),
+		GoroutineID: uint64(ints[4]),
+		Name:        string(strs[0]),
+		Message:     string(strs[1]),
+		Stack:       string(strs[2]),
+		ID:          string(strs[3]),
+		Fields:      fields,
+		Tags:        tags,
+		File:        string(strs[6]),
+		Line:        int(ints[5]),
+	}
+
+	return nil
 }
@@ -0,0 +1,56 @@
+package log4go
+
+import (
+	"crypto/rand"
+	"sync/atomic"
+	"time"
+)
+
+var recordIDsEnabled int32 // accessed atomically
+
+// EnableRecordIDs makes every record get a unique ID (a ULID, see Record.ID), false to disable (the default).
+// The IDs allow individual records to be referenced unambiguously, e.g. in tickets.
+func EnableRecordIDs(enable bool) {
+	var value int32
+	if enable {
+		value = 1
+	}
+	atomic.StoreInt32(&recordIDsEnabled, value)
+}
+
+func recordIDs() bool {
+	return atomic.LoadInt32(&recordIDsEnabled) != 0
+}
+
+// crockford is the Crockford base32 alphabet used by ULIDs.
+const crockford = "0123456789ABCDEFGHJKMNPQRSTVWXYZ"
+
+// newULID returns a new ULID (https://github.com/ulid/spec): a 48 bit millisecond
+// timestamp followed by 80 random bits, as 26 characters (lexicographically sortable by time).
+func newULID(t time.Time) string {
+	var id [16]byte
+
+	ms := uint64(t.UnixNano() / int64(time.Millisecond))
+	for idx := 5; idx >= 0; idx-- {
+		id[idx] = byte(ms)
+		ms >>= 8
+	}
+	rand.Read(id[6:])
+
+	// encode 128 bits as 26 5-bit characters (the first one only holds 3 bits)
+	var out [26]byte
+	var acc uint64
+	bits := 2 // 130 bits in total, pad the front
+	pos := 0
+	for _, b := range id {
+		acc = acc<<8 | uint64(b)
+		bits += 8
+		for bits >= 5 {
+			bits -= 5
+			out[pos] = crockford[(acc>>uint(bits))&0x1f]
+			pos++
+		}
+	}
+
+	return string(out[:])
+}
@@ -0,0 +1,881 @@
+package log4go
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strings"
+	"sync"
+	"time"
+)
+
+// maxRelayFrame is the maximum size of an encoded record accepted by RelayServer.
+const maxRelayFrame = 16 << 20
+
+// relayAckFlag is set in the frame size of records to acknowledge, which are prefixed by
+// the sender's session ID and the record's sequence number.
+const relayAckFlag = 1 << 31
+
+// relayBatchFlag is set in the frame size of encoded batches of frames (see RelayOpts.Codec), which are
+// prefixed by the codec's name (its length as a byte, then the name).
+const relayBatchFlag = 1 << 30
+
+// relayBatchSize is the size of the frames batched (before encoding), at most.
+const relayBatchSize = 256 << 10
+
+// maxRelayBatch is the maximum size of a decoded batch accepted by RelayServer: a batch is sent once
+// its frames reach relayBatchSize, i.e. it's exceeded by its last frame (with its header) at most.
+const maxRelayBatch = relayBatchSize + maxRelayFrame + 20
+
+// maxRelayUnacked is the maximum number of records awaiting acknowledgement, before sending waits.
+var maxRelayUnacked = 10000
+
+// relayAckTimeout is the time to wait for acknowledgements, before the connection is considered broken.
+var relayAckTimeout = 5 * time.Second
+
+// errUnacked is returned (wrapped) by RelayHandler.write when the acknowledgements time out: the record
+// has been written, and is awaiting acknowledgement (i.e. resent after reconnecting), so it's not to be spilled.
+var errUnacked = errors.New("records not acknowledged")
+
+// maxRelaySessions is the maximum number of sessions a RelayServer tracks; the least recently seen
+// ones are forgotten beyond that.
+var maxRelaySessions = 10000
+
+// RelayOpts is used to supply options to NewRelayHandler.
+type RelayOpts struct {
+	// Spill, if set, queues the records on disk while the connection is down (instead of dropping them),
+	// replaying them on reconnect; it's closed by Shutdown.
+	// NewRelayHandler doesn't fail if the server is unreachable.
+	Spill *SpillQueue
+	// Acks makes the server acknowledge the records it has received (at least once delivery):
+	// records not acknowledged are resent after reconnecting, the server discarding those it already has
+	// (by their sequence numbers). Requires a RelayServer of this version.
+	Acks bool
+	// OnAck, if set (with Acks), is called with the number of records acknowledged, as acknowledgements arrive.
+	OnAck func(count int)
+	// Codec, if set, encodes (e.g. compresses, see GzipCodec) the records sent in batches. The server
+	// must have the codec registered (see RegisterCodec), and be of this version.
+	Codec Codec
+}
+
+// RelayDelivery is the delivery status of a RelayHandler with acknowledgements enabled (see RelayOpts).
+type RelayDelivery struct {
+	// Sent is the number of records sent (not counting resends).
+	Sent uint64
+	// Acked is the number of records acknowledged by the server.
+	Acked uint64
+	// Resent is the number of records resent after reconnecting.
+	Resent uint64
+	// Unacked is the number of sent records awaiting acknowledgement.
+	Unacked int
+}
+
+// relayPending is a sent record, awaiting acknowledgement.
+type relayPending struct {
+	seq uint64
+	rec Record
+}
+
+// RelayHandler forwards (unformatted) records to a RelayServer, typically in another process,
+// which applies formatting and routing there.
+// Records are sent in the background; if the connection fails, it's re-established (with a back off).
+type RelayHandler struct {
+	addr      string
+	spill     *SpillQueue
+	formatter Formatter
+	level     Level
+
+	lock          sync.RWMutex // guards commitChannel against being closed while sending
+	commitChannel chan Record
+	done          chan struct{}
+
+	conn   net.Conn // only accessed by the committer goroutine
+	writer *bufio.Writer
+	codec  Codec
+	batch  *relayBatchWriter // the writer's target, with a codec
+
+	acks    bool
+	onAck   func(count int)
+	session uint64
+	seq     uint64 // sequence number of the last record sent, only accessed by the committer goroutine
+
+	ackLock  sync.Mutex // guards pending and delivery
+	pending  []relayPending
+	delivery RelayDelivery
+	acked    chan struct{} // signaled when acknowledgements arrive
+
+	statusLock sync.Mutex // guards status
+	status     HandlerHealth
+}
+
+// NewRelayHandler returns a new RelayHandler instance sending records to addr ("host:port").
+func NewRelayHandler(addr string, opts ...RelayOpts) (*RelayHandler, error) {
+	h := &RelayHandler{
+		addr:          addr,
+		commitChannel: make(chan Record, 1000),
+		done:          make(chan struct{}),
+		acked:         make(chan struct{}, 1),
+		status:        HandlerHealth{Healthy: true},
+	}
+	if len(opts) > 0 {
+		h.spill = opts[0].Spill
+		h.acks = opts[0].Acks
+		h.onAck = opts[0].OnAck
+		h.codec = opts[0].Codec
+	}
+	if h.acks {
+		var session [8]byte
+		rand.Read(session[:])
+		h.session = binary.BigEndian.Uint64(session[:])
+	}
+
+	// fail early on misconfiguration
+	if err := h.connect(); err != nil {
+		if h.spill == nil {
+			return nil, fmt.Errorf("log4go: cannot connect to relay server %s: %w", addr, err)
+		}
+		h.failed(err)
+	}
+
+	go h.committer(h.commitChannel)
+
+	return h, nil
+}
+
+func (h *RelayHandler) connect() error {
+	conn, err := net.DialTimeout("tcp", h.addr, 5*time.Second)
+	if err != nil {
+		return err
+	}
+	h.conn = conn
+	if h.codec != nil {
+		h.batch = &relayBatchWriter{conn: conn, codec: h.codec}
+		h.writer = bufio.NewWriter(h.batch)
+	} else {
+		h.writer = bufio.NewWriter(conn)
+	}
+
+	if h.acks {
+		go h.readAcks(conn)
+		if err := h.resend(); err != nil {
+			h.disconnect()
+			return err
+		}
+	}
+	return nil
+}
+
+func (h *RelayHandler) disconnect() {
+	if h.conn != nil {
+		h.conn.Close()
+		h.conn = nil
+		h.writer = nil
+		h.batch = nil
+	}
+}
+
+// Handle queues the record to be sent.
+func (h *RelayHandler) Handle(rec *Record) error {
+	if rec.Level < h.level {
+		return nil
+	}
+
+	h.lock.RLock()
+	defer h.lock.RUnlock()
+
+	if h.commitChannel != nil {
+		h.commitChannel <- *rec
+	}
+	return nil
+}
+
+func (h *RelayHandler) committer(commitChannel <-chan Record) {
+	defer close(h.done)
+	defer h.disconnect()
+
+	var retryAt time.Time
+	backoff := 100 * time.Millisecond
+
+	for rec := range commitChannel {
+		if h.conn == nil {
+			if time.Now().Before(retryAt) {
+				h.spillRecord(&rec)
+				continue
+			}
+			if err := h.connect(); err != nil {
+				report(ERROR, "RelayHandler", "connect error: %v", err)
+				h.failed(err)
+				retryAt = time.Now().Add(backoff)
+				if backoff < time.Minute {
+					backoff *= 2
+				}
+				h.spillRecord(&rec)
+				continue
+			}
+			h.updateStatus(func(status *HandlerHealth) {
+				if !status.Healthy {
+					status.Healthy = true
+					status.Reopens++
+				}
+			})
+			backoff = 100 * time.Millisecond
+		}
+
+		written := false
+		err := h.replay()
+		if err == nil {
+			err = h.write(&rec)
+			written = err == nil || errors.Is(err, errUnacked)
+		}
+		if err == nil && len(commitChannel) == 0 {
+			err = h.flush()
+		}
+		if err != nil {
+			report(ERROR, "RelayHandler", "write error: %v", err)
+			h.failed(err)
+			h.disconnect()
+			if !written || !h.acks {
+				h.spillRecord(&rec) // (written records awaiting acknowledgement are resent)
+			}
+		}
+	}
+
+	if h.writer != nil {
+		h.replay()
+		if h.flush() == nil && h.acks {
+			h.awaitAcks(0)
+		}
+	}
+	if h.acks {
+		h.dropUnacked()
+	}
+	if h.spill != nil {
+		h.spill.Close()
+	}
+}
+
+// spillRecord queues the (unsent) record on disk, if spilling is enabled; otherwise it's dropped.
+func (h *RelayHandler) spillRecord(rec *Record) {
+	if h.spill == nil {
+		h.updateStatus(func(status *HandlerHealth) { status.Dropped++ })
+		return
+	}
+	if err := h.spill.Push(rec); err != nil {
+		report(ERROR, "RelayHandler", "spill error: %v", err)
+	}
+}
+
+// replay sends the records queued on disk (if any).
+func (h *RelayHandler) replay() error {
+	if h.spill == nil || h.spill.Size() == 0 {
+		return nil
+	}
+	if !h.acks {
+		// records are only removed from the queue once flushed, not while they're only buffered
+		_, err := h.spill.Replay(h.write, ReplayOpts{Flush: h.flush})
+		return err
+	}
+
+	// records written are awaiting acknowledgement (resent after reconnecting, or spilled again when
+	// shutting down), including the one whose acknowledgements timed out, stopping the replay
+	var unacked error
+	_, err := h.spill.Replay(func(rec *Record) error {
+		if unacked != nil {
+			return unacked
+		}
+		err := h.write(rec)
+		if errors.Is(err, errUnacked) {
+			unacked = err
+			return nil
+		}
+		return err
+	})
+	if err == nil {
+		err = unacked
+	}
+	if err == nil {
+		err = h.flush()
+	}
+	return err
+}
+
+// flush sends the buffered frames (in an encoded batch, with a codec).
+func (h *RelayHandler) flush() error {
+	if err := h.writer.Flush(); err != nil {
+		return err
+	}
+	if h.batch != nil {
+		return h.batch.flush()
+	}
+	return nil
+}
+
+// buffered returns the size of the frames buffered, not sent yet.
+func (h *RelayHandler) buffered() int {
+	buffered := h.writer.Buffered()
+	if h.batch != nil {
+		buffered += len(h.batch.frames)
+	}
+	return buffered
+}
+
+// write sends the record, to be acknowledged if enabled.
+func (h *RelayHandler) write(rec *Record) error {
+	if !h.acks {
+		return h.writeFrame(0, rec)
+	}
+
+	if err := h.writeFrame(h.seq+1, rec); err != nil {
+		return err
+	}
+	h.seq++
+
+	h.ackLock.Lock()
+	h.pending = append(h.pending, relayPending{seq: h.seq, rec: *rec})
+	h.delivery.Sent++
+	full := len(h.pending) >= maxRelayUnacked
+	h.ackLock.Unlock()
+
+	if full {
+		if err := h.flush(); err != nil {
+			return err
+		}
+		return h.awaitAcks(maxRelayUnacked - 1)
+	}
+	return nil
+}
+
+// writeFrame writes the encoded record, prefixed by its size (and the session ID and sequence number, if acknowledged).
+func (h *RelayHandler) writeFrame(seq uint64, rec *Record) error {
+	data, err := rec.MarshalBinary()
+	if err != nil {
+		return err
+	}
+
+	var header [20]byte
+	headerSize := 4
+	if seq == 0 {
+		binary.BigEndian.PutUint32(header[:], uint32(len(data)))
+	} else {
+		binary.BigEndian.PutUint32(header[:], uint32(len(data))|relayAckFlag)
+		binary.BigEndian.PutUint64(header[4:], h.session)
+		binary.BigEndian.PutUint64(header[12:], seq)
+		headerSize = len(header)
+	}
+	if _, err = h.writer.Write(header[:headerSize]); err == nil {
+		_, err = h.writer.Write(data)
+	}
+	return err
+}
+
+// relayBatchWriter collects frames, sending them as encoded batches (see RelayOpts.Codec).
+type relayBatchWriter struct {
+	conn   net.Conn
+	codec  Codec
+	frames []byte
+}
+
+// Write adds the data (of frames) to the batch, sending it when full.
+func (w *relayBatchWriter) Write(data []byte) (int, error) {
+	w.frames = append(w.frames, data...)
+	if len(w.frames) >= relayBatchSize {
+		if err := w.flush(); err != nil {
+			return 0, err
+		}
+	}
+	return len(data), nil
+}
+
+// flush sends the batch (if any), encoded.
+func (w *relayBatchWriter) flush() error {
+	if len(w.frames) == 0 {
+		return nil
+	}
+	encoded, err := w.codec.Encode(w.frames)
+	if err != nil {
+		return err
+	}
+	name := w.codec.Name()
+	header := make([]byte, 5, 5+len(name)+len(encoded))
+	binary.BigEndian.PutUint32(header, uint32(len(encoded))|relayBatchFlag)
+	header[4] = byte(len(name))
+	header = append(append(header, name...), encoded...)
+	if _, err := w.conn.Write(header); err != nil {
+		return err
+	}
+	w.frames = w.frames[:0]
+	return nil
+}
+
+// resend sends the records awaiting acknowledgement (after reconnecting).
+func (h *RelayHandler) resend() error {
+	h.ackLock.Lock()
+	pending := append([]relayPending(nil), h.pending...)
+	h.ackLock.Unlock()
+
+	for idx := range pending {
+		if err := h.writeFrame(pending[idx].seq, &pending[idx].rec); err != nil {
+			return err
+		}
+	}
+	if len(pending) == 0 {
+		return nil
+	}
+
+	h.ackLock.Lock()
+	h.delivery.Resent += uint64(len(pending))
+	h.ackLock.Unlock()
+
+	return h.flush()
+}
+
+// readAcks receives the acknowledgements (each being the sequence number of the last record received)
+// until the connection is closed.
+func (h *RelayHandler) readAcks(conn net.Conn) {
+	var ack [8]byte
+	for {
+		if _, err := io.ReadFull(conn, ack[:]); err != nil {
+			return
+		}
+		seq := binary.BigEndian.Uint64(ack[:])
+
+		if h.onAck != nil {
+			// called before updating the status, so it's done once Shutdown has seen all records acknowledged
+			if count := h.countAcked(seq); count > 0 {
+				h.onAck(count)
+			}
+		}
+
+		h.ackLock.Lock()
+		count := h.countAckedLocked(seq)
+		h.pending = h.pending[count:]
+		h.delivery.Acked += uint64(count)
+		h.ackLock.Unlock()
+
+		if count > 0 {
+			select {
+			case h.acked <- struct{}{}:
+			default:
+			}
+		}
+	}
+}
+
+// countAcked returns the number of records awaiting acknowledgement, up to the sequence number.
+func (h *RelayHandler) countAcked(seq uint64) int {
+	h.ackLock.Lock()
+	defer h.ackLock.Unlock()
+
+	return h.countAckedLocked(seq)
+}
+
+// countAckedLocked does the actual work of countAcked (ackLock must be held).
+func (h *RelayHandler) countAckedLocked(seq uint64) int {
+	count := 0
+	for count < len(h.pending) && h.pending[count].seq <= seq {
+		count++
+	}
+	return count
+}
+
+// awaitAcks waits until at most max records are awaiting acknowledgement, or returns an error on timeout.
+func (h *RelayHandler) awaitAcks(max int) error {
+	timeout := time.NewTimer(relayAckTimeout)
+	defer timeout.Stop()
+
+	for {
+		h.ackLock.Lock()
+		unacked := len(h.pending)
+		h.ackLock.Unlock()
+		if unacked <= max {
+			return nil
+		}
+
+		select {
+		case <-h.acked:
+		case <-timeout.C:
+			return fmt.Errorf("%d %w within %v", unacked, errUnacked, relayAckTimeout)
+		}
+	}
+}
+
+// dropUnacked spills (or drops) the records still awaiting acknowledgement when shutting down.
+func (h *RelayHandler) dropUnacked() {
+	h.ackLock.Lock()
+	pending := h.pending
+	h.pending = nil
+	h.ackLock.Unlock()
+
+	if len(pending) == 0 {
+		return
+	}
+	if h.spill == nil {
+		report(ERROR, "RelayHandler", "dropped %d unacknowledged records", len(pending))
+	}
+	for idx := range pending {
+		h.spillRecord(&pending[idx].rec)
+	}
+}
+
+// Delivery returns the delivery status (if acknowledgements are enabled, see RelayOpts).
+func (h *RelayHandler) Delivery() RelayDelivery {
+	h.ackLock.Lock()
+	defer h.ackLock.Unlock()
+
+	delivery := h.delivery
+	delivery.Unacked = len(h.pending)
+	return delivery
+}
+
+// failed marks the handler unhealthy, after failing to connect or send.
+func (h *RelayHandler) failed(err error) {
+	h.updateStatus(func(status *HandlerHealth) {
+		status.Healthy = false
+		status.WriteErrors++
+		status.LastError = err
+	})
+}
+
+func (h *RelayHandler) updateStatus(update func(status *HandlerHealth)) {
+	h.statusLock.Lock()
+	update(&h.status)
+	h.statusLock.Unlock()
+}
+
+// Health returns the health status of the connection: unhealthy while disconnected after an error
+// (Reopens counting the reconnects, and Dropped the records dropped meanwhile, unless spilled).
+func (h *RelayHandler) Health() HandlerHealth {
+	h.statusLock.Lock()
+	defer h.statusLock.Unlock()
+
+	return h.status
+}
+
+// SetFormatter sets the handler's Formatter (not used, records are sent unformatted).
+func (h *RelayHandler) SetFormatter(formatter Formatter) {
+	h.formatter = formatter
+}
+
+// Formatter returns the handler's Formatter.
+func (h *RelayHandler) Formatter() Formatter {
+	return h.formatter
+}
+
+// SetLevel sets the level the handler will (at least) handle.
+func (h *RelayHandler) SetLevel(level Level) {
+	h.level = level
+}
+
+// Level returns the level previously set (or INHERIT if not set).
+func (h *RelayHandler) Level() Level {
+	return h.level
+}
+
+// Shutdown sends any queued records and closes the connection.
+func (h *RelayHandler) Shutdown() {
+	h.lock.Lock()
+	cc := h.commitChannel
+	h.commitChannel = nil
+	h.lock.Unlock()
+
+	if cc != nil {
+		close(cc)
+		<-h.done
+	}
+}
+
+// RelayServer receives records from RelayHandler instances and logs them
+// using the local configuration: each record is passed to the logger with the same name.
+type RelayServer struct {
+	listener net.Listener
+
+	lock     sync.Mutex
+	conns    map[net.Conn]bool
+	sessions map[uint64]*relaySession
+	loggers  int // created for the names of the records received (see relayLogger)
+	wg       sync.WaitGroup
+}
+
+// relaySession tracks the last record received from a RelayHandler (with acknowledgements enabled),
+// to discard records resent after a reconnect.
+type relaySession struct {
+	seq  uint64
+	seen time.Time
+}
+
+// relaySessionExpiry is the time after which idle sessions are forgotten.
+const relaySessionExpiry = time.Hour
+
+// maxRelayLoggers is the maximum number of loggers a RelayServer creates for the names of the records received;
+// records of other names are passed to the closest existing logger.
+var maxRelayLoggers = 1000
+
+// NewRelayServer returns a new RelayServer listening on addr ("host:port"); call Serve to start receiving.
+func NewRelayServer(addr string) (*RelayServer, error) {
+	listener, err := net.Listen("tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+	return &RelayServer{
+		listener: listener,
+		conns:    map[net.Conn]bool{},
+		sessions: map[uint64]*relaySession{},
+	}, nil
+}
+
+// Addr returns the address the server is listening on.
+func (s *RelayServer) Addr() net.Addr {
+	return s.listener.Addr()
+}
+
+// Serve accepts connections (until Close is called), returning the error stopping it.
+func (s *RelayServer) Serve() error {
+	for {
+		conn, err := s.listener.Accept()
+		if err != nil {
+			return err
+		}
+
+		s.lock.Lock()
+		s.conns[conn] = true
+		s.lock.Unlock()
+
+		s.wg.Add(1)
+		go s.receive(conn)
+	}
+}
+
+func (s *RelayServer) receive(conn net.Conn) {
+	defer s.wg.Done()
+	defer func() {
+		s.lock.Lock()
+		delete(s.conns, conn)
+		s.lock.Unlock()
+		conn.Close()
+	}()
+
+	reader := bufio.NewReader(conn)
+	var size [4]byte
+	var ack [8]byte
+
+	for {
+		if _, err := io.ReadFull(reader, size[:]); err != nil {
+			return
+		}
+		frameSize := binary.BigEndian.Uint32(size[:])
+
+		var acked bool
+		var seq uint64
+		var err error
+		if frameSize&relayBatchFlag != 0 {
+			acked, seq, err = s.receiveBatch(reader, frameSize&^relayBatchFlag)
+		} else {
+			acked, seq, err = s.receiveFrame(reader, frameSize)
+		}
+		if err != nil {
+			if err != io.EOF && err != io.ErrUnexpectedEOF {
+				report(ERROR, "RelayServer", "%v from %s", err, conn.RemoteAddr())
+			}
+			return
+		}
+
+		// acknowledge once all records received so far are dispatched
+		if acked && reader.Buffered() == 0 {
+			binary.BigEndian.PutUint64(ack[:], seq)
+			if _, err := conn.Write(ack[:]); err != nil {
+				return
+			}
+		}
+	}
+}
+
+// receiveFrame reads the record of the frame (its size read already) and dispatches it (unless received before),
+// returning whether it's to be acknowledged, and its sequence number.
+func (s *RelayServer) receiveFrame(reader io.Reader, frameSize uint32) (bool, uint64, error) {
+	var session, seq uint64
+	acked := frameSize&relayAckFlag != 0
+	if acked {
+		frameSize &^= relayAckFlag
+		var ids [16]byte
+		if _, err := io.ReadFull(reader, ids[:]); err != nil {
+			return false, 0, err
+		}
+		session = binary.BigEndian.Uint64(ids[:])
+		seq = binary.BigEndian.Uint64(ids[8:])
+	}
+	if frameSize > maxRelayFrame {
+		return false, 0, fmt.Errorf("frame too large (%d bytes)", frameSize)
+	}
+
+	data := make([]byte, frameSize)
+	if _, err := io.ReadFull(reader, data); err != nil {
+		return false, 0, err
+	}
+
+	var rec Record
+	if err := rec.UnmarshalBinary(data); err != nil {
+		return false, 0, fmt.Errorf("invalid record: %v", err)
+	}
+
+	if !acked || s.received(session, seq) {
+		s.relayLogger(rec.Name).dispatch(&rec)
+	}
+	return acked, seq, nil
+}
+
+// receiveBatch reads and decodes a batch of frames (its size read already), and receives them,
+// returning whether any is to be acknowledged, and the last sequence number.
+func (s *RelayServer) receiveBatch(reader io.Reader, batchSize uint32) (bool, uint64, error) {
+	var nameSize [1]byte
+	if _, err := io.ReadFull(reader, nameSize[:]); err != nil {
+		return false, 0, err
+	}
+	name := make([]byte, nameSize[0])
+	if _, err := io.ReadFull(reader, name); err != nil {
+		return false, 0, err
+	}
+	codec := codecByName(string(name))
+	if codec == nil {
+		return false, 0, fmt.Errorf("unknown codec %q", name)
+	}
+	if batchSize > maxRelayFrame {
+		return false, 0, fmt.Errorf("batch too large (%d bytes)", batchSize)
+	}
+
+	encoded := make([]byte, batchSize)
+	if _, err := io.ReadFull(reader, encoded); err != nil {
+		return false, 0, err
+	}
+	frames, err := decodeLimited(codec, encoded, maxRelayBatch)
+	if err != nil {
+		return false, 0, fmt.Errorf("invalid %s batch: %v", name, err)
+	}
+
+	var acked bool
+	var seq uint64
+	batch := bytes.NewReader(frames)
+	var size [4]byte
+	for batch.Len() > 0 {
+		if _, err := io.ReadFull(batch, size[:]); err != nil {
+			return false, 0, fmt.Errorf("invalid %s batch: %v", name, err)
+		}
+		frameAcked, frameSeq, err := s.receiveFrame(batch, binary.BigEndian.Uint32(size[:]))
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			return false, 0, fmt.Errorf("invalid %s batch: truncated frame", name)
+		} else if err != nil {
+			return false, 0, err
+		}
+		if frameAcked {
+			acked, seq = true, frameSeq
+		}
+	}
+	return acked, seq, nil
+}
+
+// received records the sequence number of the session, returning false if the record was received before.
+func (s *RelayServer) received(session, seq uint64) bool {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	now := time.Now()
+	state, exists := s.sessions[session]
+	if !exists {
+		var oldest uint64
+		for id, state := range s.sessions {
+			if now.Sub(state.seen) > relaySessionExpiry {
+				delete(s.sessions, id)
+			} else if old, ok := s.sessions[oldest]; !ok || state.seen.Before(old.seen) {
+				oldest = id
+			}
+		}
+		// not letting clients (sending any session IDs) grow the map without limit
+		if len(s.sessions) >= maxRelaySessions {
+			delete(s.sessions, oldest)
+		}
+		state = &relaySession{}
+		s.sessions[session] = state
+	}
+	state.seen = now
+
+	if seq <= state.seq {
+		return false
+	}
+	state.seq = seq
+	return true
+}
+
+// relayLogger returns the logger of the name, creating it (and its missing ancestors) unless that would exceed
+// maxRelayLoggers created, i.e. not letting clients grow the logger tree without limit; the closest existing one then.
+func (s *RelayServer) relayLogger(name string) *Logger {
+	logger, exact := closestLogger(name)
+	if exact {
+		return logger
+	}
+	missing := strings.Count(name, "/") + 1
+	if len(logger.name) > 0 {
+		missing -= strings.Count(logger.name, "/") + 1
+	}
+
+	s.lock.Lock()
+	create := s.loggers+missing <= maxRelayLoggers
+	if create {
+		s.loggers += missing
+	}
+	s.lock.Unlock()
+
+	if create {
+		return loggerByName(name)
+	}
+	return logger
+}
+
+// Close stops accepting connections, and waits for the current connections to finish.
+func (s *RelayServer) Close() error {
+	err := s.listener.Close()
+
+	s.lock.Lock()
+	for conn := range s.conns {
+		// let the receivers finish reading what's already been sent
+		conn.SetReadDeadline(time.Now().Add(100 * time.Millisecond))
+	}
+	s.lock.Unlock()
+
+	s.wg.Wait()
+
+	return err
+}
+
+// loggerByName returns the logger with the full name (e.g. "a/b/c"), creating it and its ancestors as needed.
+func loggerByName(name string) *Logger {
+	logger := GetLogger()
+	if len(name) == 0 {
+		return logger
+	}
+	for _, part := range strings.Split(name, "/") {
+		logger = logger.GetLogger(part)
+	}
+	return logger
+}
+
+// closestLogger returns the logger with the full name if it exists (and true), or its closest existing ancestor,
+// without creating any.
+func closestLogger(name string) (*Logger, bool) {
+	logger := GetLogger()
+	if len(name) == 0 {
+		return logger, true
+	}
+	for _, part := range strings.Split(name, "/") {
+		children, _ := logger.childByName.Load().(map[string]*Logger)
+		child, exists := children[part]
+		if !exists {
+			return logger, false
+		}
+		logger = child
+	}
+	return logger, true
+}
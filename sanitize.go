@@ -0,0 +1,66 @@
+package log4go
+
+import (
+	"fmt"
+	"strings"
+	"sync/atomic"
+	"unicode/utf8"
+)
+
+// sanitizingDisabled is set by EnableSanitizing, accessed atomically.
+var sanitizingDisabled int32
+
+// EnableSanitizing makes control characters in messages (e.g. from untrusted input) be escaped, the default;
+// false to disable. ANSI escape sequences are rendered harmless by escaping the ESC character (as "\x1b"),
+// carriage returns as "\r", other control characters (including C1 ones) as "\xNN" or "\uNNNN", as are
+// the Unicode bidirectional overrides (reordering the text displayed). Newlines and tabs are kept, i.e.
+// multi-line messages; structured formatters (e.g. JSONFormatter) escape them, preventing forged lines.
+// The format string and arguments are sanitized alike, prefixes and suffixes (see SetPrefix) aren't.
+func EnableSanitizing(enable bool) {
+	var value int32
+	if !enable {
+		value = 1
+	}
+	atomic.StoreInt32(&sanitizingDisabled, value)
+}
+
+func sanitizing() bool {
+	return atomic.LoadInt32(&sanitizingDisabled) == 0
+}
+
+// sanitize returns the message with its control characters escaped (see EnableSanitizing),
+// not allocating unless there are any.
+func sanitize(message string) string {
+	idx := 0
+	for ; idx < len(message); idx++ {
+		if c := message[idx]; (c < 0x20 && c != '\n' && c != '\t') || c == 0x7f || c == 0xc2 || c == 0xe2 {
+			break // 0xc2 and 0xe2 start the UTF-8 encodings of C1 controls and the bidirectional overrides
+		}
+	}
+	if idx == len(message) {
+		return message
+	}
+
+	var b strings.Builder
+	b.Grow(len(message) + 8)
+	b.WriteString(message[:idx])
+	for idx < len(message) {
+		r, size := utf8.DecodeRuneInString(message[idx:])
+		switch {
+		case r == utf8.RuneError && size == 1:
+			b.WriteByte(message[idx]) // invalid UTF-8 is kept as it is
+		case r == '\n' || r == '\t':
+			b.WriteRune(r)
+		case r == '\r':
+			b.WriteString(`\r`)
+		case r < 0x20 || r == 0x7f:
+			fmt.Fprintf(&b, `\x%02x`, r)
+		case (r >= 0x80 && r <= 0x9f) || (r >= 0x202a && r <= 0x202e) || (r >= 0x2066 && r <= 0x2069):
+			fmt.Fprintf(&b, `\u%04x`, r)
+		default:
+			b.WriteString(message[idx : idx+size])
+		}
+		idx += size
+	}
+	return b.String()
+}
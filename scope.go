@@ -0,0 +1,104 @@
+package log4go
+
+import (
+	"context"
+	"sync"
+)
+
+// Capture collects the records emitted by loggers derived using WithCapture, without affecting the
+// normal handler output; e.g. to include the records of one HTTP request in an error response.
+type Capture struct {
+	level Level
+	max   int
+
+	lock    sync.Mutex
+	records []Record
+}
+
+// NewCapture returns a new Capture, collecting (at most max, 0 meaning no limit) records of at least the level.
+// INHERIT means the records emitted to the handlers, i.e. the records passing the loggers' levels.
+// A lower level captures records the handlers don't get as well (e.g. DEBUG, while the logger is at WARNING).
+func NewCapture(level Level, max int) *Capture {
+	return &Capture{
+		level: level,
+		max:   max,
+	}
+}
+
+// Records returns (a copy of) the captured records.
+func (c *Capture) Records() []Record {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	return append([]Record(nil), c.records...)
+}
+
+// Reset discards the captured records.
+func (c *Capture) Reset() {
+	c.lock.Lock()
+	c.records = nil
+	c.lock.Unlock()
+}
+
+// wants returns whether the capture collects records of the level (emitted to the handlers, or not).
+func (c *Capture) wants(lvl Level, emitted bool) bool {
+	if c.level == INHERIT {
+		return emitted
+	}
+	return lvl >= c.level
+}
+
+func (c *Capture) add(rec *Record) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	if c.max > 0 && len(c.records) >= c.max {
+		return
+	}
+	c.records = append(c.records, *rec)
+}
+
+// captures are the captures of a (derived) logger, replaced (never modified) on change.
+type captures []*Capture
+
+func (cs captures) wants(lvl Level, emitted bool) bool {
+	for _, c := range cs {
+		if c.wants(lvl, emitted) {
+			return true
+		}
+	}
+	return false
+}
+
+func (cs captures) add(rec *Record, emitted bool) {
+	for _, c := range cs {
+		if c.wants(rec.Level, emitted) {
+			c.add(rec)
+		}
+	}
+}
+
+// WithCapture returns a logger additionally passing its records to the capture (see Capture).
+// Like With, the returned logger uses the level and handlers of the logger it was derived from,
+// and loggers derived from it capture as well.
+func (l *Logger) WithCapture(c *Capture) *Logger {
+	derived := l.derive()
+	derived.captures = append(captures{c}, l.captures...)
+	return derived
+}
+
+// contextKey is the key of the logger in a context.Context.
+type contextKey struct{}
+
+// NewContext returns a context carrying the logger, e.g. one capturing the records of a request (see WithCapture).
+func NewContext(ctx context.Context, l *Logger) context.Context {
+	return context.WithValue(ctx, contextKey{}, l)
+}
+
+// FromContext returns the logger carried by the context, or the root logger if it has none.
+func FromContext(ctx context.Context) *Logger {
+	if l, ok := ctx.Value(contextKey{}).(*Logger); ok {
+		return l
+	}
+	return GetLogger()
+}
@@ -0,0 +1,95 @@
+package log4go
+
+import (
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+// sourceRule enables records of (at least) the level, logged from code in the source (see SetSourceLevel).
+type sourceRule struct {
+	source string
+	file   bool // whether source is a file, rather than a package
+	level  Level
+}
+
+// sourceRules are replaced (never modified) on change.
+type sourceRules struct {
+	rules []sourceRule
+	min   Level // the lowest level of the rules
+}
+
+var sourceLevels atomic.Value // *sourceRules
+var sourceLevelsLock sync.Mutex
+
+// SetSourceLevel enables records of (at least) the level, logged from code in the source, regardless of the
+// logger's level; e.g. to enable DEBUG only for the package db/pool, when the code doesn't use distinct loggers.
+// The source is either a package path, or its last elements (e.g. "db/pool"), or a source file name,
+// possibly with the last elements of its directory (e.g. "pool.go" or "db/pool/conn.go").
+// Use INHERIT to remove the rule.
+//
+// The rules only enable records, they don't restrict the loggers' levels.
+// Looking up the caller costs about a microsecond, done for records (of at least the lowest level of the rules)
+// not enabled by the logger's level.
+func SetSourceLevel(source string, level Level) {
+	sourceLevelsLock.Lock()
+	defer sourceLevelsLock.Unlock()
+
+	updated := &sourceRules{}
+	if current, _ := sourceLevels.Load().(*sourceRules); current != nil {
+		for _, rule := range current.rules {
+			if rule.source != source {
+				updated.rules = append(updated.rules, rule)
+			}
+		}
+	}
+	if level != INHERIT {
+		updated.rules = append(updated.rules, sourceRule{
+			source: source,
+			file:   strings.HasSuffix(source, ".go"),
+			level:  level,
+		})
+	}
+
+	if len(updated.rules) == 0 {
+		sourceLevels.Store((*sourceRules)(nil))
+		return
+	}
+	updated.min = updated.rules[0].level
+	for _, rule := range updated.rules[1:] {
+		if rule.level < updated.min {
+			updated.min = rule.level
+		}
+	}
+	sourceLevels.Store(updated)
+}
+
+// sourceEnabled returns whether a record of the level is enabled by a rule matching its caller
+// (skip frames above log4go's own, see Logger.WithCallerSkip).
+func sourceEnabled(lvl Level, skip int) bool {
+	current, _ := sourceLevels.Load().(*sourceRules)
+	if current == nil || lvl < current.min {
+		return false
+	}
+
+	frames := callerFrames(skip, 1)
+	if len(frames) == 0 {
+		return false
+	}
+	frame := frames[0]
+	pkg := funcPackage(frame.Function)
+
+	for _, rule := range current.rules {
+		if lvl < rule.level {
+			continue
+		}
+		if rule.file {
+			if frame.File == rule.source || strings.HasSuffix(frame.File, "/"+rule.source) {
+				return true
+			}
+		} else if pkg == rule.source || strings.HasSuffix(pkg, "/"+rule.source) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,286 @@
+package log4go
+
+import (
+	"encoding/binary"
+	"fmt"
+	"hash/crc32"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// SpillOpts is used to supply options to NewSpillQueue.
+type SpillOpts struct {
+	// MaxSize is the maximum total size of the queue's files (default 64 MB); when exceeded, the oldest
+	// segments are removed.
+	MaxSize int64
+	// MaxAge is the maximum age of records to replay (default 24 hours); older ones are dropped.
+	MaxAge time.Duration
+	// SegmentSize is the size at which a new segment file is started (default 4 MB).
+	SegmentSize int64
+}
+
+const spillSuffix = ".spill"
+
+// SpillQueue is an on-disk queue of records, for handlers shipping to remote systems to buffer records
+// during outages and replay them on reconnect (see RelayOpts).
+// The records are appended to segment files in a directory, each record with a checksum; corrupt records
+// (e.g. the last one written before a crash) are skipped when replaying.
+// Records left in the directory (e.g. by a previous process) are replayed as well.
+type SpillQueue struct {
+	dir  string
+	opts SpillOpts
+
+	lock     sync.Mutex
+	segments []*spillSegment // oldest first, the last one possibly being written
+	current  *os.File
+	next     uint64 // sequence number of the next segment
+	size     int64
+
+	replayLock sync.Mutex // serializes Replay
+}
+
+type spillSegment struct {
+	name string
+	size int64
+}
+
+// NewSpillQueue returns a new SpillQueue, storing its files in the directory (created if missing).
+func NewSpillQueue(dir string, opts ...SpillOpts) (*SpillQueue, error) {
+	q := &SpillQueue{dir: dir}
+	if len(opts) > 0 {
+		q.opts = opts[0]
+	}
+	if q.opts.MaxSize <= 0 {
+		q.opts.MaxSize = 64 << 20
+	}
+	if q.opts.MaxAge <= 0 {
+		q.opts.MaxAge = 24 * time.Hour
+	}
+	if q.opts.SegmentSize <= 0 {
+		q.opts.SegmentSize = 4 << 20
+	}
+
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+	names, err := filepath.Glob(filepath.Join(dir, "*"+spillSuffix))
+	if err != nil {
+		return nil, err
+	}
+	sort.Strings(names) // the sequence numbers are zero-padded
+	for _, name := range names {
+		info, err := os.Stat(name)
+		if err != nil {
+			return nil, err
+		}
+		seq, err := strconv.ParseUint(strings.TrimSuffix(filepath.Base(name), spillSuffix), 10, 64)
+		if err != nil {
+			continue // not ours
+		}
+		q.segments = append(q.segments, &spillSegment{name: name, size: info.Size()})
+		q.size += info.Size()
+		q.next = seq + 1
+	}
+
+	return q, nil
+}
+
+// Push appends the record to the queue.
+func (q *SpillQueue) Push(rec *Record) error {
+	data, err := rec.MarshalBinary()
+	if err != nil {
+		return err
+	}
+	frame := make([]byte, 8+len(data))
+	binary.BigEndian.PutUint32(frame, uint32(len(data)))
+	binary.BigEndian.PutUint32(frame[4:], crc32.ChecksumIEEE(data))
+	copy(frame[8:], data)
+
+	q.lock.Lock()
+	defer q.lock.Unlock()
+
+	if q.current != nil && q.segments[len(q.segments)-1].size+int64(len(frame)) > q.opts.SegmentSize {
+		q.current.Close()
+		q.current = nil
+	}
+	if q.current == nil {
+		name := filepath.Join(q.dir, fmt.Sprintf("%016d%s", q.next, spillSuffix))
+		if q.current, err = os.OpenFile(name, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0644); err != nil {
+			return err
+		}
+		q.next++
+		q.segments = append(q.segments, &spillSegment{name: name})
+	}
+
+	n, err := q.current.Write(frame)
+	q.segments[len(q.segments)-1].size += int64(n)
+	q.size += int64(n)
+
+	// remove the oldest segments (but not the one being written) while too large
+	for q.size > q.opts.MaxSize && len(q.segments) > 1 {
+		dropped := q.segments[0]
+		q.segments = q.segments[1:]
+		q.size -= dropped.size
+		os.Remove(dropped.name)
+		report(WARNING, "SpillQueue", "size limit exceeded, dropped %s", dropped.name)
+	}
+
+	return err
+}
+
+// ReplayOpts is used to supply options to SpillQueue.Replay.
+type ReplayOpts struct {
+	// Flush sends the records buffered by send (e.g. in a bufio.Writer): records are only removed from
+	// the queue once flushed, i.e. the records sent since the last successful flush are kept if send
+	// (or Flush) fails.
+	Flush func() error
+	// FlushEvery is the size of the records (encoded) sent between flushes (default 32 kB).
+	FlushEvery int
+}
+
+// Replay passes the queued records, oldest first, to send, removing them from the queue.
+// If send fails, the remaining records are kept (including the failed one), and the error returned.
+// Records pushed while replaying are not replayed until the next call.
+func (q *SpillQueue) Replay(send func(rec *Record) error, opts ...ReplayOpts) (int, error) {
+	q.replayLock.Lock()
+	defer q.replayLock.Unlock()
+
+	var opt ReplayOpts
+	if len(opts) > 0 {
+		opt = opts[0]
+	}
+	if opt.FlushEvery <= 0 {
+		opt.FlushEvery = 32 << 10
+	}
+
+	q.lock.Lock()
+	if q.current != nil {
+		q.current.Close()
+		q.current = nil
+	}
+	segments := append([]*spillSegment(nil), q.segments...)
+	q.lock.Unlock()
+
+	count := 0
+	expired := time.Now().Add(-q.opts.MaxAge)
+	for _, segment := range segments {
+		data, err := ioutil.ReadFile(segment.name)
+		if err != nil && !os.IsNotExist(err) {
+			return count, err
+		}
+
+		// the records before flushed have been sent (i.e. flushed, if buffered)
+		offset, flushed, unflushed := 0, 0, 0
+		for offset+8 <= len(data) {
+			size := int(binary.BigEndian.Uint32(data[offset:]))
+			checksum := binary.BigEndian.Uint32(data[offset+4:])
+			if offset+8+size > len(data) || crc32.ChecksumIEEE(data[offset+8:offset+8+size]) != checksum {
+				break
+			}
+
+			var rec Record
+			if rec.UnmarshalBinary(data[offset+8:offset+8+size]) == nil && !rec.Time.Before(expired) {
+				if err := send(&rec); err != nil {
+					q.keep(segment, data[flushed:])
+					return count - unflushed, err
+				}
+				count++
+				unflushed++
+			}
+			offset += 8 + size
+
+			if opt.Flush == nil {
+				flushed, unflushed = offset, 0
+			} else if offset-flushed >= opt.FlushEvery {
+				if err := opt.Flush(); err != nil {
+					q.keep(segment, data[flushed:])
+					return count - unflushed, err
+				}
+				flushed, unflushed = offset, 0
+			}
+		}
+		if offset < len(data) {
+			report(ERROR, "SpillQueue", "skipped %d corrupt bytes in %s", len(data)-offset, segment.name)
+		}
+
+		if opt.Flush != nil && flushed < offset {
+			if err := opt.Flush(); err != nil {
+				q.keep(segment, data[flushed:])
+				return count - unflushed, err
+			}
+		}
+		q.remove(segment)
+	}
+
+	return count, nil
+}
+
+// keep replaces the segment's contents with the remaining (unsent) data, unless it's been removed meanwhile.
+func (q *SpillQueue) keep(segment *spillSegment, remaining []byte) {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+
+	if !q.listed(segment) {
+		return
+	}
+	temp := segment.name + ".tmp"
+	if err := ioutil.WriteFile(temp, remaining, 0644); err == nil {
+		if err = os.Rename(temp, segment.name); err == nil {
+			q.size -= segment.size - int64(len(remaining))
+			segment.size = int64(len(remaining))
+			return
+		}
+		os.Remove(temp)
+	}
+}
+
+// remove removes the (replayed) segment, unless it's been removed meanwhile.
+func (q *SpillQueue) remove(segment *spillSegment) {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+
+	for idx, s := range q.segments {
+		if s == segment {
+			q.segments = append(q.segments[:idx], q.segments[idx+1:]...)
+			q.size -= segment.size
+			os.Remove(segment.name)
+			return
+		}
+	}
+}
+
+func (q *SpillQueue) listed(segment *spillSegment) bool {
+	for _, s := range q.segments {
+		if s == segment {
+			return true
+		}
+	}
+	return false
+}
+
+// Size returns the total size of the queue's files.
+func (q *SpillQueue) Size() int64 {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+
+	return q.size
+}
+
+// Close closes the segment file being written; the queued records are kept (to be replayed by a later instance).
+func (q *SpillQueue) Close() error {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+
+	if q.current == nil {
+		return nil
+	}
+	err := q.current.Close()
+	q.current = nil
+	return err
+}
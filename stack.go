@@ -0,0 +1,153 @@
+package log4go
+
+import (
+	"fmt"
+	"reflect"
+	"runtime"
+	"strconv"
+	"strings"
+	"sync/atomic"
+)
+
+// stackPolicy is set by SetStackCapture.
+type stackPolicy struct {
+	level  Level
+	frames int
+}
+
+// SetStackCapture makes records of (at least) the level, logged by the logger or its descendants (unless set on them),
+// get an abbreviated stack trace attached (see Record.Stack, and the {stack} token): at most frames frames
+// (0 means all), excluding those of log4go itself. E.g. SetStackCapture(ERROR, 10). Use INHERIT to unset.
+func (l *Logger) SetStackCapture(level Level, frames int) {
+	l = l.node()
+
+	loggersLock.Lock()
+	defer loggersLock.Unlock()
+
+	if level == INHERIT {
+		l.stackPolicy.Store((*stackPolicy)(nil))
+	} else {
+		l.stackPolicy.Store(&stackPolicy{level: level, frames: frames})
+	}
+}
+
+// capturedStack returns the stack trace for a record of the level, if the stack capture policy
+// of the logger (or its nearest ancestor having one) says so.
+func (l *Logger) capturedStack(lvl Level, skip int) string {
+	for logger := l; logger != nil; logger = logger.parent {
+		if policy, _ := logger.stackPolicy.Load().(*stackPolicy); policy != nil {
+			if lvl < policy.level {
+				return ""
+			}
+			return formatFrames(callerFrames(skip, policy.frames))
+		}
+	}
+	return ""
+}
+
+// callersEnabled is set by EnableCallers, accessed atomically.
+var callersEnabled int32
+
+// EnableCallers makes every record get the location of the logging call (see Record.File and Record.Line,
+// and the {file} and {line} tokens), false to disable (the default). It costs about a microsecond per record.
+// Wrapper libraries use Logger.WithCallerSkip to report their callers' locations.
+func EnableCallers(enable bool) {
+	var value int32
+	if enable {
+		value = 1
+	}
+	atomic.StoreInt32(&callersEnabled, value)
+}
+
+func callers() bool {
+	return atomic.LoadInt32(&callersEnabled) != 0
+}
+
+// packagePath is the import path of log4go, the prefix of the function names of its own (and its sub packages') frames.
+var packagePath = reflect.TypeOf((*Logger)(nil)).Elem().PkgPath()
+
+// callerFrames returns (at most max, 0 meaning all) frames of the calling goroutine's stack,
+// excluding log4go's own (i.e. starting at the caller of the logging function), and skip more
+// (e.g. those of a wrapper library, see Logger.WithCallerSkip).
+func callerFrames(skip, max int) []runtime.Frame {
+	pcs := make([]uintptr, 64)
+	pcs = pcs[:runtime.Callers(2, pcs)]
+
+	size := len(pcs)
+	if max > 0 && max < size {
+		size = max
+	}
+	result := make([]runtime.Frame, 0, size)
+	frames := runtime.CallersFrames(pcs)
+	for more := true; more && (max == 0 || len(result) < max); {
+		var frame runtime.Frame
+		frame, more = frames.Next()
+
+		if len(result) == 0 && isInternal(frame) {
+			continue
+		}
+		if skip > 0 {
+			skip--
+			continue
+		}
+		result = append(result, frame)
+	}
+
+	return result
+}
+
+// isInternal returns whether the frame is in log4go (tests excluded).
+func isInternal(frame runtime.Frame) bool {
+	if strings.HasSuffix(frame.File, "_test.go") {
+		return false
+	}
+	return strings.HasPrefix(frame.Function, packagePath+".") || strings.HasPrefix(frame.Function, packagePath+"/")
+}
+
+// formatFrames renders the frames in the style of debug.Stack: the function, followed by the indented location.
+func formatFrames(frames []runtime.Frame) string {
+	lines := make([]string, 0, len(frames))
+	for _, frame := range frames {
+		lines = append(lines, fmt.Sprintf("%s()\n\t%s:%d", frame.Function, frame.File, frame.Line))
+	}
+	return strings.Join(lines, "\n")
+}
+
+// parseStack returns the frames of a stack trace formatted by debug.Stack (or runtime.Stack, its first goroutine),
+// excluding those of debug.Stack itself; with the function "runtime.gopanic" for that of a panic.
+func parseStack(stack []byte) []runtime.Frame {
+	var frames []runtime.Frame
+	lines := strings.Split(string(stack), "\n")
+	for idx := 0; idx+1 < len(lines); idx++ {
+		function, location := lines[idx], lines[idx+1]
+		if len(function) == 0 && len(frames) > 0 {
+			break // the next goroutine (of runtime.Stack)
+		}
+		if strings.HasPrefix(function, "created by ") || !strings.HasPrefix(location, "\t") {
+			continue // e.g. "goroutine 1 [running]:"
+		}
+		idx++
+
+		// e.g. "main.(*T).run(0xc000010000, {0x4b1f20, 0x5})" and "\t/src/main.go:42 +0x1d"
+		if strings.HasSuffix(function, ")") {
+			function = function[:strings.LastIndexByte(function, '(')]
+		}
+		if function == "panic" {
+			function = "runtime.gopanic"
+		}
+		if strings.HasPrefix(function, "runtime/debug.") {
+			continue
+		}
+		location = strings.TrimPrefix(location, "\t")
+		if offset := strings.LastIndex(location, " +0x"); offset >= 0 {
+			location = location[:offset]
+		}
+		frame := runtime.Frame{Function: function, File: location}
+		if colon := strings.LastIndexByte(location, ':'); colon >= 0 {
+			frame.File = location[:colon]
+			frame.Line, _ = strconv.Atoi(location[colon+1:])
+		}
+		frames = append(frames, frame)
+	}
+	return frames
+}
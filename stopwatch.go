@@ -0,0 +1,80 @@
+package log4go
+
+import "time"
+
+// TimeOpts controls at which levels TimeOperation and Stopwatch log.
+type TimeOpts struct {
+	// StartLevel is used for the "started" record (default DEBUG).
+	StartLevel Level
+	// FinishLevel is used for the "finished" and lap records (default INFO).
+	FinishLevel Level
+}
+
+func timeOpts(opts []TimeOpts) TimeOpts {
+	var o TimeOpts
+	if len(opts) > 0 {
+		o = opts[0]
+	}
+	if o.StartLevel == INHERIT {
+		o.StartLevel = DEBUG
+	}
+	if o.FinishLevel == INHERIT {
+		o.FinishLevel = INFO
+	}
+	return o
+}
+
+// TimeOperation logs the start of an operation and returns a function logging its end, with the elapsed duration.
+// Typical usage:
+//
+//	defer log.TimeOperation("rebuild index")()
+func (l *Logger) TimeOperation(operation string, opts ...TimeOpts) func() {
+	sw := l.Stopwatch(operation, opts...)
+	return sw.Stop
+}
+
+// Stopwatch logs the progress of an operation, with elapsed durations.
+type Stopwatch struct {
+	logger    *Logger
+	operation string
+	opts      TimeOpts
+	start     time.Time
+	lap       time.Time
+}
+
+// Stopwatch logs the start of an operation and returns a Stopwatch for logging its progress.
+func (l *Logger) Stopwatch(operation string, opts ...TimeOpts) *Stopwatch {
+	sw := &Stopwatch{
+		logger:    l,
+		operation: operation,
+		opts:      timeOpts(opts),
+		start:     time.Now(),
+	}
+	sw.lap = sw.start
+
+	l.Log(sw.opts.StartLevel, "%s: started", operation)
+
+	return sw
+}
+
+// Lap logs the duration since the start (or the previous lap) and returns it.
+func (sw *Stopwatch) Lap(what string) time.Duration {
+	now := time.Now()
+	d := now.Sub(sw.lap)
+	sw.lap = now
+
+	sw.logger.LogTimed(sw.opts.FinishLevel, d, "%s: %s (%s)", sw.operation, what, formatDuration(d))
+
+	return d
+}
+
+// Elapsed returns the duration since the start.
+func (sw *Stopwatch) Elapsed() time.Duration {
+	return time.Since(sw.start)
+}
+
+// Stop logs the total duration since the start.
+func (sw *Stopwatch) Stop() {
+	d := sw.Elapsed()
+	sw.logger.LogTimed(sw.opts.FinishLevel, d, "%s: finished (%s)", sw.operation, formatDuration(d))
+}
@@ -0,0 +1,330 @@
+package log4go
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// parseTOML parses a TOML document into nested maps. Supported is the subset needed for configuration files:
+// tables (not arrays of tables), bare, quoted and dotted keys, basic and literal strings (single line),
+// integers, floats, booleans, arrays and inline tables. Dates and multi-line strings are not.
+func parseTOML(data []byte) (map[string]interface{}, error) {
+	p := &tomlParser{input: string(data), line: 1}
+
+	doc := map[string]interface{}{}
+	table := doc
+	for {
+		p.skipSpace(true)
+		if p.eof() {
+			return doc, nil
+		}
+
+		var err error
+		if p.peek() == '[' {
+			table, err = p.parseTableHeader(doc)
+		} else {
+			err = p.parseKeyValue(table)
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		// nothing but a comment may follow on the line
+		p.skipSpace(false)
+		if !p.eof() && p.peek() != '\n' {
+			return nil, p.errorf("unexpected %q", p.peek())
+		}
+	}
+}
+
+type tomlParser struct {
+	input string
+	pos   int
+	line  int
+}
+
+func (p *tomlParser) eof() bool {
+	return p.pos >= len(p.input)
+}
+
+func (p *tomlParser) peek() byte {
+	return p.input[p.pos]
+}
+
+func (p *tomlParser) errorf(format string, args ...interface{}) error {
+	return fmt.Errorf("toml: line %d: %s", p.line, fmt.Sprintf(format, args...))
+}
+
+// skipSpace skips whitespace and comments, and newlines if specified.
+func (p *tomlParser) skipSpace(newlines bool) {
+	for !p.eof() {
+		switch p.peek() {
+		case ' ', '\t', '\r':
+		case '\n':
+			if !newlines {
+				return
+			}
+			p.line++
+		case '#':
+			for !p.eof() && p.peek() != '\n' {
+				p.pos++
+			}
+			continue
+		default:
+			return
+		}
+		p.pos++
+	}
+}
+
+// parseTableHeader parses "[a.b]", returning the (created) table.
+func (p *tomlParser) parseTableHeader(doc map[string]interface{}) (map[string]interface{}, error) {
+	p.pos++ // [
+	if !p.eof() && p.peek() == '[' {
+		return nil, p.errorf("arrays of tables are not supported")
+	}
+	p.skipSpace(false)
+	keys, err := p.parseKey()
+	if err != nil {
+		return nil, err
+	}
+	if p.eof() || p.peek() != ']' {
+		return nil, p.errorf("expected ]")
+	}
+	p.pos++
+
+	return p.table(doc, keys)
+}
+
+// table returns the table at the (dotted) key, creating it as needed.
+func (p *tomlParser) table(table map[string]interface{}, keys []string) (map[string]interface{}, error) {
+	for _, key := range keys {
+		switch value := table[key].(type) {
+		case nil:
+			sub := map[string]interface{}{}
+			table[key] = sub
+			table = sub
+		case map[string]interface{}:
+			table = value
+		default:
+			return nil, p.errorf("%s is not a table", key)
+		}
+	}
+	return table, nil
+}
+
+// parseKeyValue parses "key = value" into the table.
+func (p *tomlParser) parseKeyValue(table map[string]interface{}) error {
+	keys, err := p.parseKey()
+	if err != nil {
+		return err
+	}
+	if p.eof() || p.peek() != '=' {
+		return p.errorf("expected =")
+	}
+	p.pos++
+	p.skipSpace(false)
+
+	value, err := p.parseValue()
+	if err != nil {
+		return err
+	}
+
+	table, err = p.table(table, keys[:len(keys)-1])
+	if err != nil {
+		return err
+	}
+	key := keys[len(keys)-1]
+	if _, exists := table[key]; exists {
+		return p.errorf("duplicate key: %s", key)
+	}
+	table[key] = value
+	return nil
+}
+
+// parseKey parses a (dotted) key, e.g. loggers."db/pool".level, and any following whitespace.
+func (p *tomlParser) parseKey() ([]string, error) {
+	var keys []string
+	for {
+		var key string
+		if p.eof() {
+			return nil, p.errorf("expected key")
+		}
+		switch c := p.peek(); {
+		case c == '"' || c == '\'':
+			var err error
+			if key, err = p.parseString(); err != nil {
+				return nil, err
+			}
+		default:
+			start := p.pos
+			for !p.eof() && isBareKeyChar(p.peek()) {
+				p.pos++
+			}
+			if p.pos == start {
+				return nil, p.errorf("unexpected %q", c)
+			}
+			key = p.input[start:p.pos]
+		}
+		keys = append(keys, key)
+
+		p.skipSpace(false)
+		if p.eof() || p.peek() != '.' {
+			return keys, nil
+		}
+		p.pos++
+		p.skipSpace(false)
+	}
+}
+
+func isBareKeyChar(c byte) bool {
+	return c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || c == '_' || c == '-'
+}
+
+func (p *tomlParser) parseValue() (interface{}, error) {
+	if p.eof() {
+		return nil, p.errorf("expected value")
+	}
+
+	switch p.peek() {
+	case '"', '\'':
+		return p.parseString()
+	case '[':
+		return p.parseArray()
+	case '{':
+		return p.parseInlineTable()
+	}
+
+	start := p.pos
+	for !p.eof() && strings.IndexByte(" \t\r\n#,]}", p.peek()) < 0 {
+		p.pos++
+	}
+	token := p.input[start:p.pos]
+
+	switch token {
+	case "true":
+		return true, nil
+	case "false":
+		return false, nil
+	}
+	number := strings.Replace(token, "_", "", -1)
+	if n, err := strconv.ParseInt(number, 0, 64); err == nil {
+		return n, nil
+	}
+	if f, err := strconv.ParseFloat(number, 64); err == nil {
+		return f, nil
+	}
+	return nil, p.errorf("unsupported value: %q", token)
+}
+
+// parseString parses a basic ("...") or literal ('...') string.
+func (p *tomlParser) parseString() (string, error) {
+	quote := p.peek()
+	p.pos++
+
+	var s strings.Builder
+	for {
+		if p.eof() || p.peek() == '\n' {
+			return "", p.errorf("unterminated string")
+		}
+		c := p.peek()
+		p.pos++
+
+		switch {
+		case c == quote:
+			return s.String(), nil
+		case c == '\\' && quote == '"':
+			if p.eof() {
+				return "", p.errorf("unterminated string")
+			}
+			escape := p.peek()
+			p.pos++
+			switch escape {
+			case 'n':
+				s.WriteByte('\n')
+			case 't':
+				s.WriteByte('\t')
+			case 'r':
+				s.WriteByte('\r')
+			case '"', '\\':
+				s.WriteByte(escape)
+			case 'u', 'U':
+				size := 4
+				if escape == 'U' {
+					size = 8
+				}
+				if p.pos+size > len(p.input) {
+					return "", p.errorf("invalid escape")
+				}
+				code, err := strconv.ParseUint(p.input[p.pos:p.pos+size], 16, 32)
+				if err != nil || !utf8.ValidRune(rune(code)) {
+					return "", p.errorf("invalid escape")
+				}
+				s.WriteRune(rune(code))
+				p.pos += size
+			default:
+				return "", p.errorf("invalid escape: \\%c", escape)
+			}
+		default:
+			s.WriteByte(c)
+		}
+	}
+}
+
+// parseArray parses an array, possibly spanning several lines.
+func (p *tomlParser) parseArray() ([]interface{}, error) {
+	p.pos++ // [
+	values := []interface{}{}
+	for {
+		p.skipSpace(true)
+		if p.eof() {
+			return nil, p.errorf("unterminated array")
+		}
+		if p.peek() == ']' {
+			p.pos++
+			return values, nil
+		}
+
+		value, err := p.parseValue()
+		if err != nil {
+			return nil, err
+		}
+		values = append(values, value)
+
+		p.skipSpace(true)
+		if !p.eof() && p.peek() == ',' {
+			p.pos++
+		} else if p.eof() || p.peek() != ']' {
+			return nil, p.errorf("expected , or ]")
+		}
+	}
+}
+
+// parseInlineTable parses an inline table, e.g. {type = "file", file = "app.log"}.
+func (p *tomlParser) parseInlineTable() (map[string]interface{}, error) {
+	p.pos++ // {
+	table := map[string]interface{}{}
+	for {
+		p.skipSpace(false)
+		if p.eof() {
+			return nil, p.errorf("unterminated inline table")
+		}
+		if p.peek() == '}' {
+			p.pos++
+			return table, nil
+		}
+
+		if err := p.parseKeyValue(table); err != nil {
+			return nil, err
+		}
+
+		p.skipSpace(false)
+		if !p.eof() && p.peek() == ',' {
+			p.pos++
+		} else if p.eof() || p.peek() != '}' {
+			return nil, p.errorf("expected , or }")
+		}
+	}
+}
@@ -0,0 +1,202 @@
+package log4go
+
+import (
+	"fmt"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// handlerWrapper is implemented by handlers passing records on to other handlers.
+type handlerWrapper interface {
+	wrappedHandlers() []Handler
+}
+
+// Validate inspects the logger tree for likely configuration mistakes, returning a description of each:
+//   - the same file written by several handlers (their lines interleave, and rotation breaks)
+//   - handlers without a formatter
+//   - logger levels not accepted by any of the logger's handlers (e.g. a DEBUG logger whose handlers are all
+//     at WARNING), and loggers without any handlers
+//   - handlers (indirectly) passing records on to themselves
+func Validate() []string {
+	loggersLock.RLock()
+	defer loggersLock.RUnlock()
+
+	if rootLogger == nil {
+		return nil
+	}
+
+	problems := duplicateFiles()
+	problems = append(problems, handlerCycles()...)
+	for _, h := range allHandlers() {
+		if _, wrapper := h.handler.(handlerWrapper); wrapper {
+			continue // they use the wrapped handlers' formatters (and might be part of a cycle)
+		}
+		if h.handler.Formatter() == nil {
+			problems = append(problems, fmt.Sprintf("%T (on logger %s) has no formatter", h.handler, h.logger))
+		}
+	}
+	problems = append(problems, rootLogger.unreachableLevels()...)
+
+	sort.Strings(problems)
+	return problems
+}
+
+// loggerHandler is a handler, and the name of the (first) logger it's used by.
+type loggerHandler struct {
+	handler Handler
+	logger  string
+}
+
+// allHandlers returns the unique handlers of the tree, including those wrapped by others (loggersLock must be held).
+func allHandlers() []loggerHandler {
+	var handlers []loggerHandler
+	seen := map[string]bool{}
+
+	var add func(h Handler, logger string)
+	add = func(h Handler, logger string) {
+		// use the pointer address as the unique key
+		if key := fmt.Sprintf("%p", h); !seen[key] {
+			seen[key] = true
+			handlers = append(handlers, loggerHandler{h, logger})
+			if w, ok := h.(handlerWrapper); ok {
+				for _, wrapped := range w.wrappedHandlers() {
+					add(wrapped, logger)
+				}
+			}
+		}
+	}
+
+	var walk func(l *Logger)
+	walk = func(l *Logger) {
+		name := configLoggerName(l.name)
+		for _, h := range l.ownHandlers() {
+			add(h, name)
+		}
+		if entry, _ := l.stagedTo.Load().(stagedHandlerEntry); entry.handler != nil {
+			add(entry.handler, name)
+		}
+		for _, child := range l.children {
+			walk(child)
+		}
+	}
+	walk(rootLogger)
+
+	return handlers
+}
+
+// duplicateFiles describes the files written by several handlers (loggersLock must be held).
+func duplicateFiles() []string {
+	writers := map[string][]string{}
+	for _, h := range allHandlers() {
+		if filename := handlerFile(h.handler); len(filename) > 0 {
+			writers[filename] = append(writers[filename], h.logger)
+		}
+	}
+
+	var problems []string
+	for filename, loggers := range writers {
+		if len(loggers) > 1 {
+			problems = append(problems, fmt.Sprintf("file %s is written by %d handlers (on loggers %s)",
+				filename, len(loggers), strings.Join(loggers, ", ")))
+		}
+	}
+	return problems
+}
+
+// warnDuplicateFiles writes a warning to stderr for each file written by several handlers (loggersLock must be held).
+func warnDuplicateFiles(context string) {
+	if rootLogger == nil {
+		return
+	}
+	for _, problem := range duplicateFiles() {
+		report(WARNING, context, "warning: %s", problem)
+	}
+}
+
+// handlerFile returns the (absolute) name of the file written by the handler, if any.
+func handlerFile(h Handler) string {
+	var stream *StreamHandler
+	switch h := h.(type) {
+	case *StreamHandler:
+		stream = h
+	case *WatchedFileHandler:
+		stream = h.StreamHandler
+	default:
+		return ""
+	}
+
+	if w, ok := stream.writer.(*fileWriter); ok {
+		if filename, err := filepath.Abs(w.template); err == nil {
+			return filename
+		}
+		return w.template
+	}
+	return ""
+}
+
+// handlerCycles describes the handlers passing records on to themselves (loggersLock must be held).
+func handlerCycles() []string {
+	const (
+		visiting = 1
+		visited  = 2
+	)
+	state := map[string]int{}
+
+	var problems []string
+	var visit func(h Handler, logger string)
+	visit = func(h Handler, logger string) {
+		key := fmt.Sprintf("%p", h)
+		switch state[key] {
+		case visiting:
+			problems = append(problems, fmt.Sprintf("%T (on logger %s) passes records on to itself", h, logger))
+			return
+		case visited:
+			return
+		}
+
+		state[key] = visiting
+		if w, ok := h.(handlerWrapper); ok {
+			for _, wrapped := range w.wrappedHandlers() {
+				visit(wrapped, logger)
+			}
+		}
+		state[key] = visited
+	}
+
+	for _, h := range allHandlers() {
+		visit(h.handler, h.logger)
+	}
+	return problems
+}
+
+// unreachableLevels describes the levels of the logger and its descendants (those having a level set)
+// that none of their handlers accept (loggersLock must be held).
+func (l *Logger) unreachableLevels() []string {
+	var problems []string
+
+	if (l.parent == nil || l.level != INHERIT) && l.level != OFF {
+		name := configLoggerName(l.name)
+		handlers := l.Handlers()
+		if len(handlers) == 0 {
+			problems = append(problems, fmt.Sprintf("logger %s (level %s) has no handlers, nor do its ancestors",
+				name, LevelName(l.level)))
+		} else {
+			lowest := OFF
+			for _, h := range handlers {
+				if level := h.Level(); level < lowest {
+					lowest = level
+				}
+			}
+			if l.level < lowest {
+				problems = append(problems, fmt.Sprintf("logger %s (level %s): records below %s are dropped by all its handlers",
+					name, LevelName(l.level), LevelName(lowest)))
+			}
+		}
+	}
+
+	for _, child := range l.children {
+		problems = append(problems, child.unreachableLevels()...)
+	}
+	return problems
+}
@@ -0,0 +1,125 @@
+package log4go
+
+import (
+	"math/bits"
+	"sync"
+	"time"
+)
+
+// WriteStats describes the latencies of a handler's writes (see StreamHandler.WriteStats).
+// The percentiles are approximate: the upper bounds of power-of-2 (microsecond) buckets.
+type WriteStats struct {
+	Writes uint64
+	Total  time.Duration
+	Max    time.Duration
+	P50    time.Duration
+	P99    time.Duration
+}
+
+const writeStatsBuckets = 40
+
+// slowWriteWindow is the number of writes (or the time, if fewer) the p99 latency is checked over,
+// and slowWriteWarnInterval the minimum time between warnings.
+const (
+	slowWriteWindow       = 100
+	slowWriteWindowTime   = 10 * time.Second
+	slowWriteWarnInterval = time.Minute
+)
+
+// writeStats tracks the latencies of a handler's writes, and warns when they get slow.
+type writeStats struct {
+	lock    sync.Mutex
+	count   uint64
+	total   time.Duration
+	max     time.Duration
+	buckets [writeStatsBuckets]uint64
+
+	threshold   time.Duration // warn when the p99 latency of a window exceeds this (0: never)
+	window      [writeStatsBuckets]uint64
+	windowCount uint64
+	windowStart time.Time
+	warned      time.Time
+}
+
+// latencyBucket returns the index of the bucket of the latency: 0 for less than a microsecond,
+// otherwise 1 + the position of its highest bit (in microseconds).
+func latencyBucket(latency time.Duration) int {
+	bucket := bits.Len64(uint64(latency / time.Microsecond))
+	if bucket >= writeStatsBuckets {
+		bucket = writeStatsBuckets - 1
+	}
+	return bucket
+}
+
+// percentile returns the (upper bound of the bucket of the) latency below which the fraction of the writes are.
+func percentile(buckets *[writeStatsBuckets]uint64, count uint64, fraction float64, max time.Duration) time.Duration {
+	if count == 0 {
+		return 0
+	}
+	target := uint64(fraction*float64(count) + 0.5)
+	if target < 1 {
+		target = 1
+	}
+	var seen uint64
+	for idx, n := range buckets {
+		seen += n
+		if seen >= target {
+			bound := time.Duration(uint64(1)<<uint(idx)) * time.Microsecond
+			if bound > max {
+				return max
+			}
+			return bound
+		}
+	}
+	return max
+}
+
+// add records the latency of a write, warning (to stderr, at most once a minute) if the writes have become slow.
+func (s *writeStats) add(latency time.Duration, name string, pending int) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	bucket := latencyBucket(latency)
+	s.count++
+	s.total += latency
+	if latency > s.max {
+		s.max = latency
+	}
+	s.buckets[bucket]++
+
+	if s.threshold <= 0 {
+		return
+	}
+	now := time.Now()
+	if s.windowCount == 0 {
+		s.windowStart = now
+	}
+	s.window[bucket]++
+	s.windowCount++
+	if s.windowCount < slowWriteWindow && now.Sub(s.windowStart) < slowWriteWindowTime {
+		return
+	}
+
+	p99 := percentile(&s.window, s.windowCount, 0.99, s.max)
+	if p99 > s.threshold && now.Sub(s.warned) >= slowWriteWarnInterval {
+		report(WARNING, name, "slow writes: p99 %v over the last %d writes (threshold %v), %d records queued",
+			p99, s.windowCount, s.threshold, pending)
+		s.warned = now
+	}
+	s.window = [writeStatsBuckets]uint64{}
+	s.windowCount = 0
+}
+
+// stats returns the statistics of all writes.
+func (s *writeStats) stats() WriteStats {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	return WriteStats{
+		Writes: s.count,
+		Total:  s.total,
+		Max:    s.max,
+		P50:    percentile(&s.buckets, s.count, 0.5, s.max),
+		P99:    percentile(&s.buckets, s.count, 0.99, s.max),
+	}
+}